@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// adminUserIDs holds the Telegram users allowed to run admin commands, read
+// from ADMIN_USER_IDS at startup.
+var adminUserIDs = parseAdminUserIDs("")
+
+// adminToken is the bearer token required by the /admin endpoints, read from
+// ADMIN_TOKEN at startup. The endpoints are disabled when empty.
+var adminToken string
+
+// parseAdminUserIDs parses a comma separated list of Telegram user IDs.
+func parseAdminUserIDs(list string) map[int]bool {
+	ids := map[int]bool{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			log.Printf("WARNING: invalid user ID %q in ADMIN_USER_IDS", s)
+			continue
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+// isAdmin returns whether the message was sent by an admin. Admin commands
+// sent by anyone else are treated as unknown commands.
+func isAdmin(msg *telegram.Message) bool {
+	return msg.From != nil && adminUserIDs[msg.From.ID]
+}
+
+// requireAdminToken checks the bearer token of an /admin request, replying
+// with an error when it doesn't match adminToken. It returns whether the
+// request can proceed.
+func requireAdminToken(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		http.Error(w, "admin endpoints are disabled", http.StatusForbidden)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// lastSearchTTL is how long the latest search of a chat can be run again.
+const lastSearchTTL = 24 * time.Hour
+
+// lastSearch is a release search as given by the chat, before the prefs
+// apply, so that running it again follows the current ones.
+type lastSearch struct {
+	matches  []string
+	filter   resultFilter
+	searched time.Time
+}
+
+// searchReplays remembers the latest release search of each chat, in memory,
+// for the again command.
+type searchReplays struct {
+	mu     sync.Mutex
+	latest map[int64]lastSearch
+}
+
+var lastSearches = &searchReplays{latest: map[int64]lastSearch{}}
+
+// record remembers the search of the chat, the matches of releaseCommand or
+// releaseYearCommand with the filter of the search.
+func (s *searchReplays) record(chatID int64, matches []string, filter resultFilter, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, l := range s.latest {
+		if now.Sub(l.searched) > lastSearchTTL {
+			delete(s.latest, id)
+		}
+	}
+	s.latest[chatID] = lastSearch{matches: append([]string(nil), matches...), filter: filter, searched: now}
+}
+
+// last returns the latest search of the chat, ok is false when it didn't
+// search in the last lastSearchTTL.
+func (s *searchReplays) last(chatID int64, now time.Time) (lastSearch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.latest[chatID]
+	if !ok || now.Sub(l.searched) > lastSearchTTL {
+		return lastSearch{}, false
+	}
+	return l, true
+}
+
+// handleAgain runs the latest release search of the chat again, with its
+// current prefs, e.g. after TMDB failed or once the region changed.
+func handleAgain(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	l, ok := lastSearches.last(chatID, time.Now())
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "There's no search to run again, search for a movie first with \"releases <movie title>\"."))
+		return
+	}
+	handleRelease(ctx, update, l.matches, l.filter)
+}
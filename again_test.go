@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchReplays(t *testing.T) {
+	replays := &searchReplays{latest: map[int64]lastSearch{}}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	matches := []string{"releases dune", "", "dune"}
+	replays.record(42, matches, resultFilter{MinRating: 7}, now)
+	matches[2] = "tenet"
+
+	l, ok := replays.last(42, now.Add(time.Hour))
+	if !ok {
+		t.Fatal("last search not found")
+	}
+	if want := []string{"releases dune", "", "dune"}; !reflect.DeepEqual(l.matches, want) {
+		t.Errorf("matches = %q, want %q", l.matches, want)
+	}
+	if l.filter != (resultFilter{MinRating: 7}) {
+		t.Errorf("filter = %+v, want the one of the search", l.filter)
+	}
+
+	if _, ok := replays.last(43, now); ok {
+		t.Error("found a search of a chat that didn't search")
+	}
+	if _, ok := replays.last(42, now.Add(lastSearchTTL+time.Minute)); ok {
+		t.Error("found an expired search")
+	}
+
+	replays.record(43, matches, resultFilter{}, now.Add(lastSearchTTL+time.Minute))
+	if _, ok := replays.latest[42]; ok {
+		t.Error("expired search of chat 42 still remembered")
+	}
+}
+
+func TestHandleAgain(t *testing.T) {
+	previous := lastSearches
+	lastSearches = &searchReplays{latest: map[int64]lastSearch{}}
+	t.Cleanup(func() { lastSearches = previous })
+
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 1, "title": "Dune", "release_date": "2021-09-15", "vote_average": 8, "vote_count": 100},
+			{"id": 2, "title": "Dune: Part Three", "release_date": "", "vote_average": 8, "vote_count": 100},
+			{"id": 3, "title": "Dune Drifter", "release_date": "2020-12-01", "vote_average": 4, "vote_count": 100},
+		},
+	})
+
+	update := testMessage(42, "again")
+	handleAgain(context.Background(), update)
+	if texts := tg.texts(42); len(texts) != 1 || !strings.HasPrefix(texts[0], "There's no search to run again") {
+		t.Fatalf("without a search, sent %q, want a prompt to search first", texts)
+	}
+
+	update = testMessage(42, "releases dune")
+	handleRelease(context.Background(), update, releaseCommand.FindStringSubmatch(update.Message.Text), resultFilter{MinRating: 7})
+
+	// The chat now hides undated movies, the replay follows it
+	s.prefs[42] = UserPrefs{ChatID: 42, HideUndated: true}
+	update = testMessage(42, "again")
+	handleAgain(context.Background(), update)
+
+	if n := api.requests("/search/movie"); n != 2 {
+		t.Errorf("searched %d times, want the search run again", n)
+	}
+	var results []string
+	for _, c := range append(tg.sent("sendMessage"), tg.sent("editMessageText")...) {
+		results = append(results, c.Params.Get("text"))
+	}
+	if len(results) != 3 {
+		t.Fatalf("sent %q, want the prompt and the results twice", results)
+	}
+	replayed := results[2]
+	if !strings.Contains(replayed, "Dune (2021)") {
+		t.Errorf("replayed results %q, want the movie listed", replayed)
+	}
+	if strings.Contains(replayed, "Dune Drifter") {
+		t.Errorf("replayed results %q, want the rating filter of the search kept", replayed)
+	}
+	if strings.Contains(replayed, "Dune: Part Three") {
+		t.Errorf("replayed results %q, want the undated movie hidden by the current prefs", replayed)
+	}
+	if !strings.Contains(results[1], "Dune: Part Three") {
+		t.Errorf("first results %q, want the undated movie listed", results[1])
+	}
+}
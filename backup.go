@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// backupSchemaVersion is the version of the backup format, bumped on
+	// incompatible changes. Restore rejects other versions.
+	backupSchemaVersion = 1
+	// backupKindEnd is the kind of the last record of a complete backup.
+	backupKindEnd = "end"
+	// backupInterval is the minimum delay between two backups, or two
+	// restores.
+	backupInterval = time.Minute
+)
+
+// backupRecord is a line of a backup, holding a single entity. The last line
+// of a complete backup is of kind backupKindEnd and holds the number of
+// entities, so that truncated backups are detected.
+type backupRecord struct {
+	Schema       int             `json:"schema"`
+	Kind         string          `json:"kind"`
+	Release      *MovieRelease   `json:"release,omitempty"`
+	Season       *SeasonRelease  `json:"season,omitempty"`
+	Prefs        *UserPrefs      `json:"prefs,omitempty"`
+	Search       *SearchedMovie  `json:"search,omitempty"`
+	CalendarLink *CalendarLink   `json:"calendar_link,omitempty"`
+	User         *User           `json:"user,omitempty"`
+	Followed     *FollowedPerson `json:"followed_person,omitempty"`
+	Count        int             `json:"count,omitempty"`
+}
+
+// newBackupRecord wraps an entity given by Store.Export.
+func newBackupRecord(entity interface{}) (backupRecord, error) {
+	rec := backupRecord{Schema: backupSchemaVersion}
+	switch e := entity.(type) {
+	case *MovieRelease:
+		rec.Kind, rec.Release = kindMovieRelease, e
+	case *SeasonRelease:
+		rec.Kind, rec.Season = kindSeason, e
+	case *UserPrefs:
+		rec.Kind, rec.Prefs = kindUserPrefs, e
+	case *SearchedMovie:
+		rec.Kind, rec.Search = kindSearch, e
+	case *CalendarLink:
+		rec.Kind, rec.CalendarLink = kindCalendarLink, e
+	case *User:
+		rec.Kind, rec.User = kindUser, e
+	case *FollowedPerson:
+		rec.Kind, rec.Followed = kindFollowed, e
+	default:
+		return rec, errors.Errorf("unexpected entity %T", entity)
+	}
+	return rec, nil
+}
+
+// adminThrottle paces the expensive admin endpoints.
+type adminThrottle struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+var backupThrottle = &adminThrottle{last: map[string]time.Time{}}
+
+// allow returns whether the named endpoint can run now, and records the run.
+// Otherwise it returns how long to wait.
+func (t *adminThrottle) allow(name string, interval time.Duration, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if wait := t.last[name].Add(interval).Sub(now); wait > 0 {
+		return wait, false
+	}
+	t.last[name] = now
+	return 0, true
+}
+
+// requireThrottle is like requireAdminToken for adminThrottle.
+func requireThrottle(w http.ResponseWriter, name string) bool {
+	wait, ok := backupThrottle.allow(name, backupInterval, time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, name+" ran recently, try again later", http.StatusTooManyRequests)
+	}
+	return ok
+}
+
+// handleAdminBackup streams every stored entity as newline delimited JSON,
+// see backupRecord.
+func handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireThrottle(w, "backup") {
+		return
+	}
+	ctx := withRequestID(r.Context(), newRequestID())
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=backup-%s.ndjson", time.Now().UTC().Format("20060102-150405")))
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	count := 0
+	err := store.Export(ctx, func(entity interface{}) error {
+		rec, err := newBackupRecord(entity)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(rec); err != nil {
+			return errors.Wrap(err, "failed to write backup")
+		}
+		count++
+		if flusher != nil && count%maxBatchSize == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status is already sent, the missing end record tells the
+		// backup is incomplete
+		logf(ctx, "backup stopped: entities=%d: %s", count, err)
+		return
+	}
+
+	enc.Encode(backupRecord{Schema: backupSchemaVersion, Kind: backupKindEnd, Count: count})
+	logf(ctx, "backup done: entities=%d", count)
+}
+
+// handleAdminRestore stores back the entities of a backup made by
+// handleAdminBackup. Entities are replaced as they are read, restoring the
+// same backup again is harmless, e.g. after a partial failure.
+func handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireThrottle(w, "restore") {
+		return
+	}
+	ctx := withRequestID(r.Context(), newRequestID())
+
+	restored := map[string]int{}
+	total := 0
+	var searches []SearchedMovie
+	flushSearches := func() error {
+		if len(searches) == 0 {
+			return nil
+		}
+		if err := store.PutSearches(ctx, searches); err != nil {
+			return err
+		}
+		searches = nil
+		return nil
+	}
+
+	dec := json.NewDecoder(r.Body)
+	complete := false
+	for line := 1; ; line++ {
+		var rec backupRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logf(ctx, "restore stopped, invalid backup: line=%d restored=%d: %s", line, total, err)
+			http.Error(w, fmt.Sprintf("invalid record on line %d after restoring %d entities: %s", line, total, err), http.StatusBadRequest)
+			return
+		}
+		if rec.Schema != backupSchemaVersion {
+			http.Error(w, fmt.Sprintf("unsupported schema version %d on line %d, expected %d", rec.Schema, line, backupSchemaVersion), http.StatusBadRequest)
+			return
+		}
+		if complete {
+			http.Error(w, fmt.Sprintf("unexpected record after the end of the backup on line %d", line), http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case rec.Kind == backupKindEnd:
+			if rec.Count != total {
+				http.Error(w, fmt.Sprintf("backup holds %d entities, expected %d", total, rec.Count), http.StatusBadRequest)
+				return
+			}
+			complete = true
+			continue
+		case rec.Kind == kindMovieRelease && rec.Release != nil:
+			err = store.PutRelease(ctx, *rec.Release)
+		case rec.Kind == kindSeason && rec.Season != nil:
+			err = store.PutSeason(ctx, *rec.Season)
+		case rec.Kind == kindUserPrefs && rec.Prefs != nil:
+			err = store.PutPrefs(ctx, *rec.Prefs)
+		case rec.Kind == kindSearch && rec.Search != nil:
+			searches = append(searches, *rec.Search)
+			if len(searches) == maxBatchSize {
+				err = flushSearches()
+			}
+		case rec.Kind == kindCalendarLink && rec.CalendarLink != nil:
+			err = store.PutCalendarLink(ctx, *rec.CalendarLink)
+		case rec.Kind == kindUser && rec.User != nil:
+			err = store.PutUser(ctx, *rec.User)
+		case rec.Kind == kindFollowed && rec.Followed != nil:
+			err = store.PutFollowedPerson(ctx, *rec.Followed)
+		default:
+			http.Error(w, fmt.Sprintf("invalid record of kind %q on line %d", rec.Kind, line), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logf(ctx, "restore stopped: line=%d restored=%d: %s", line, total, err)
+			http.Error(w, fmt.Sprintf("restore stopped on line %d after %d entities, run it again: %s", line, total, err), http.StatusInternalServerError)
+			return
+		}
+		restored[rec.Kind]++
+		total++
+	}
+	if err := flushSearches(); err != nil {
+		logf(ctx, "restore stopped: restored=%d: %s", total, err)
+		http.Error(w, fmt.Sprintf("restore stopped after %d entities, run it again: %s", total, err), http.StatusInternalServerError)
+		return
+	}
+
+	if !complete {
+		logf(ctx, "restored truncated backup: restored=%d", total)
+		http.Error(w, fmt.Sprintf("the backup is truncated, restored the %d entities it holds", total), http.StatusBadRequest)
+		return
+	}
+
+	logf(ctx, "restore done: restored=%d", total)
+	for _, k := range exportedKinds {
+		fmt.Fprintf(w, "%s=%d\n", k.kind, restored[k.kind])
+	}
+	fmt.Fprintf(w, "total=%d\n", total)
+}
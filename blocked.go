@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// defaultBlockedChatThreshold is how many notifications in a row a chat
+// refuses before its data is deleted, unless BLOCKED_CHAT_THRESHOLD is set.
+const defaultBlockedChatThreshold = 5
+
+var blockedChatThreshold = parseBlockedChatThreshold("")
+
+// parseBlockedChatThreshold parses how many notifications in a row a chat
+// refuses before its data is deleted. Zero never deletes it.
+func parseBlockedChatThreshold(v string) int {
+	if v == "" {
+		return defaultBlockedChatThreshold
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("WARNING: invalid BLOCKED_CHAT_THRESHOLD %q, using %d", v, defaultBlockedChatThreshold)
+		return defaultBlockedChatThreshold
+	}
+	return n
+}
+
+// chatBlocked returns whether Telegram refused the message because the bot
+// can't reach the chat anymore: the user blocked it or deleted their account,
+// or the bot was removed from the group.
+func chatBlocked(err error) bool {
+	tgErr, ok := errors.Cause(err).(telegram.Error)
+	return ok && strings.HasPrefix(tgErr.Message, "Forbidden:")
+}
+
+// recordRefused counts a notification refused by the chat, or resets the
+// count once one is delivered, see UserPrefs.RefusedNotifications. The count
+// is updated in a transaction, notifications of the chat may be sent
+// concurrently. Failures are only logged, the count is updated on the next
+// notification.
+func recordRefused(ctx context.Context, chatID int64, refused bool) {
+	count := 0
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		if !refused && prefs.RefusedNotifications == 0 {
+			return errSkipUpdate
+		}
+		if refused {
+			prefs.RefusedNotifications++
+		} else {
+			prefs.RefusedNotifications = 0
+		}
+		count = prefs.RefusedNotifications
+		return nil
+	})
+	if err != nil {
+		logf(ctx, "failed to save refused notifications: chat_id=%d: %s", chatID, err)
+		return
+	}
+	if refused {
+		logf(ctx, "chat %d refused a notification: refused=%d threshold=%d", chatID, count, blockedChatThreshold)
+	}
+}
+
+// cleanupBlockedChats deletes the data of the chats that refused
+// blockedChatThreshold notifications in a row, they can't be reached anymore.
+// It runs at the end of the refresh job, once the refreshes that may notify
+// them are done, so that their subscriptions aren't written back.
+func cleanupBlockedChats(ctx context.Context) {
+	if blockedChatThreshold == 0 {
+		return
+	}
+	prefs, err := store.RefusingChats(ctx, blockedChatThreshold)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get chats refusing notifications")
+		return
+	}
+
+	deleted := 0
+	for _, p := range prefs {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping blocked chats cleanup: %s", ctx.Err())
+			break
+		}
+		logf(ctx, "deleting data of chat %d, it refused %d notifications in a row", p.ChatID, p.RefusedNotifications)
+		if err := deleteChatData(ctx, p.ChatID); err != nil {
+			jobStoreFailed(ctx, err, fmt.Sprintf("failed to delete data of blocked chat: chat_id=%d", p.ChatID))
+			return
+		}
+		deleted++
+	}
+	logf(ctx, "cleaned up blocked chats: deleted=%d", deleted)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseBlockedChatThreshold(t *testing.T) {
+	tests := []struct {
+		v    string
+		want int
+	}{
+		{"", defaultBlockedChatThreshold},
+		{"3", 3},
+		{"0", 0},
+		{"-1", defaultBlockedChatThreshold},
+		{"many", defaultBlockedChatThreshold},
+	}
+	for _, tt := range tests {
+		if got := parseBlockedChatThreshold(tt.v); got != tt.want {
+			t.Errorf("parseBlockedChatThreshold(%q) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRecordRefused(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+
+	// Nothing refused yet, the delivery doesn't store default prefs
+	recordRefused(ctx, 42, false)
+	if _, ok := s.prefs[42]; ok {
+		t.Errorf("prefs = %+v, want none stored", s.prefs[42])
+	}
+
+	s.prefs[42] = UserPrefs{ChatID: 42, Posters: true}
+	recordRefused(ctx, 42, true)
+	recordRefused(ctx, 42, true)
+	if p := s.prefs[42]; p.RefusedNotifications != 2 || !p.Posters {
+		t.Errorf("prefs = %+v, want 2 refused and the other prefs kept", p)
+	}
+	recordRefused(ctx, 42, false)
+	if p := s.prefs[42]; p.RefusedNotifications != 0 {
+		t.Errorf("refused = %d after a delivery, want 0", p.RefusedNotifications)
+	}
+}
+
+func TestNotifyBlockedChatCleanedUp(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+	previous := blockedChatThreshold
+	blockedChatThreshold = 3
+	t.Cleanup(func() { blockedChatThreshold = previous })
+
+	tg.fail = func(call telegramCall) string {
+		if call.Params.Get("chat_id") == "42" {
+			return "Forbidden: bot was blocked by the user"
+		}
+		return ""
+	}
+	release := MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: time.Now().AddDate(0, 0, 2), Subscribers: []Subscriber{{ChatID: 42}, {ChatID: 43}}}
+	if err := store.PutRelease(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutFollowedPerson(ctx, FollowedPerson{ChatID: 42, PersonID: 7, Name: "Denis Villeneuve"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= blockedChatThreshold; i++ {
+		// Each refresh tries the notification again, it was never delivered
+		notifyReleases(ctx)
+		if got := s.prefs[42].RefusedNotifications; got != i {
+			t.Fatalf("run %d: refused = %d, want %d", i, got, i)
+		}
+		cleanupBlockedChats(ctx)
+		if deleted := len(s.releases[1].Subscribers) == 1; deleted != (i == blockedChatThreshold) {
+			t.Errorf("run %d: subscribers = %+v, want chat 42 deleted: %t", i, s.releases[1].Subscribers, i == blockedChatThreshold)
+		}
+	}
+
+	if subs := s.releases[1].Subscribers; len(subs) != 1 || subs[0].ChatID != 43 {
+		t.Errorf("subscribers = %+v, want only chat 43 kept", subs)
+	}
+	if _, ok := s.prefs[42]; ok {
+		t.Errorf("prefs of chat 42 = %+v, want them deleted", s.prefs[42])
+	}
+	if following, _ := store.FollowedPeople(ctx, 42); len(following) != 0 {
+		t.Errorf("followed people of chat 42 = %+v, want none", following)
+	}
+	if len(tg.texts(43)) != 1 {
+		t.Errorf("chat 43 got %q, want notified once", tg.texts(43))
+	}
+	if n := len(tg.texts(42)); n != blockedChatThreshold {
+		t.Errorf("tried to notify chat 42 %d times, want %d", n, blockedChatThreshold)
+	}
+}
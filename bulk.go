@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// maxBulkTitles is the maximum number of titles accepted by a single
+	// bulk subscribe.
+	maxBulkTitles = 20
+	// bulkQueryInterval is the minimum delay between two TMDB searches of a
+	// bulk subscribe.
+	bulkQueryInterval = 250 * time.Millisecond
+	// bulkConcurrency is how many titles of a bulk subscribe are searched at
+	// once.
+	bulkConcurrency = 3
+	// bulkQueryAttempts is how many times the search of a title is attempted
+	// before giving up on it.
+	bulkQueryAttempts = 3
+	// bulkRetryBackoff is the delay before the first retry of a search,
+	// doubled after each attempt.
+	bulkRetryBackoff = 500 * time.Millisecond
+)
+
+// splitTitles splits a list of titles separated by newlines or commas.
+func splitTitles(list string) []string {
+	fields := strings.FieldsFunc(list, func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+
+	var titles []string
+	for _, f := range fields {
+		if t := strings.TrimSpace(f); t != "" {
+			titles = append(titles, t)
+		}
+	}
+	return titles
+}
+
+func handleBulkSubscribe(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	titles := splitTitles(matches[1])
+	if len(titles) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Give me some titles, one per line or separated by commas."))
+		return
+	}
+	if len(titles) > maxBulkTitles {
+		text := fmt.Sprintf("That's a lot of movies! I can handle up to %d titles at once.", maxBulkTitles)
+		sendMsg(ctx, telegram.NewMessage(chatID, text))
+		return
+	}
+
+	thread := receivedTopics.thread(update.Message)
+	var subscribed, already, ambiguous, notFound, failed []string
+	for _, search := range searchBulkTitles(ctx, titles) {
+		if search.err != nil {
+			failed = append(failed, search.title)
+			continue
+		}
+
+		switch len(search.upcoming) {
+		case 0:
+			notFound = append(notFound, search.title)
+		case 1:
+			existing, err := subscribeChat(ctx, chatID, search.upcoming[0], 0, regionDate{}, thread)
+			if err != nil {
+				storeFailed(ctx, chatID, err, "failed to subscribe to movie release")
+				return
+			}
+			if existing != "" {
+				already = append(already, existing)
+				continue
+			}
+			subscribed = append(subscribed, search.upcoming[0].MovieTitle)
+		default:
+			ambiguous = append(ambiguous, search.title)
+		}
+	}
+
+	text := bulkSummary("Subscribed ✅", subscribed) +
+		bulkSummary("Already subscribed 👌", already) +
+		bulkSummary("Multiple matches, use `subscribe to <movie title>` 🤔", ambiguous) +
+		bulkSummary("No upcoming release found 🤷", notFound) +
+		bulkSummary("Failed, TMDB didn't answer ⚠️", failed)
+	if len(failed) > 0 {
+		text += "To try these again, send: " + escapeMarkdown("subscribe list "+strings.Join(failed, ", "))
+	}
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ParseMode = "Markdown"
+	sendMsg(ctx, msgConfig)
+}
+
+// bulkSearch is the outcome of the search of a title of a bulk subscribe.
+type bulkSearch struct {
+	title    string
+	upcoming []MovieRelease
+	err      error
+}
+
+// searchBulkTitles searches the upcoming releases of the titles, at most
+// bulkConcurrency at once and bulkQueryInterval apart. The searches are
+// returned in the order of the titles, failed ones holding their last
+// error.
+func searchBulkTitles(ctx context.Context, titles []string) []bulkSearch {
+	searches := make([]bulkSearch, len(titles))
+
+	throttle := time.NewTicker(bulkQueryInterval)
+	defer throttle.Stop()
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < bulkConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				searches[i] = searchBulkTitle(ctx, titles[i], throttle.C)
+			}
+		}()
+	}
+	for i := range titles {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return searches
+}
+
+// searchBulkTitle searches the upcoming releases of the title, retrying
+// failed searches up to bulkQueryAttempts times. Each attempt waits for a
+// tick of throttle.
+func searchBulkTitle(ctx context.Context, title string, throttle <-chan time.Time) bulkSearch {
+	search := bulkSearch{title: title}
+	backoff := bulkRetryBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-throttle:
+		case <-ctx.Done():
+			search.err = ctx.Err()
+			return search
+		}
+
+		results, err := queryMovies(ctx, title, "")
+		if err == nil {
+			search.upcoming, search.err = upcomingReleases(results), nil
+			return search
+		}
+		search.err = err
+		if attempt == bulkQueryAttempts {
+			logf(ctx, "bulk subscribe search failed: title=%q attempts=%d: %s", title, attempt, err)
+			return search
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return search
+		}
+		backoff *= 2
+	}
+}
+
+// bulkSummary formats one section of the bulk subscribe summary, empty
+// sections are omitted.
+func bulkSummary(header string, titles []string) string {
+	if len(titles) == 0 {
+		return ""
+	}
+	text := header + "\n"
+	for _, t := range titles {
+		text += "- " + escapeMarkdown(t) + "\n"
+	}
+	return text + "\n"
+}
+
+var (
+	tmdbListURL       = regexp.MustCompile(`themoviedb\.org/list/([0-9]+)`)
+	letterboxdListURL = regexp.MustCompile(`letterboxd\.com/`)
+)
+
+// parseTMDBListID extracts the list ID from a TMDB list URL such as
+// https://www.themoviedb.org/list/12345-my-list.
+func parseTMDBListID(rawURL string) (int64, bool) {
+	m := tmdbListURL.FindStringSubmatch(rawURL)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func handleImport(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	rawURL := matches[1]
+
+	if letterboxdListURL.MatchString(rawURL) {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I can't read Letterboxd lists yet 😕 Import your watchlist into a TMDB list and send me its URL instead."))
+		return
+	}
+
+	listID, ok := parseTMDBListID(rawURL)
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Send me the URL of a TMDB list, e.g. \"import https://www.themoviedb.org/list/12345\"."))
+		return
+	}
+
+	list, err := movieList(ctx, listID)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get movie list")
+		return
+	}
+
+	now := time.Now()
+	thread := receivedTopics.thread(update.Message)
+	var subscribed, already, skipped []string
+	for _, m := range list.Items {
+		// Lists can contain TV shows too
+		if m.MediaType != "" && m.MediaType != "movie" {
+			continue
+		}
+		if !m.ReleaseTime.After(now) {
+			skipped = append(skipped, m.Title)
+			continue
+		}
+		existing, err := subscribeChat(ctx, chatID, newMovieRelease(m.MovieAPIResult), 0, regionDate{}, thread)
+		if err != nil {
+			storeFailed(ctx, chatID, err, "failed to subscribe to movie release")
+			return
+		}
+		if existing != "" {
+			already = append(already, existing)
+			continue
+		}
+		subscribed = append(subscribed, m.Title)
+	}
+
+	text := fmt.Sprintf("Imported %q: %d subscribed, %d already subscribed, %d skipped.\n\n", list.Name, len(subscribed), len(already), len(skipped)) +
+		bulkSummary("Subscribed ✅", subscribed) +
+		bulkSummary("Already subscribed 👌", already) +
+		bulkSummary("Skipped, already released or no release date 🤷", skipped)
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ParseMode = "Markdown"
+	sendMsg(ctx, msgConfig)
+}
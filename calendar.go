@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	calendarCallbackPath = "/calendar/callback"
+	calendarEventsURL    = "https://www.googleapis.com/calendar/v3/calendars/primary/events"
+	calendarRevokeURL    = "https://oauth2.googleapis.com/revoke"
+	calendarScope        = "https://www.googleapis.com/auth/calendar.events"
+	calendarCallTimeout  = 10 * time.Second
+)
+
+// errCalendarRevoked is returned when the user revoked the access of the bot
+// to their calendar, or the token expired for good.
+var errCalendarRevoked = errors.New("calendar access revoked")
+
+// calendarConfig is the OAuth configuration of the Google Calendar
+// integration, nil when not configured.
+type calendarConfig struct {
+	oauth *oauth2.Config
+	// key encrypts the tokens of the users at rest, see sealToken.
+	key []byte
+}
+
+var calendar *calendarConfig
+
+// loadCalendarConfig returns the Google Calendar configuration from
+// GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET and CALENDAR_TOKEN_KEY, a base64
+// encoded 32 bytes key. It returns nil when any of them is missing or
+// invalid, the integration is then unavailable.
+func loadCalendarConfig(getenv func(string) string, host string) *calendarConfig {
+	clientID := getenv("GOOGLE_CLIENT_ID")
+	clientSecret := getenv("GOOGLE_CLIENT_SECRET")
+	rawKey := getenv("CALENDAR_TOKEN_KEY")
+	if clientID == "" && clientSecret == "" && rawKey == "" {
+		return nil
+	}
+	if clientID == "" || clientSecret == "" {
+		log.Printf("WARNING: GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET must both be set, Google Calendar is disabled")
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(rawKey)
+	if err != nil || len(key) != 32 {
+		log.Printf("WARNING: invalid CALENDAR_TOKEN_KEY, expected 32 base64 encoded bytes, Google Calendar is disabled")
+		return nil
+	}
+	return &calendarConfig{
+		oauth: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     google.Endpoint,
+			RedirectURL:  strings.TrimSuffix(host, "/") + calendarCallbackPath,
+			Scopes:       []string{calendarScope},
+		},
+		key: key,
+	}
+}
+
+// sealToken encrypts the token with AES-GCM, see sealSecret.
+func (c *calendarConfig) sealToken(token *oauth2.Token) ([]byte, error) {
+	plain, err := json.Marshal(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode token")
+	}
+	return sealSecret(c.key, plain)
+}
+
+// openToken decrypts a token encrypted by sealToken.
+func (c *calendarConfig) openToken(sealed []byte) (*oauth2.Token, error) {
+	plain, err := openSecret(c.key, sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt token")
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(plain, &token); err != nil {
+		return nil, errors.Wrap(err, "failed to decode token")
+	}
+	return &token, nil
+}
+
+// sealSecret encrypts plain with AES-GCM and the 32 bytes key, the nonce is
+// prepended to the result.
+func sealSecret(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+// openSecret decrypts a secret encrypted by sealSecret with the same key.
+func openSecret(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed secret too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+// calendarState returns the OAuth state identifying the chat connecting its
+// calendar, "<chat ID>:<random nonce>".
+func calendarState(chatID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate state")
+	}
+	return fmt.Sprintf("%d:%s", chatID, hex.EncodeToString(b)), nil
+}
+
+// calendarSession calls the Google Calendar API on behalf of a chat. The
+// OAuth token is refreshed as needed, see save.
+type calendarSession struct {
+	client *http.Client
+	source oauth2.TokenSource
+	token  *oauth2.Token
+}
+
+func newCalendarSession(ctx context.Context, link CalendarLink) (*calendarSession, error) {
+	token, err := calendar.openToken(link.Token)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: calendarCallTimeout})
+	source := calendar.oauth.TokenSource(ctx, token)
+	return &calendarSession{
+		client: oauth2.NewClient(ctx, source),
+		source: source,
+		token:  token,
+	}, nil
+}
+
+// save stores the refreshed OAuth token into the link, if it changed.
+func (s *calendarSession) save(link *CalendarLink) error {
+	token, err := s.source.Token()
+	if err != nil || token.AccessToken == s.token.AccessToken {
+		return nil
+	}
+	sealed, err := calendar.sealToken(token)
+	if err != nil {
+		return err
+	}
+	link.Token = sealed
+	s.token = token
+	return nil
+}
+
+// calendarEventBody is the Google Calendar event of a release, an all day
+// event.
+type calendarEventBody struct {
+	Summary     string            `json:"summary"`
+	Description string            `json:"description"`
+	Start       calendarEventDate `json:"start"`
+	End         calendarEventDate `json:"end"`
+}
+
+type calendarEventDate struct {
+	Date string `json:"date"`
+}
+
+func releaseEvent(rec MovieRelease) calendarEventBody {
+	return calendarEventBody{
+		Summary:     "🎬 " + rec.MovieTitle + " release",
+		Description: fmt.Sprintf("https://www.themoviedb.org/movie/%d", rec.ID),
+		Start:       calendarEventDate{Date: rec.ReleaseDate.Format("2006-01-02")},
+		End:         calendarEventDate{Date: rec.ReleaseDate.AddDate(0, 0, 1).Format("2006-01-02")},
+	}
+}
+
+// call sends a request to the Google Calendar API and decodes the response
+// into out, if not nil. Missing events are reported as found=false.
+func (s *calendarSession) call(ctx context.Context, method, endpoint string, body interface{}, out interface{}) (found bool, err error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to encode event")
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create request")
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if tokenRevoked(err) {
+			return false, errCalendarRevoked
+		}
+		return false, errors.Wrap(err, "calendar request failed")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return false, errCalendarRevoked
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return false, nil
+	case resp.StatusCode >= 300:
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, errors.Errorf("calendar API returned %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, errors.Wrap(err, "failed to decode calendar response")
+		}
+	}
+	return true, nil
+}
+
+// tokenRevoked returns whether err is the refusal to refresh the OAuth token,
+// as opposed to the token endpoint being unavailable.
+func tokenRevoked(err error) bool {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return false
+	}
+	retrieveErr, ok := urlErr.Err.(*oauth2.RetrieveError)
+	if !ok || retrieveErr.Response == nil {
+		return false
+	}
+	code := retrieveErr.Response.StatusCode
+	return code == http.StatusBadRequest || code == http.StatusUnauthorized
+}
+
+func (s *calendarSession) insertEvent(ctx context.Context, rec MovieRelease) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	if _, err := s.call(ctx, http.MethodPost, calendarEventsURL, releaseEvent(rec), &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (s *calendarSession) updateEvent(ctx context.Context, eventID string, rec MovieRelease) (bool, error) {
+	return s.call(ctx, http.MethodPut, calendarEventsURL+"/"+url.PathEscape(eventID), releaseEvent(rec), nil)
+}
+
+func (s *calendarSession) deleteEvent(ctx context.Context, eventID string) error {
+	_, err := s.call(ctx, http.MethodDelete, calendarEventsURL+"/"+url.PathEscape(eventID), nil, nil)
+	return err
+}
+
+// calendarSyncResult counts the events changed by syncCalendar.
+type calendarSyncResult struct {
+	Created, Updated, Deleted int
+}
+
+// syncCalendar brings the calendar events of the link in line with the
+// subscriptions of the chat: events are created for dated releases, updated
+// when the title or date changed, and deleted when the subscription is gone
+// or lost its date. The events of the link are updated as they are synced,
+// so that a partial sync is picked up where it stopped by the next one.
+// Subscriptions following a region get the release date there.
+func syncCalendar(ctx context.Context, link *CalendarLink, subscriptions []MovieRelease) (result calendarSyncResult, err error) {
+	subscriptions = regionalSubscriptions(subscriptions, link.ChatID)
+	session, err := newCalendarSession(ctx, *link)
+	if err != nil {
+		return result, err
+	}
+
+	dated := map[int64]MovieRelease{}
+	for _, rec := range subscriptions {
+		if !rec.ReleaseDate.IsZero() {
+			dated[rec.ID] = rec
+		}
+	}
+
+	var events []CalendarEvent
+	synced := map[int64]bool{}
+	defer func() {
+		link.Events = events
+		if saveErr := session.save(link); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	for i, event := range link.Events {
+		rec, ok := dated[event.MovieID]
+		if !ok || synced[event.MovieID] {
+			if err := session.deleteEvent(ctx, event.EventID); err != nil {
+				events = append(events, link.Events[i:]...)
+				return result, err
+			}
+			result.Deleted++
+			continue
+		}
+		synced[event.MovieID] = true
+
+		if event.Title != rec.MovieTitle || !event.Date.Equal(rec.ReleaseDate) {
+			found, err := session.updateEvent(ctx, event.EventID, rec)
+			if err != nil {
+				events = append(events, link.Events[i:]...)
+				return result, err
+			}
+			if !found {
+				// Deleted by the user, created again below
+				synced[event.MovieID] = false
+				continue
+			}
+			event.Title, event.Date = rec.MovieTitle, rec.ReleaseDate
+			result.Updated++
+		}
+		events = append(events, event)
+	}
+
+	for _, rec := range subscriptions {
+		if synced[rec.ID] || rec.ReleaseDate.IsZero() {
+			continue
+		}
+		eventID, err := session.insertEvent(ctx, rec)
+		if err != nil {
+			return result, err
+		}
+		events = append(events, CalendarEvent{
+			MovieID: rec.ID,
+			EventID: eventID,
+			Title:   rec.MovieTitle,
+			Date:    rec.ReleaseDate,
+		})
+		synced[rec.ID] = true
+		result.Created++
+	}
+
+	return result, nil
+}
+
+// revokeCalendar revokes the OAuth token of the link, so that the bot can no
+// longer access the calendar.
+func revokeCalendar(ctx context.Context, link CalendarLink) error {
+	token, err := calendar.openToken(link.Token)
+	if err != nil {
+		return err
+	}
+	revoke := token.RefreshToken
+	if revoke == "" {
+		revoke = token.AccessToken
+	}
+	req, err := http.NewRequest(http.MethodPost, calendarRevokeURL, strings.NewReader(url.Values{"token": {revoke}}.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{Timeout: calendarCallTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "revoke request failed")
+	}
+	defer resp.Body.Close()
+	// Already revoked tokens are rejected as invalid
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return errors.Errorf("revoke returned %s", resp.Status)
+	}
+	return nil
+}
+
+// calendarRevoked forgets the link of a chat whose calendar access was
+// revoked, and tells the chat.
+func calendarRevoked(ctx context.Context, chatID int64) error {
+	if err := store.DeleteCalendarLink(ctx, chatID); err != nil {
+		return err
+	}
+	logf(ctx, "calendar access revoked: chat_id=%d", chatID)
+	text := "I lost access to your Google Calendar, release dates are no longer synced. Send \"connect calendar\" to connect it again."
+	if err := sendNotification(ctx, Subscriber{ChatID: chatID}, text); err != nil {
+		logf(ctx, "failed to tell chat %d its calendar access was revoked: %s", chatID, err)
+	}
+	return nil
+}
+
+// refreshCalendars syncs the calendar of every connected chat after the
+// releases have been refreshed, see handleTaskRefresh.
+func refreshCalendars(ctx context.Context) {
+	if calendar == nil {
+		return
+	}
+	links, err := store.CalendarLinks(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get calendar links")
+		return
+	}
+	if len(links) == 0 {
+		return
+	}
+	records, err := store.Releases(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all subscriptions")
+		return
+	}
+
+	for _, link := range links {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping calendar sync: %s", ctx.Err())
+			return
+		}
+		if len(link.Token) == 0 {
+			continue
+		}
+
+		var subscriptions []MovieRelease
+		for _, rec := range records {
+			for _, sub := range rec.Subscribers {
+				if sub.ChatID == link.ChatID {
+					subscriptions = append(subscriptions, rec)
+					break
+				}
+			}
+		}
+
+		result, err := syncCalendar(ctx, &link, subscriptions)
+		if err == errCalendarRevoked {
+			if err := calendarRevoked(ctx, link.ChatID); err != nil {
+				jobStoreFailed(ctx, err, "failed to delete calendar link")
+				return
+			}
+			continue
+		}
+		if err != nil {
+			logf(ctx, "failed to sync calendar: chat_id=%d: %s", link.ChatID, err)
+		}
+		if err := store.PutCalendarLink(ctx, link); err != nil {
+			jobStoreFailed(ctx, err, "failed to save calendar link")
+			return
+		}
+		logf(ctx, "synced calendar: chat_id=%d created=%d updated=%d deleted=%d", link.ChatID, result.Created, result.Updated, result.Deleted)
+	}
+}
+
+func handleCalendar(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	if !requireFeature(ctx, chatID, featureCalendar) {
+		return
+	}
+	if calendar == nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Sorry, Google Calendar isn't configured here."))
+		return
+	}
+
+	switch matches[1] {
+	case "connect":
+		handleConnectCalendar(ctx, update)
+	case "disconnect":
+		handleDisconnectCalendar(ctx, update)
+	default:
+		handleSyncCalendar(ctx, update)
+	}
+}
+
+// handleConnectCalendar sends the link to authorize the bot to manage the
+// calendar of the user. Only private chats can be connected, the calendar is
+// the one of whoever opens the link.
+func handleConnectCalendar(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+	if !update.Message.Chat.IsPrivate() {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Google Calendar can only be connected in a private chat with me."))
+		return
+	}
+
+	link, err := store.CalendarLink(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get calendar link")
+		return
+	}
+	state, err := calendarState(chatID)
+	if err != nil {
+		fatalf(ctx, "failed to create calendar state: %s", err)
+	}
+	link.State = state
+	if err := store.PutCalendarLink(ctx, link); err != nil {
+		storeFailed(ctx, chatID, err, "failed to save calendar link")
+		return
+	}
+
+	authURL := calendar.oauth.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	msg := telegram.NewMessage(chatID, "Allow me to add the release dates of your subscriptions to your Google Calendar, I keep the events up to date when dates change.")
+	msg.ReplyMarkup = telegram.NewInlineKeyboardMarkup(
+		telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonURL("Connect Google Calendar", authURL)),
+	)
+	sendMsg(ctx, msg)
+}
+
+func handleDisconnectCalendar(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	link, err := store.CalendarLink(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get calendar link")
+		return
+	}
+	if len(link.Token) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Your Google Calendar isn't connected."))
+		return
+	}
+	if err := revokeCalendar(ctx, link); err != nil {
+		logf(ctx, "failed to revoke calendar token: chat_id=%d: %s", chatID, err)
+	}
+	if err := store.DeleteCalendarLink(ctx, chatID); err != nil {
+		storeFailed(ctx, chatID, err, "failed to delete calendar link")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Your Google Calendar is disconnected. The events already created are kept."))
+}
+
+func handleSyncCalendar(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	link, err := store.CalendarLink(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get calendar link")
+		return
+	}
+	if len(link.Token) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Your Google Calendar isn't connected, send \"connect calendar\" first."))
+		return
+	}
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+
+	result, syncErr := syncCalendar(ctx, &link, subscriptions)
+	if syncErr == errCalendarRevoked {
+		if err := calendarRevoked(ctx, chatID); err != nil {
+			storeFailed(ctx, chatID, err, "failed to delete calendar link")
+		}
+		return
+	}
+	if err := store.PutCalendarLink(ctx, link); err != nil {
+		storeFailed(ctx, chatID, err, "failed to save calendar link")
+		return
+	}
+	if syncErr != nil {
+		logf(ctx, "failed to sync calendar: chat_id=%d: %s", chatID, syncErr)
+		sendMsg(ctx, telegram.NewMessage(chatID, "I couldn't reach Google Calendar, please try again later."))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Your Google Calendar is in sync: %d added, %d updated, %d removed.", result.Created, result.Updated, result.Deleted)))
+}
+
+// handleCalendarCallback completes the OAuth flow started by
+// handleConnectCalendar, then runs a first sync.
+func handleCalendarCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := withRequestID(r.Context(), newRequestID())
+	if calendar == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := r.FormValue("state")
+	parts := strings.SplitN(state, ":", 2)
+	chatID, err := strconv.ParseInt(parts[0], 10, 64)
+	if len(parts) != 2 || err != nil {
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	link, err := store.CalendarLink(ctx, chatID)
+	if err != nil {
+		logf(ctx, "failed to get calendar link: %s", err)
+		http.Error(w, "failed to get calendar link, please try again", http.StatusServiceUnavailable)
+		return
+	}
+	if link.State == "" || subtle.ConstantTimeCompare([]byte(link.State), []byte(state)) != 1 {
+		http.Error(w, "this link expired, send \"connect calendar\" to the bot again", http.StatusBadRequest)
+		return
+	}
+
+	if reason := r.FormValue("error"); reason != "" {
+		logf(ctx, "calendar authorization denied: chat_id=%d: %s", chatID, reason)
+		fmt.Fprintln(w, "Google Calendar wasn't connected, you can close this page.")
+		return
+	}
+
+	exchangeCtx := context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: calendarCallTimeout})
+	token, err := calendar.oauth.Exchange(exchangeCtx, r.FormValue("code"))
+	if err != nil {
+		logf(ctx, "failed to exchange calendar code: chat_id=%d: %s", chatID, err)
+		http.Error(w, "failed to connect Google Calendar, please try again", http.StatusBadGateway)
+		return
+	}
+	sealed, err := calendar.sealToken(token)
+	if err != nil {
+		fatalf(ctx, "failed to seal calendar token: %s", err)
+	}
+	link.State = ""
+	link.Token = sealed
+	link.ConnectedAt = time.Now()
+	if err := store.PutCalendarLink(ctx, link); err != nil {
+		logf(ctx, "failed to save calendar link: %s", err)
+		http.Error(w, "failed to save the connection, please try again", http.StatusServiceUnavailable)
+		return
+	}
+	logf(ctx, "calendar connected: chat_id=%d", chatID)
+	fmt.Fprintln(w, "Google Calendar connected, you can go back to Telegram.")
+
+	text := "Your Google Calendar is connected, I'll add your subscriptions to it shortly."
+	if subscriptions, err := chatSubscriptions(ctx, chatID); err == nil {
+		result, err := syncCalendar(ctx, &link, subscriptions)
+		if err != nil {
+			logf(ctx, "failed to sync calendar: chat_id=%d: %s", chatID, err)
+		} else {
+			text = fmt.Sprintf("Your Google Calendar is connected, I added %d releases to it.", result.Created)
+		}
+		if err := store.PutCalendarLink(ctx, link); err != nil {
+			logf(ctx, "failed to save calendar link: %s", err)
+		}
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
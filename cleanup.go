@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// defaultRetentionDays is how many days records of released movies are kept
+// once all their subscribers have been notified, unless
+// RELEASE_RETENTION_DAYS is set.
+const defaultRetentionDays = 30
+
+var releaseRetention = parseRetention("")
+
+// parseRetention parses the retention period, in days.
+func parseRetention(days string) time.Duration {
+	n := defaultRetentionDays
+	if days != "" {
+		parsed, err := strconv.Atoi(days)
+		if err != nil || parsed < 0 {
+			log.Printf("WARNING: invalid RELEASE_RETENTION_DAYS %q, keeping records %d days", days, defaultRetentionDays)
+		} else {
+			n = parsed
+		}
+	}
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// releaseExpired returns whether the record can be deleted: the movie came
+// out more than retention ago and every subscriber has been notified.
+// Records without a known release date are kept.
+func releaseExpired(record MovieRelease, retention time.Duration, now time.Time) bool {
+	if record.ReleaseDate.IsZero() || !now.After(record.ReleaseDate.Add(retention)) {
+		return false
+	}
+	for _, sub := range record.Subscribers {
+		if !sub.Notified {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanupReleases deletes the expired movie release records, see
+// releaseExpired.
+func cleanupReleases(ctx context.Context) {
+	records, err := store.Releases(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all subscriptions")
+		return
+	}
+
+	now := time.Now()
+	deleted := 0
+	for _, record := range records {
+		if !releaseExpired(record, releaseRetention, now) {
+			continue
+		}
+		// Checked again within the transaction, a subscriber might have
+		// been added since
+		ok, err := store.DeleteRelease(ctx, record.ID, func(stored MovieRelease) bool {
+			return releaseExpired(stored, releaseRetention, now)
+		})
+		if err != nil {
+			jobStoreFailed(ctx, err, "failed to delete movie release")
+			return
+		}
+		if ok {
+			deleted++
+		}
+	}
+	logf(ctx, "cleaned up released movies: deleted=%d", deleted)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		days string
+		want time.Duration
+	}{
+		{"", defaultRetentionDays * 24 * time.Hour},
+		{"7", 7 * 24 * time.Hour},
+		{"0", 0},
+		{"-1", defaultRetentionDays * 24 * time.Hour},
+		{"a week", defaultRetentionDays * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := parseRetention(tt.days); got != tt.want {
+			t.Errorf("parseRetention(%q) = %s, want %s", tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseExpired(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	retention := 30 * 24 * time.Hour
+	notified := []Subscriber{{ChatID: 1, Notified: true}, {ChatID: 2, Notified: true}}
+	pending := []Subscriber{{ChatID: 1, Notified: true}, {ChatID: 2}}
+
+	tests := []struct {
+		name   string
+		record MovieRelease
+		want   bool
+	}{
+		{"released long ago, all notified", MovieRelease{ReleaseDate: now.AddDate(0, -2, 0), Subscribers: notified}, true},
+		{"released long ago, no subscribers", MovieRelease{ReleaseDate: now.AddDate(0, -2, 0)}, true},
+		{"released long ago, one pending", MovieRelease{ReleaseDate: now.AddDate(0, -2, 0), Subscribers: pending}, false},
+		{"released within retention", MovieRelease{ReleaseDate: now.AddDate(0, 0, -10), Subscribers: notified}, false},
+		{"released exactly retention ago", MovieRelease{ReleaseDate: now.Add(-retention), Subscribers: notified}, false},
+		{"upcoming", MovieRelease{ReleaseDate: now.AddDate(0, 1, 0), Subscribers: notified}, false},
+		{"no release date", MovieRelease{Subscribers: notified}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := releaseExpired(tt.record, retention, now); got != tt.want {
+				t.Errorf("releaseExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupReleases(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	old := time.Now().Add(-releaseRetention).AddDate(0, 0, -1)
+
+	records := []MovieRelease{
+		{ID: 1, MovieTitle: "Dune", ReleaseDate: old, Subscribers: []Subscriber{{ChatID: 42, Notified: true}}},
+		{ID: 2, MovieTitle: "Alien", ReleaseDate: old, Subscribers: []Subscriber{{ChatID: 42}}},
+		{ID: 3, MovieTitle: "Heat", ReleaseDate: time.Now().AddDate(0, 1, 0), Subscribers: []Subscriber{{ChatID: 42}}},
+	}
+	for _, r := range records {
+		if err := store.PutRelease(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanupReleases(ctx)
+
+	for id, want := range map[int64]bool{1: false, 2: true, 3: true} {
+		if _, ok := s.releases[id]; ok != want {
+			t.Errorf("release %d kept = %v, want %v", id, ok, want)
+		}
+	}
+}
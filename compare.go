@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// defaultCompareRegions are the regions shown by the compare command when
+// COMPARE_REGIONS is not set.
+const defaultCompareRegions = "DE,US,GB,FR,JP"
+
+var compareRegions = parseRegions("")
+
+// parseRegions parses a comma separated list of regions, e.g. "DE,US".
+// An empty list gives defaultCompareRegions, unknown regions are skipped.
+func parseRegions(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		list = defaultCompareRegions
+	}
+	var regions []string
+	for _, r := range strings.Split(list, ",") {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+		code, err := normalizeRegion(r)
+		if err != nil {
+			log.Printf("WARNING: ignoring region in COMPARE_REGIONS: %s", err)
+			continue
+		}
+		regions = append(regions, code)
+	}
+	return regions
+}
+
+// regionDate is the release date of a movie in a region.
+type regionDate struct {
+	Region string
+	Date   time.Time
+	// Group is the region group the date is the earliest of, empty for a
+	// single region.
+	Group []string
+	// Policy is the date policy that picked the date, empty when chosen by
+	// the chat.
+	Policy string
+}
+
+// compareReleaseDates returns the release dates of the given regions,
+// earliest first. Regions without a date are omitted.
+func compareReleaseDates(dates map[string]time.Time, regions []string) []regionDate {
+	var compared []regionDate
+	for _, r := range regions {
+		if d, ok := dates[r]; ok {
+			compared = append(compared, regionDate{Region: r, Date: d})
+		}
+	}
+	sort.SliceStable(compared, func(i, j int) bool {
+		return compared[i].Date.Before(compared[j].Date)
+	})
+	return compared
+}
+
+func handleCompare(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	title := strings.TrimSpace(matches[1])
+
+	results, err := queryMovies(ctx, title, "")
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to search movies")
+		return
+	}
+
+	match, ok := bestMatch(results, title)
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "No entry found 🤓"))
+		return
+	}
+
+	dates, err := movieReleaseDates(ctx, match.ID)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get release dates")
+		return
+	}
+
+	compared := compareReleaseDates(dates, compareRegions)
+	if len(compared) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "No release dates known yet for "+match.Title+" in "+strings.Join(compareRegions, ", ")+"."))
+		return
+	}
+
+	text := "Release dates of " + match.Title + ":\n"
+	for _, c := range compared {
+		text += fmt.Sprintf("%s %s\n", regionLabel(c.Region), formatReleaseDate(c.Date))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// defaultConfirmInterval is the minimum delay between two subscribe
+// confirmations sent to a chat, unless CONFIRM_INTERVAL_SECONDS is set.
+const defaultConfirmInterval = 10 * time.Second
+
+// parseConfirmInterval parses the minimum delay between subscribe
+// confirmations, in seconds. Zero sends every confirmation right away.
+func parseConfirmInterval(seconds string) time.Duration {
+	if seconds == "" {
+		return defaultConfirmInterval
+	}
+	n, err := strconv.Atoi(seconds)
+	if err != nil || n < 0 {
+		log.Printf("WARNING: invalid CONFIRM_INTERVAL_SECONDS %q, using %s", seconds, defaultConfirmInterval)
+		return defaultConfirmInterval
+	}
+	return time.Duration(n) * time.Second
+}
+
+// subscribeConfirmation confirms a single subscription, note tells about
+// its reminder or region, if any. The poster and release date make the card
+// of chats with posters on.
+type subscribeConfirmation struct {
+	title      string
+	note       string
+	posterPath string
+	date       time.Time
+}
+
+type chatConfirmations struct {
+	sent    time.Time
+	pending []subscribeConfirmation
+	timer   *time.Timer
+}
+
+// confirmationBatcher paces the subscribe confirmations of each chat: the
+// first one is sent right away, the ones following within interval are
+// batched into a single message sent once the interval is over.
+type confirmationBatcher struct {
+	mu       sync.Mutex
+	interval time.Duration
+	chats    map[int64]*chatConfirmations
+}
+
+var subscribeConfirmations = &confirmationBatcher{interval: defaultConfirmInterval, chats: map[int64]*chatConfirmations{}}
+
+// add sends the confirmation to the chat, or batches it with the next ones.
+func (b *confirmationBatcher) add(ctx context.Context, chatID int64, c subscribeConfirmation) {
+	now := time.Now()
+
+	b.mu.Lock()
+	for id, chat := range b.chats {
+		if chat.timer == nil && now.Sub(chat.sent) >= b.interval {
+			delete(b.chats, id)
+		}
+	}
+	chat, ok := b.chats[chatID]
+	if ok {
+		chat.pending = append(chat.pending, c)
+		if chat.timer == nil {
+			chat.timer = time.AfterFunc(chat.sent.Add(b.interval).Sub(now), func() {
+				b.flush(withRequestID(context.Background(), newRequestID()), chatID)
+			})
+		}
+		b.mu.Unlock()
+		return
+	}
+	if b.interval > 0 {
+		b.chats[chatID] = &chatConfirmations{sent: now}
+	}
+	b.mu.Unlock()
+
+	sendConfirmations(ctx, chatID, []subscribeConfirmation{c}, false)
+}
+
+// flush sends the batched confirmations of the chat.
+func (b *confirmationBatcher) flush(ctx context.Context, chatID int64) {
+	b.mu.Lock()
+	chat, ok := b.chats[chatID]
+	if !ok || len(chat.pending) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	pending := chat.pending
+	chat.pending = nil
+	if chat.timer != nil {
+		chat.timer.Stop()
+		chat.timer = nil
+	}
+	chat.sent = time.Now()
+	b.mu.Unlock()
+
+	sendConfirmations(ctx, chatID, pending, true)
+}
+
+// flushAll sends every batched confirmation, before the process exits.
+func (b *confirmationBatcher) flushAll(ctx context.Context) {
+	b.mu.Lock()
+	var chatIDs []int64
+	for id := range b.chats {
+		chatIDs = append(chatIDs, id)
+	}
+	b.mu.Unlock()
+
+	for _, id := range chatIDs {
+		b.flush(ctx, id)
+	}
+}
+
+// sendConfirmations sends a single message confirming the subscriptions,
+// batched is set when they are sent after the commands that subscribed.
+// Failures are only logged, the subscriptions are already stored.
+func sendConfirmations(ctx context.Context, chatID int64, confirmations []subscribeConfirmation, batched bool) {
+	if len(confirmations) == 1 && !batched && sendConfirmationCard(ctx, chatID, confirmations[0]) {
+		return
+	}
+
+	var text string
+	if len(confirmations) == 1 {
+		text = "Done!"
+		if batched {
+			text = "Subscribed to " + confirmations[0].title + " too!"
+		}
+		if note := confirmations[0].note; note != "" {
+			text += " " + note
+		}
+	} else {
+		text = fmt.Sprintf("Subscribed to %d movies:\n", len(confirmations))
+		for _, c := range confirmations {
+			text += "- " + c.title
+			if c.note != "" {
+				text += ": " + c.note
+			}
+			text += "\n"
+		}
+	}
+	if _, err := trySendMsg(ctx, telegram.NewMessage(chatID, text)); err != nil {
+		logf(ctx, "failed to confirm subscriptions: count=%d: %s", len(confirmations), err)
+	}
+}
+
+// sendConfirmationCard confirms the subscription with the poster of the movie
+// captioned by confirmationCard, for chats with posters on. It returns false
+// when the text confirmation must be sent instead: posters off, no poster or
+// the photo failed to send.
+func sendConfirmationCard(ctx context.Context, chatID int64, c subscribeConfirmation) bool {
+	if c.posterPath == "" {
+		return false
+	}
+	prefs := prefsOrDefault(ctx, chatID)
+	if !prefs.Posters {
+		return false
+	}
+
+	photo := telegram.NewPhotoShare(chatID, MovieAPIResult{PosterPath: c.posterPath}.PosterURL())
+	photo.Caption = confirmationCard(c, prefs, time.Now())
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.Send(photo); err != nil {
+		logf(ctx, "failed to send confirmation card, confirming with text: %s", err)
+		return false
+	}
+	return true
+}
+
+// confirmationCard returns the caption of the poster confirming the
+// subscription: the title, the release date and the days left.
+func confirmationCard(c subscribeConfirmation, prefs UserPrefs, now time.Time) string {
+	text := "Subscribed to " + c.title + " 🔔\n"
+	if c.date.IsZero() {
+		text += "📅 Release date unknown\n"
+	} else {
+		text += "📅 " + prefs.formatDate(c.date) + "\n"
+		switch days := daysUntil(c.date, now, prefs.location()); {
+		case days <= 0:
+			text += "🍿 Out now\n"
+		case days == 1:
+			text += "⏳ 1 day to go\n"
+		default:
+			text += fmt.Sprintf("⏳ %d days to go\n", days)
+		}
+	}
+	if c.note != "" {
+		text += c.note
+	}
+	return strings.TrimSpace(text)
+}
+
+func handlePosters(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.Posters = enabled
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "Subscriptions will be confirmed with a short text."
+	if enabled {
+		text = "Subscriptions will be confirmed with the poster of the movie."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+// flushOnShutdown sends the batched confirmations when the process is asked
+// to stop, then exits.
+func flushOnShutdown() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+
+	ctx := withRequestID(context.Background(), newRequestID())
+	logf(ctx, "shutting down, sending batched confirmations")
+	subscribeConfirmations.flushAll(ctx)
+	os.Exit(0)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmationCard(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		c    subscribeConfirmation
+		want string
+	}{
+		{
+			subscribeConfirmation{title: "Dune", date: time.Date(2021, 3, 11, 0, 0, 0, 0, time.UTC)},
+			"Subscribed to Dune 🔔\n📅 " + UserPrefs{}.formatDate(time.Date(2021, 3, 11, 0, 0, 0, 0, time.UTC)) + "\n⏳ 10 days to go",
+		},
+		{
+			subscribeConfirmation{title: "Dune", date: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)},
+			"Subscribed to Dune 🔔\n📅 " + UserPrefs{}.formatDate(time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)) + "\n⏳ 1 day to go",
+		},
+		{
+			subscribeConfirmation{title: "Dune", date: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+			"Subscribed to Dune 🔔\n📅 " + UserPrefs{}.formatDate(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) + "\n🍿 Out now",
+		},
+		{
+			subscribeConfirmation{title: "Dune", note: "I'll remind you a week before."},
+			"Subscribed to Dune 🔔\n📅 Release date unknown\nI'll remind you a week before.",
+		},
+	}
+	for _, tt := range tests {
+		if got := confirmationCard(tt.c, UserPrefs{}, now); got != tt.want {
+			t.Errorf("confirmationCard(%+v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestSendConfirmationsFallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		posters    bool
+		posterPath string
+		photoFails bool
+		wantCard   bool
+	}{
+		{"card", true, "/dune.jpg", false, true},
+		{"no poster", true, "", false, false},
+		{"posters off", false, "/dune.jpg", false, false},
+		{"photo refused", true, "/dune.jpg", true, false},
+	}
+	for _, tt := range tests {
+		s := useMemStore(t)
+		s.prefs[42] = UserPrefs{ChatID: 42, Posters: tt.posters}
+		tg := useFakeTelegram(t)
+		if tt.photoFails {
+			tg.fail = func(call telegramCall) string {
+				if call.Method == "sendPhoto" {
+					return "Bad Request: wrong file identifier/HTTP URL specified"
+				}
+				return ""
+			}
+		}
+
+		c := subscribeConfirmation{title: "Dune", posterPath: tt.posterPath, date: time.Now().AddDate(0, 1, 0)}
+		sendConfirmations(context.Background(), 42, []subscribeConfirmation{c}, false)
+
+		photos := tg.sent("sendPhoto")
+		if tt.wantCard {
+			if len(photos) != 1 || !strings.HasPrefix(photos[0].Params.Get("caption"), "Subscribed to Dune 🔔") {
+				t.Errorf("%s: sent photos %+v, want the card", tt.name, photos)
+			}
+			if texts := tg.texts(42); len(texts) != 0 {
+				t.Errorf("%s: sent %q, want only the card", tt.name, texts)
+			}
+			continue
+		}
+		if texts := tg.texts(42); len(texts) != 1 || texts[0] != "Done!" {
+			t.Errorf("%s: sent %q, want the text confirmation", tt.name, texts)
+		}
+		if tt.posterPath == "" || !tt.posters {
+			if len(photos) != 0 {
+				t.Errorf("%s: sent photos %+v, want none", tt.name, photos)
+			}
+		}
+	}
+}
+
+func TestSendConfirmationsBatchedWithoutCard(t *testing.T) {
+	s := useMemStore(t)
+	s.prefs[42] = UserPrefs{ChatID: 42, Posters: true}
+	tg := useFakeTelegram(t)
+
+	c := subscribeConfirmation{title: "Dune", posterPath: "/dune.jpg"}
+	sendConfirmations(context.Background(), 42, []subscribeConfirmation{c}, true)
+
+	if photos := tg.sent("sendPhoto"); len(photos) != 0 {
+		t.Errorf("sent photos %+v, want batched confirmations as text", photos)
+	}
+	if texts := tg.texts(42); len(texts) != 1 || texts[0] != "Subscribed to Dune too!" {
+		t.Errorf("sent %q, want the batched text confirmation", texts)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// pendingTTL is how long the bot remembers a prompt it asked the user to
+// reply to.
+const pendingTTL = time.Hour
+
+var yearReply = regexp.MustCompile("^[0-9]{4}$")
+
+// pendingSubscribe is a subscribe request waiting for the user to pick one of
+// several candidates.
+type pendingSubscribe struct {
+	candidates []MovieRelease
+	remindDays int
+	region     string
+	created    time.Time
+}
+
+type pendingKey struct {
+	chatID    int64
+	messageID int
+}
+
+// pendingSubscribeStore keeps the pending subscribe requests in memory, keyed
+// by the ID of the prompt message the user is expected to reply to.
+type pendingSubscribeStore struct {
+	mu      sync.Mutex
+	pending map[pendingKey]pendingSubscribe
+}
+
+var pendingSubscribes = &pendingSubscribeStore{pending: map[pendingKey]pendingSubscribe{}}
+
+func (s *pendingSubscribeStore) add(chatID int64, messageID int, candidates []MovieRelease, remindDays int, region string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget about prompts nobody replied to
+	now := time.Now()
+	for k, p := range s.pending {
+		if now.Sub(p.created) > pendingTTL {
+			delete(s.pending, k)
+		}
+	}
+
+	s.pending[pendingKey{chatID, messageID}] = pendingSubscribe{candidates: candidates, remindDays: remindDays, region: region, created: now}
+}
+
+// take removes and returns the pending request for the given prompt message.
+func (s *pendingSubscribeStore) take(chatID int64, messageID int) (pendingSubscribe, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := pendingKey{chatID, messageID}
+	p, ok := s.pending[key]
+	if !ok || time.Since(p.created) > pendingTTL {
+		return pendingSubscribe{}, false
+	}
+	delete(s.pending, key)
+	return p, true
+}
+
+// handleReply handles a message replying to one of the bot prompts, treating
+// it as a refinement of the original query. It returns false if the message
+// doesn't reply to a pending prompt.
+func handleReply(ctx context.Context, msg *telegram.Message, text string) bool {
+	p, ok := pendingSubscribes.take(msg.Chat.ID, msg.ReplyToMessage.MessageID)
+	if !ok {
+		return false
+	}
+
+	subscribeToCandidates(ctx, msg, refineCandidates(p.candidates, text), p.remindDays, p.region)
+	return true
+}
+
+// refineCandidates narrows down candidates given the user reply: either the
+// number of a candidate in the prompt list, a year of release, or a part of
+// the title.
+func refineCandidates(candidates []MovieRelease, reply string) []MovieRelease {
+	if n, err := strconv.Atoi(reply); err == nil && !yearReply.MatchString(reply) {
+		if n < 1 || n > len(candidates) {
+			return nil
+		}
+		return candidates[n-1 : n]
+	}
+
+	var refined []MovieRelease
+	for _, c := range candidates {
+		if yearReply.MatchString(reply) {
+			if strconv.Itoa(c.ReleaseDate.Year()) == reply {
+				refined = append(refined, c)
+			}
+		} else if strings.Contains(strings.ToLower(c.MovieTitle), reply) {
+			refined = append(refined, c)
+		}
+	}
+	return refined
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// daysUntil returns the number of days left before the release, counted in
+// the user's timezone.
+func daysUntil(release time.Time, now time.Time, loc *time.Location) int {
+	y, m, d := now.In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	ry, rm, rd := release.UTC().Date()
+	releaseDay := time.Date(ry, rm, rd, 0, 0, 0, 0, time.UTC)
+	return int(releaseDay.Sub(today).Hours() / 24)
+}
+
+// countdownText returns the text of the countdown message.
+func countdownText(title string, days int) string {
+	switch {
+	case days <= 0:
+		return fmt.Sprintf("%s is out! 🍿", title)
+	case days == 1:
+		return fmt.Sprintf("%s: 1 day to go ⏳", title)
+	default:
+		return fmt.Sprintf("%s: %d days to go ⏳", title, days)
+	}
+}
+
+// pinMessage pins the message without notifying the chat members.
+func pinMessage(ctx context.Context, chatID int64, messageID int) error {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	_, err := bot.PinChatMessage(telegram.PinChatMessageConfig{
+		ChatID:              chatID,
+		MessageID:           messageID,
+		DisableNotification: true,
+	})
+	return errors.Wrapf(err, "failed to pin message %d in chat %d", messageID, chatID)
+}
+
+// unpinMessage unpins the given message only, other pinned messages are left
+// untouched.
+func unpinMessage(ctx context.Context, chatID int64, messageID int) error {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(chatID, 10))
+	v.Add("message_id", strconv.Itoa(messageID))
+	_, err := bot.MakeRequest("unpinChatMessage", v)
+	return errors.Wrapf(err, "failed to unpin message %d in chat %d", messageID, chatID)
+}
+
+// editMessage replaces the text of a message sent by the bot.
+func editMessage(ctx context.Context, chatID int64, messageID int, text string) error {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	_, err := bot.Send(telegram.NewEditMessageText(chatID, messageID, text))
+	return errors.Wrapf(err, "failed to edit message %d in chat %d", messageID, chatID)
+}
+
+// updateCountdown edits the subscriber countdown message, called daily by
+// the refresh job. Once the movie is released the message is unpinned and
+// the subscriber returned without countdown.
+func updateCountdown(ctx context.Context, record MovieRelease, sub Subscriber, prefs UserPrefs, now time.Time) Subscriber {
+	record = sub.regional(record)
+	if sub.CountdownMessageID == 0 || record.ReleaseDate.IsZero() {
+		return sub
+	}
+
+	days := daysUntil(record.ReleaseDate, now, prefs.location())
+	if err := editMessage(ctx, sub.ChatID, sub.CountdownMessageID, countdownText(record.MovieTitle, days)); err != nil {
+		// Telegram refuses edits that don't change the text
+		if !strings.Contains(err.Error(), "message is not modified") {
+			logf(ctx, "failed to update countdown: id=%d chat=%d: %s", record.ID, sub.ChatID, err)
+		}
+	}
+	if days > 0 {
+		return sub
+	}
+
+	if sub.CountdownPinned {
+		if err := unpinMessage(ctx, sub.ChatID, sub.CountdownMessageID); err != nil {
+			logf(ctx, "failed to unpin countdown: id=%d chat=%d: %s", record.ID, sub.ChatID, err)
+		}
+	}
+	sub.CountdownMessageID = 0
+	sub.CountdownPinned = false
+	return sub
+}
+
+func handleCountdown(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+	title := strings.TrimSpace(matches[2])
+
+	action := "countdownoff"
+	if enabled {
+		action = "countdownon"
+	}
+	actOnSubscription(ctx, chatID, title, action)
+}
+
+// countdownAction starts or stops the countdown of a subscription.
+func countdownAction(ctx context.Context, chatID int64, rec MovieRelease, enabled bool) {
+	var sub Subscriber
+	for _, s := range rec.Subscribers {
+		if s.ChatID == chatID {
+			sub = s
+		}
+	}
+	rec = sub.regional(rec)
+
+	if !enabled {
+		if sub.CountdownMessageID == 0 {
+			sendMsg(ctx, telegram.NewMessage(chatID, "There is no countdown for "+rec.MovieTitle+"."))
+			return
+		}
+		if sub.CountdownPinned {
+			if err := unpinMessage(ctx, chatID, sub.CountdownMessageID); err != nil {
+				logf(ctx, "failed to unpin countdown: id=%d chat=%d: %s", rec.ID, chatID, err)
+			}
+		}
+		err := updateSubscriber(ctx, rec.ID, chatID, func(sub *Subscriber) {
+			sub.CountdownMessageID = 0
+			sub.CountdownPinned = false
+		})
+		if err != nil {
+			storeFailed(ctx, chatID, err, "failed to update subscription")
+			return
+		}
+		sendMsg(ctx, telegram.NewMessage(chatID, "Countdown for "+rec.MovieTitle+" stopped."))
+		return
+	}
+
+	if sub.CountdownMessageID != 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "There is already a countdown for "+rec.MovieTitle+"."))
+		return
+	}
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+
+	now := time.Now()
+	if rec.ReleaseDate.IsZero() || daysUntil(rec.ReleaseDate, now, prefs.location()) <= 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "There is no upcoming release date to count down to for "+rec.MovieTitle+"."))
+		return
+	}
+
+	sent, err := trySendMsg(ctx, telegram.NewMessage(chatID, countdownText(rec.MovieTitle, daysUntil(rec.ReleaseDate, now, prefs.location()))))
+	if err != nil {
+		logf(ctx, "failed to send countdown: id=%d: %s", rec.ID, err)
+		return
+	}
+
+	// In groups the bot needs to be an admin allowed to pin messages, the
+	// countdown is still updated when it cannot be pinned
+	pinned := true
+	if err := pinMessage(ctx, chatID, sent.MessageID); err != nil {
+		logf(ctx, "failed to pin countdown: id=%d chat=%d: %s", rec.ID, chatID, err)
+		pinned = false
+	}
+
+	err = updateSubscriber(ctx, rec.ID, chatID, func(sub *Subscriber) {
+		sub.CountdownMessageID = sent.MessageID
+		sub.CountdownPinned = pinned
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to update subscription")
+		return
+	}
+
+	if !pinned {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I couldn't pin the countdown, I need to be allowed to pin messages in this chat. I'll still update it every day."))
+	}
+}
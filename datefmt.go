@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Date formats a chat can choose from.
+const (
+	dateFormatDMY = "dmy"
+	dateFormatMDY = "mdy"
+	dateFormatISO = "iso"
+)
+
+// dateLayouts maps each date format to its time layout.
+var dateLayouts = map[string]string{
+	dateFormatDMY: "2 Jan 2006",
+	dateFormatMDY: "Jan 2, 2006",
+	dateFormatISO: "2006-01-02",
+}
+
+// monthFirstRegions are the regions writing the month before the day by
+// default.
+var monthFirstRegions = map[string]bool{
+	"US": true,
+}
+
+// dateFormat returns the date format of the chat, defaulting to the one usual
+// in its region.
+func (p UserPrefs) dateFormat() string {
+	if _, ok := dateLayouts[p.DateFormat]; ok {
+		return p.DateFormat
+	}
+	if monthFirstRegions[p.region()] {
+		return dateFormatMDY
+	}
+	return dateFormatDMY
+}
+
+// formatDate formats a release date following the chat preference, zero
+// dates are unknown release dates.
+func (p UserPrefs) formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(dateLayouts[p.dateFormat()])
+}
+
+func handleDateFormat(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	var prefs UserPrefs
+	err := store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.DateFormat = matches[1]
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	example := prefs.formatDate(time.Date(2019, time.February, 14, 0, 0, 0, 0, time.UTC))
+	sendMsg(ctx, telegram.NewMessage(chatID, "Dates will be shown like "+example+"."))
+}
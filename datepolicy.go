@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// Date policies, which release date subscriptions follow when they don't
+// give a region, see UserPrefs.DatePolicy.
+const (
+	// datePolicyPrimary follows the top-level TMDB release date, the one of
+	// the primary region of the movie.
+	datePolicyPrimary = "primary"
+	// datePolicyRegion follows the release date in the region of the chat.
+	datePolicyRegion = "region"
+	// datePolicyEarliest follows the earliest release date in any region.
+	datePolicyEarliest = "earliest"
+)
+
+// datePolicy returns the date policy of the chat, datePolicyRegion unless
+// it chose another.
+func (p UserPrefs) datePolicy() string {
+	if p.DatePolicy != "" {
+		return p.DatePolicy
+	}
+	return datePolicyRegion
+}
+
+// policyRegionDate returns the release date the policy picks among the
+// regional release dates of a movie, zero while unknown. The primary policy
+// picks none, the top-level date is followed.
+func policyRegionDate(policy string, dates map[string]time.Time, region string) regionDate {
+	switch policy {
+	case datePolicyRegion:
+		return regionDate{Region: region, Date: dates[region], Policy: policy}
+	case datePolicyEarliest:
+		regions := make([]string, 0, len(dates))
+		for r := range dates {
+			regions = append(regions, r)
+		}
+		// Ties go to the same region whatever the order of the map
+		sort.Strings(regions)
+		earliest, _ := earliestRegionDate(dates, regions)
+		earliest.Policy = policy
+		return earliest
+	}
+	return regionDate{}
+}
+
+// chatPolicyDate returns the release date of the movie the date policy of
+// the chat picks. TMDB failures are only logged, the top-level date is
+// followed until the next refresh.
+func chatPolicyDate(ctx context.Context, movieID int64, prefs UserPrefs) regionDate {
+	policy := prefs.datePolicy()
+	if policy == datePolicyPrimary {
+		return regionDate{}
+	}
+	dates, err := movieReleaseDates(ctx, movieID)
+	if err != nil {
+		logf(ctx, "failed to get release dates for date policy: id=%d: %s", movieID, err)
+	}
+	return policyRegionDate(policy, dates, prefs.region())
+}
+
+// followsPolicy returns whether the subscriber follows the date policy of its
+// chat, rather than a region or a region group it chose.
+func (s Subscriber) followsPolicy() bool {
+	return s.DatePolicy != "" || (s.Region == "" && len(s.Regions) == 0)
+}
+
+// applyDatePolicy updates the release date followed by the subscriptions of
+// the chat following its date policy, each in its own transaction. It
+// returns how many were updated.
+func applyDatePolicy(ctx context.Context, chatID int64, prefs UserPrefs) (int, error) {
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get subscriptions")
+	}
+
+	updated := 0
+	for _, rec := range subscriptions {
+		follows := false
+		for _, sub := range rec.Subscribers {
+			if sub.ChatID == chatID {
+				follows = sub.followsPolicy()
+				break
+			}
+		}
+		if !follows {
+			continue
+		}
+		regional := chatPolicyDate(ctx, rec.ID, prefs)
+		err := updateSubscriber(ctx, rec.ID, chatID, func(sub *Subscriber) {
+			sub.Region = regional.Region
+			sub.RegionDate = regional.Date
+			sub.DatePolicy = regional.Policy
+		})
+		if err != nil {
+			return updated, errors.Wrapf(err, "failed to update subscription %d", rec.ID)
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// datePolicyText describes which release date the policy follows.
+func datePolicyText(policy string, prefs UserPrefs) string {
+	switch policy {
+	case datePolicyPrimary:
+		return "the primary release date on TMDB"
+	case datePolicyEarliest:
+		return "the earliest release date in any region"
+	}
+	return "the release date in " + regionLabel(prefs.region()) + " " + prefs.region()
+}
+
+func handleDatePolicy(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	policy := matches[1]
+
+	var prefs UserPrefs
+	err := store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.DatePolicy = policy
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	updated, err := applyDatePolicy(ctx, chatID, prefs)
+	if err != nil {
+		storeFailed(ctx, chatID, err, fmt.Sprintf("failed to apply date policy: updated=%d", updated))
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Your subscriptions now follow %s, unless you chose a region for them.", datePolicyText(policy, prefs))))
+}
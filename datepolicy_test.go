@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDatePolicy(t *testing.T) {
+	if got := (UserPrefs{}).datePolicy(); got != datePolicyRegion {
+		t.Errorf("default datePolicy() = %q, want %q", got, datePolicyRegion)
+	}
+	if got := (UserPrefs{DatePolicy: datePolicyEarliest}).datePolicy(); got != datePolicyEarliest {
+		t.Errorf("datePolicy() = %q, want the chosen %q", got, datePolicyEarliest)
+	}
+}
+
+func TestPolicyRegionDate(t *testing.T) {
+	march := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	dates := map[string]time.Time{"US": april, "GB": march, "DE": march}
+
+	tests := []struct {
+		policy string
+		dates  map[string]time.Time
+		region string
+		want   regionDate
+	}{
+		{datePolicyPrimary, dates, "US", regionDate{}},
+		{datePolicyRegion, dates, "US", regionDate{Region: "US", Date: april, Policy: datePolicyRegion}},
+		// Not dated in the region yet, followed once announced
+		{datePolicyRegion, dates, "FR", regionDate{Region: "FR", Policy: datePolicyRegion}},
+		// Ties go to the region sorted first
+		{datePolicyEarliest, dates, "US", regionDate{Region: "DE", Date: march, Policy: datePolicyEarliest}},
+		{datePolicyEarliest, nil, "US", regionDate{Policy: datePolicyEarliest}},
+	}
+	for _, tt := range tests {
+		got := policyRegionDate(tt.policy, tt.dates, tt.region)
+		if got.Region != tt.want.Region || !got.Date.Equal(tt.want.Date) || got.Policy != tt.want.Policy {
+			t.Errorf("policyRegionDate(%q, %q) = %+v, want %+v", tt.policy, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestFollowsPolicy(t *testing.T) {
+	tests := []struct {
+		sub  Subscriber
+		want bool
+	}{
+		{Subscriber{}, true},
+		{Subscriber{Region: "DE", DatePolicy: datePolicyRegion}, true},
+		{Subscriber{Region: "GB", DatePolicy: datePolicyEarliest}, true},
+		{Subscriber{Region: "US"}, false},
+		{Subscriber{Regions: []string{"US", "GB"}, Region: "GB"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.sub.followsPolicy(); got != tt.want {
+			t.Errorf("%+v.followsPolicy() = %t, want %t", tt.sub, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriberRegionalPolicy(t *testing.T) {
+	release := time.Date(2021, 9, 15, 0, 0, 0, 0, time.UTC)
+	regionRelease := time.Date(2021, 10, 21, 0, 0, 0, 0, time.UTC)
+	record := MovieRelease{ReleaseDate: release}
+
+	tests := []struct {
+		sub  Subscriber
+		want time.Time
+	}{
+		{Subscriber{}, release},
+		{Subscriber{Region: "DE", RegionDate: regionRelease, DatePolicy: datePolicyRegion}, regionRelease},
+		// The policy follows the top-level date until the region has one
+		{Subscriber{Region: "DE", DatePolicy: datePolicyRegion}, release},
+		// A chosen region isn't dated until announced there
+		{Subscriber{Region: "DE"}, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := tt.sub.regional(record).ReleaseDate; !got.Equal(tt.want) {
+			t.Errorf("%+v.regional() date = %s, want %s", tt.sub, got, tt.want)
+		}
+	}
+}
+
+func TestHandleSubscribeDatePolicy(t *testing.T) {
+	// Confirmations sent right away, not batched with those of other tests
+	previous := subscribeConfirmations
+	subscribeConfirmations = &confirmationBatcher{chats: map[int64]*chatConfirmations{}}
+	t.Cleanup(func() { subscribeConfirmations = previous })
+
+	primary := time.Now().AddDate(0, 3, 0).UTC().Truncate(24 * time.Hour)
+	gbRelease := time.Now().AddDate(0, 2, 0).UTC().Truncate(24 * time.Hour)
+	deRelease := time.Now().AddDate(0, 4, 0).UTC().Truncate(24 * time.Hour)
+	tests := []struct {
+		chatID     int64
+		policy     string
+		wantRegion string
+		wantDate   time.Time
+		wantPolicy string
+	}{
+		{301, datePolicyPrimary, "", time.Time{}, ""},
+		{302, "", "DE", deRelease, datePolicyRegion},
+		{303, datePolicyEarliest, "GB", gbRelease, datePolicyEarliest},
+	}
+	for _, tt := range tests {
+		s := useMemStore(t)
+		s.prefs[tt.chatID] = UserPrefs{ChatID: tt.chatID, DatePolicy: tt.policy}
+		useFakeTelegram(t)
+		api := useFakeTMDB(t)
+		api.route("/search/movie", map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"id": 438631, "title": "Dune", "release_date": primary.Format("2006-01-02")},
+			},
+		})
+		api.route("/movie/438631/release_dates", map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"iso_3166_1": "GB", "release_dates": []map[string]interface{}{
+					{"release_date": gbRelease.Format(time.RFC3339)},
+				}},
+				{"iso_3166_1": "DE", "release_dates": []map[string]interface{}{
+					{"release_date": deRelease.Format(time.RFC3339)},
+				}},
+			},
+		})
+
+		update := testMessage(tt.chatID, "subscribe to dune")
+		handleSubscribe(context.Background(), update, subscribeCommand.FindStringSubmatch(update.Message.Text))
+
+		subs := s.releases[438631].Subscribers
+		if len(subs) != 1 {
+			t.Fatalf("policy %q: subscribers = %+v, want one", tt.policy, subs)
+		}
+		sub := subs[0]
+		if sub.Region != tt.wantRegion || !sub.RegionDate.Equal(tt.wantDate) || sub.DatePolicy != tt.wantPolicy {
+			t.Errorf("policy %q: subscription follows %q %s %q, want %q %s %q", tt.policy, sub.Region, sub.RegionDate, sub.DatePolicy, tt.wantRegion, tt.wantDate, tt.wantPolicy)
+		}
+	}
+}
+
+func TestHandleDatePolicy(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+	march := time.Date(2031, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2031, 4, 1, 0, 0, 0, 0, time.UTC)
+	api.route("/movie/1/release_dates", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"iso_3166_1": "GB", "release_dates": []map[string]interface{}{{"release_date": march.Format(time.RFC3339)}}},
+			{"iso_3166_1": "DE", "release_dates": []map[string]interface{}{{"release_date": april.Format(time.RFC3339)}}},
+		},
+	})
+
+	// Release 1 follows the policy of the chat, release 2 the chosen region
+	for _, r := range []MovieRelease{
+		{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: 42, Region: "DE", RegionDate: april, DatePolicy: datePolicyRegion}}},
+		{ID: 2, MovieTitle: "Tenet", Subscribers: []Subscriber{{ChatID: 42, Region: "US", RegionDate: april}}},
+	} {
+		if err := store.PutRelease(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		policy     string
+		wantRegion string
+		wantDate   time.Time
+		wantText   string
+	}{
+		{datePolicyEarliest, "GB", march, "the earliest release date in any region"},
+		{datePolicyPrimary, "", time.Time{}, "the primary release date on TMDB"},
+		{datePolicyRegion, "DE", april, "the release date in 🇩🇪 DE"},
+	}
+	for _, tt := range tests {
+		handleUpdate(testMessage(42, "set date policy "+tt.policy))
+
+		if got := s.prefs[42].DatePolicy; got != tt.policy {
+			t.Errorf("%s: stored policy %q", tt.policy, got)
+		}
+		sub := s.releases[1].Subscribers[0]
+		if sub.Region != tt.wantRegion || !sub.RegionDate.Equal(tt.wantDate) {
+			t.Errorf("%s: subscription follows %q %s, want %q %s", tt.policy, sub.Region, sub.RegionDate, tt.wantRegion, tt.wantDate)
+		}
+		if sub := s.releases[2].Subscribers[0]; sub.Region != "US" || !sub.RegionDate.Equal(april) || sub.DatePolicy != "" {
+			t.Errorf("%s: subscription with a chosen region = %+v, want it unchanged", tt.policy, sub)
+		}
+		texts := tg.texts(42)
+		if len(texts) == 0 || !strings.Contains(texts[len(texts)-1], tt.wantText) {
+			t.Errorf("%s: sent %q, want the policy described", tt.policy, texts)
+		}
+	}
+}
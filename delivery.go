@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// deliveryTime returns the time of day the chat wants its reminders at, ok
+// is false when they are sent whenever the notify job runs.
+func (p UserPrefs) deliveryTime() (timeOfDay, bool) {
+	if p.DeliveryTime == "" {
+		return 0, false
+	}
+	t, err := parseTimeOfDay(p.DeliveryTime)
+	if err != nil {
+		return 0, false
+	}
+	return t, true
+}
+
+// deliveryDue returns when the reminder of a release is due for a chat with a
+// delivery time: at that time of day in its timezone, remindDays before the
+// day of release. Times skipped by a DST change are moved forward, see
+// time.Date. ok is false without a delivery time.
+func (p UserPrefs) deliveryDue(release time.Time, remindDays int) (time.Time, bool) {
+	t, ok := p.deliveryTime()
+	if !ok {
+		return time.Time{}, false
+	}
+	y, m, d := release.UTC().Date()
+	return time.Date(y, m, d-remindDays, t.Hour(), t.Minute(), 0, 0, p.location()), true
+}
+
+// handleTaskDeliver sends the reminders of the chats with a delivery time.
+// It runs more often than the notify job so that they arrive on time, and
+// shares its lease so that a reminder isn't sent by both at once.
+func handleTaskDeliver(w http.ResponseWriter, r *http.Request) {
+	ctx := withRequestID(r.Context(), newRequestID())
+	runAsLeader(ctx, "notify", func(ctx context.Context) {
+		notifyScheduledReleases(ctx)
+	})
+}
+
+func handleDeliveryTime(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	t, err := parseTimeOfDay(matches[1])
+	if err != nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, err.Error()))
+		return
+	}
+
+	var prefs UserPrefs
+	err = store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.DeliveryTime = t.format(timeFormat24h)
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, "Reminders will arrive at "+prefs.formatTimeOfDay(t)+", "+prefs.location().String()+" time."))
+}
+
+func handleClearDeliveryTime(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.DeliveryTime = ""
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, "Reminders will arrive as soon as they are due."))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeliveryDue(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefs      UserPrefs
+		release    time.Time
+		remindDays int
+		want       time.Time
+		wantOK     bool
+	}{
+		{
+			"no delivery time",
+			UserPrefs{Timezone: "Europe/Berlin"},
+			time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), 3,
+			time.Time{}, false,
+		},
+		{
+			"summer time",
+			UserPrefs{Timezone: "Europe/Berlin", DeliveryTime: "08:00"},
+			time.Date(2026, 10, 27, 0, 0, 0, 0, time.UTC), 3,
+			time.Date(2026, 10, 24, 6, 0, 0, 0, time.UTC), true,
+		},
+		{
+			"day summer time ends",
+			UserPrefs{Timezone: "Europe/Berlin", DeliveryTime: "08:00"},
+			time.Date(2026, 10, 28, 0, 0, 0, 0, time.UTC), 3,
+			time.Date(2026, 10, 25, 7, 0, 0, 0, time.UTC), true,
+		},
+		{
+			"time skipped when summer time starts",
+			UserPrefs{Timezone: "Europe/Berlin", DeliveryTime: "02:30"},
+			time.Date(2026, 3, 30, 0, 0, 0, 0, time.UTC), 1,
+			time.Date(2026, 3, 29, 1, 30, 0, 0, time.UTC), true,
+		},
+		{
+			"release day east of UTC",
+			UserPrefs{Timezone: "Asia/Tokyo", DeliveryTime: "20:00"},
+			time.Date(2026, 10, 20, 15, 0, 0, 0, time.UTC), 1,
+			time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC), true,
+		},
+		{
+			"invalid delivery time",
+			UserPrefs{Timezone: "Asia/Tokyo", DeliveryTime: "noon"},
+			time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), 1,
+			time.Time{}, false,
+		},
+	}
+	for _, tt := range tests {
+		got, ok := tt.prefs.deliveryDue(tt.release, tt.remindDays)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("%s: deliveryDue() = %s, %t, want %s, %t", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestNotificationTextDeliveryTime(t *testing.T) {
+	prefs := UserPrefs{ChatID: 42, Timezone: "Europe/Berlin", DeliveryTime: "08:00"}
+	record := MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: time.Date(2026, 10, 28, 0, 0, 0, 0, time.UTC)}
+	sub := Subscriber{ChatID: 42, RemindDays: 3}
+
+	// 08:00 in Berlin, an hour later in UTC than the day before
+	due := time.Date(2026, 10, 25, 7, 0, 0, 0, time.UTC)
+	if _, _, ok := notificationText(record, sub, prefs, due.Add(-time.Minute)); ok {
+		t.Error("notificationText() sent the reminder before the delivery time")
+	}
+	if _, _, ok := notificationText(record, sub, prefs, due); !ok {
+		t.Error("notificationText() didn't send the reminder at the delivery time")
+	}
+}
+
+func TestNotifyScheduledReleasesOnlyScheduledChats(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+
+	s.prefs[42] = UserPrefs{ChatID: 42, Timezone: "UTC", DeliveryTime: "00:00"}
+	release := MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: time.Now().AddDate(0, 0, 2), Subscribers: []Subscriber{{ChatID: 42}, {ChatID: 43}}}
+	if err := store.PutRelease(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+
+	notifyScheduledReleases(ctx)
+
+	if texts := tg.texts(42); len(texts) != 1 {
+		t.Errorf("sent %q to the chat with a delivery time, want its reminder", texts)
+	}
+	if texts := tg.texts(43); len(texts) != 0 {
+		t.Errorf("sent %q to the chat without a delivery time, want it left to the notify job", texts)
+	}
+}
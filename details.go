@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// detailsCastSize is how many top billed actors the details card lists.
+const detailsCastSize = 5
+
+// bestMatch returns the result best matching the searched title: the most
+// popular exact title match if there is one, the most popular result
+// otherwise.
+func bestMatch(results MovieAPIResults, title string) (MovieAPIResult, bool) {
+	var best MovieAPIResult
+	found, exact := false, false
+	for _, res := range results {
+		isExact := strings.ToLower(res.Title) == title
+		if !found || (isExact && !exact) || (isExact == exact && res.Popularity > best.Popularity) {
+			best, found, exact = res, true, isExact
+		}
+	}
+	return best, found
+}
+
+// formatRuntime formats a runtime in minutes, e.g. "2h 35m".
+func formatRuntime(minutes int) string {
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
+
+// formatUSD formats an amount of US dollars for display, e.g. "$165 million".
+func formatUSD(amount int64) string {
+	switch {
+	case amount >= 1000000000:
+		return fmt.Sprintf("$%s billion", strings.TrimSuffix(fmt.Sprintf("%.1f", float64(amount)/1e9), ".0"))
+	case amount >= 1000000:
+		return fmt.Sprintf("$%s million", strings.TrimSuffix(fmt.Sprintf("%.1f", float64(amount)/1e6), ".0"))
+	}
+
+	digits := fmt.Sprintf("%d", amount)
+	var out []byte
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			out = append(out, ',')
+		}
+		out = append(out, digits[i])
+	}
+	return "$" + string(out)
+}
+
+// formatMovieDetails renders the details card of a movie, in Markdown.
+func formatMovieDetails(d MovieDetails) string {
+	text := "*" + escapeMarkdown(d.Title) + "*"
+	if !d.ReleaseTime.IsZero() {
+		text += fmt.Sprintf(" (%d)", d.ReleaseTime.Year())
+	}
+	text += "\n"
+	if tagline := strings.TrimSpace(d.Tagline); tagline != "" {
+		text += "_" + escapeMarkdown(tagline) + "_\n"
+	}
+
+	switch directors := d.Directors(); len(directors) {
+	case 0:
+	case 1:
+		text += "Director: " + escapeMarkdown(directors[0]) + "\n"
+	default:
+		text += "Directors: " + escapeMarkdown(strings.Join(directors, ", ")) + "\n"
+	}
+	if cast := d.TopCast(detailsCastSize); len(cast) > 0 {
+		var names []string
+		for _, c := range cast {
+			name := escapeMarkdown(strings.TrimSpace(c.Name))
+			if character := strings.TrimSpace(c.Character); character != "" {
+				name += " (" + escapeMarkdown(character) + ")"
+			}
+			names = append(names, name)
+		}
+		text += "Starring: " + strings.Join(names, ", ") + "\n"
+	}
+	if d.Status != "" {
+		text += "Status: " + d.Status + "\n"
+	}
+	text += "Release: " + formatReleaseDate(d.ReleaseTime) + "\n"
+	if d.Runtime > 0 {
+		text += "Runtime: " + formatRuntime(d.Runtime) + "\n"
+	}
+	if d.VoteCount > 0 {
+		text += fmt.Sprintf("Rating: %.1f/10 (%d votes)\n", d.VoteAverage, d.VoteCount)
+	}
+	if d.Budget > 0 {
+		text += "Budget: " + formatUSD(d.Budget) + "\n"
+	}
+	if d.Revenue > 0 {
+		text += "Revenue: " + formatUSD(d.Revenue) + "\n"
+	}
+	if d.Overview != "" {
+		text += "\n" + escapeMarkdown(d.Overview) + "\n"
+	}
+	text += "\n" + tmdbMovieURL(d.ID)
+	return text
+}
+
+func handleDetails(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	title := strings.TrimSpace(matches[1])
+
+	results, err := queryMovies(ctx, title, "")
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to search movies")
+		return
+	}
+
+	match, ok := bestMatch(results, title)
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "No entry found 🤓"))
+		return
+	}
+
+	details, err := movieDetails(ctx, match.ID, "credits")
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get movie details")
+		return
+	}
+
+	// The details don't need the store, only the subscribe button does
+	subscribed, err := isSubscribed(ctx, chatID, details.ID)
+	if err != nil {
+		logf(ctx, "failed to get subscriptions, sending details anyway: %s", err)
+	}
+	prefs := prefsOrDefault(ctx, chatID)
+
+	msgConfig := telegram.NewMessage(chatID, formatMovieDetails(details))
+	msgConfig.ParseMode = "Markdown"
+	// Only upcoming movies can be subscribed to
+	if subscribed || details.ReleaseTime.After(time.Now()) {
+		msgConfig.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(subscriptionButton(details.ID, subscribed)))
+	}
+	sendMsg(ctx, msgConfig)
+	if prefs.CastPhotos {
+		sendCastPhotos(ctx, chatID, details)
+	}
+}
+
+// sendCastPhotos sends the profile photos of the top billed actors of the
+// movie, as a single media group. Actors without a photo are left out.
+func sendCastPhotos(ctx context.Context, chatID int64, d MovieDetails) {
+	var media []interface{}
+	for _, c := range d.TopCast(detailsCastSize) {
+		if photo := c.ProfileURL(); photo != "" {
+			item := telegram.NewInputMediaPhoto(photo)
+			item.Caption = c.Name
+			media = append(media, item)
+		}
+	}
+
+	defer trackTime(ctx, timingTelegram, time.Now())
+	switch len(media) {
+	case 0:
+		return
+	case 1:
+		// Media groups need at least two items
+		item := media[0].(telegram.InputMediaPhoto)
+		photo := telegram.NewPhotoShare(chatID, item.Media)
+		photo.Caption = item.Caption
+		if _, err := bot.Send(photo); err != nil {
+			logf(ctx, "failed to send cast photo: %s", err)
+		}
+		return
+	}
+
+	// The client decodes every response as a single message, media groups
+	// are answered with a list of messages
+	b, err := json.Marshal(media)
+	if err != nil {
+		fatalf(ctx, "failed to encode cast photos: %s", err)
+	}
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(chatID, 10))
+	v.Add("media", string(b))
+	if _, err := bot.MakeRequest("sendMediaGroup", v); err != nil {
+		logf(ctx, "failed to send cast photos: %s", err)
+	}
+}
+
+func handleCastPhotos(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.CastPhotos = enabled
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "Movie details won't include cast photos anymore."
+	if enabled {
+		text = "Movie details will be followed by photos of the cast."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// diagnosticCheck is a live check of one of the bot dependencies.
+type diagnosticCheck struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// handleDiagnose runs a live check of every integration and reports the
+// result and latency of each.
+func handleDiagnose(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	checks := []diagnosticCheck{
+		{"TMDB search", func(ctx context.Context) error {
+			_, err := queryMovies(ctx, "alien", "")
+			return err
+		}},
+		{"Datastore round-trip", store.Check},
+		{"Telegram send", func(ctx context.Context) error {
+			_, err := trySendMsg(ctx, telegram.NewMessage(chatID, "Running diagnostics…"))
+			return err
+		}},
+	}
+
+	text := "Diagnostics:\n"
+	for _, c := range checks {
+		start := time.Now()
+		err := c.run(ctx)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			logf(ctx, "diagnostic check failed: check=%q: %s", c.name, err)
+			text += fmt.Sprintf("❌ %s (%s): %s\n", c.name, elapsed, err)
+			continue
+		}
+		text += fmt.Sprintf("✅ %s (%s)\n", c.name, elapsed)
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // TMDB serves some posters as PNG
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// tmdbPosterSize is the size of the movie posters drawn by the digest
+	// image.
+	tmdbPosterSize = "w185"
+
+	// digestSize is the number of upcoming releases shown by the digest
+	// image, laid out on digestColumns columns.
+	digestSize    = 6
+	digestColumns = 3
+
+	digestPosterWidth  = 185
+	digestPosterHeight = 278
+	digestPadding      = 12
+	// digestTextScale is the size of a pixel of digestFont.
+	digestTextScale  = 2
+	digestLineHeight = (glyphHeight + 3) * digestTextScale
+
+	// maxPosterSize is the largest poster downloaded.
+	maxPosterSize = 1 << 20
+)
+
+var (
+	posterClient = &http.Client{Timeout: 10 * time.Second}
+
+	digestBackground = color.RGBA{20, 20, 28, 255}
+	digestMissing    = color.RGBA{60, 60, 72, 255}
+	digestText       = color.RGBA{240, 240, 240, 255}
+	digestDate       = color.RGBA{255, 196, 0, 255}
+)
+
+// PosterURL returns the URL of the poster of the movie, empty if there is
+// none.
+func (m MovieAPIResult) PosterURL() string {
+	if m.PosterPath == "" {
+		return ""
+	}
+	return tmdbImageURL(tmdbPosterSize, m.PosterPath)
+}
+
+// fetchPoster downloads and decodes the poster at url.
+func fetchPoster(ctx context.Context, url string) (image.Image, error) {
+	defer trackTime(ctx, timingTMDB, time.Now())
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create http request")
+	}
+	res, err := posterClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download poster")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	img, _, err := image.Decode(io.LimitReader(res.Body, maxPosterSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode poster")
+	}
+	return img, nil
+}
+
+// releasePoster returns the poster of the release, nil if it has none.
+// Releases stored before posters were recorded are looked up on TMDB.
+func releasePoster(ctx context.Context, rec MovieRelease) (image.Image, error) {
+	path := rec.PosterPath
+	if path == "" {
+		details, err := movieDetails(ctx, rec.ID)
+		if err != nil {
+			return nil, err
+		}
+		path = details.PosterPath
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return fetchPoster(ctx, MovieAPIResult{PosterPath: path}.PosterURL())
+}
+
+// drawScaled draws src stretched over r of dst, with nearest neighbor
+// sampling.
+func drawScaled(dst *image.RGBA, r image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	for y := 0; y < r.Dy(); y++ {
+		sy := b.Min.Y + y*b.Dy()/r.Dy()
+		for x := 0; x < r.Dx(); x++ {
+			sx := b.Min.X + x*b.Dx()/r.Dx()
+			dst.Set(r.Min.X+x, r.Min.Y+y, src.At(sx, sy))
+		}
+	}
+}
+
+// fitText shortens the text to fit on width pixels of digestFont.
+func fitText(text string, width int) string {
+	max := (width + digestTextScale) / ((glyphWidth + 1) * digestTextScale)
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-2]) + ".."
+}
+
+// renderDigest draws the releases as a grid of posters, each with its title
+// and release date. posters holds the poster of each release, nil ones are
+// drawn as placeholders, telling the posters that failed to download from
+// errs. It returns the JPEG encoded image.
+func renderDigest(releases []MovieRelease, posters []image.Image, errs []error, prefs UserPrefs) ([]byte, error) {
+	columns := digestColumns
+	if len(releases) < columns {
+		columns = len(releases)
+	}
+	rows := (len(releases) + digestColumns - 1) / digestColumns
+	cellWidth := digestPosterWidth + digestPadding
+	cellHeight := digestPosterHeight + 2*digestLineHeight + digestPadding
+
+	img := image.NewRGBA(image.Rect(0, 0, columns*cellWidth+digestPadding, rows*cellHeight+digestPadding))
+	draw.Draw(img, img.Bounds(), &image.Uniform{digestBackground}, image.ZP, draw.Src)
+
+	for i, rec := range releases {
+		x := digestPadding + (i%digestColumns)*cellWidth
+		y := digestPadding + (i/digestColumns)*cellHeight
+		poster := image.Rect(x, y, x+digestPosterWidth, y+digestPosterHeight)
+
+		if posters[i] != nil {
+			drawScaled(img, poster, posters[i])
+		} else {
+			draw.Draw(img, poster, &image.Uniform{digestMissing}, image.ZP, draw.Src)
+			label := "NO POSTER"
+			if errs[i] != nil {
+				label = "UNAVAILABLE"
+			}
+			p := image.Pt(x+(digestPosterWidth-textWidth(label, digestTextScale))/2, y+(digestPosterHeight-glyphHeight*digestTextScale)/2)
+			drawText(img, p, label, digestText, digestTextScale)
+		}
+
+		textY := y + digestPosterHeight + digestTextScale*2
+		drawText(img, image.Pt(x, textY), fitText(rec.MovieTitle, digestPosterWidth), digestText, digestTextScale)
+		drawText(img, image.Pt(x, textY+digestLineHeight), fitText(prefs.formatDate(rec.ReleaseDate), digestPosterWidth), digestDate, digestTextScale)
+	}
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, errors.Wrap(err, "failed to encode digest image")
+	}
+	return b.Bytes(), nil
+}
+
+// handleDigestImage sends the next upcoming subscriptions of the chat as a
+// single image of their posters.
+func handleDigestImage(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+	subscriptions = regionalSubscriptions(subscriptions, chatID)
+
+	now := time.Now()
+	var upcoming []MovieRelease
+	for _, rec := range subscriptions {
+		if rec.ReleaseDate.After(now) {
+			upcoming = append(upcoming, rec)
+		}
+	}
+	if len(upcoming) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You aren't subscribed to any movie with an upcoming release date."))
+		return
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].ReleaseDate.Before(upcoming[j].ReleaseDate) })
+	if len(upcoming) > digestSize {
+		upcoming = upcoming[:digestSize]
+	}
+
+	posters := make([]image.Image, len(upcoming))
+	errs := fetchAll(ctx, len(upcoming), func(ctx context.Context, i int) error {
+		var err error
+		posters[i], err = releasePoster(ctx, upcoming[i])
+		return err
+	})
+	for i, err := range errs {
+		if err != nil {
+			logf(ctx, "failed to get movie poster: id=%d: %s", upcoming[i].ID, err)
+		}
+	}
+	b, err := renderDigest(upcoming, posters, errs, prefs)
+	if err != nil {
+		fatalf(ctx, "failed to render digest image: %s", err)
+	}
+
+	photo := telegram.NewPhotoUpload(chatID, telegram.FileBytes{Name: "digest.jpg", Bytes: b})
+	photo.Caption = "Your next releases 🍿"
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.Send(photo); err != nil {
+		fatalf(ctx, "failed to send digest image: %s", err)
+	}
+}
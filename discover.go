@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// discoverWindow is how far ahead the discover command looks for releases.
+const discoverWindow = 90 * 24 * time.Hour
+
+func handleSetFavoriteGenre(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	genreName := strings.TrimSpace(matches[1])
+
+	genre, ok, err := findGenre(ctx, genreName)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to find genre")
+		return
+	}
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("I don't know the genre %q 🤔", genreName)))
+		return
+	}
+
+	err = store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.FavoriteGenreID = genre.ID
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Favorite genre set to "+genre.Name+", send \"discover\" to see what's coming."))
+}
+
+func handleDiscover(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+	if prefs.FavoriteGenreID == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Set your favorite genre first, e.g. \"set favorite genre scifi\"."))
+		return
+	}
+
+	genre, ok, err := genreByID(ctx, prefs.FavoriteGenreID)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to find genre")
+		return
+	}
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Your favorite genre doesn't exist anymore, set another one with \"set favorite genre <genre>\"."))
+		return
+	}
+
+	from := startOfDay(time.Now().In(prefs.location()))
+	to := from.Add(discoverWindow)
+	results, err := discoverReleases(ctx, prefs.region(), genre.ID, from, to)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to discover movies")
+		return
+	}
+	if len(results) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Nothing coming out in "+genre.Name+" soon 🤷"))
+		return
+	}
+
+	text := fmt.Sprintf("Upcoming %s releases in %s 🍿:\n", genre.Name, regionLabel(prefs.region()))
+	for _, m := range results {
+		text += fmt.Sprintf("- %s (%s)\n", displayTitle(m.Title, m.ID), prefs.formatDate(m.ReleaseTime))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+const (
+	callbackPreview = "preview"
+	// previewPageSize is the number of releases on a preview page.
+	previewPageSize = 5
+)
+
+// parsePreviewArgs splits the arguments of the preview command into a genre
+// and a region, both possibly made of several words, e.g. "science fiction
+// united states".
+func parsePreviewArgs(ctx context.Context, args string) (Genre, string, bool, error) {
+	words := strings.Fields(args)
+	for i := 1; i < len(words); i++ {
+		region, err := normalizeRegion(strings.Join(words[i:], " "))
+		if err != nil {
+			continue
+		}
+		genre, ok, err := findGenre(ctx, strings.Join(words[:i], " "))
+		if err != nil {
+			return Genre{}, "", false, err
+		}
+		if ok {
+			return genre, region, true, nil
+		}
+	}
+	return Genre{}, "", false, nil
+}
+
+// previewPage renders a page of the upcoming releases of the genre in the
+// region, with buttons for each release and to browse the pages. ok is false
+// when the page doesn't exist.
+func previewPage(ctx context.Context, genre Genre, region string, page int, now time.Time) (string, telegram.InlineKeyboardMarkup, bool, error) {
+	from := startOfDay(now)
+	results, err := discoverReleases(ctx, region, genre.ID, from, from.Add(discoverWindow))
+	if err != nil {
+		return "", telegram.InlineKeyboardMarkup{}, false, err
+	}
+
+	pages := (len(results) + previewPageSize - 1) / previewPageSize
+	if page < 0 || page >= pages {
+		return "", telegram.InlineKeyboardMarkup{}, false, nil
+	}
+	results = results[page*previewPageSize:]
+	if len(results) > previewPageSize {
+		results = results[:previewPageSize]
+	}
+
+	text := fmt.Sprintf("Upcoming %s releases in %s (%d/%d) 🍿:\n", genre.Name, regionLabel(region), page+1, pages)
+	for _, m := range results {
+		text += fmt.Sprintf("- %s (%s)\n", displayTitle(m.Title, m.ID), formatReleaseDate(m.ReleaseTime))
+	}
+
+	markup := resultsKeyboard(results, now)
+	var nav []telegram.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, telegram.NewInlineKeyboardButtonData("◀️", fmt.Sprintf("%s:%d:%s:%d", callbackPreview, genre.ID, region, page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, telegram.NewInlineKeyboardButtonData("▶️", fmt.Sprintf("%s:%d:%s:%d", callbackPreview, genre.ID, region, page+1)))
+	}
+	if len(nav) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, nav)
+	}
+	return text, markup, true, nil
+}
+
+func handlePreview(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	genre, region, ok, err := parsePreviewArgs(ctx, matches[1])
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to find genre")
+		return
+	}
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Tell me a genre and a region, e.g. \"preview scifi DE\". Supported regions are "+strings.Join(supportedRegions(), ", ")+"."))
+		return
+	}
+
+	text, markup, ok, err := previewPage(ctx, genre, region, 0, time.Now())
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to discover movies")
+		return
+	}
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Nothing coming out in %s in %s soon 🤷", genre.Name, regionLabel(region))))
+		return
+	}
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ReplyMarkup = markup
+	sendMsg(ctx, msgConfig)
+}
+
+// handlePreviewCallback shows another page of a preview by editing the
+// message. It returns the callback answer.
+func handlePreviewCallback(ctx context.Context, query *telegram.CallbackQuery, arg string) string {
+	parts := strings.Split(arg, ":")
+	if len(parts) != 3 {
+		logf(ctx, "invalid preview callback data: %q", query.Data)
+		return ""
+	}
+	genreID, err1 := strconv.Atoi(parts[0])
+	page, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil {
+		logf(ctx, "invalid preview callback data: %q", query.Data)
+		return ""
+	}
+
+	genre, ok, err := genreByID(ctx, genreID)
+	if err != nil {
+		return callbackTMDBFailed(ctx, query.Message.Chat.ID, err, "failed to find genre")
+	}
+	if !ok {
+		return "That genre doesn't exist anymore."
+	}
+
+	text, markup, ok, err := previewPage(ctx, genre, parts[1], page, time.Now())
+	if err != nil {
+		return callbackTMDBFailed(ctx, query.Message.Chat.ID, err, "failed to discover movies")
+	}
+	if !ok {
+		return "That page doesn't exist anymore."
+	}
+
+	edit := telegram.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ReplyMarkup = &markup
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.Send(edit); err != nil {
+		logf(ctx, "failed to edit preview: %s", err)
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// detailConcurrency is how many per-movie TMDB calls enriching a list
+	// run at once.
+	detailConcurrency = 4
+	// detailCallTimeout bounds each of these calls, so that a slow movie
+	// doesn't hold up the whole list.
+	detailCallTimeout = 5 * time.Second
+)
+
+// fetchAll calls fetch for each index up to n, detailConcurrency at a time
+// and each within detailCallTimeout. It returns the error of each call, a
+// failed call doesn't stop the others so that the list can be shown partly
+// enriched.
+func fetchAll(ctx context.Context, n int, fetch func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < detailConcurrency && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				callCtx, cancel := context.WithTimeout(ctx, detailCallTimeout)
+				errs[i] = fetch(callCtx, i)
+				cancel()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
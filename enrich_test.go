@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchAll(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	fetched := make([]bool, 10)
+
+	errs := fetchAll(context.Background(), len(fetched), func(ctx context.Context, i int) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("call %d has no deadline", i)
+		}
+
+		mu.Lock()
+		running--
+		fetched[i] = true
+		mu.Unlock()
+		if i == 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	if maxRunning > detailConcurrency {
+		t.Errorf("ran %d calls at once, want at most %d", maxRunning, detailConcurrency)
+	}
+	for i, err := range errs {
+		if !fetched[i] {
+			t.Errorf("call %d not made", i)
+		}
+		if (err != nil) != (i == 3) {
+			t.Errorf("call %d returned %v", i, err)
+		}
+	}
+	if errs := fetchAll(context.Background(), 0, nil); len(errs) != 0 {
+		t.Errorf("fetchAll() of nothing = %v", errs)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// telegramCall is a request received by the fake Telegram API.
+type telegramCall struct {
+	Method string
+	Params url.Values
+}
+
+// fakeTelegram is a Telegram Bot API recording the calls of the bot. Sent
+// messages are answered with a message of the same chat, other calls with
+// true unless results has a JSON result for the method. fail, if set, returns
+// the error description of the calls to refuse, e.g. "Forbidden: bot was
+// blocked by the user". Descriptions such as "Too Many Requests: retry after
+// 5" also carry the delay, like Telegram does.
+type fakeTelegram struct {
+	mu      sync.Mutex
+	calls   []telegramCall
+	results map[string]string
+	fail    func(call telegramCall) string
+}
+
+// useFakeTelegram makes the bot talk to a new fakeTelegram for the test.
+func useFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+	f := &fakeTelegram{results: map[string]string{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := bot
+	bot = &telegram.BotAPI{
+		Token:  "test",
+		Client: &http.Client{Transport: redirectTransport{target}},
+		Self:   telegram.User{ID: 1, UserName: "movie_releases_test_bot"},
+	}
+	t.Cleanup(func() { bot = previous })
+	return f
+}
+
+// redirectTransport sends the requests to target whatever their host, the
+// Telegram client has api.telegram.org hardcoded.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	req.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call := telegramCall{
+		Method: r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:],
+		Params: r.Form,
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	id := len(f.calls)
+	result, ok := f.results[call.Method]
+	fail := f.fail
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if fail != nil {
+		if description := fail(call); description != "" {
+			response := map[string]interface{}{"ok": false, "description": description}
+			if i := strings.Index(description, "retry after "); i >= 0 {
+				retryAfter, _ := strconv.Atoi(description[i+len("retry after "):])
+				response["error_code"] = http.StatusTooManyRequests
+				response["parameters"] = map[string]int{"retry_after": retryAfter}
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+	}
+	if !ok {
+		result = "true"
+		if strings.HasPrefix(call.Method, "send") || strings.HasPrefix(call.Method, "edit") {
+			chatID, _ := strconv.ParseInt(call.Params.Get("chat_id"), 10, 64)
+			result = fmt.Sprintf(`{"message_id":%d,"chat":{"id":%d},"date":0,"text":%q}`, id, chatID, call.Params.Get("text"))
+		}
+	}
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+}
+
+// sent returns the calls of the given method, e.g. "sendMessage".
+func (f *fakeTelegram) sent(method string) []telegramCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []telegramCall
+	for _, c := range f.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// texts returns the texts of the messages sent to the chat.
+func (f *fakeTelegram) texts(chatID int64) []string {
+	var texts []string
+	for _, c := range f.sent("sendMessage") {
+		if c.Params.Get("chat_id") == strconv.FormatInt(chatID, 10) {
+			texts = append(texts, c.Params.Get("text"))
+		}
+	}
+	return texts
+}
+
+// fakeTMDB is a TMDB API answering the paths of routes, e.g. "/movie/42",
+// with their value encoded as JSON, and any other path with a 404. Responses
+// carry the headers of header, e.g. rate limit ones. It counts the requests
+// made for each path and the API keys they were sent with.
+type fakeTMDB struct {
+	mu     sync.Mutex
+	routes map[string]interface{}
+	header http.Header
+	hits   map[string]int
+	keys   []string
+}
+
+// useFakeTMDB makes the TMDB client talk to a new fakeTMDB for the test.
+func useFakeTMDB(t *testing.T) *fakeTMDB {
+	t.Helper()
+	f := &fakeTMDB{routes: map[string]interface{}{}, header: http.Header{}, hits: map[string]int{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	previous := tmdb
+	tmdb = newTMDBClient("key", server.Client())
+	tmdb.baseURL = server.URL + "/3"
+	t.Cleanup(func() { tmdb = previous })
+	return f
+}
+
+func (f *fakeTMDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/3")
+	f.mu.Lock()
+	f.hits[path]++
+	f.keys = append(f.keys, r.URL.Query().Get("api_key"))
+	v, ok := f.routes[path]
+	for k, values := range f.header {
+		w.Header()[k] = values
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"status_code":34,"status_message":"The resource you requested could not be found."}`)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// route sets the response of the path.
+func (f *fakeTMDB) route(path string, v interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[path] = v
+}
+
+// setHeader sets a header of the next responses.
+func (f *fakeTMDB) setHeader(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header.Set(key, value)
+}
+
+// requests returns how many requests were made for the path.
+func (f *fakeTMDB) requests(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits[path]
+}
+
+// testMessage returns an update of a text message sent to the bot in the
+// private chat of the user chatID.
+func testMessage(chatID int64, text string) telegram.Update {
+	return telegram.Update{
+		Message: &telegram.Message{
+			MessageID: 1,
+			From:      &telegram.User{ID: int(chatID), FirstName: "Test"},
+			Chat:      &telegram.Chat{ID: chatID, Type: "private"},
+			Text:      text,
+		},
+	}
+}
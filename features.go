@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Optional features, they can be enabled per deployment via the FEATURES
+// environment variable.
+const (
+	featureTrailers      = "trailers"
+	featureSurprise      = "surprise"
+	featureBulkSubscribe = "bulk"
+	featureDetails       = "details"
+	featureHistory       = "history"
+	featureImport        = "import"
+	featureCalendar      = "calendar"
+	featureStreaming     = "streaming"
+	featureDigestImage   = "digest"
+)
+
+// allFeatures lists the known features. They are all enabled when FEATURES
+// is not set.
+var allFeatures = []string{
+	featureTrailers,
+	featureSurprise,
+	featureBulkSubscribe,
+	featureDetails,
+	featureHistory,
+	featureImport,
+	featureCalendar,
+	featureStreaming,
+	featureDigestImage,
+}
+
+var enabledFeatures = parseFeatures("")
+
+// parseFeatures parses a comma separated list of enabled features, e.g.
+// "trailers,details". An empty list enables every feature.
+func parseFeatures(list string) map[string]bool {
+	enabled := map[string]bool{}
+	if strings.TrimSpace(list) == "" {
+		for _, f := range allFeatures {
+			enabled[f] = true
+		}
+		return enabled
+	}
+
+	known := map[string]bool{}
+	for _, f := range allFeatures {
+		known[f] = true
+	}
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !known[f] {
+			log.Printf("WARNING: unknown feature %q in FEATURES", f)
+			continue
+		}
+		enabled[f] = true
+	}
+	return enabled
+}
+
+// featureEnabled returns whether the feature is enabled in this deployment.
+func featureEnabled(name string) bool {
+	return enabledFeatures[name]
+}
+
+// requireFeature returns whether the feature is enabled, telling the user
+// otherwise.
+func requireFeature(ctx context.Context, chatID int64, name string) bool {
+	if featureEnabled(name) {
+		return true
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Sorry, that feature isn't enabled here."))
+	return false
+}
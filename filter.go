@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// resultFilterModifier matches the "min rating <n>" and "min votes <n>"
+// modifiers of the release commands.
+var resultFilterModifier = regexp.MustCompile(` min (rating|votes) ([0-9]+(?:\.[0-9]+)?)`)
+
+// resultFilter holds the thresholds search results must reach to be
+// displayed. The zero value keeps every result.
+type resultFilter struct {
+	MinRating float64
+	MinVotes  int
+}
+
+// extractResultFilter parses and removes the filter modifiers from text.
+func extractResultFilter(text string) (string, resultFilter) {
+	var filter resultFilter
+	for _, m := range resultFilterModifier.FindAllStringSubmatch(text, -1) {
+		switch m[1] {
+		case "rating":
+			filter.MinRating, _ = strconv.ParseFloat(m[2], 64)
+		case "votes":
+			filter.MinVotes, _ = strconv.Atoi(m[2])
+		}
+	}
+	return resultFilterModifier.ReplaceAllString(text, ""), filter
+}
+
+// apply returns the results reaching the filter thresholds.
+func (f resultFilter) apply(results MovieAPIResults) MovieAPIResults {
+	if f == (resultFilter{}) {
+		return results
+	}
+
+	var filtered MovieAPIResults
+	for _, m := range results {
+		if m.VoteAverage >= f.MinRating && m.VoteCount >= f.MinVotes {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// datedResults returns the results with a known release date, for chats
+// hiding undated movies.
+func datedResults(results MovieAPIResults) MovieAPIResults {
+	var dated MovieAPIResults
+	for _, m := range results {
+		if !m.ReleaseTime.IsZero() {
+			dated = append(dated, m)
+		}
+	}
+	return dated
+}
+
+func handleShowUndated(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	show := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.HideUndated = !show
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "Movies without a release date are hidden from search results."
+	if show {
+		text = "Movies without a release date are listed in search results again."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+func handleDefaultYear(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	year := matches[1]
+	if !plausibleYear(year, time.Now()) {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("%s isn't a valid release year, use a year between %d and %d.", year, minReleaseYear, time.Now().Year()+maxYearsAhead)))
+		return
+	}
+	// Cannot fail, the command only matches 4 digits
+	defaultYear, _ := strconv.Atoi(year)
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.DefaultYear = defaultYear
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := fmt.Sprintf("Searches will only list movies of %d, unless you give another year with `year <year>`.", defaultYear)
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ParseMode = "Markdown"
+	sendMsg(ctx, msgConfig)
+}
+
+func handleClearDefaultYear(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	cleared := false
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		if prefs.DefaultYear == 0 {
+			return errSkipUpdate
+		}
+		prefs.DefaultYear = 0
+		cleared = true
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	if !cleared {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You don't have a default year."))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Searches will list movies of any year again."))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExtractResultFilter(t *testing.T) {
+	tests := []struct {
+		text     string
+		wantText string
+		want     resultFilter
+	}{
+		{"releases dune", "releases dune", resultFilter{}},
+		{"releases dune min rating 7.5", "releases dune", resultFilter{MinRating: 7.5}},
+		{"releases dune min votes 100 min rating 6", "releases dune", resultFilter{MinRating: 6, MinVotes: 100}},
+	}
+	for _, tt := range tests {
+		text, filter := extractResultFilter(tt.text)
+		if text != tt.wantText || filter != tt.want {
+			t.Errorf("extractResultFilter(%q) = %q, %+v, want %q, %+v", tt.text, text, filter, tt.wantText, tt.want)
+		}
+	}
+}
+
+func TestResultFilterApply(t *testing.T) {
+	results := MovieAPIResults{
+		{ID: 1, VoteAverage: 8.1, VoteCount: 5000},
+		{ID: 2, VoteAverage: 0, VoteCount: 0},
+		{ID: 3, VoteAverage: 9, VoteCount: 3},
+		{ID: 4, VoteAverage: 6.5, VoteCount: 800},
+	}
+
+	tests := []struct {
+		filter resultFilter
+		want   []int64
+	}{
+		{resultFilter{}, []int64{1, 2, 3, 4}},
+		{resultFilter{MinRating: 7}, []int64{1, 3}},
+		{resultFilter{MinVotes: 100}, []int64{1, 4}},
+		{resultFilter{MinRating: 7, MinVotes: 100}, []int64{1}},
+	}
+	for _, tt := range tests {
+		var got []int64
+		for _, m := range tt.filter.apply(results) {
+			got = append(got, m.ID)
+		}
+		if !equalIDs(got, tt.want) {
+			t.Errorf("%+v.apply() = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandleReleaseHideUndated(t *testing.T) {
+	for i, hide := range []bool{false, true} {
+		// Each its own chat, not refining the search of the previous one
+		chatID := int64(101 + i)
+		s := useMemStore(t)
+		tg := useFakeTelegram(t)
+		api := useFakeTMDB(t)
+		api.route("/search/movie", map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"id": 1, "title": "Dune", "release_date": "2021-09-15"},
+				{"id": 2, "title": "Dune: Part Three", "release_date": ""},
+			},
+		})
+		s.prefs[chatID] = UserPrefs{ChatID: chatID, HideUndated: hide}
+
+		update := testMessage(chatID, "releases dune")
+		handleRelease(context.Background(), update, releaseCommand.FindStringSubmatch(update.Message.Text), resultFilter{})
+
+		texts := tg.texts(chatID)
+		if len(texts) != 1 || !strings.Contains(texts[0], "Dune (2021)") {
+			t.Fatalf("hide=%t: sent %q, want the dated movie listed", hide, texts)
+		}
+		if listed := strings.Contains(texts[0], "Dune: Part Three"); listed == hide {
+			t.Errorf("hide=%t: undated movie listed=%t in %q", hide, listed, texts[0])
+		}
+	}
+}
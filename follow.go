@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// maxFollowedPeople is how many people a chat can follow.
+	maxFollowedPeople = 20
+	// maxFollowingMovies is how many upcoming movies the following command
+	// lists per person.
+	maxFollowingMovies = 5
+)
+
+// bestPersonMatch returns the person best matching the searched name: the
+// most popular exact name match if there is one, the most popular result
+// otherwise.
+func bestPersonMatch(results []PersonAPIResult, name string) (PersonAPIResult, bool) {
+	var best PersonAPIResult
+	found := false
+	score := func(r PersonAPIResult) int {
+		if strings.ToLower(r.Name) == name {
+			return 1
+		}
+		return 0
+	}
+	for _, r := range results {
+		if !found || score(r) > score(best) || (score(r) == score(best) && r.Popularity > best.Popularity) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// upcomingCredits returns the movies not released yet, the dated ones first
+// by release date, then the ones without a release date.
+func upcomingCredits(movies MovieAPIResults, now time.Time) MovieAPIResults {
+	today := now.UTC().Truncate(24 * time.Hour)
+	var upcoming MovieAPIResults
+	for _, m := range movies {
+		if m.ReleaseTime.IsZero() || !m.ReleaseTime.Before(today) {
+			upcoming = append(upcoming, m)
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		a, b := upcoming[i].ReleaseTime, upcoming[j].ReleaseTime
+		if a.IsZero() || b.IsZero() {
+			return !a.IsZero() && b.IsZero()
+		}
+		return a.Before(b)
+	})
+	return upcoming
+}
+
+// creditIDs returns the IDs of the movies, and of the ones among them having
+// a release date, see FollowedPerson.KnownMovies.
+func creditIDs(movies MovieAPIResults) (known, dated []int64) {
+	for _, m := range movies {
+		known = append(known, m.ID)
+		if !m.ReleaseTime.IsZero() {
+			dated = append(dated, m.ID)
+		}
+	}
+	return known, dated
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// newCredits returns the upcoming movies the chat following the person
+// wasn't told about yet, and the known ones that got a release date since.
+func newCredits(person FollowedPerson, upcoming MovieAPIResults) (announced, dated MovieAPIResults) {
+	for _, m := range upcoming {
+		switch {
+		case !containsID(person.KnownMovies, m.ID):
+			announced = append(announced, m)
+		case !m.ReleaseTime.IsZero() && !containsID(person.DatedMovies, m.ID):
+			dated = append(dated, m)
+		}
+	}
+	return announced, dated
+}
+
+// creditText describes an upcoming movie of a followed person.
+func creditText(m MovieAPIResult, prefs UserPrefs) string {
+	title := displayTitle(m.Title, m.ID)
+	if m.ReleaseTime.IsZero() {
+		return title + ", release date to be announced"
+	}
+	return title + ", out on " + prefs.formatDate(m.ReleaseTime)
+}
+
+// newCreditsText is the notification telling a chat about new movies of a
+// person it follows.
+func newCreditsText(person FollowedPerson, announced, dated MovieAPIResults, prefs UserPrefs) string {
+	text := fmt.Sprintf("News about %s 🎭\n", person.Name)
+	for _, m := range announced {
+		text += "- New movie: " + creditText(m, prefs) + "\n"
+	}
+	for _, m := range dated {
+		text += "- " + displayTitle(m.Title, m.ID) + " now has a release date: " + prefs.formatDate(m.ReleaseTime) + "\n"
+	}
+	return text
+}
+
+func handleFollowPerson(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(matches[1])
+
+	people, err := store.FollowedPeople(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get followed people")
+		return
+	}
+
+	results, err := searchPeople(ctx, name)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to search people")
+		return
+	}
+	match, ok := bestPersonMatch(results, name)
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "No one found with that name 🤓"))
+		return
+	}
+	for _, p := range people {
+		if p.PersonID == match.ID {
+			sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("You already follow %s, send \"following\" to see their upcoming movies.", match.Name)))
+			return
+		}
+	}
+	if len(people) >= maxFollowedPeople {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("You can follow up to %d people, unfollow someone first with \"unfollow person <name>\".", maxFollowedPeople)))
+		return
+	}
+
+	movies, err := personMovies(ctx, match.ID)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get movies of person")
+		return
+	}
+	// The movies already announced are listed by the following command, only
+	// the next ones are notified
+	upcoming := upcomingCredits(movies, time.Now())
+	person := FollowedPerson{ChatID: chatID, PersonID: match.ID, Name: match.Name, FollowedAt: time.Now()}
+	person.KnownMovies, person.DatedMovies = creditIDs(upcoming)
+	if err := store.PutFollowedPerson(ctx, person); err != nil {
+		storeFailed(ctx, chatID, err, "failed to save followed person")
+		return
+	}
+
+	text := fmt.Sprintf("Following %s 🎭 I'll let you know when they have a new movie.", match.Name)
+	if len(upcoming) == 0 {
+		text += " They have nothing upcoming for now."
+	} else {
+		text += fmt.Sprintf(" They have %d upcoming movies, send \"following\" to see them.", len(upcoming))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+func handleUnfollowPerson(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(matches[1])
+
+	people, err := store.FollowedPeople(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get followed people")
+		return
+	}
+	for _, p := range people {
+		if strings.ToLower(p.Name) != name {
+			continue
+		}
+		if err := store.DeleteFollowedPerson(ctx, chatID, p.PersonID); err != nil {
+			storeFailed(ctx, chatID, err, "failed to delete followed person")
+			return
+		}
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("You no longer follow %s.", p.Name)))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "You don't follow anyone with that name, send \"following\" to see who you follow."))
+}
+
+// handleFollowing lists the upcoming movies of the people the chat follows.
+func handleFollowing(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	people, err := store.FollowedPeople(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get followed people")
+		return
+	}
+	if len(people) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You don't follow anyone yet, send \"follow person <name>\" to be told about the new movies of an actor or a director."))
+		return
+	}
+	sort.SliceStable(people, func(i, j int) bool { return people[i].Name < people[j].Name })
+	prefs := prefsOrDefault(ctx, chatID)
+
+	now := time.Now()
+	text := "Upcoming movies of the people you follow 🎭\n"
+	for _, p := range people {
+		text += "\n" + p.Name + "\n"
+		movies, err := personMovies(ctx, p.PersonID)
+		if err != nil {
+			logf(ctx, "failed to get movies of person %d: %s", p.PersonID, err)
+			text += "- I couldn't get their movies from TMDB, please try again later\n"
+			continue
+		}
+		upcoming := upcomingCredits(movies, now)
+		if len(upcoming) == 0 {
+			text += "- Nothing upcoming for now\n"
+			continue
+		}
+		for i, m := range upcoming {
+			if i == maxFollowingMovies {
+				text += fmt.Sprintf("- and %d more\n", len(upcoming)-maxFollowingMovies)
+				break
+			}
+			text += "- " + creditText(m, prefs) + "\n"
+		}
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+// refreshFollowedPeople re-fetches the movies of every followed person from
+// TMDB and tells the chats following them about newly announced movies, and
+// about the ones getting a release date.
+func refreshFollowedPeople(ctx context.Context) {
+	people, err := store.FollowedPeople(ctx, 0)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all followed people")
+		return
+	}
+
+	// A person can be followed by several chats
+	credits := map[int64]MovieAPIResults{}
+	prefs := map[int64]UserPrefs{}
+	now := time.Now()
+
+	for _, person := range people {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping refresh job: %s", ctx.Err())
+			return
+		}
+
+		movies, ok := credits[person.PersonID]
+		if !ok {
+			movies, err = personMovies(ctx, person.PersonID)
+			if err != nil {
+				logf(ctx, "failed to refresh followed person: person_id=%d: %s", person.PersonID, err)
+				continue
+			}
+			credits[person.PersonID] = movies
+		}
+
+		upcoming := upcomingCredits(movies, now)
+		announced, dated := newCredits(person, upcoming)
+		known, knownDated := creditIDs(upcoming)
+		if len(announced) == 0 && len(dated) == 0 && len(known) == len(person.KnownMovies) && len(knownDated) == len(person.DatedMovies) {
+			continue
+		}
+
+		if len(announced) > 0 || len(dated) > 0 {
+			p, ok := prefs[person.ChatID]
+			if !ok {
+				p, err = store.Prefs(ctx, person.ChatID)
+				if err != nil {
+					jobStoreFailed(ctx, err, "failed to get user prefs")
+					return
+				}
+				prefs[person.ChatID] = p
+			}
+			var logs []NotificationLog
+			for _, m := range append(announced, dated...) {
+				logs = append(logs, notificationLog(notificationFollowed, m.ID, m.Title))
+			}
+			sub := Subscriber{ChatID: person.ChatID, NotifyChatID: p.NotifyChatID}
+			if err := sendNotification(ctx, sub, newCreditsText(person, announced, dated, p), logs...); err != nil {
+				// The credits stay unknown, the next refresh tells them again
+				logf(ctx, "failed to send new credits: chat_id=%d person_id=%d: %s", person.ChatID, person.PersonID, err)
+				continue
+			}
+		}
+
+		// Movies released since are dropped, the lists only hold upcoming ones
+		person.KnownMovies, person.DatedMovies = known, knownDated
+		person.ChatID = migratedChats.resolve(person.ChatID)
+		if err := store.PutFollowedPerson(ctx, person); err != nil {
+			jobStoreFailed(ctx, err, fmt.Sprintf("failed to update followed person: chat_id=%d person_id=%d", person.ChatID, person.PersonID))
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpcomingCredits(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC) }
+	movies := MovieAPIResults{
+		{ID: 1, ReleaseTime: day(20)},
+		{ID: 2},
+		{ID: 3, ReleaseTime: day(1)},
+		{ID: 4, ReleaseTime: time.Date(2021, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{ID: 5, ReleaseTime: day(10)},
+	}
+
+	var got []int64
+	for _, m := range upcomingCredits(movies, now) {
+		got = append(got, m.ID)
+	}
+	if want := []int64{3, 5, 1, 2}; !equalIDs(got, want) {
+		t.Errorf("upcomingCredits = %v, want %v", got, want)
+	}
+}
+
+func TestNewCredits(t *testing.T) {
+	dated := time.Date(2031, 3, 1, 0, 0, 0, 0, time.UTC)
+	person := FollowedPerson{KnownMovies: []int64{1, 2, 3}, DatedMovies: []int64{1}}
+	upcoming := MovieAPIResults{
+		{ID: 1, ReleaseTime: dated},
+		{ID: 2, ReleaseTime: dated},
+		{ID: 3},
+		{ID: 4},
+		{ID: 5, ReleaseTime: dated},
+	}
+
+	announced, newlyDated := newCredits(person, upcoming)
+	var gotAnnounced, gotDated []int64
+	for _, m := range announced {
+		gotAnnounced = append(gotAnnounced, m.ID)
+	}
+	for _, m := range newlyDated {
+		gotDated = append(gotDated, m.ID)
+	}
+	if want := []int64{4, 5}; !equalIDs(gotAnnounced, want) {
+		t.Errorf("announced = %v, want %v", gotAnnounced, want)
+	}
+	if want := []int64{2}; !equalIDs(gotDated, want) {
+		t.Errorf("dated = %v, want %v", gotDated, want)
+	}
+
+	if announced, dated := newCredits(FollowedPerson{KnownMovies: []int64{1, 2, 3, 4, 5}, DatedMovies: []int64{1, 2, 5}}, upcoming); len(announced) != 0 || len(dated) != 0 {
+		t.Errorf("nothing new: announced = %+v, dated = %+v, want none", announced, dated)
+	}
+}
+
+func TestRefreshFollowedPeople(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+	first := time.Now().AddDate(0, 2, 0).Format("2006-01-02")
+	second := time.Now().AddDate(0, 5, 0).Format("2006-01-02")
+	api.route("/person/7/movie_credits", map[string]interface{}{
+		"cast": []map[string]interface{}{
+			{"id": 1, "title": "Dune", "release_date": first},
+			{"id": 2, "title": "Dune: Part Two", "release_date": second},
+			{"id": 3, "title": "Dune: Part Three", "release_date": ""},
+			{"id": 4, "title": "Arrival", "release_date": "2016-11-11"},
+		},
+		"crew": []map[string]interface{}{
+			{"id": 3, "title": "Dune: Part Three", "release_date": "", "job": "Director"},
+		},
+	})
+
+	for _, p := range []FollowedPerson{
+		// Told about Part Two while it had no release date
+		{ChatID: 42, PersonID: 7, Name: "Denis Villeneuve", KnownMovies: []int64{1, 2}, DatedMovies: []int64{1}},
+		// Told about everything, Part Three once had a release date
+		{ChatID: 43, PersonID: 7, Name: "Denis Villeneuve", KnownMovies: []int64{1, 2, 3}, DatedMovies: []int64{1, 2, 3}},
+	} {
+		if err := store.PutFollowedPerson(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refreshFollowedPeople(ctx)
+
+	if n := api.requests("/person/7/movie_credits"); n != 1 {
+		t.Errorf("requested the credits %d times, want once for both chats", n)
+	}
+	texts := tg.texts(42)
+	if len(texts) != 1 {
+		t.Fatalf("sent %q to chat 42, want the new credits", texts)
+	}
+	for _, want := range []string{"News about Denis Villeneuve", "New movie: Dune: Part Three, release date to be announced", "Dune: Part Two now has a release date"} {
+		if !strings.Contains(texts[0], want) {
+			t.Errorf("notification %q, want %q", texts[0], want)
+		}
+	}
+	if strings.Contains(texts[0], "Arrival") || strings.Contains(texts[0], "New movie: Dune,") {
+		t.Errorf("notification %q, want only the new credits", texts[0])
+	}
+	if texts := tg.texts(43); len(texts) != 0 {
+		t.Errorf("sent %q to chat 43, want nothing new", texts)
+	}
+
+	following, _ := store.FollowedPeople(ctx, 0)
+	for _, p := range following {
+		if !reflect.DeepEqual(p.KnownMovies, []int64{1, 2, 3}) || !reflect.DeepEqual(p.DatedMovies, []int64{1, 2}) {
+			t.Errorf("chat %d knows %v dated %v, want [1 2 3] dated [1 2]", p.ChatID, p.KnownMovies, p.DatedMovies)
+		}
+	}
+	logs, _ := store.NotificationLogs(ctx, 42)
+	if len(logs) != 2 || logs[0].Kind != notificationFollowed {
+		t.Errorf("logs = %+v, want both credits logged", logs)
+	}
+
+	refreshFollowedPeople(ctx)
+	if texts := tg.texts(42); len(texts) != 1 {
+		t.Errorf("second refresh sent %q, want nothing new", texts[1:])
+	}
+	if len(s.followed) != 2 {
+		t.Errorf("followed = %+v, want both chats still following", s.followed)
+	}
+}
+
+func TestRefreshFollowedPeopleSendFails(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+	tg.fail = func(call telegramCall) string { return "Internal Server Error" }
+	api.route("/person/7/movie_credits", map[string]interface{}{
+		"cast": []map[string]interface{}{{"id": 1, "title": "Dune", "release_date": ""}},
+	})
+	if err := store.PutFollowedPerson(ctx, FollowedPerson{ChatID: 42, PersonID: 7, Name: "Denis Villeneuve"}); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshFollowedPeople(ctx)
+
+	// The credit stays unknown, told about on the next refresh
+	following, _ := store.FollowedPeople(ctx, 42)
+	if len(following) != 1 || len(following[0].KnownMovies) != 0 {
+		t.Errorf("followed = %+v, want the credit still unknown", following)
+	}
+}
+
+func TestHandleFollowPersonNothingUpcoming(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	api.route("/search/person", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 8, "name": "Sergio Leone", "popularity": 5},
+			{"id": 9, "name": "Sergio Leone Jr", "popularity": 9},
+		},
+	})
+	api.route("/person/8/movie_credits", map[string]interface{}{
+		"cast": []map[string]interface{}{{"id": 1, "title": "Once Upon a Time in the West", "release_date": "1968-12-21"}},
+	})
+
+	handleUpdate(testMessage(42, "follow person sergio leone"))
+
+	p, ok := s.followed[memFollowedKey(42, 8)]
+	if !ok || len(p.KnownMovies) != 0 {
+		t.Errorf("followed = %+v, want the exact name match followed without upcoming movies", s.followed)
+	}
+	if texts := tg.texts(42); len(texts) != 1 || !strings.HasSuffix(texts[0], "They have nothing upcoming for now.") {
+		t.Errorf("sent %q, want nothing upcoming told", texts)
+	}
+
+	handleUpdate(testMessage(42, "following"))
+	if texts := tg.texts(42); len(texts) != 2 || !strings.Contains(texts[1], "Sergio Leone\n- Nothing upcoming for now") {
+		t.Errorf("sent %q, want nothing upcoming listed", texts)
+	}
+}
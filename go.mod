@@ -7,7 +7,10 @@ require (
 	github.com/pkg/errors v0.8.0
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	go.opencensus.io v0.18.0 // indirect
-	google.golang.org/api v0.0.0-20181120235003-faade3cbb06a // indirect
+	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
+	golang.org/x/text v0.3.0
+	google.golang.org/api v0.0.0-20181120235003-faade3cbb06a
 	google.golang.org/appengine v1.3.0 // indirect
 	google.golang.org/genproto v0.0.0-20181109154231-b5d43981345b // indirect
+	google.golang.org/grpc v1.16.0
 )
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// commandHelp documents a command for the help command.
+type commandHelp struct {
+	Name string
+	// Usage lists the forms of the command, with a short explanation in
+	// parentheses when needed.
+	Usage    []string
+	Details  string
+	Examples []string
+}
+
+// commandHelps lists the documented commands, in the order of the overview.
+var commandHelps = []commandHelp{
+	{
+		Name: "releases",
+		Usage: []string{
+			"`releases [exact] <movie title>`",
+			"`releases [exact] <movie title> year <year of release>` (the year of release can be region specific)",
+			"`releases <movie title> min rating <n> min votes <n>` (only well rated movies)",
+			"`set show undated on|off` (list movies without a release date, on by default)",
+			"`set default year <year>` / `clear default year` (search movies of that year unless you give another)",
+			"`again` (run your last search again, with your current settings)",
+		},
+		Details:  "Searches TMDB and lists the matching movies with their release date. `exact` only keeps titles matching exactly, `min rating` and `min votes` hide movies below the thresholds. Tap ℹ️ on a result for its details, 🔔 to subscribe to an upcoming one.",
+		Examples: []string{"release climax year 2018", "release exact julia", "releases alita min rating 7"},
+	},
+	{
+		Name:     "coming",
+		Usage:    []string{"`coming out this weekend|this week|next week|this month|next month` (movies released in theaters then)"},
+		Details:  "Lists the movies released in theaters in your region during the period, in your timezone.",
+		Examples: []string{"coming out this weekend", "releases coming out next month"},
+	},
+	{
+		Name: "subscribe",
+		Usage: []string{
+			"`subscribe to <movie title> [in <region>] [remind <n> days|weeks|months before]`",
+			"`subscribe list <titles>` (one title per line or separated by commas)",
+			"`unsubscribe from <movie title>`",
+			"`posters on|off` (confirm subscriptions with the poster, release date and days left)",
+		},
+		Details:  "Notifies you before an upcoming movie comes out, a week before unless you choose otherwise. With a region the subscription follows the release date there instead of the worldwide one. When several movies match I ask you to pick one. In a group with topics, notifications are posted in the topic you subscribed from. Commands acting on one of your subscriptions, like `unsubscribe from`, `trailers`, `countdown` or `history`, accept any part of its title.",
+		Examples: []string{"subscribe to Alita", "subscribe to Dune remind 2 weeks before", "subscribe to Dune in US", "subscribe list Dune, Alita", "posters on"},
+	},
+	{
+		Name:     "list",
+		Usage:    []string{"`list subscriptions [by month]` (the year of release can be region specific)", "`set list order <soonest|added|alpha>`", "`total runtime` (how long watching all your upcoming movies takes)", "`digest image` (the posters of your next releases in a single picture)"},
+		Details:  "Lists the movies you are subscribed to, optionally grouped by month of release. Subscriptions are listed soonest release first by default, you can list them by latest added or alphabetically instead. Movies already out show how long ago they came out, with a button to remove them. Movies without a known runtime yet are left out of the total.",
+		Examples: []string{"list subscriptions", "list subscriptions by month", "set list order added", "total runtime"},
+	},
+	{
+		Name: "surprise",
+		Usage: []string{
+			"`surprise me [genre]` (a random upcoming release)",
+			"`surprise notifications on|off` (hear about movies you searched for getting a date)",
+		},
+		Details:  "Picks a random upcoming movie in your region, optionally of the given genre, with a button to subscribe. With surprise notifications on, I remember the movies without a release date you search for during 30 days and tell you once they get one. Turning them off forgets your searches.",
+		Examples: []string{"surprise me", "surprise me horror", "surprise notifications on"},
+	},
+	{
+		Name: "discover",
+		Usage: []string{
+			"`set favorite genre <genre>` / `discover` (upcoming movies of your favorite genre)",
+			"`preview <genre> <region>` (upcoming movies of any genre and region)",
+			"`trending searches` (the movies searched the most on the bot this week)",
+		},
+		Details:  "Lists the movies of your favorite genre coming out in your region in the next 3 months. `preview` does the same for the given genre and region, without changing your settings. Trending searches only show titles, never who searched them.",
+		Examples: []string{"set favorite genre scifi", "discover", "preview scifi DE", "preview horror united states", "trending searches"},
+	},
+	{
+		Name:     "trailers",
+		Usage:    []string{"`trailers on|off [for <movie title>]` (get notified about new trailers)"},
+		Details:  "Sends you the new official trailers of your subscriptions, all of them or a single one.",
+		Examples: []string{"trailers on", "trailers on for alita"},
+	},
+	{
+		Name:     "calendar",
+		Usage:    []string{"`connect calendar` / `sync calendar` / `disconnect calendar` (release dates in your Google Calendar)"},
+		Details:  "Adds the release dates of your subscriptions to your Google Calendar and keeps the events up to date when dates change or you unsubscribe. Only private chats can be connected.",
+		Examples: []string{"connect calendar", "sync calendar"},
+	},
+	{
+		Name:     "countdown",
+		Usage:    []string{"`countdown on|off for <movie title>` (pin a countdown to the release)"},
+		Details:  "Pins a message in the chat counting the days left before the release, updated every day and unpinned once the movie is out. In groups I need to be allowed to pin messages.",
+		Examples: []string{"countdown on for dune", "countdown off for dune"},
+	},
+	{
+		Name: "notify",
+		Usage: []string{
+			"`set notify chat <chat id>` / `clear notify chat` (receive notifications in another chat)",
+			"`notify via <channel>` (how notifications are delivered, only `telegram` for now)",
+			"`set silent on|off` (notifications without sound or vibration)",
+			"`set delivery time <time>` / `clear delivery time` (get reminders at a fixed time of your day, e.g. `set delivery time 8am`)",
+			"`notification history` (the notifications I sent you lately)",
+			"`light notifications on|off` (no reminder for movies you just searched for)",
+			"`leaving streaming on|off` (when a streaming service stops listing one of your movies)",
+		},
+		Details:  "Sends your notifications to another chat, e.g. a group or a channel. I must be able to post there and you must be a member of it. With a delivery time, reminders arrive at that time in your timezone, the right number of days before the release. With light notifications I skip the reminder of a release you searched for in the last day. TMDB doesn't know when a movie leaves a streaming service, I can only tell you once it is no longer listed in your region.",
+		Examples: []string{"set notify chat -1001234567890", "clear notify chat", "notify via telegram", "set silent on", "set delivery time 8:00", "notification history", "light notifications on", "leaving streaming on"},
+	},
+	{
+		Name:     "history",
+		Usage:    []string{"`history <movie title>` (changes to the title or date of one of your subscriptions)"},
+		Details:  "Shows how the title, release date and production status of one of your subscriptions changed over time.",
+		Examples: []string{"history dune"},
+	},
+	{
+		Name:     "details",
+		Usage:    []string{"`details <movie title>` (status, runtime, budget and more)", "`cast photos on|off` (follow details with photos of the cast)"},
+		Details:  "Shows the tagline, director, main cast, production status, runtime, rating, budget and revenue of a movie, with a button to subscribe. `/movie <movie title>` works too.",
+		Examples: []string{"details alita", "/movie dune", "cast photos on"},
+	},
+	{
+		Name:     "inline",
+		Usage:    []string{"`@<my username> <movie title>` in any chat (suggestions while you type)"},
+		Details:  "Suggests the matching movies with their year as you type, pick one to share its release date in the chat.",
+		Examples: []string{"@<my username> alita"},
+	},
+	{
+		Name: "track",
+		Usage: []string{
+			"`track anime|show <title> season <n>` (get notified when a season premieres)",
+			"`subscribe next season <title>` (get notified when the next season is announced)",
+			"`season episodes on|off` (get notified about every episode of tracked seasons)",
+		},
+		Details:  "Tracks a season of a TV show, `anime` only looks for animated shows. Seasons not announced yet are tracked until they are, or until the show ends.",
+		Examples: []string{"track anime attack on titan season 4", "subscribe next season severance", "season episodes on"},
+	},
+	{
+		Name: "follow",
+		Usage: []string{
+			"`follow person <name>` / `unfollow person <name>` (get notified about the new movies of an actor or a director)",
+			"`following` (the upcoming movies of the people you follow)",
+		},
+		Details:  "Follows the movies a person acts in or directs: I let you know when one is announced, and when it gets a release date. You can follow up to 20 people.",
+		Examples: []string{"follow person greta gerwig", "unfollow person greta gerwig", "following"},
+	},
+	{
+		Name:     "compare",
+		Usage:    []string{"`compare <movie title>` (release dates across regions, earliest first)"},
+		Details:  "Shows where a movie comes out first.",
+		Examples: []string{"compare dune"},
+	},
+	{
+		Name:     "import",
+		Usage:    []string{"`import <TMDB list URL>` (subscribe to the upcoming movies of a list)"},
+		Details:  "Subscribes you to every upcoming movie of a public TMDB list.",
+		Examples: []string{"import https://www.themoviedb.org/list/1234"},
+	},
+	{
+		Name:     "summary",
+		Usage:    []string{"`monthly summary on|off` (a look back at each month)"},
+		Details:  "At the start of every month, sends the subscriptions released the month before and those coming out in the new month.",
+		Examples: []string{"monthly summary on"},
+	},
+	{
+		Name:     "pause",
+		Usage:    []string{"`pause notifications [until <yyyy-mm-dd>]` / `resume notifications` (nothing is lost, missed releases are sent on resume)"},
+		Details:  "Stops sending notifications, until you resume them or until the given date. What came due in the meantime is sent on resume.",
+		Examples: []string{"pause notifications until 2019-08-31", "resume notifications"},
+	},
+	{
+		Name:     "mute",
+		Usage:    []string{"`mute word <word>` / `unmute word <word>` (ignore group messages containing a word)"},
+		Details:  "In groups, I ignore the messages containing a muted word unless you mention me. Only group admins can change the muted words.",
+		Examples: []string{"mute word release", "unmute word release"},
+	},
+	{
+		Name:     "template",
+		Usage:    []string{"`set template upcoming|released <template>` / `clear template upcoming|released` (customize notifications, e.g. `{{.Title}} is out in {{.Days}} days!`)"},
+		Details:  "Customizes the wording of your notifications. Templates can use {{.Title}}, {{.Days}} and {{.Date}}.",
+		Examples: []string{"set template upcoming {{.Title}} is out in {{.Days}} days!", "clear template upcoming"},
+	},
+	{
+		Name: "region",
+		Usage: []string{
+			"`set region <country>` (e.g. `set region us` or `set region germany`)",
+			"`set timezone <timezone>` (e.g. `set timezone Europe/Berlin`)",
+			"`set regions <countries>` / `clear regions` (follow the earliest release date among several regions, e.g. `set regions us,gb,de`)",
+			"`set date policy primary|region|earliest` (which release date subscriptions follow, your region's by default)",
+		},
+		Details:  "Sets the region release dates are looked up for, and your timezone. The timezone defaults to the one of the region. With several regions, new subscriptions follow the earliest release date among them and notifications tell you where the movie comes out first. Otherwise subscriptions follow the date policy: the release date in your region, falling back to the primary TMDB date until there is one, the primary date, or the earliest date anywhere.",
+		Examples: []string{"set region us", "set region germany", "set timezone America/Los_Angeles", "set regions us,gb,de", "clear regions", "set date policy earliest"},
+	},
+	{
+		Name: "format",
+		Usage: []string{
+			"`set date format dmy|mdy|iso` (how dates are shown)",
+			"`set time format 12h|24h` (how times of day are shown)",
+		},
+		Details:  "Changes how dates and times are shown. The defaults depend on your region.",
+		Examples: []string{"set date format iso", "set time format 12h"},
+	},
+	{
+		Name:     "transfer",
+		Usage:    []string{"`transfer subscriptions to <chat id>` (move your subscriptions to another chat, which must accept)"},
+		Details:  "Moves all your subscriptions to another chat, e.g. a group or your new account. I give you a code to send there with `accept transfer <code>`.",
+		Examples: []string{"transfer subscriptions to -1001234567890", "accept transfer 1a2b3c4d"},
+	},
+	{
+		Name: "link",
+		Usage: []string{
+			"`link chat [<chat id>]` / `unlink chat [<chat id>]` (share notifications between your chats, e.g. your private chat and a channel)",
+			"`linked chats` / `set primary chat <chat id>|all` (where the notifications of your linked chats go)",
+		},
+		Details:  "Links this chat, and the given one, to you. The notifications of your linked chats go to each of them, or only to your primary chat, and a movie subscribed to from several of them is notified once. A chat can only be linked to one person.",
+		Examples: []string{"link chat -1001234567890", "set primary chat -1001234567890", "set primary chat all", "unlink chat"},
+	},
+	{
+		Name:     "tmdb",
+		Usage:    []string{"`set tmdb key <key>` / `clear tmdb key` (use your own TMDB API key instead of the shared one)"},
+		Details:  "Searches and movie details then count against your own TMDB rate limit, handy if you use the bot a lot. I check the key with TMDB before saving it encrypted, and delete your message so that it doesn't stay in the chat. Get a key at https://www.themoviedb.org/settings/api",
+		Examples: []string{"set tmdb key 0123456789abcdef0123456789abcdef", "clear tmdb key"},
+	},
+	{
+		Name:     "data",
+		Usage:    []string{"`my data` / `delete my data` (export or delete everything I know about this chat)"},
+		Details:  "Sends you a JSON export of your data, or deletes all of it after confirmation.",
+		Examples: []string{"my data", "delete my data"},
+	},
+}
+
+// helpAliases maps other words users might ask help for to the command name.
+var helpAliases = map[string]string{
+	"release":       "releases",
+	"subscriptions": "list",
+	"undated":       "releases",
+	"year":          "releases",
+	"again":         "releases",
+	"unsubscribe":   "subscribe",
+	"posters":       "subscribe",
+	"google":        "calendar",
+	"order":         "list",
+	"runtime":       "list",
+	"digest":        "list",
+	"trailer":       "trailers",
+	"movie":         "details",
+	"cast":          "details",
+	"season":        "track",
+	"anime":         "track",
+	"show":          "track",
+	"person":        "follow",
+	"unfollow":      "follow",
+	"following":     "follow",
+	"actor":         "follow",
+	"director":      "follow",
+	"resume":        "pause",
+	"templates":     "template",
+	"date":          "format",
+	"time":          "format",
+	"timezone":      "region",
+	"regions":       "region",
+	"policy":        "region",
+	"weekend":       "coming",
+	"privacy":       "data",
+	"silent":        "notify",
+	"light":         "notify",
+	"delivery":      "notify",
+	"streaming":     "notify",
+	"unmute":        "mute",
+	"monthly":       "summary",
+	"genre":         "discover",
+	"favorite":      "discover",
+	"preview":       "discover",
+	"trending":      "discover",
+	"next":          "soonest",
+	"delete":        "data",
+	"accept":        "transfer",
+	"unlink":        "link",
+	"linked":        "link",
+	"primary":       "link",
+	"move":          "transfer",
+	"key":           "tmdb",
+	"api":           "tmdb",
+}
+
+// findCommandHelp returns the help of the named command.
+func findCommandHelp(name string) (commandHelp, bool) {
+	name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "/")
+	if alias, ok := helpAliases[name]; ok {
+		name = alias
+	}
+	for _, h := range commandHelps {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return commandHelp{}, false
+}
+
+// helpOverview renders the list of commands, in Markdown.
+func helpOverview(prefs UserPrefs) string {
+	text := "Looking for information about movie releases? I can help with the following questions 😌\n"
+	for _, h := range commandHelps {
+		for _, u := range h.Usage {
+			text += u + "\n"
+		}
+	}
+	text += "\nSend `help <command>` for details and examples, e.g. `help subscribe`.\n\n"
+	text += "Current region: " + regionLabel(prefs.region())
+	return text
+}
+
+// formatCommandHelp renders the detailed help of a command, in Markdown.
+func formatCommandHelp(h commandHelp) string {
+	text := strings.Join(h.Usage, "\n") + "\n\n" + h.Details + "\n"
+	if len(h.Examples) > 0 {
+		text += "\nExamples:\n"
+		for _, e := range h.Examples {
+			text += "`" + e + "`\n"
+		}
+	}
+	return text
+}
+
+// handleHelp sends the detailed help of the given command, or the overview of
+// all commands when topic is empty or unknown.
+func handleHelp(ctx context.Context, update telegram.Update, topic string) {
+	chatID := update.Message.Chat.ID
+
+	var text string
+	if h, ok := findCommandHelp(topic); ok {
+		text = formatCommandHelp(h)
+	} else {
+		prefs, err := store.Prefs(ctx, chatID)
+		if err != nil {
+			logf(ctx, "failed to get user prefs: %s", err)
+		}
+		text = helpOverview(prefs)
+		if topic != "" {
+			text = "I don't know the " + escapeMarkdown(topic) + " command.\n\n" + text
+		}
+	}
+
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ParseMode = "Markdown"
+	sendMsg(ctx, msgConfig)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Kinds of logged notifications, see NotificationLog.
+const (
+	notificationReminder  = "reminder"
+	notificationReleased  = "released"
+	notificationMissed    = "missed release"
+	notificationTrailer   = "trailer"
+	notificationReplaced  = "replaced on TMDB"
+	notificationMissing   = "missing from TMDB"
+	notificationSurprise  = "surprise"
+	notificationSeason    = "season"
+	notificationEpisode   = "episode"
+	notificationShowEnded = "show ended"
+	notificationStreaming = "streaming"
+	notificationSummary   = "monthly summary"
+	notificationFollowed  = "followed person"
+)
+
+const (
+	// notificationLogRetention is how long sent notifications are logged.
+	notificationLogRetention = 90 * 24 * time.Hour
+	// maxNotificationHistory is how many notifications the history lists.
+	maxNotificationHistory = 20
+)
+
+// notificationLog returns the log of a notification of the given kind about
+// the movie or TV show, see sendNotification.
+func notificationLog(kind string, id int64, title string) NotificationLog {
+	return NotificationLog{MovieID: id, Title: title, Kind: kind}
+}
+
+// seasonLog is like notificationLog for a season of a TV show.
+func seasonLog(kind string, record SeasonRelease) NotificationLog {
+	return notificationLog(kind, record.ShowID, fmt.Sprintf("%s season %d", record.ShowName, record.Season))
+}
+
+// recordNotifications stores the logs of a notification sent to the chat.
+// Failures are only logged, the notification is already sent.
+func recordNotifications(ctx context.Context, chatID int64, logs []NotificationLog) {
+	if len(logs) == 0 {
+		return
+	}
+	now := time.Now()
+	for i := range logs {
+		logs[i].ChatID = chatID
+		logs[i].SentAt = now
+	}
+	if err := store.PutNotificationLogs(ctx, logs); err != nil {
+		logf(ctx, "failed to log notification: chat_id=%d: %s", chatID, err)
+	}
+}
+
+// cleanupNotificationLogs deletes the logs older than
+// notificationLogRetention.
+func cleanupNotificationLogs(ctx context.Context) {
+	deleted, err := store.DeleteNotificationLogs(ctx, 0, time.Now().Add(-notificationLogRetention))
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to delete old notification logs")
+		return
+	}
+	logf(ctx, "cleaned up notification logs: deleted=%d", deleted)
+}
+
+// chatNotificationLogs returns the logged notifications of the chat, latest
+// first.
+func chatNotificationLogs(ctx context.Context, chatID int64) ([]NotificationLog, error) {
+	logs, err := store.NotificationLogs(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].SentAt.After(logs[j].SentAt) })
+	return logs, nil
+}
+
+func handleNotificationHistory(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	logs, err := chatNotificationLogs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get notification logs")
+		return
+	}
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+
+	if len(logs) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("I haven't sent you any notification in the last %d days.", int(notificationLogRetention.Hours()/24))))
+		return
+	}
+	if len(logs) > maxNotificationHistory {
+		logs = logs[:maxNotificationHistory]
+	}
+
+	text := "Your latest notifications 🔔\n"
+	for _, l := range logs {
+		text += fmt.Sprintf("- %s, %s", prefs.formatDate(l.SentAt.In(prefs.location())), l.Kind)
+		if l.Title != "" {
+			text += ": " + displayTitle(l.Title, 0)
+		}
+		text += "\n"
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
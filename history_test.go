@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyReleasesLogsNotifications(t *testing.T) {
+	s := useMemStore(t)
+	useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	day := now.AddDate(0, 0, 2)
+	for i, title := range []string{"Dune", "Alien"} {
+		// Sent to another chat, logged for the chat that subscribed
+		release := MovieRelease{ID: int64(i + 1), MovieTitle: title, ReleaseDate: day, Subscribers: []Subscriber{{ChatID: 42, NotifyChatID: -100}}}
+		if err := store.PutRelease(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifyReleases(ctx)
+
+	logs := s.logs
+	if len(logs) != 2 {
+		t.Fatalf("logs = %+v, want one per release of the combined notification", logs)
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i].MovieID < logs[j].MovieID })
+	for i, title := range []string{"Dune", "Alien"} {
+		l := logs[i]
+		if l.ChatID != 42 || l.MovieID != int64(i+1) || l.Title != title || l.Kind != notificationReminder || l.SentAt.Before(now) {
+			t.Errorf("log = %+v, want the reminder of %s to chat 42", l, title)
+		}
+	}
+}
+
+func TestHandleNotificationHistory(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+
+	handleNotificationHistory(ctx, testMessage(42, "notification history"))
+	if texts := tg.texts(42); len(texts) != 1 || !strings.HasPrefix(texts[0], "I haven't sent you any notification in the last 90 days.") {
+		t.Fatalf("sent %q, want no notification reported", texts)
+	}
+
+	now := time.Now()
+	s.logs = append(s.logs,
+		NotificationLog{ChatID: 42, MovieID: 1, Title: "Dune", Kind: notificationReminder, SentAt: now.AddDate(0, 0, -2)},
+		NotificationLog{ChatID: 42, MovieID: 1, Title: "Dune", Kind: notificationReleased, SentAt: now},
+		NotificationLog{ChatID: 43, MovieID: 2, Title: "Alien", Kind: notificationReminder, SentAt: now},
+	)
+	for i := 0; i < maxNotificationHistory; i++ {
+		s.logs = append(s.logs, NotificationLog{ChatID: 42, Kind: notificationSummary, SentAt: now.AddDate(0, 0, -10-i)})
+	}
+
+	handleNotificationHistory(ctx, testMessage(42, "notification history"))
+	texts := tg.texts(42)
+	lines := strings.Split(strings.TrimSpace(texts[len(texts)-1]), "\n")
+	if len(lines) != maxNotificationHistory+1 {
+		t.Fatalf("history has %d lines, want %d notifications: %q", len(lines)-1, maxNotificationHistory, lines)
+	}
+	if !strings.HasSuffix(lines[1], "released: Dune") || !strings.HasSuffix(lines[2], "reminder: Dune") {
+		t.Errorf("history = %q, want the latest notifications first", lines)
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "Alien") {
+			t.Errorf("history lists %q, a notification of another chat", line)
+		}
+	}
+}
+
+func TestCleanupNotificationLogs(t *testing.T) {
+	s := useMemStore(t)
+	now := time.Now()
+	s.logs = []NotificationLog{
+		{ChatID: 42, Kind: notificationReminder, SentAt: now.Add(-notificationLogRetention - time.Hour)},
+		{ChatID: 42, Kind: notificationReleased, SentAt: now.Add(-notificationLogRetention + time.Hour)},
+	}
+
+	cleanupNotificationLogs(context.Background())
+
+	if len(s.logs) != 1 || s.logs[0].Kind != notificationReleased {
+		t.Errorf("logs = %+v, want only the logs past the retention deleted", s.logs)
+	}
+}
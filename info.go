@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	callbackInfo = "info"
+	// callbackSubscribeResult subscribes from a row of a result list. Unlike
+	// callbackSubscribe the keyboard is left untouched, it holds the buttons
+	// of the other results.
+	callbackSubscribeResult = "subscriberesult"
+
+	// infoButtonTitleLength is the length titles are truncated to in info
+	// buttons.
+	infoButtonTitleLength = 30
+)
+
+// infoButton returns an inline button sending the details of the movie.
+func infoButton(m MovieAPIResult) telegram.InlineKeyboardButton {
+	title, _ := truncateTitle(m.Title, infoButtonTitleLength)
+	return telegram.NewInlineKeyboardButtonData("ℹ️ "+title, fmt.Sprintf("%s:%d", callbackInfo, m.ID))
+}
+
+// resultsKeyboard returns one row per result with its info button, followed
+// by a subscribe button for upcoming movies.
+func resultsKeyboard(results MovieAPIResults, now time.Time) telegram.InlineKeyboardMarkup {
+	var rows [][]telegram.InlineKeyboardButton
+	for _, m := range results {
+		row := telegram.NewInlineKeyboardRow(infoButton(m))
+		if m.ReleaseTime.After(now) {
+			row = append(row, telegram.NewInlineKeyboardButtonData("🔔", fmt.Sprintf("%s:%d", callbackSubscribeResult, m.ID)))
+		}
+		rows = append(rows, row)
+	}
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleInfoCallback sends the details of the movie of an info button, as
+// the details command does. It returns the callback answer.
+func handleInfoCallback(ctx context.Context, query *telegram.CallbackQuery, arg string) string {
+	chatID := query.Message.Chat.ID
+
+	movieID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		logf(ctx, "invalid movie id in callback data: %q", query.Data)
+		return ""
+	}
+
+	details, err := movieDetails(ctx, movieID, "credits")
+	if err != nil {
+		return callbackTMDBFailed(ctx, chatID, err, "failed to get movie details")
+	}
+
+	subscribed, err := isSubscribed(ctx, chatID, details.ID)
+	if err != nil {
+		logf(ctx, "failed to get subscriptions, sending details anyway: %s", err)
+	}
+	prefs := prefsOrDefault(ctx, chatID)
+
+	msgConfig := telegram.NewMessage(chatID, formatMovieDetails(details))
+	msgConfig.ParseMode = "Markdown"
+	if subscribed || details.ReleaseTime.After(time.Now()) {
+		msgConfig.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(subscriptionButton(details.ID, subscribed)))
+	}
+	sendMsg(ctx, msgConfig)
+	if prefs.CastPhotos {
+		sendCastPhotos(ctx, chatID, details)
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// inlineDebounce is how long an inline query waits for the user to stop
+	// typing. Telegram sends a query per keystroke, only the latest one is
+	// searched.
+	inlineDebounce = 400 * time.Millisecond
+	// inlineMinQueryLength is the shortest query searched.
+	inlineMinQueryLength = 3
+	// maxInlineSuggestions caps the number of suggestions returned.
+	maxInlineSuggestions = 5
+	// inlineCacheTime is how long Telegram caches the suggestions of a query,
+	// in seconds. Recent queries are also served from the TMDB cache.
+	inlineCacheTime = 300
+	// inlineThrottledCacheTime is how long Telegram caches the empty answer
+	// of a throttled query, short so that the user gets results once the
+	// searches slow down.
+	inlineThrottledCacheTime = 5
+	// inlineSearchRate and inlineSearchBurst bound the TMDB searches made for
+	// inline queries, all users together, in searches per second.
+	inlineSearchRate  = 4
+	inlineSearchBurst = 10
+)
+
+// inlineThrottled counts the inline queries answered without searching.
+var inlineThrottled = expvar.NewInt("inline_queries_throttled")
+
+// searchLimiter is a token bucket bounding the rate of searches.
+type searchLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var inlineSearches = newSearchLimiter(inlineSearchRate, inlineSearchBurst)
+
+func newSearchLimiter(rate, burst float64) *searchLimiter {
+	return &searchLimiter{rate: rate, burst: burst, tokens: burst}
+}
+
+// allow returns whether a search can be made now, and takes it into account.
+func (l *searchLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// inlineDebouncer remembers the latest inline query of each user.
+type inlineDebouncer struct {
+	mu     sync.Mutex
+	latest map[int]string
+}
+
+var inlineQueries = &inlineDebouncer{latest: map[int]string{}}
+
+// wait waits for the debounce delay and returns whether the query is still
+// the latest of the user, i.e. whether it should be answered.
+func (d *inlineDebouncer) wait(ctx context.Context, userID int, queryID string, delay time.Duration) bool {
+	d.mu.Lock()
+	d.latest[userID] = queryID
+	d.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.latest[userID] != queryID {
+		return false
+	}
+	delete(d.latest, userID)
+	return true
+}
+
+// inlineSuggestions returns the articles suggested for the search results,
+// at most maxInlineSuggestions.
+func inlineSuggestions(results MovieAPIResults) []interface{} {
+	if len(results) > maxInlineSuggestions {
+		results = results[:maxInlineSuggestions]
+	}
+
+	articles := make([]interface{}, 0, len(results))
+	for _, m := range results {
+		year := "unknown release date"
+		if !m.ReleaseTime.IsZero() {
+			year = strconv.Itoa(m.ReleaseTime.Year())
+		}
+		text := fmt.Sprintf("%s (%s)\nRelease: %s\n%s", m.Title, year, formatReleaseDate(m.ReleaseTime), tmdbMovieURL(m.ID))
+		article := telegram.NewInlineQueryResultArticle(strconv.FormatInt(m.ID, 10), m.Title, text)
+		article.Description = year
+		articles = append(articles, article)
+	}
+	return articles
+}
+
+// handleInlineQuery answers an inline query with the matching movies once the
+// user stopped typing. It runs in its own goroutine so that waiting doesn't
+// delay other updates. Throttled queries get no suggestions, see
+// inlineSearches.
+func handleInlineQuery(ctx context.Context, query *telegram.InlineQuery) {
+	title := strings.TrimSpace(strings.ToLower(query.Query))
+	if len([]rune(title)) < inlineMinQueryLength || query.From == nil {
+		return
+	}
+	if !inlineQueries.wait(ctx, query.From.ID, query.ID, inlineDebounce) {
+		return
+	}
+
+	// Anyone can send inline queries, they must not use up the TMDB quota
+	// the notifications need
+	now := time.Now()
+	if tmdb.quota.low(now) || !inlineSearches.allow(now) {
+		inlineThrottled.Add(1)
+		answerInlineQuery(ctx, query, []interface{}{}, inlineThrottledCacheTime)
+		return
+	}
+
+	results, err := queryMovies(ctx, title, "")
+	if err != nil {
+		logf(ctx, "failed to search movies for inline query: %s", err)
+		if keyRejected(ctx, err) {
+			forgetRejectedKey(ctx, int64(query.From.ID))
+		}
+		return
+	}
+	answerInlineQuery(ctx, query, inlineSuggestions(results), inlineCacheTime)
+}
+
+// answerInlineQuery sends the suggestions for the inline query, cached by
+// Telegram for cacheTime seconds.
+func answerInlineQuery(ctx context.Context, query *telegram.InlineQuery, suggestions []interface{}, cacheTime int) {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	_, err := bot.AnswerInlineQuery(telegram.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       suggestions,
+		CacheTime:     cacheTime,
+	})
+	if err != nil {
+		logf(ctx, "failed to answer inline query: %s", err)
+	}
+}
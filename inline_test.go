@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestSearchLimiter(t *testing.T) {
+	l := newSearchLimiter(2, 3)
+	now := time.Now()
+
+	steps := []struct {
+		after time.Duration
+		want  bool
+	}{
+		// The burst is allowed right away
+		{0, true},
+		{0, true},
+		{0, true},
+		{0, false},
+		// Tokens come back at the rate
+		{250 * time.Millisecond, false},
+		{250 * time.Millisecond, true},
+		{0, false},
+		// Never more than the burst
+		{time.Minute, true},
+		{0, true},
+		{0, true},
+		{0, false},
+	}
+	for i, s := range steps {
+		now = now.Add(s.after)
+		if got := l.allow(now); got != s.want {
+			t.Errorf("step %d: allow() = %t, want %t", i, got, s.want)
+		}
+	}
+}
+
+func TestInlineDebouncer(t *testing.T) {
+	d := &inlineDebouncer{latest: map[int]string{}}
+	ctx := context.Background()
+
+	first := make(chan bool)
+	go func() { first <- d.wait(ctx, 7, "first", 200*time.Millisecond) }()
+	time.Sleep(20 * time.Millisecond)
+	if !d.wait(ctx, 7, "second", 10*time.Millisecond) {
+		t.Error("latest query not answered")
+	}
+	// Another user isn't debounced with the first one
+	if !d.wait(ctx, 8, "other", 10*time.Millisecond) {
+		t.Error("query of another user not answered")
+	}
+	if <-first {
+		t.Error("query answered although the user typed another one")
+	}
+}
+
+func TestHandleInlineQueryThrottled(t *testing.T) {
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 438631, "title": "Dune", "release_date": "2021-09-15"},
+		},
+	})
+	previous := inlineSearches
+	inlineSearches = newSearchLimiter(0.001, 1)
+	t.Cleanup(func() { inlineSearches = previous })
+
+	for _, id := range []string{"1", "2"} {
+		handleInlineQuery(context.Background(), &telegram.InlineQuery{ID: id, From: &telegram.User{ID: 7}, Query: "dune"})
+	}
+
+	answers := tg.sent("answerInlineQuery")
+	if len(answers) != 2 {
+		t.Fatalf("sent %d answers, want 2", len(answers))
+	}
+	if got := answers[0].Params.Get("cache_time"); got != "300" || answers[0].Params.Get("results") == "[]" {
+		t.Errorf("first answer cache_time=%s results=%s, want suggestions", got, answers[0].Params.Get("results"))
+	}
+	if got := answers[1].Params.Get("cache_time"); got != "5" || answers[1].Params.Get("results") != "[]" {
+		t.Errorf("throttled answer cache_time=%s results=%s, want no suggestions cached shortly", got, answers[1].Params.Get("results"))
+	}
+	if n := api.requests("/search/movie"); n != 1 {
+		t.Errorf("sent %d searches to TMDB, want only the first query searched", n)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const (
+	// leaseTTL is how long a job lease is held without renewal. A crashed
+	// leader is replaced once its lease expires.
+	leaseTTL = 2 * time.Minute
+	// leaseRenewInterval is how often the leader renews its lease while the
+	// job runs.
+	leaseRenewInterval = leaseTTL / 3
+)
+
+// NotifyLease is the datastore entity electing the instance running a
+// scheduled job, one per job.
+type NotifyLease struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// available returns whether owner can take the lease at the given time:
+// nobody holds it, it expired, or owner already holds it.
+func (l NotifyLease) available(owner string, now time.Time) bool {
+	return l.Owner == "" || l.Owner == owner || !now.Before(l.ExpiresAt)
+}
+
+// instanceID identifies this instance as a lease owner.
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	if id := os.Getenv("GAE_INSTANCE"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return host + "-" + newRequestID()
+}
+
+// runAsLeader runs the job only if this instance can acquire the lease named
+// after it, so that a scheduled job triggered on several instances runs only
+// once. The lease is renewed while the job runs and released afterwards. The
+// context given to job is canceled if the lease is lost. It returns whether
+// the job ran.
+func runAsLeader(ctx context.Context, name string, job func(ctx context.Context)) bool {
+	ok, err := store.AcquireLease(ctx, name, instanceID, time.Now().Add(leaseTTL))
+	if err != nil {
+		logf(ctx, "failed to acquire lease: name=%s: %s", name, err)
+		return false
+	}
+	if !ok {
+		logf(ctx, "lease held by another instance, skipping job: name=%s", name)
+		return false
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ok, err := store.AcquireLease(ctx, name, instanceID, time.Now().Add(leaseTTL))
+				if err != nil {
+					// Keep going, the lease is still valid until it expires
+					logf(ctx, "failed to renew lease: name=%s: %s", name, err)
+					continue
+				}
+				if !ok {
+					logf(ctx, "lease taken over by another instance: name=%s", name)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	job(jobCtx)
+
+	close(done)
+	cancel()
+	if err := store.ReleaseLease(ctx, name, instanceID); err != nil {
+		logf(ctx, "failed to release lease: name=%s: %s", name, err)
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNotifyLeaseAvailable(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		lease NotifyLease
+		owner string
+		want  bool
+	}{
+		{NotifyLease{}, "a", true},
+		{NotifyLease{Owner: "a", ExpiresAt: now.Add(time.Minute)}, "a", true},
+		{NotifyLease{Owner: "a", ExpiresAt: now.Add(time.Minute)}, "b", false},
+		{NotifyLease{Owner: "a", ExpiresAt: now}, "b", true},
+		{NotifyLease{Owner: "a", ExpiresAt: now.Add(-time.Minute)}, "b", true},
+	}
+	for _, tt := range tests {
+		if got := tt.lease.available(tt.owner, now); got != tt.want {
+			t.Errorf("%+v.available(%q) = %t, want %t", tt.lease, tt.owner, got, tt.want)
+		}
+	}
+}
+
+func TestRunAsLeader(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+
+	// Held by another instance
+	s.leases["notify"] = NotifyLease{Owner: "other", ExpiresAt: time.Now().Add(time.Minute)}
+	if runAsLeader(ctx, "notify", func(ctx context.Context) { t.Error("job ran while the lease was held") }) {
+		t.Error("runAsLeader() = true while the lease was held")
+	}
+
+	// Taken over once expired, and released after the job
+	s.leases["notify"] = NotifyLease{Owner: "other", ExpiresAt: time.Now().Add(-time.Second)}
+	ran := false
+	ok := runAsLeader(ctx, "notify", func(ctx context.Context) {
+		ran = true
+		if lease := s.leases["notify"]; lease.Owner != instanceID {
+			t.Errorf("lease owner = %q during the job, want %q", lease.Owner, instanceID)
+		}
+	})
+	if !ok || !ran {
+		t.Errorf("runAsLeader() = %t, ran = %t, want the expired lease taken over", ok, ran)
+	}
+	if lease, held := s.leases["notify"]; held {
+		t.Errorf("lease = %+v after the job, want it released", lease)
+	}
+
+	// Held again by this instance, e.g. after a crash, it's renewed
+	s.leases["notify"] = NotifyLease{Owner: instanceID, ExpiresAt: time.Now().Add(time.Minute)}
+	if !runAsLeader(ctx, "notify", func(ctx context.Context) {}) {
+		t.Error("runAsLeader() = false with a lease held by this instance")
+	}
+}
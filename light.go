@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// lightQueryWindow is how recently a chat must have searched for a movie for
+// its reminder to be skipped with light notifications.
+const lightQueryWindow = 24 * time.Hour
+
+// queriedRecently returns whether the chat searched for the movie within
+// lightQueryWindow.
+func (s Subscriber) queriedRecently(now time.Time) bool {
+	return !s.QueriedAt.IsZero() && now.Sub(s.QueriedAt) < lightQueryWindow
+}
+
+// recordQueries records when the chat searched for the movies it is
+// subscribed to among the results, if opted in to light notifications.
+// Failures are only logged, the search itself already succeeded.
+func recordQueries(ctx context.Context, chatID int64, results MovieAPIResults) {
+	if len(results) == 0 {
+		return
+	}
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		logf(ctx, "failed to get user prefs, query not recorded: %s", err)
+		return
+	}
+	if !prefs.LightNotifications {
+		return
+	}
+
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		logf(ctx, "failed to get subscriptions, query not recorded: %s", err)
+		return
+	}
+	subscribed := map[int64]bool{}
+	for _, rec := range subscriptions {
+		subscribed[rec.ID] = true
+	}
+
+	now := time.Now()
+	for _, m := range results {
+		if !subscribed[m.ID] {
+			continue
+		}
+		err := updateSubscriber(ctx, m.ID, chatID, func(sub *Subscriber) {
+			sub.QueriedAt = now
+		})
+		if err != nil {
+			logf(ctx, "failed to record query: id=%d: %s", m.ID, err)
+			return
+		}
+	}
+}
+
+func handleLightNotifications(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.LightNotifications = enabled
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "I'll remind you of every release again."
+	if enabled {
+		text = "I won't remind you of a release if you searched for it in the last day."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// linked returns whether the chat is linked to the user.
+func (u User) linked(chatID int64) bool {
+	for _, id := range u.ChatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// unlink removes the chat from the linked chats of the user, and as primary
+// chat.
+func (u *User) unlink(chatID int64) {
+	var chats []int64
+	for _, id := range u.ChatIDs {
+		if id != chatID {
+			chats = append(chats, id)
+		}
+	}
+	u.ChatIDs = chats
+	if u.PrimaryChatID == chatID {
+		u.PrimaryChatID = 0
+	}
+}
+
+// chatUsers returns the users having linked chats, by linked chat.
+func chatUsers(ctx context.Context) (map[int64]User, error) {
+	users, err := store.Users(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byChat := map[int64]User{}
+	for _, u := range users {
+		for _, id := range u.ChatIDs {
+			byChat[id] = u
+		}
+	}
+	return byChat, nil
+}
+
+// routeNotifications sends the notifications of the chats linked to a user
+// to its primary chat, or to each of its linked chats. A release the user is
+// subscribed to from several linked chats is notified once, the other
+// subscribers being marked notified along.
+func routeNotifications(pending []pendingNotification, users map[int64]User) []pendingNotification {
+	type userRelease struct {
+		userID, releaseID int64
+	}
+
+	var routed []pendingNotification
+	first := map[userRelease]int{}
+	for _, n := range pending {
+		u, ok := users[n.sub.ChatID]
+		if !ok {
+			routed = append(routed, n)
+			continue
+		}
+		key := userRelease{u.UserID, n.releaseID}
+		if i, ok := first[key]; ok {
+			routed[i].marks = append(routed[i].marks, n.sub.ChatID)
+			continue
+		}
+		first[key] = len(routed)
+
+		recipients := u.ChatIDs
+		if u.PrimaryChatID != 0 {
+			recipients = []int64{u.PrimaryChatID}
+		}
+		for i, chatID := range recipients {
+			c := n
+			// Only the first copy marks the subscriber notified
+			c.marks = []int64{}
+			if i == 0 {
+				c.marks = []int64{n.sub.ChatID}
+			}
+			if chatID != n.sub.ChatID {
+				c.sub.ChatID, c.sub.NotifyChatID, c.sub.ThreadID = chatID, 0, 0
+			}
+			routed = append(routed, c)
+		}
+	}
+	return routed
+}
+
+// handleLinkChat links the chat, and the given one if any, to the user
+// sending the command. Their notifications then follow the linked chats
+// setting, see routeNotifications. Only admins can link groups and channels.
+func handleLinkChat(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil {
+		return
+	}
+	if !requireGroupAdmin(ctx, update.Message, "link this chat") {
+		return
+	}
+	userID := int64(update.Message.From.ID)
+
+	chats := []int64{chatID}
+	if matches[1] != "" {
+		target, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			sendMsg(ctx, telegram.NewMessage(chatID, "That doesn't look like a chat ID."))
+			return
+		}
+		if err := validateNotifyChat(target, update.Message.From.ID); err != nil {
+			logf(ctx, "invalid chat to link %d: %s", target, err)
+			sendMsg(ctx, telegram.NewMessage(chatID, "I can't send messages to that chat. Add me to it first, and make sure you are a member too."))
+			return
+		}
+		// Other than the private chat of the user, the target is a group or
+		// a channel
+		if target != userID {
+			admin, err := isChatAdmin(target, update.Message.From.ID)
+			if err != nil {
+				logf(ctx, "failed to get chat membership: %s", err)
+				sendMsg(ctx, telegram.NewMessage(chatID, "I couldn't check whether you are an admin of that chat, try again later."))
+				return
+			}
+			if !admin {
+				sendMsg(ctx, telegram.NewMessage(chatID, "Only admins of that chat can link it."))
+				return
+			}
+		}
+		chats = append(chats, target)
+	}
+
+	var user User
+	err := store.UpdateUser(ctx, userID, func(u *User) error {
+		for _, id := range chats {
+			if !u.linked(id) {
+				u.ChatIDs = append(u.ChatIDs, id)
+			}
+		}
+		if u.LinkedAt.IsZero() {
+			u.LinkedAt = time.Now()
+		}
+		user = *u
+		return nil
+	})
+	if errors.Cause(err) == errChatLinked {
+		sendMsg(ctx, telegram.NewMessage(chatID, "That chat is linked to someone else, they must unlink it first with \"unlink chat\"."))
+		return
+	}
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to link chat")
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, "Linked 🔗\n"+linkedChatsText(user, chatID)))
+}
+
+func handleUnlinkChat(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil {
+		return
+	}
+
+	target := chatID
+	if matches[1] != "" {
+		id, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			sendMsg(ctx, telegram.NewMessage(chatID, "That doesn't look like a chat ID."))
+			return
+		}
+		target = id
+	}
+
+	var user User
+	linked := true
+	err := store.UpdateUser(ctx, int64(update.Message.From.ID), func(u *User) error {
+		if !u.linked(target) {
+			linked = false
+			return errSkipUpdate
+		}
+		u.unlink(target)
+		user = *u
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to unlink chat")
+		return
+	}
+	if !linked {
+		sendMsg(ctx, telegram.NewMessage(chatID, "This chat isn't linked to you."))
+		return
+	}
+
+	text := fmt.Sprintf("Unlinked chat %d, it gets its own notifications again.", target)
+	if len(user.ChatIDs) > 0 {
+		text += "\n" + linkedChatsText(user, chatID)
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+func handleLinkedChats(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil {
+		return
+	}
+
+	user, err := store.User(ctx, int64(update.Message.From.ID))
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user")
+		return
+	}
+	if len(user.ChatIDs) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You have no linked chats, link one with \"link chat <chat id>\"."))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, linkedChatsText(user, chatID)))
+}
+
+// handlePrimaryChat sets the linked chat receiving the notifications of all
+// the linked chats of the user. Only admins can set it from groups and
+// channels.
+func handlePrimaryChat(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil {
+		return
+	}
+	if !requireGroupAdmin(ctx, update.Message, "change where linked chats are notified") {
+		return
+	}
+
+	var primary int64
+	if matches[1] != "all" {
+		id, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			sendMsg(ctx, telegram.NewMessage(chatID, "That doesn't look like a chat ID."))
+			return
+		}
+		primary = id
+	}
+
+	var user User
+	linked := true
+	err := store.UpdateUser(ctx, int64(update.Message.From.ID), func(u *User) error {
+		if primary != 0 && !u.linked(primary) {
+			linked = false
+			return errSkipUpdate
+		}
+		u.PrimaryChatID = primary
+		user = *u
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to set primary chat")
+		return
+	}
+	if !linked {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Chat %d isn't linked to you, link it first with \"link chat %d\".", primary, primary)))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, linkedChatsText(user, chatID)))
+}
+
+// linkedChatsText lists the linked chats of the user and where their
+// notifications go.
+func linkedChatsText(user User, chatID int64) string {
+	text := "Your linked chats:\n"
+	for _, id := range user.ChatIDs {
+		text += fmt.Sprintf("- %d", id)
+		if id == chatID {
+			text += " (this chat)"
+		}
+		if id == user.PrimaryChatID {
+			text += " ⭐"
+		}
+		text += "\n"
+	}
+	if user.PrimaryChatID != 0 {
+		return text + fmt.Sprintf("Their notifications go to chat %d.", user.PrimaryChatID)
+	}
+	return text + "Their notifications go to each of them."
+}
+
+// unlinkChatUser unlinks the chat from the user it is linked to, if any.
+func unlinkChatUser(ctx context.Context, chatID int64) error {
+	user, err := store.ChatUser(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if user.UserID == 0 {
+		return nil
+	}
+	err = store.UpdateUser(ctx, user.UserID, func(u *User) error {
+		if !u.linked(chatID) {
+			return errSkipUpdate
+		}
+		u.unlink(chatID)
+		return nil
+	})
+	return errors.Wrap(err, "failed to unlink chat")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestHandleLinkChat(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	const group = -100
+	groupMessage := func(text string) telegram.Update {
+		update := testMessage(7, text)
+		update.Message.Chat = &telegram.Chat{ID: group, Type: "group"}
+		return update
+	}
+
+	tg.results["getChatMember"] = `{"user":{"id":7},"status":"member"}`
+	handleUpdate(groupMessage("link chat"))
+	if texts := tg.texts(group); len(texts) != 1 || texts[0] != "Only group admins can link this chat." {
+		t.Errorf("sent %q, want the member refused", texts)
+	}
+	if len(s.users) != 0 {
+		t.Fatalf("users = %+v, want the group left unlinked", s.users)
+	}
+
+	tg.results["getChatMember"] = `{"user":{"id":7},"status":"administrator"}`
+	handleUpdate(groupMessage("link chat"))
+	if chats := s.users[7].ChatIDs; !reflect.DeepEqual(chats, []int64{group}) {
+		t.Errorf("linked chats = %v, want the group", chats)
+	}
+
+	// Another admin of the group can't link it to them
+	tg.results["getChat"] = `{"id":-100,"type":"group"}`
+	handleUpdate(testMessage(8, "link chat -100"))
+	if texts := tg.texts(8); len(texts) != 1 || !strings.HasPrefix(texts[0], "That chat is linked to someone else") {
+		t.Errorf("sent %q, want the chat told linked to someone else", texts)
+	}
+	if _, ok := s.users[8]; ok {
+		t.Error("the group was linked to a second user")
+	}
+	if user, _ := store.ChatUser(context.Background(), group); user.UserID != 7 {
+		t.Errorf("user of the group = %d, want 7", user.UserID)
+	}
+
+	handleUpdate(testMessage(7, "unlink chat -100"))
+	if _, ok := s.users[7]; ok {
+		t.Errorf("users = %+v, want the user deleted with its last linked chat", s.users)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	timingsKey
+	tmdbKeyKey
+)
+
+// newRequestID returns a random identifier for a single bot update or task
+// run.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// withRequestID returns a copy of ctx carrying the given request ID.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// requestID returns the request ID carried by ctx, or an empty string.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// logPrefix returns the fields prefixed to every log line of the request.
+func logPrefix(ctx context.Context) string {
+	if id := requestID(ctx); id != "" {
+		return "request_id=" + id + " "
+	}
+	return ""
+}
+
+// logf logs a message including the request ID carried by ctx.
+func logf(ctx context.Context, format string, v ...interface{}) {
+	log.Print(logPrefix(ctx) + fmt.Sprintf(format, v...))
+}
+
+// fatalf is like logf followed by a call to os.Exit(1).
+func fatalf(ctx context.Context, format string, v ...interface{}) {
+	log.Fatal(logPrefix(ctx) + fmt.Sprintf(format, v...))
+}
+
+// parseTelegramDebug parses TELEGRAM_DEBUG. Debug logging is off unless
+// explicitly turned on: the telegram client then logs every request and
+// response in full, including the text of the messages users send.
+func parseTelegramDebug(v string) bool {
+	if v == "" {
+		return false
+	}
+	debug, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("WARNING: invalid TELEGRAM_DEBUG %q, debug logging is off", v)
+		return false
+	}
+	return debug
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseTelegramDebug(t *testing.T) {
+	tests := []struct {
+		v    string
+		want bool
+	}{
+		// Off by default
+		{"", false},
+		{"false", false},
+		{"0", false},
+		{"true", true},
+		{"1", true},
+		{"verbose", false},
+	}
+	for _, tt := range tests {
+		if got := parseTelegramDebug(tt.v); got != tt.want {
+			t.Errorf("parseTelegramDebug(%q) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestLogfRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	logf(withRequestID(context.Background(), "abc123"), "refreshed: id=%d", 42)
+	if got := logs.String(); !strings.Contains(got, "request_id=abc123 refreshed: id=42") {
+		t.Errorf("logged %q, want the request ID before the message", got)
+	}
+}
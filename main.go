@@ -2,54 +2,136 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math"
+	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/pkg/errors"
 )
 
-const region = "DE"
-
 var (
 	regionToEmoji = map[string]string{
 		"DE": "🇩🇪",
+		"US": "🇺🇸",
+		"GB": "🇬🇧",
+		"FR": "🇫🇷",
+		"JP": "🇯🇵",
 	}
 
 	subscribeCommand         = regexp.MustCompile("subscribe to (.+)")
+	bulkSubscribeCommand     = regexp.MustCompile("(?s)subscribe list:?\\s+(.+)")
 	releaseCommand           = regexp.MustCompile("releases? ?(exact)? (.+)")
 	releaseYearCommand       = regexp.MustCompile("releases? ?(exact)? (.+) year ([0-9]{4})")
-	listSubscriptionsCommand = regexp.MustCompile("list subscriptions?")
-
-	movieAPIKey     = ""
-	datastoreClient *datastore.Client
-	bot             *telegram.BotAPI
+	listSubscriptionsCommand = regexp.MustCompile("list subscriptions?( by month)?")
+	surpriseCommand          = regexp.MustCompile("surprise me(?: (.+))?")
+	surpriseNotifyCommand    = regexp.MustCompile("^surprise notifications (on|off)$")
+	favoriteGenreCommand     = regexp.MustCompile("^set favorite genre (.+)$")
+	discoverCommand          = regexp.MustCompile("^/?discover$")
+	previewCommand           = regexp.MustCompile("^preview (.+)$")
+	soonestCommand           = regexp.MustCompile("^/?soonest$")
+	trailersCommand          = regexp.MustCompile("trailers (on|off)(?: for (.+))?")
+	countdownCommand         = regexp.MustCompile("^countdown (on|off) for (.+)$")
+	notifyChatCommand        = regexp.MustCompile("(set|clear) notify chat ?(-?[0-9]+)?")
+	historyCommand           = regexp.MustCompile("history (.+)")
+	myDataCommand            = regexp.MustCompile("^my data$")
+	importCommand            = regexp.MustCompile("^import (\\S+)")
+	detailsCommand           = regexp.MustCompile("(?:^/movie(?:@\\w+)?|details) (.+)")
+	deleteMyDataCommand      = regexp.MustCompile("^delete my data$")
+	helpCommand              = regexp.MustCompile("^/?help(?:@\\w+)?(?: (.+))?$")
+	pauseCommand             = regexp.MustCompile("^pause notifications(?: until ([0-9]{4}-[0-9]{2}-[0-9]{2}))?$")
+	resumeCommand            = regexp.MustCompile("^resume notifications$")
+	diagnoseCommand          = regexp.MustCompile("^/?diagnose$")
+	clearTemplateCommand     = regexp.MustCompile("^clear template (upcoming|released)$")
+	compareCommand           = regexp.MustCompile("^compare (.+)")
+	timeFormatCommand        = regexp.MustCompile("^set time format (12h|24h)$")
+	setRegionCommand         = regexp.MustCompile("^set region (.+)$")
+	dateFormatCommand        = regexp.MustCompile("^set date format (dmy|mdy|iso)$")
+	trackSeasonCommand       = regexp.MustCompile("^track (anime|show) (.+) season ([0-9]+)$")
+	unsubscribeCommand       = regexp.MustCompile("^unsubscribe (?:from )?(.+)$")
+	nextSeasonCommand        = regexp.MustCompile("^subscribe (?:to )?(?:the )?next season (?:of )?(.+)$")
+	seasonEpisodesCommand    = regexp.MustCompile("^season episodes (on|off)$")
+	silentCommand            = regexp.MustCompile("^set silent (on|off)$")
+	monthlySummaryCommand    = regexp.MustCompile("^monthly summary (on|off)$")
+	defaultYearCommand       = regexp.MustCompile("^set default year ([0-9]{4})$")
+	clearDefaultYearCommand  = regexp.MustCompile("^clear default year$")
+	digestImageCommand       = regexp.MustCompile("^digest image$")
+	totalRuntimeCommand      = regexp.MustCompile("^total runtime$")
+	showUndatedCommand       = regexp.MustCompile("^set show undated (on|off)$")
+	leavingStreamingCommand  = regexp.MustCompile("^leaving streaming (on|off)$")
+	lightNotifyCommand       = regexp.MustCompile("^light notifications (on|off)$")
+	castPhotosCommand        = regexp.MustCompile("^cast photos (on|off)$")
+	calendarCommand          = regexp.MustCompile("^(connect|disconnect|sync) (?:google )?calendar$")
+	listOrderCommand         = regexp.MustCompile("^set list order (\\S+)$")
+	muteWordCommand          = regexp.MustCompile("^(mute|unmute) word ([\\pL\\pN]+)$")
+	notifyChannelCommand     = regexp.MustCompile("^notify via (\\S+)$")
+	comingOutCommand         = regexp.MustCompile("^(?:releases? )?coming out (this weekend|this week|next week|this month|next month)$")
+	setTimezoneCommand       = regexp.MustCompile("^set timezone (\\S+)$")
+	transferCommand          = regexp.MustCompile("^transfer subscriptions to (-?[0-9]+)$")
+	acceptTransferCommand    = regexp.MustCompile("^accept transfer ([0-9a-f]+)$")
+	deliveryTimeCommand      = regexp.MustCompile("^set delivery time (.+)$")
+	clearDeliveryTimeCommand = regexp.MustCompile("^clear delivery time$")
+	trendingCommand          = regexp.MustCompile("^/?trending(?: searches)?$")
+	linkChatCommand          = regexp.MustCompile("^link chat ?(-?[0-9]+)?$")
+	unlinkChatCommand        = regexp.MustCompile("^unlink chat ?(-?[0-9]+)?$")
+	linkedChatsCommand       = regexp.MustCompile("^linked chats$")
+	primaryChatCommand       = regexp.MustCompile("^set primary chat (-?[0-9]+|all)$")
+	notifyHistoryCommand     = regexp.MustCompile("^notification history$")
+	setTMDBKeyCommand        = regexp.MustCompile("^set tmdb key \\S+$")
+	clearTMDBKeyCommand      = regexp.MustCompile("^clear tmdb key$")
+	regionGroupCommand       = regexp.MustCompile("^set regions (.+)$")
+	clearRegionGroupCommand  = regexp.MustCompile("^clear regions$")
+	againCommand             = regexp.MustCompile("^/?(?:search )?again$")
+	postersCommand           = regexp.MustCompile("^posters (on|off)$")
+	datePolicyCommand        = regexp.MustCompile("^set date policy (primary|region|earliest)$")
+	followPersonCommand      = regexp.MustCompile("^follow person (.+)$")
+	unfollowPersonCommand    = regexp.MustCompile("^unfollow person (.+)$")
+	followingCommand         = regexp.MustCompile("^following$")
+
+	store Store
+	bot   *telegram.BotAPI
 )
 
 func main() {
 	host := os.Getenv("HOST")
 	port := os.Getenv("PORT")
 	botKey := os.Getenv("TELEGRAM_BOT_KEY")
-	movieAPIKey = os.Getenv("THEMOVIEDB_API_KEY")
+	tmdb = newTMDBClient(os.Getenv("THEMOVIEDB_API_KEY"), newTMDBHTTPClient())
+	tmdb.configure(os.Getenv)
+	if err := loadTMDBConfiguration(context.Background()); err != nil {
+		log.Printf("WARNING: %s, using %s until reloaded", err, defaultImageBaseURL)
+	}
+	enabledFeatures = parseFeatures(os.Getenv("FEATURES"))
+	adminUserIDs = parseAdminUserIDs(os.Getenv("ADMIN_USER_IDS"))
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	notifyTemplates = loadTemplates(os.Getenv)
+	compareRegions = parseRegions(os.Getenv("COMPARE_REGIONS"))
+	releaseRetention = parseRetention(os.Getenv("RELEASE_RETENTION_DAYS"))
+	missedReleaseWindow = parseMissedReleaseWindow(os.Getenv("MISSED_RELEASE_DAYS"))
+	blockedChatThreshold = parseBlockedChatThreshold(os.Getenv("BLOCKED_CHAT_THRESHOLD"))
+	maxTitleLength = parseMaxTitleLength(os.Getenv("MAX_TITLE_LENGTH"))
+	calendar = loadCalendarConfig(os.Getenv, host)
+	tmdbKeySecret = parseTMDBKeySecret(os.Getenv("TMDB_KEY_SECRET"))
+	subscribeConfirmations.interval = parseConfirmInterval(os.Getenv("CONFIRM_INTERVAL_SECONDS"))
+	if os.Getenv("NOTIFY_DRY_RUN") != "" {
+		log.Printf("NOTIFY_DRY_RUN is set, notifications are only logged")
+		setDryRun()
+	}
 
 	// Create GCP datastore client
 	ctx := context.TODO()
-	var err error
-	datastoreClient, err = datastore.NewClient(ctx, "")
+	datastoreClient, err := newDatastoreClient(ctx)
 	if err != nil {
 		log.Fatalf("failed to create datastore client: %s", err)
 	}
+	store = newDatastoreStore(datastoreClient)
 
 	// Create telegram bot API client
 	bot, err = telegram.NewBotAPI(botKey)
@@ -57,94 +139,384 @@ func main() {
 		log.Fatalf("failed to create bot: %s", err)
 	}
 
-	bot.Debug = true
+	bot.Debug = parseTelegramDebug(os.Getenv("TELEGRAM_DEBUG"))
+	if bot.Debug {
+		log.Printf("TELEGRAM_DEBUG is set, telegram requests and responses are logged in full")
+	}
 
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
 	// Register telegram bot
-	_, err = bot.SetWebhook(telegram.NewWebhook(host + "/" + bot.Token))
+	err = retryStartup("setup webhook", func() error {
+		_, err := bot.SetWebhook(telegram.NewWebhook(host + "/" + bot.Token))
+		return err
+	})
 	if err != nil {
-		log.Fatalf("failed to setup webhook: %s", err)
+		log.Fatalf("failed to setup webhook, giving up: %s", err)
 	}
 
-	info, err := bot.GetWebhookInfo()
+	var info telegram.WebhookInfo
+	err = retryStartup("get webhook info", func() error {
+		var err error
+		info, err = bot.GetWebhookInfo()
+		return err
+	})
 	if err != nil {
-		log.Fatalf("failed to get webhook info: %s", err)
+		log.Fatalf("failed to get webhook info, giving up: %s", err)
 	}
 	if info.LastErrorDate != 0 {
 		log.Printf("telegram callback failed: %s", info.LastErrorMessage)
 	}
 
 	// Listen for messages received by the bot
-	updates := bot.ListenForWebhook("/" + bot.Token)
+	updates := listenForWebhook("/" + bot.Token)
 
 	// Listen for trigger of notify task
 	http.HandleFunc("/tasks/notify", handleTaskNotify)
+	http.HandleFunc("/tasks/deliver", handleTaskDeliver)
+	http.HandleFunc("/tasks/refresh", handleTaskRefresh)
+	http.HandleFunc("/admin/migrate", handleAdminMigrate)
+	http.HandleFunc("/admin/refresh", handleAdminRefresh)
+	http.HandleFunc("/admin/backup", handleAdminBackup)
+	http.HandleFunc("/admin/restore", handleAdminRestore)
+	http.HandleFunc("/admin/reload", handleAdminReload)
+	http.HandleFunc(calendarCallbackPath, handleCalendarCallback)
 
 	go http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
 
-	// Handle bot messages
+	go flushOnShutdown()
+
+	// Handle bot messages, see updatePool
+	pool := newUpdatePool(updateWorkers, handleUpdate)
 	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
-		if update.Message.Text == "" {
-			continue
-		}
-
-		text := strings.TrimSpace(strings.ToLower(update.Message.Text))
-
-		if matches := releaseYearCommand.FindStringSubmatch(text); matches != nil {
-			handleRelease(update, matches)
-		} else if matches := releaseCommand.FindStringSubmatch(text); matches != nil {
-			handleRelease(update, matches)
-		} else if matches := subscribeCommand.FindStringSubmatch(text); matches != nil {
-			handleSubscribe(update, matches)
-		} else if matches := listSubscriptionsCommand.FindStringSubmatch(text); matches != nil {
-			handlelistSubscriptions(update)
-		} else {
-			msgText := "Looking for information about movie releases? I can help with the following questions 😌\n" +
-				"`releases [exact] <movie title>`\n" +
-				"`releases [exact] <movie title> year <year of release>` (the year of release can be region specific)\n" +
-				"`subscribe to <movie title>`\n" +
-				"`list subscriptions` (the year of release can be region specific)\n" +
-				"\n" +
-				"Examples:\n" +
-				"`release climax year 2018`\n" +
-				"`release exact julia`\n" +
-				"`subscribe to Alita`\n" +
-				"\n"
-
-			regionEmoji, ok := regionToEmoji[region]
-			if !ok {
-				regionEmoji = region
-			}
+		pool.dispatch(update)
+	}
+}
 
-			msgText += "Current region: " + regionEmoji
+// handleUpdate handles a single update received by the bot.
+func handleUpdate(update telegram.Update) {
+	ctx := withRequestID(context.Background(), newRequestID())
+	logf(ctx, "handling update: update_id=%d", update.UpdateID)
 
-			msgConfig := telegram.NewMessage(update.Message.Chat.ID, msgText)
-			msgConfig.ParseMode = "Markdown"
-			sendMsg(msgConfig)
+	if update.CallbackQuery != nil {
+		ctx, timings := withTimings(ctx)
+		start := time.Now()
+		if update.CallbackQuery.Message != nil {
+			ctx = withChatTMDBKey(ctx, update.CallbackQuery.Message.Chat.ID)
 		}
+		handleCallback(ctx, update.CallbackQuery)
+		timings.log(ctx, "callback", start)
+		return
+	}
+	if update.InlineQuery != nil {
+		go func(ctx context.Context, query *telegram.InlineQuery) {
+			ctx, timings := withTimings(ctx)
+			start := time.Now()
+			// The private chat with the user has the ID of the user
+			ctx = withChatTMDBKey(ctx, int64(query.From.ID))
+			handleInlineQuery(ctx, query)
+			timings.log(ctx, "inline", start)
+		}(ctx, update.InlineQuery)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
+	if from, to, ok := chatMigration(update.Message); ok {
+		handleChatMigration(ctx, from, to)
+		return
+	}
+	if sentByBot(update.Message) {
+		logf(ctx, "ignoring message sent by a bot")
+		return
 	}
+	if update.Message.Text == "" {
+		return
+	}
+
+	text := strings.TrimSpace(strings.ToLower(update.Message.Text))
+
+	if update.Message.ReplyToMessage != nil && handleReply(ctx, update.Message, text) {
+		return
+	}
+
+	// Muted words must not prevent unmuting them
+	if !muteWordCommand.MatchString(text) && ignoredAsMuted(ctx, update.Message, text) {
+		return
+	}
+
+	releaseText, filter := extractResultFilter(text)
+
+	ctx, timings := withTimings(ctx)
+	start := time.Now()
+	command := "help"
+	ctx = withChatTMDBKey(ctx, update.Message.Chat.ID)
+
+	// Templates are free text that could match any other command
+	if strings.HasPrefix(text, "set template ") {
+		command = "set_template"
+		handleSetTemplate(ctx, update)
+	} else if matches := muteWordCommand.FindStringSubmatch(text); matches != nil {
+		command = "mute_word"
+		handleMuteWord(ctx, update, matches)
+	} else if matches := unsubscribeCommand.FindStringSubmatch(text); matches != nil {
+		command = "unsubscribe"
+		handleUnsubscribe(ctx, update, matches)
+	} else if matches := nextSeasonCommand.FindStringSubmatch(text); matches != nil {
+		command = "next_season"
+		handleTrackNextSeason(ctx, update, matches)
+	} else if matches := helpCommand.FindStringSubmatch(text); matches != nil {
+		command = "help"
+		handleHelp(ctx, update, matches[1])
+	} else if matches := comingOutCommand.FindStringSubmatch(text); matches != nil {
+		command = "coming_out"
+		handleComingOut(ctx, update, matches)
+	} else if matches := transferCommand.FindStringSubmatch(text); matches != nil {
+		command = "transfer"
+		handleTransferSubscriptions(ctx, update, matches)
+	} else if matches := acceptTransferCommand.FindStringSubmatch(text); matches != nil {
+		command = "accept_transfer"
+		handleAcceptTransfer(ctx, update, matches)
+	} else if matches := deliveryTimeCommand.FindStringSubmatch(text); matches != nil {
+		command = "delivery_time"
+		handleDeliveryTime(ctx, update, matches)
+	} else if clearDeliveryTimeCommand.MatchString(text) {
+		command = "clear_delivery_time"
+		handleClearDeliveryTime(ctx, update)
+	} else if trendingCommand.MatchString(text) {
+		command = "trending"
+		handleTrendingSearches(ctx, update)
+	} else if matches := linkChatCommand.FindStringSubmatch(text); matches != nil {
+		command = "link_chat"
+		handleLinkChat(ctx, update, matches)
+	} else if matches := unlinkChatCommand.FindStringSubmatch(text); matches != nil {
+		command = "unlink_chat"
+		handleUnlinkChat(ctx, update, matches)
+	} else if linkedChatsCommand.MatchString(text) {
+		command = "linked_chats"
+		handleLinkedChats(ctx, update)
+	} else if matches := primaryChatCommand.FindStringSubmatch(text); matches != nil {
+		command = "primary_chat"
+		handlePrimaryChat(ctx, update, matches)
+	} else if notifyHistoryCommand.MatchString(text) {
+		command = "notification_history"
+		handleNotificationHistory(ctx, update)
+	} else if setTMDBKeyCommand.MatchString(text) {
+		command = "set_tmdb_key"
+		handleSetTMDBKey(ctx, update)
+	} else if clearTMDBKeyCommand.MatchString(text) {
+		command = "clear_tmdb_key"
+		handleClearTMDBKey(ctx, update)
+	} else if matches := regionGroupCommand.FindStringSubmatch(text); matches != nil {
+		command = "region_group"
+		handleSetRegionGroup(ctx, update, matches)
+	} else if clearRegionGroupCommand.MatchString(text) {
+		command = "clear_region_group"
+		handleClearRegionGroup(ctx, update)
+	} else if againCommand.MatchString(text) {
+		command = "again"
+		handleAgain(ctx, update)
+	} else if matches := postersCommand.FindStringSubmatch(text); matches != nil {
+		command = "posters"
+		handlePosters(ctx, update, matches)
+	} else if matches := datePolicyCommand.FindStringSubmatch(text); matches != nil {
+		command = "date_policy"
+		handleDatePolicy(ctx, update, matches)
+	} else if matches := followPersonCommand.FindStringSubmatch(text); matches != nil {
+		command = "follow_person"
+		handleFollowPerson(ctx, update, matches)
+	} else if matches := unfollowPersonCommand.FindStringSubmatch(text); matches != nil {
+		command = "unfollow_person"
+		handleUnfollowPerson(ctx, update, matches)
+	} else if followingCommand.MatchString(text) {
+		command = "following"
+		handleFollowing(ctx, update)
+	} else if matches := releaseYearCommand.FindStringSubmatch(releaseText); matches != nil {
+		command = "release"
+		handleRelease(ctx, update, matches, filter)
+	} else if matches := releaseCommand.FindStringSubmatch(releaseText); matches != nil {
+		command = "release"
+		handleRelease(ctx, update, matches, filter)
+	} else if matches := bulkSubscribeCommand.FindStringSubmatch(text); matches != nil {
+		command = "bulk_subscribe"
+		if requireFeature(ctx, update.Message.Chat.ID, featureBulkSubscribe) {
+			handleBulkSubscribe(ctx, update, matches)
+		}
+	} else if matches := subscribeCommand.FindStringSubmatch(text); matches != nil {
+		command = "subscribe"
+		handleSubscribe(ctx, update, matches)
+	} else if matches := listSubscriptionsCommand.FindStringSubmatch(text); matches != nil {
+		command = "list_subscriptions"
+		handlelistSubscriptions(ctx, update, matches)
+	} else if matches := favoriteGenreCommand.FindStringSubmatch(text); matches != nil {
+		command = "favorite_genre"
+		handleSetFavoriteGenre(ctx, update, matches)
+	} else if discoverCommand.MatchString(text) {
+		command = "discover"
+		handleDiscover(ctx, update)
+	} else if soonestCommand.MatchString(text) {
+		command = "soonest"
+		handleSoonest(ctx, update)
+	} else if matches := previewCommand.FindStringSubmatch(text); matches != nil {
+		command = "preview"
+		handlePreview(ctx, update, matches)
+	} else if matches := surpriseNotifyCommand.FindStringSubmatch(text); matches != nil {
+		command = "surprise_notifications"
+		handleSurpriseNotifications(ctx, update, matches)
+	} else if matches := surpriseCommand.FindStringSubmatch(text); matches != nil {
+		command = "surprise"
+		if requireFeature(ctx, update.Message.Chat.ID, featureSurprise) {
+			handleSurprise(ctx, update, matches)
+		}
+	} else if matches := trailersCommand.FindStringSubmatch(text); matches != nil {
+		command = "trailers"
+		if requireFeature(ctx, update.Message.Chat.ID, featureTrailers) {
+			handleTrailers(ctx, update, matches)
+		}
+	} else if matches := countdownCommand.FindStringSubmatch(text); matches != nil {
+		command = "countdown"
+		handleCountdown(ctx, update, matches)
+	} else if matches := notifyChatCommand.FindStringSubmatch(text); matches != nil {
+		command = "notify_chat"
+		handleNotifyChat(ctx, update, matches)
+	} else if matches := historyCommand.FindStringSubmatch(text); matches != nil {
+		command = "history"
+		if requireFeature(ctx, update.Message.Chat.ID, featureHistory) {
+			handleHistory(ctx, update, matches)
+		}
+	} else if matches := detailsCommand.FindStringSubmatch(text); matches != nil {
+		command = "details"
+		if requireFeature(ctx, update.Message.Chat.ID, featureDetails) {
+			handleDetails(ctx, update, matches)
+		}
+	} else if matches := importCommand.FindStringSubmatch(text); matches != nil {
+		command = "import"
+		if requireFeature(ctx, update.Message.Chat.ID, featureImport) {
+			handleImport(ctx, update, matches)
+		}
+	} else if myDataCommand.MatchString(text) {
+		command = "my_data"
+		handleMyData(ctx, update)
+	} else if deleteMyDataCommand.MatchString(text) {
+		command = "delete_my_data"
+		handleDeleteMyData(ctx, update)
+	} else if matches := pauseCommand.FindStringSubmatch(text); matches != nil {
+		command = "pause"
+		handlePause(ctx, update, matches)
+	} else if resumeCommand.MatchString(text) {
+		command = "resume"
+		handleResume(ctx, update)
+	} else if matches := setRegionCommand.FindStringSubmatch(text); matches != nil {
+		command = "set_region"
+		handleSetRegion(ctx, update, matches)
+	} else if matches := setTimezoneCommand.FindStringSubmatch(text); matches != nil {
+		command = "set_timezone"
+		handleSetTimezone(ctx, update, matches)
+	} else if matches := silentCommand.FindStringSubmatch(text); matches != nil {
+		command = "silent"
+		handleSilent(ctx, update, matches)
+	} else if matches := defaultYearCommand.FindStringSubmatch(text); matches != nil {
+		command = "default_year"
+		handleDefaultYear(ctx, update, matches)
+	} else if clearDefaultYearCommand.MatchString(text) {
+		command = "clear_default_year"
+		handleClearDefaultYear(ctx, update)
+	} else if digestImageCommand.MatchString(text) {
+		command = "digest_image"
+		if requireFeature(ctx, update.Message.Chat.ID, featureDigestImage) {
+			handleDigestImage(ctx, update)
+		}
+	} else if totalRuntimeCommand.MatchString(text) {
+		command = "total_runtime"
+		handleTotalRuntime(ctx, update)
+	} else if matches := showUndatedCommand.FindStringSubmatch(text); matches != nil {
+		command = "show_undated"
+		handleShowUndated(ctx, update, matches)
+	} else if matches := leavingStreamingCommand.FindStringSubmatch(text); matches != nil {
+		command = "leaving_streaming"
+		if requireFeature(ctx, update.Message.Chat.ID, featureStreaming) {
+			handleLeavingStreaming(ctx, update, matches)
+		}
+	} else if matches := lightNotifyCommand.FindStringSubmatch(text); matches != nil {
+		command = "light_notifications"
+		handleLightNotifications(ctx, update, matches)
+	} else if matches := castPhotosCommand.FindStringSubmatch(text); matches != nil {
+		command = "cast_photos"
+		handleCastPhotos(ctx, update, matches)
+	} else if matches := calendarCommand.FindStringSubmatch(text); matches != nil {
+		command = "calendar"
+		handleCalendar(ctx, update, matches)
+	} else if matches := listOrderCommand.FindStringSubmatch(text); matches != nil {
+		command = "list_order"
+		handleListOrder(ctx, update, matches)
+	} else if matches := monthlySummaryCommand.FindStringSubmatch(text); matches != nil {
+		command = "monthly_summary"
+		handleMonthlySummary(ctx, update, matches)
+	} else if matches := notifyChannelCommand.FindStringSubmatch(text); matches != nil {
+		command = "notify_channel"
+		handleNotifyChannel(ctx, update, matches)
+	} else if matches := trackSeasonCommand.FindStringSubmatch(text); matches != nil {
+		command = "track_season"
+		handleTrackSeason(ctx, update, matches)
+	} else if matches := seasonEpisodesCommand.FindStringSubmatch(text); matches != nil {
+		command = "season_episodes"
+		handleSeasonEpisodes(ctx, update, matches)
+	} else if matches := dateFormatCommand.FindStringSubmatch(text); matches != nil {
+		command = "date_format"
+		handleDateFormat(ctx, update, matches)
+	} else if matches := timeFormatCommand.FindStringSubmatch(text); matches != nil {
+		command = "time_format"
+		handleTimeFormat(ctx, update, matches)
+	} else if matches := compareCommand.FindStringSubmatch(text); matches != nil {
+		command = "compare"
+		handleCompare(ctx, update, matches)
+	} else if matches := clearTemplateCommand.FindStringSubmatch(text); matches != nil {
+		command = "clear_template"
+		handleClearTemplate(ctx, update, matches)
+	} else if diagnoseCommand.MatchString(text) && isAdmin(update.Message) {
+		command = "diagnose"
+		handleDiagnose(ctx, update)
+	} else if suggestion, ok := suggestCommand(text); ok {
+		command = "suggestion"
+		handleSuggestion(ctx, update, suggestion)
+	} else {
+		handleHelp(ctx, update, "")
+	}
+
+	// Only a search right after another one refines it
+	if command != "release" {
+		resultMessages.forget(update.Message.Chat.ID)
+	}
+	timings.log(ctx, command, start)
 }
 
-func handleRelease(update telegram.Update, matches []string) {
+func handleRelease(ctx context.Context, update telegram.Update, matches []string, filter resultFilter) {
 	exact := false
 	if matches[1] != "" {
 		exact = true
 	}
 
 	title := matches[2]
+	lastSearches.record(update.Message.Chat.ID, matches, filter, time.Now())
+
+	prefs := prefsOrDefault(ctx, update.Message.Chat.ID)
 
 	var year string
+	if prefs.DefaultYear != 0 {
+		year = strconv.Itoa(prefs.DefaultYear)
+	}
 	if len(matches) == 4 {
 		year = matches[3]
+		if !plausibleYear(year, time.Now()) {
+			sendMsg(ctx, telegram.NewMessage(update.Message.Chat.ID, fmt.Sprintf("%s isn't a valid release year, use a year between %d and %d.", year, minReleaseYear, time.Now().Year()+maxYearsAhead)))
+			return
+		}
 	}
 
-	results, err := queryMovies(title, year)
+	results, err := queryMovies(ctx, title, year)
 	if err != nil {
-		log.Fatalf("failed to search movies with year: %s", err)
+		tmdbFailed(ctx, update.Message.Chat.ID, err, "failed to search movies with year")
+		return
 	}
 
 	if exact {
@@ -156,13 +528,42 @@ func handleRelease(update telegram.Update, matches []string) {
 		}
 	}
 
-	sendResults(update, results)
+	results = filter.apply(results)
+
+	recordSearches(ctx, update.Message.Chat.ID, results)
+	recordTrend(update.Message.Chat.ID, results)
+	recordQueries(ctx, update.Message.Chat.ID, results)
+
+	if prefs.HideUndated {
+		results = datedResults(results)
+	}
+	sendResults(ctx, update, title, results)
 }
 
-func sendResults(update telegram.Update, results MovieAPIResults) {
+const (
+	// minReleaseYear is the year of the first motion picture.
+	minReleaseYear = 1888
+	// maxYearsAhead is how far in the future release years are accepted.
+	maxYearsAhead = 10
+)
+
+// plausibleYear returns whether the year is one a movie could be released
+// in, from minReleaseYear to maxYearsAhead years from now.
+func plausibleYear(year string, now time.Time) bool {
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return false
+	}
+	return y >= minReleaseYear && y <= now.Year()+maxYearsAhead
+}
+
+// sendResults sends the results of the search of the title, editing the
+// results of the previous search when refining it, see sendRefinable.
+func sendResults(ctx context.Context, update telegram.Update, title string, results MovieAPIResults) {
+	chatID := update.Message.Chat.ID
 	switch len(results) {
 	case 0:
-		sendMsg(telegram.NewMessage(update.Message.Chat.ID, "No entry found 🤓"))
+		sendRefinable(ctx, chatID, title, "No entry found 🤓", nil)
 	default:
 		text := "I found these entries 🍿:\n"
 		for _, m := range results {
@@ -170,239 +571,624 @@ func sendResults(update telegram.Update, results MovieAPIResults) {
 			if m.ReleaseTime.IsZero() {
 				year = "unknown release date"
 			}
-			text += fmt.Sprintf("- %s (%s)\n", m.Title, year)
+			text += fmt.Sprintf("- %s (%s)\n", displayTitle(m.Title, m.ID), year)
 		}
-		sendMsg(telegram.NewMessage(update.Message.Chat.ID, text))
+		keyboard := resultsKeyboard(results, time.Now())
+		sendRefinable(ctx, chatID, title, text, &keyboard)
 	}
 }
 
-func handleSubscribe(update telegram.Update, matches []string) {
-	movieTitle := matches[1]
-	results, err := queryMovies(movieTitle, "")
+func handleSubscribe(ctx context.Context, update telegram.Update, matches []string) {
+	movieTitle, remindDays, err := extractRemindDays(matches[1])
+	if err != nil {
+		sendMsg(ctx, telegram.NewMessage(update.Message.Chat.ID, "I don't understand when to remind you: "+err.Error()))
+		return
+	}
+	movieTitle, region, err := extractRegion(movieTitle)
+	if err != nil {
+		sendMsg(ctx, telegram.NewMessage(update.Message.Chat.ID, "Sorry, I don't know that region. Supported regions are "+strings.Join(supportedRegions(), ", ")+"."))
+		return
+	}
+
+	results, err := queryMovies(ctx, movieTitle, "")
 	if err != nil {
-		log.Fatalf("failed to search movies with year: %s", err)
+		tmdbFailed(ctx, update.Message.Chat.ID, err, "failed to search movies")
+		return
 	}
 
+	upcoming := upcomingReleases(results)
+
+	// The movie exists but is already out, tell the user instead of implying
+	// nothing was found
+	if len(upcoming) == 0 {
+		if released, ok := bestReleasedMatch(results, movieTitle); ok {
+			text := fmt.Sprintf("%s already came out on %s 🍿\n%s",
+				released.Title, released.ReleaseTime.Format("2 Jan 2006"), tmdbMovieURL(released.ID))
+			sendMsg(ctx, telegram.NewMessage(update.Message.Chat.ID, text))
+			return
+		}
+	}
+
+	subscribeToCandidates(ctx, update.Message, upcoming, remindDays, region)
+}
+
+// upcomingReleases returns release records for the results not released yet.
+func upcomingReleases(results MovieAPIResults) []MovieRelease {
 	now := time.Now()
 
 	var upcoming []MovieRelease
 	for _, res := range results {
 		if res.ReleaseTime.After(now) {
-			upcoming = append(upcoming, MovieRelease{
-				ID:          res.ID,
-				MovieTitle:  res.Title,
-				ReleaseDate: res.ReleaseTime,
-			})
+			upcoming = append(upcoming, newMovieRelease(res))
 		}
 	}
+	return upcoming
+}
+
+// bestReleasedMatch returns the already released result best matching the
+// searched title: an exact title match if there is one, the latest release
+// otherwise. results must be sorted most recent first.
+func bestReleasedMatch(results MovieAPIResults, title string) (MovieAPIResult, bool) {
+	var released MovieAPIResults
+	for _, res := range results {
+		if !res.ReleaseTime.IsZero() && res.ReleaseTime.Before(time.Now()) {
+			released = append(released, res)
+		}
+	}
+	if len(released) == 0 {
+		return MovieAPIResult{}, false
+	}
+
+	for _, res := range released {
+		if strings.ToLower(res.Title) == title {
+			return res, true
+		}
+	}
+	return released[0], true
+}
+
+// subscribeToCandidates subscribes the chat to the release if there is a
+// single candidate. With several candidates the user is asked to reply with a
+// more specific query, see handleReply. remindDays is the reminder offset of
+// the subscription, zero for the default. region is the region whose release
+// date the subscription follows, empty for the worldwide date.
+func subscribeToCandidates(ctx context.Context, msg *telegram.Message, upcoming []MovieRelease, remindDays int, region string) {
+	chatID := msg.Chat.ID
 
-	var text string
 	switch len(upcoming) {
 	case 0:
-		text = "No movie releases found :("
+		sendMsg(ctx, telegram.NewMessage(chatID, "No movie releases found :("))
 	case 1:
 		release := upcoming[0]
 
-		ctx := context.TODO()
-		key := datastore.NameKey("MovieRelease", fmt.Sprintf("%d", release.ID), nil)
-		_, err := datastoreClient.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
-			var txRelease MovieRelease
-
-			// Try to get a stored record
-			err := tx.Get(key, &txRelease)
-			if err != nil && err != datastore.ErrNoSuchEntity {
-				return err
+		var regional regionDate
+		if region != "" {
+			dates, err := movieReleaseDates(ctx, release.ID)
+			if err != nil {
+				tmdbFailed(ctx, chatID, err, "failed to get release dates")
+				return
 			}
+			regional = regionDate{Region: region, Date: dates[region]}
+		}
 
-			// Handle case where record doesn't exist yet
-			if err == datastore.ErrNoSuchEntity {
-				txRelease = release
-			}
+		existing, err := subscribeChat(ctx, chatID, release, remindDays, regional, receivedTopics.thread(msg))
+		if err != nil {
+			storeFailed(ctx, chatID, err, "failed to subscribe to movie release")
+			return
+		}
 
-			// Create subscriber
-			sub := Subscriber{
-				Notified: false,
-				ChatID:   update.Message.Chat.ID,
+		var note string
+		if remindDays != 0 {
+			note = fmt.Sprintf("I'll remind you %d days before.", remindDays)
+		}
+		if region != "" {
+			note = strings.TrimSpace(note + " " + regionalDateText(regional))
+		}
+		if existing == "" {
+			date := release.ReleaseDate
+			if region != "" {
+				date = regional.Date
 			}
+			subscribeConfirmations.add(ctx, chatID, subscribeConfirmation{title: release.MovieTitle, note: note, posterPath: release.PosterPath, date: date})
+			return
+		}
 
-			// Check if user already subscribed to movie release
-			for i := range txRelease.Subscribers {
-				if txRelease.Subscribers[i].ChatID == sub.ChatID {
-					// user found, do not update
+		text := alreadySubscribedText(existing)
+		if remindDays != 0 {
+			text += fmt.Sprintf(" I'll now remind you %d days before.", remindDays)
+		}
+		if region != "" {
+			text += " " + regionalDateText(regional)
+		}
+		sendMsg(ctx, telegram.NewMessage(chatID, text))
+	default:
+		text := "Found multiple movies, be more specific please. Reply to this message with the number, the year or more of the title:\n"
+		for i, rec := range upcoming {
+			text += fmt.Sprintf("%d. %s (%s)\n", i+1, rec.MovieTitle, rec.ReleaseDate.Format("2 Jan 2006"))
+		}
+
+		msgConfig := telegram.NewMessage(chatID, text)
+		msgConfig.ReplyToMessageID = msg.MessageID
+		msgConfig.ReplyMarkup = telegram.ForceReply{ForceReply: true, Selective: true}
+		prompt, err := trySendMsg(ctx, msgConfig)
+		if err != nil {
+			logf(ctx, "failed to ask which movie to subscribe to: %s", err)
+			return
+		}
+
+		pendingSubscribes.add(chatID, prompt.MessageID, upcoming, remindDays, region)
+	}
+}
+
+// newMovieRelease creates a release record, without subscribers, from a TMDB
+// result.
+func newMovieRelease(res MovieAPIResult) MovieRelease {
+	return MovieRelease{
+		ID:          res.ID,
+		MovieTitle:  res.Title,
+		ReleaseDate: res.ReleaseTime,
+		PosterPath:  res.PosterPath,
+	}
+}
+
+// subscribeChat adds the chat to the subscribers of the movie release, to be
+// reminded remindDays before the release (zero for the default),
+// creating the release record if it doesn't exist yet. A non zero regional
+// date makes the subscription follow the release date of that region, a non
+// zero thread posts its notifications in that forum topic of the group.
+// Subscriptions are identified by TMDB ID: when the chat is already
+// subscribed, whatever the title it searched for, only an explicitly given
+// reminder or region is updated and the stored title of the release is
+// returned. Without a region, new subscriptions of a chat with a region
+// group follow the earliest date among it, the others the date its date
+// policy picks.
+func subscribeChat(ctx context.Context, chatID int64, release MovieRelease, remindDays int, regional regionDate, thread int) (existing string, err error) {
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		return "", err
+	}
+	explicitRegion := regional.Region != ""
+	switch {
+	case explicitRegion:
+	case len(prefs.Regions) > 0:
+		regional = groupRegionDate(ctx, release.ID, prefs.Regions)
+	default:
+		regional = chatPolicyDate(ctx, release.ID, prefs)
+	}
+
+	err = store.UpdateRelease(ctx, release.ID, func(txRelease *MovieRelease) error {
+		// Handle case where record doesn't exist yet
+		if txRelease.ID == 0 {
+			*txRelease = release
+		}
+
+		// Create subscriber
+		sub := Subscriber{
+			Notified:     false,
+			ChatID:       chatID,
+			NotifyChatID: prefs.NotifyChatID,
+			RemindDays:   remindDays,
+			CreatedAt:    time.Now(),
+			Region:       regional.Region,
+			RegionDate:   regional.Date,
+			Regions:      regional.Group,
+			DatePolicy:   regional.Policy,
+			ThreadID:     thread,
+		}
+
+		// Check if user already subscribed to movie release
+		existing = ""
+		for i := range txRelease.Subscribers {
+			if txRelease.Subscribers[i].ChatID == sub.ChatID {
+				existing = txRelease.MovieTitle
+				// user found, only update an explicitly given reminder or
+				// region
+				if remindDays == 0 && !explicitRegion {
 					return nil
 				}
+				if remindDays != 0 && remindDays != txRelease.Subscribers[i].RemindDays {
+					// The new reminder is sent even if the previous one was
+					txRelease.Subscribers[i].RemindDays = remindDays
+					if txRelease.Subscribers[i].regional(*txRelease).ReleaseDate.After(time.Now()) {
+						txRelease.Subscribers[i].Notified = false
+					}
+				}
+				if explicitRegion {
+					txRelease.Subscribers[i].Region = regional.Region
+					txRelease.Subscribers[i].RegionDate = regional.Date
+					txRelease.Subscribers[i].Regions = nil
+					txRelease.Subscribers[i].DatePolicy = ""
+				}
+				return nil
 			}
+		}
 
-			txRelease.Subscribers = append(txRelease.Subscribers, sub)
+		txRelease.Subscribers = append(txRelease.Subscribers, sub)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
 
-			_, err = tx.Put(key, &txRelease)
-			if err != nil {
-				return err
+	return existing, nil
+}
+
+// alreadySubscribedText tells the chat it was already subscribed to the
+// movie, under the title stored at the time.
+func alreadySubscribedText(existing string) string {
+	return fmt.Sprintf("You're already subscribed to this movie, listed as %s.", existing)
+}
+
+// unsubscribeChat removes the chat from the subscribers of the movie release.
+func unsubscribeChat(ctx context.Context, chatID, releaseID int64) error {
+	return store.UpdateRelease(ctx, releaseID, func(txRelease *MovieRelease) error {
+		var subscribers []Subscriber
+		for _, sub := range txRelease.Subscribers {
+			if sub.ChatID != chatID {
+				subscribers = append(subscribers, sub)
 			}
+		}
+		if len(subscribers) == len(txRelease.Subscribers) {
+			return errSkipUpdate
+		}
+		txRelease.Subscribers = subscribers
+		return nil
+	})
+}
 
-			return nil
-		})
-		if err != nil {
-			log.Fatalf("failed to subscribe to movie release: %s", err)
+// updateSubscriber calls fn with the subscriber entry of the chat in the
+// stored release and saves the change. Nothing happens if the release doesn't
+// exist or the chat isn't subscribed to it.
+func updateSubscriber(ctx context.Context, releaseID, chatID int64, fn func(sub *Subscriber)) error {
+	chatID = migratedChats.resolve(chatID)
+	return store.UpdateRelease(ctx, releaseID, func(txRelease *MovieRelease) error {
+		found := false
+		for i := range txRelease.Subscribers {
+			if txRelease.Subscribers[i].ChatID == chatID {
+				fn(&txRelease.Subscribers[i])
+				found = true
+			}
 		}
+		if !found {
+			return errSkipUpdate
+		}
+		return nil
+	})
+}
 
-		text = "Done!"
-	default:
-		text = "Found multiple movies, be more specific please."
-	}
+func handleSurprise(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
 
-	sendMsg(telegram.NewMessage(update.Message.Chat.ID, text))
-}
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
 
-func handlelistSubscriptions(update telegram.Update) {
-	var records []MovieRelease
-	_, err := datastoreClient.GetAll(context.TODO(), datastore.NewQuery("MovieRelease"), &records)
+	results, err := upcomingMovies(ctx, prefs.region())
 	if err != nil {
-		log.Fatalf("failed to get all subscriptions: %s", err)
+		tmdbFailed(ctx, chatID, err, "failed to get upcoming movies")
+		return
 	}
 
-	var subscriptions []MovieRelease
-	for _, rec := range records {
-		for _, sub := range rec.Subscribers {
-			if sub.ChatID == update.Message.Chat.ID {
-				subscriptions = append(subscriptions, rec)
-				break
+	genreName := strings.TrimSpace(matches[1])
+	if genreName != "" {
+		genre, ok, err := findGenre(ctx, genreName)
+		if err != nil {
+			tmdbFailed(ctx, chatID, err, "failed to find genre")
+			return
+		}
+		if !ok {
+			sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("I don't know the genre %q 🤔", genreName)))
+			return
+		}
+
+		var filtered MovieAPIResults
+		for _, m := range results {
+			if m.HasGenre(genre.ID) {
+				filtered = append(filtered, m)
 			}
 		}
+		results = filtered
 	}
 
-	var text string
-	switch len(subscriptions) {
-	case 0:
-		text = "No subscriptions found"
-	default:
-		text = "Your subscriptions are \n"
-		for _, sub := range subscriptions {
-			date := sub.ReleaseDate.Format("2 Jan 2006")
-			text += fmt.Sprintf("- %s %s\n", sub.MovieTitle, date)
+	// TMDB's upcoming list can contain movies released in the last days
+	now := time.Now()
+	var upcoming MovieAPIResults
+	for _, m := range results {
+		if m.ReleaseTime.After(now) {
+			upcoming = append(upcoming, m)
 		}
 	}
-	sendMsg(telegram.NewMessage(update.Message.Chat.ID, text))
-}
 
-func sendMsg(msg telegram.MessageConfig) {
-	if _, err := bot.Send(msg); err != nil {
-		log.Fatalf("failed to send message: %s", err)
+	if len(upcoming) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I couldn't find any upcoming release to suggest, try again later 🤷"))
+		return
 	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pick := upcoming[rnd.Intn(len(upcoming))]
+
+	text := fmt.Sprintf("How about *%s*? It comes out on %s 🎲", escapeMarkdown(pick.Title), pick.ReleaseTime.Format("2 Jan 2006"))
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ParseMode = "Markdown"
+	msgConfig.ReplyMarkup = telegram.NewInlineKeyboardMarkup(
+		telegram.NewInlineKeyboardRow(subscribeButton(pick.ID)),
+	)
+	sendMsg(ctx, msgConfig)
 }
 
 const (
-	// EntityMovieReleases ...
-	EntityMovieReleases = "MovieReleases"
+	callbackSubscribe   = "subscribe"
+	callbackUnsubscribe = "unsubscribe"
 )
 
-// Subscriber ...
-type Subscriber struct {
-	Notified bool
-	ChatID   int64
+// subscribeButton returns an inline button subscribing the chat to the movie.
+func subscribeButton(movieID int64) telegram.InlineKeyboardButton {
+	return telegram.NewInlineKeyboardButtonData("Subscribe 🔔", fmt.Sprintf("%s:%d", callbackSubscribe, movieID))
 }
 
-// MovieRelease ...
-type MovieRelease struct {
-	ID          int64
-	MovieTitle  string
-	ReleaseDate time.Time
-	Subscribers []Subscriber
+// unsubscribeButton returns an inline button unsubscribing the chat from the
+// movie.
+func unsubscribeButton(movieID int64) telegram.InlineKeyboardButton {
+	return telegram.NewInlineKeyboardButtonData("Unsubscribe 🔕", fmt.Sprintf("%s:%d", callbackUnsubscribe, movieID))
 }
 
-// MovieAPIResult ...
-type MovieAPIResult struct {
-	Title       string `json:"title"`
-	ReleaseDate string `json:"release_date"`
-	ID          int64  `json:"id"`
-	ReleaseTime time.Time
+// subscriptionButton returns the button toggling the subscription of the chat
+// to the movie, given whether it is currently subscribed.
+func subscriptionButton(movieID int64, subscribed bool) telegram.InlineKeyboardButton {
+	if subscribed {
+		return unsubscribeButton(movieID)
+	}
+	return subscribeButton(movieID)
 }
 
-// MovieAPIResults ...
-type MovieAPIResults []MovieAPIResult
+// isSubscribed returns whether the chat is subscribed to the movie.
+func isSubscribed(ctx context.Context, chatID, movieID int64) (bool, error) {
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range subscriptions {
+		if rec.ID == movieID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-func (r MovieAPIResults) Len() int           { return len(r) }
-func (r MovieAPIResults) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
-func (r MovieAPIResults) Less(i, j int) bool { return r[i].ReleaseTime.Before(r[j].ReleaseTime) }
+// handleCallback handles taps on inline keyboard buttons. Callback data has the
+// form "<action>:<argument>".
+func handleCallback(ctx context.Context, query *telegram.CallbackQuery) {
+	if query.Message == nil {
+		return
+	}
 
-func queryMovies(movieTitle, year string) (MovieAPIResults, error) {
-	u, err := url.Parse(fmt.Sprintf("https://api.themoviedb.org/3/search/movie?api_key=%s", movieAPIKey))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse url")
+	parts := strings.SplitN(query.Data, ":", 2)
+	if len(parts) != 2 {
+		logf(ctx, "unexpected callback data: %q", query.Data)
+		return
 	}
-	q := u.Query()
-	q.Set("query", movieTitle)
-	q.Set("year", year)
-	u.RawQuery = q.Encode()
 
-	res, err := http.Get(u.String())
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to send http get request")
+	var answer string
+	switch parts[0] {
+	case callbackSubscribe:
+		answer = handleSubscribeCallback(ctx, query, parts[1], true)
+	case callbackSubscribeResult:
+		answer = handleSubscribeCallback(ctx, query, parts[1], false)
+	case callbackInfo:
+		answer = handleInfoCallback(ctx, query, parts[1])
+	case callbackPreview:
+		answer = handlePreviewCallback(ctx, query, parts[1])
+	case callbackUnsubscribe:
+		answer = handleUnsubscribeCallback(ctx, query, parts[1])
+	case callbackDeleteData:
+		answer = handleDeleteDataCallback(ctx, query, parts[1])
+	case callbackPickSubscription:
+		answer = handlePickSubscriptionCallback(ctx, query, parts[1])
+	case callbackRemoveReleased:
+		answer = handleRemoveReleasedCallback(ctx, query)
+	default:
+		logf(ctx, "unknown callback action: %q", query.Data)
+		return
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		return nil, errors.Errorf("unexpected status code: %d", res.StatusCode)
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.AnswerCallbackQuery(telegram.NewCallback(query.ID, answer)); err != nil {
+		logf(ctx, "failed to answer callback query: %s", err)
 	}
+}
+
+// handleSubscribeCallback subscribes the chat to the movie of a subscribe
+// button and, when toggle is set, turns the button into an unsubscribe one.
+// It returns the callback answer.
+func handleSubscribeCallback(ctx context.Context, query *telegram.CallbackQuery, arg string, toggle bool) string {
+	chatID := query.Message.Chat.ID
 
-	var data struct {
-		Results MovieAPIResults `json:"results"`
+	movieID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		logf(ctx, "invalid movie id in callback data: %q", query.Data)
+		return ""
+	}
+	movie, err := movieByID(ctx, movieID)
+	if err != nil {
+		return callbackTMDBFailed(ctx, chatID, err, "failed to get movie")
 	}
+	existing, err := subscribeChat(ctx, chatID, newMovieRelease(movie), 0, regionDate{}, receivedTopics.thread(query.Message))
+	if err != nil {
+		return callbackStoreFailed(ctx, err, "failed to subscribe to movie release")
+	}
+
+	if toggle {
+		toggleSubscriptionButton(ctx, query.Message, movieID, true)
+	}
+	if existing != "" {
+		return "Already subscribed to " + existing
+	}
+	return "Subscribed to " + movie.Title
+}
+
+// handleUnsubscribeCallback is the counterpart of handleSubscribeCallback.
+func handleUnsubscribeCallback(ctx context.Context, query *telegram.CallbackQuery, arg string) string {
+	chatID := query.Message.Chat.ID
 
-	b, err := ioutil.ReadAll(res.Body)
+	movieID, err := strconv.ParseInt(arg, 10, 64)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed read request body")
+		logf(ctx, "invalid movie id in callback data: %q", query.Data)
+		return ""
 	}
+	if err := unsubscribeChat(ctx, chatID, movieID); err != nil {
+		return callbackStoreFailed(ctx, err, "failed to unsubscribe from movie release")
+	}
+
+	toggleSubscriptionButton(ctx, query.Message, movieID, false)
+	return "Unsubscribed"
+}
 
-	if err := json.Unmarshal(b, &data); err != nil {
-		return nil, errors.Wrap(err, "failed to parse json")
+// toggleSubscriptionButton replaces the inline keyboard of the message with
+// the button matching the new subscription state.
+func toggleSubscriptionButton(ctx context.Context, msg *telegram.Message, movieID int64, subscribed bool) {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	markup := telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(subscriptionButton(movieID, subscribed)))
+	edit := telegram.NewEditMessageReplyMarkup(msg.Chat.ID, msg.MessageID, markup)
+	if _, err := bot.Send(edit); err != nil {
+		logf(ctx, "failed to update subscription button: %s", err)
 	}
+}
 
-	for i := range data.Results {
-		if data.Results[i].ReleaseDate == "" {
-			continue
+// chatSubscriptions returns the movie releases the chat is subscribed to.
+// Migrated releases are read by key, so that a list right after a subscribe
+// includes it. The others are only found by the eventually consistent query
+// of every release, until the migration is run, see handleAdminMigrate.
+func chatSubscriptions(ctx context.Context, chatID int64) ([]MovieRelease, error) {
+	indexed, err := store.ChatReleases(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	records, err := store.Releases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []MovieRelease
+	for _, rec := range indexed {
+		if rec.subscribed(chatID) {
+			subscriptions = append(subscriptions, rec)
 		}
-		t, err := time.Parse("2006-01-02", data.Results[i].ReleaseDate)
-		data.Results[i].ReleaseTime = t
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse release date")
+	}
+	for _, rec := range records {
+		if rec.SubscriptionsVersion < subscriptionsVersion && rec.subscribed(chatID) {
+			subscriptions = append(subscriptions, rec)
 		}
 	}
-	sort.Sort(sort.Reverse(data.Results))
-
-	return data.Results, nil
+	return subscriptions, nil
 }
 
-func handleTaskNotify(w http.ResponseWriter, r *http.Request) {
-	var records []MovieRelease
-	keys, err := datastoreClient.GetAll(context.TODO(), datastore.NewQuery("MovieRelease"), &records)
+func handlelistSubscriptions(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get all subscriptions")
+		return
+	}
+	prefs, err := store.Prefs(ctx, chatID)
 	if err != nil {
-		log.Fatalf("failed to get all subscriptions: %s", err)
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+
+	subscriptions = regionalSubscriptions(subscriptions, chatID)
+
+	// Titles are displayed in the language of the chat region
+	localized := make([]MovieRelease, len(subscriptions))
+	for i, rec := range subscriptions {
+		rec.MovieTitle = releaseTitle(ctx, rec, prefs)
+		localized[i] = rec
 	}
+	subscriptions = localized
 
-	for idxRecord, record := range records {
-		now := time.Now()
-		inOneWeek := now.Add(7 * 24 * time.Hour)
-		if !(record.ReleaseDate.After(now) && record.ReleaseDate.Before(inOneWeek)) {
-			continue
+	now := time.Now()
+	var text string
+	switch {
+	case len(subscriptions) == 0:
+		text = "No subscriptions found"
+	case matches[1] != "":
+		text = "Your subscriptions are \n" + formatByMonth(subscriptions, prefs, now)
+	default:
+		text = "Your subscriptions are \n"
+		for _, sub := range sortSubscriptions(subscriptions, chatID, prefs.listOrder()) {
+			text += fmt.Sprintf("- %s %s\n", displayTitle(sub.MovieTitle, sub.ID), subscriptionStatus(sub, prefs, now))
 		}
+	}
+	msgConfig := telegram.NewMessage(update.Message.Chat.ID, text)
+	if released := releasedSubscriptions(subscriptions, prefs, now); len(released) > 0 {
+		msgConfig.ReplyMarkup = removeReleasedKeyboard(len(released))
+	}
+	sendMsg(ctx, msgConfig)
+}
 
-		for idxSub, sub := range record.Subscribers {
-			if sub.Notified {
-				continue
+// formatByMonth lists the subscriptions grouped by month of release, sorted by
+// date. Subscriptions without a release date come last.
+func formatByMonth(subscriptions []MovieRelease, prefs UserPrefs, now time.Time) string {
+	sorted := append([]MovieRelease(nil), subscriptions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].ReleaseDate, sorted[j].ReleaseDate
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		return a.Before(b)
+	})
+
+	var text, group string
+	for _, sub := range sorted {
+		header := "Date unknown"
+		if !sub.ReleaseDate.IsZero() {
+			header = sub.ReleaseDate.Format("January 2006")
+		}
+		if header != group {
+			if group != "" {
+				text += "\n"
 			}
+			text += "── " + header + " ──\n"
+			group = header
+		}
+		text += fmt.Sprintf("- %s %s\n", displayTitle(sub.MovieTitle, sub.ID), subscriptionStatus(sub, prefs, now))
+	}
+	return text
+}
 
-			days := int(math.Ceil(record.ReleaseDate.Sub(now).Hours() / 24))
-			text := fmt.Sprintf("%s will be released in %d days.", record.MovieTitle, days)
-			sendMsg(telegram.NewMessage(sub.ChatID, text))
+// formatReleaseDate formats a release date for display, zero dates are
+// unknown release dates.
+func formatReleaseDate(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format("2 Jan 2006")
+}
 
-			record.Subscribers[idxSub].Notified = true
-		}
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "[", "\\[", "`", "\\`")
 
-		key := keys[idxRecord]
-		_, err = datastoreClient.Put(context.TODO(), key, &record)
-		if err != nil {
-			log.Fatalf("failed to update movie release: key=%v", key)
-		}
+// escapeMarkdown escapes text so it can be embedded in a Markdown message.
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// trySendMsg sends the message, returning any error to the caller instead of
+// aborting.
+func trySendMsg(ctx context.Context, msg telegram.MessageConfig) (telegram.Message, error) {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return telegram.Message{}, errors.Wrapf(err, "failed to send message to chat %d", msg.ChatID)
+	}
+	return sent, nil
+}
+
+// sendMsg sends the message, failures are only logged: the chat may have
+// blocked the bot or Telegram may be down, the others are still served. Use
+// trySendMsg when the sent message is needed.
+func sendMsg(ctx context.Context, msg telegram.MessageConfig) {
+	if _, err := trySendMsg(ctx, msg); err != nil {
+		logf(ctx, "%s", err)
 	}
 }
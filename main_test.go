@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBestReleasedMatch(t *testing.T) {
+	now := time.Now()
+	results := MovieAPIResults{
+		{ID: 3, Title: "Dune: Part Three", ReleaseTime: now.AddDate(1, 0, 0)},
+		{ID: 2, Title: "Dune: Part Two", ReleaseTime: now.AddDate(-1, 0, 0)},
+		{ID: 1, Title: "Dune", ReleaseTime: now.AddDate(-2, 0, 0)},
+		{ID: 4, Title: "Dune", ReleaseTime: time.Time{}},
+	}
+
+	tests := []struct {
+		title  string
+		wantID int64
+		wantOK bool
+	}{
+		{"dune", 1, true},
+		{"dune part", 2, true},
+	}
+	for _, tt := range tests {
+		got, ok := bestReleasedMatch(results, tt.title)
+		if ok != tt.wantOK || got.ID != tt.wantID {
+			t.Errorf("bestReleasedMatch(%q) = %d, %t, want %d, %t", tt.title, got.ID, ok, tt.wantID, tt.wantOK)
+		}
+	}
+
+	if _, ok := bestReleasedMatch(results[:1], "dune"); ok {
+		t.Error("bestReleasedMatch() found a released movie among upcoming ones")
+	}
+}
+
+func TestHandleSubscribeAlreadyReleased(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 438631, "title": "Dune", "release_date": "2021-09-15"},
+		},
+	})
+
+	update := testMessage(42, "subscribe to dune")
+	handleSubscribe(context.Background(), update, subscribeCommand.FindStringSubmatch(update.Message.Text))
+
+	texts := tg.texts(42)
+	if len(texts) != 1 {
+		t.Fatalf("sent %d messages, want 1: %q", len(texts), texts)
+	}
+	if want := "Dune already came out on 15 Sep 2021"; !strings.HasPrefix(texts[0], want) {
+		t.Errorf("message = %q, want it to start with %q", texts[0], want)
+	}
+	if !strings.Contains(texts[0], tmdbMovieURL(438631)) {
+		t.Errorf("message = %q, want a link to the movie", texts[0])
+	}
+}
+
+func TestChatSubscriptionsStaleQuery(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: 42}}}); err != nil {
+		t.Fatal(err)
+	}
+	// Not migrated yet, only found by the query
+	s.releases[2] = MovieRelease{ID: 2, MovieTitle: "Alien", Subscribers: []Subscriber{{ChatID: 42}}}
+
+	// The query lags behind the writes of the test from now on
+	s.freeze()
+	if _, err := subscribeChat(ctx, 42, MovieRelease{ID: 3, MovieTitle: "Tron"}, 0, regionDate{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unsubscribeChat(ctx, 42, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := chatSubscriptions(ctx, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, rec := range subscriptions {
+		titles = append(titles, rec.MovieTitle)
+	}
+	if got, want := strings.Join(titles, ", "), "Tron, Alien"; got != want {
+		t.Errorf("subscriptions = %s, want %s", got, want)
+	}
+}
+
+func TestPlausibleYear(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		year string
+		want bool
+	}{
+		{"0000", false},
+		{"1887", false},
+		{"1888", true},
+		{"2026", true},
+		{"2036", true},
+		{"2037", false},
+		{"3000", false},
+		{"20x6", false},
+	}
+	for _, tt := range tests {
+		if got := plausibleYear(tt.year, now); got != tt.want {
+			t.Errorf("plausibleYear(%q) = %v, want %v", tt.year, got, tt.want)
+		}
+	}
+}
+
+func TestHandleReleaseImplausibleYear(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	f := useFakeTMDB(t)
+
+	handleRelease(context.Background(), testMessage(42, "releases dune 3000"), []string{"releases dune 3000", "", "dune", "3000"}, resultFilter{})
+
+	texts := tg.texts(42)
+	if len(texts) != 1 || !strings.Contains(texts[0], "3000 isn't a valid release year") {
+		t.Errorf("sent %q, want the year rejected", texts)
+	}
+	if n := f.requests("/search/movie"); n != 0 {
+		t.Errorf("sent %d searches to TMDB, want none", n)
+	}
+}
+
+func TestHandleSubscribeSameMovieOtherTitle(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	release := time.Now().AddDate(0, 3, 0).Format("2006-01-02")
+
+	subscribe := func(text, title string) {
+		api.route("/search/movie", map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"id": 181812, "title": title, "release_date": release},
+			},
+		})
+		update := testMessage(42, text)
+		handleSubscribe(context.Background(), update, subscribeCommand.FindStringSubmatch(update.Message.Text))
+	}
+	subscribe("subscribe to star wars episode ix", "Star Wars: Episode IX")
+	subscribe("subscribe to rise of skywalker", "The Rise of Skywalker")
+
+	rec := s.releases[181812]
+	if len(s.releases) != 1 || len(rec.Subscribers) != 1 {
+		t.Fatalf("releases = %+v, want a single subscription", s.releases)
+	}
+	texts := tg.texts(42)
+	if len(texts) != 2 || !strings.Contains(texts[1], "already subscribed to this movie, listed as Star Wars: Episode IX") {
+		t.Errorf("messages = %q, want the second subscription reported as a duplicate", texts)
+	}
+}
+
+func TestHandleUpdateSendFails(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 438631, "title": "Dune", "release_date": "2031-09-15"},
+		},
+	})
+	tg.fail = func(call telegramCall) string {
+		if call.Params.Get("chat_id") == "601" {
+			return "Forbidden: bot was blocked by the user"
+		}
+		return ""
+	}
+
+	// The failed reply is logged, the bot keeps answering the other chats
+	handleUpdate(testMessage(601, "releases dune"))
+	handleUpdate(testMessage(602, "releases dune"))
+	if texts := tg.texts(602); len(texts) != 1 || !strings.Contains(texts[0], "Dune") {
+		t.Errorf("sent %q to chat 602, want the search results", texts)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// callbackPickSubscription runs an action on the subscription picked
+	// among several matching ones, see sendSubscriptionChoice.
+	callbackPickSubscription = "pick"
+
+	// maxSubscriptionChoices is how many matching subscriptions are offered
+	// to pick from.
+	maxSubscriptionChoices = 8
+)
+
+// subscriptionAction acts on a single subscription of the chat, the chat
+// being subscribed to rec.
+type subscriptionAction func(ctx context.Context, chatID int64, rec MovieRelease)
+
+// subscriptionActions are the actions taking the title of a subscription,
+// by name.
+var subscriptionActions = map[string]subscriptionAction{
+	"unsubscribe":  unsubscribeAction,
+	"history":      historyAction,
+	"trailerson":   func(ctx context.Context, chatID int64, rec MovieRelease) { trailersAction(ctx, chatID, rec, true) },
+	"trailersoff":  func(ctx context.Context, chatID int64, rec MovieRelease) { trailersAction(ctx, chatID, rec, false) },
+	"countdownon":  func(ctx context.Context, chatID int64, rec MovieRelease) { countdownAction(ctx, chatID, rec, true) },
+	"countdownoff": func(ctx context.Context, chatID int64, rec MovieRelease) { countdownAction(ctx, chatID, rec, false) },
+}
+
+// normalizeTitle lowercases the title and replaces punctuation with spaces,
+// e.g. "Star Wars: Episode IX" gives "star wars episode ix".
+func normalizeTitle(title string) string {
+	mapped := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, title)
+	return strings.Join(strings.Fields(mapped), " ")
+}
+
+// matchSubscriptions returns the subscriptions whose title contains every
+// word of the query, ignoring case and punctuation. Exact title matches win
+// over partial ones.
+func matchSubscriptions(subscriptions []MovieRelease, query string) []MovieRelease {
+	normalized := normalizeTitle(query)
+	words := strings.Fields(normalized)
+	if len(words) == 0 {
+		return nil
+	}
+
+	var exact, partial []MovieRelease
+	for _, rec := range subscriptions {
+		title := normalizeTitle(rec.MovieTitle)
+		if title == normalized {
+			exact = append(exact, rec)
+			continue
+		}
+		matches := true
+		for _, w := range words {
+			if !strings.Contains(title, w) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			partial = append(partial, rec)
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+// findUserSubscriptions returns the stored subscriptions of the chat
+// matching the title query, see matchSubscriptions.
+func findUserSubscriptions(ctx context.Context, chatID int64, query string) ([]MovieRelease, error) {
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return matchSubscriptions(subscriptions, query), nil
+}
+
+// actOnSubscription runs the named action of subscriptionActions on the
+// subscription of the chat matching the title query. When several
+// subscriptions match the user picks one with a button.
+func actOnSubscription(ctx context.Context, chatID int64, query, action string) {
+	matching, err := findUserSubscriptions(ctx, chatID, query)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+
+	switch len(matching) {
+	case 0:
+		sendMsg(ctx, telegram.NewMessage(chatID, "You aren't subscribed to a movie matching "+query))
+	case 1:
+		subscriptionActions[action](ctx, chatID, matching[0])
+	default:
+		sendSubscriptionChoice(ctx, chatID, matching, action)
+	}
+}
+
+// sendSubscriptionChoice asks the user to pick one of the matching
+// subscriptions to run the action on.
+func sendSubscriptionChoice(ctx context.Context, chatID int64, matching []MovieRelease, action string) {
+	text := "Found multiple subscriptions, which one?"
+	if len(matching) > maxSubscriptionChoices {
+		matching = matching[:maxSubscriptionChoices]
+		text = "Found many subscriptions, which one? Be more specific if it isn't listed."
+	}
+
+	var rows [][]telegram.InlineKeyboardButton
+	for _, rec := range matching {
+		label, _ := truncateTitle(rec.MovieTitle, infoButtonTitleLength)
+		if !rec.ReleaseDate.IsZero() {
+			label += fmt.Sprintf(" (%d)", rec.ReleaseDate.Year())
+		}
+		data := fmt.Sprintf("%s:%s:%d", callbackPickSubscription, action, rec.ID)
+		rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData(label, data)))
+	}
+
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ReplyMarkup = telegram.NewInlineKeyboardMarkup(rows...)
+	sendMsg(ctx, msgConfig)
+}
+
+// handlePickSubscriptionCallback runs the action on the subscription picked
+// by the user. It returns the callback answer.
+func handlePickSubscriptionCallback(ctx context.Context, query *telegram.CallbackQuery, arg string) string {
+	chatID := query.Message.Chat.ID
+
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) != 2 {
+		logf(ctx, "invalid pick callback data: %q", query.Data)
+		return ""
+	}
+	action, ok := subscriptionActions[parts[0]]
+	if !ok {
+		logf(ctx, "unknown pick callback action: %q", query.Data)
+		return ""
+	}
+	movieID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		logf(ctx, "invalid movie id in callback data: %q", query.Data)
+		return ""
+	}
+
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return callbackStoreFailed(ctx, err, "failed to get subscriptions")
+	}
+	for _, rec := range subscriptions {
+		if rec.ID == movieID {
+			action(ctx, chatID, rec)
+			return ""
+		}
+	}
+	return "You aren't subscribed to this movie anymore."
+}
+
+func handleUnsubscribe(ctx context.Context, update telegram.Update, matches []string) {
+	actOnSubscription(ctx, update.Message.Chat.ID, strings.TrimSpace(matches[1]), "unsubscribe")
+}
+
+func unsubscribeAction(ctx context.Context, chatID int64, rec MovieRelease) {
+	if err := unsubscribeChat(ctx, chatID, rec.ID); err != nil {
+		storeFailed(ctx, chatID, err, "failed to unsubscribe from movie release")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Unsubscribed from "+rec.MovieTitle+"."))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestMatchSubscriptions(t *testing.T) {
+	subscriptions := []MovieRelease{
+		{ID: 1, MovieTitle: "Dune"},
+		{ID: 2, MovieTitle: "Dune: Part Two"},
+		{ID: 3, MovieTitle: "Star Wars: Episode IX"},
+		{ID: 4, MovieTitle: "Alien"},
+	}
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"dune", []int64{1}},
+		{"DUNE part", []int64{2}},
+		{"part two", []int64{2}},
+		{"star wars episode ix", []int64{3}},
+		{"wars ix", []int64{3}},
+		{"a", []int64{2, 3, 4}},
+		{"tron", nil},
+		{" : ", nil},
+	}
+	for _, tt := range tests {
+		var got []int64
+		for _, rec := range matchSubscriptions(subscriptions, tt.query) {
+			got = append(got, rec.ID)
+		}
+		if !equalIDs(got, tt.want) {
+			t.Errorf("matchSubscriptions(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHandleUnsubscribePartialMatch(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+	for _, rec := range []MovieRelease{
+		{ID: 1, MovieTitle: "Dune: Part Two", Subscribers: []Subscriber{{ChatID: 42}}},
+		{ID: 2, MovieTitle: "Alien", Subscribers: []Subscriber{{ChatID: 42}}},
+	} {
+		if err := store.PutRelease(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := testMessage(42, "unsubscribe from dune")
+	handleUnsubscribe(ctx, update, unsubscribeCommand.FindStringSubmatch(update.Message.Text))
+
+	if s.releases[1].subscribed(42) || !s.releases[2].subscribed(42) {
+		t.Errorf("releases = %+v, want only Dune: Part Two unsubscribed", s.releases)
+	}
+	if texts := tg.texts(42); len(texts) != 1 || texts[0] != "Unsubscribed from Dune: Part Two." {
+		t.Errorf("messages = %q, want the unsubscription confirmed", texts)
+	}
+}
+
+func TestHandleUnsubscribeAmbiguous(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+	for _, rec := range []MovieRelease{
+		{ID: 1, MovieTitle: "Dune: Part One", Subscribers: []Subscriber{{ChatID: 42}}},
+		{ID: 2, MovieTitle: "Dune: Part Two", Subscribers: []Subscriber{{ChatID: 42}}},
+	} {
+		if err := store.PutRelease(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update := testMessage(42, "unsubscribe from dune")
+	handleUnsubscribe(ctx, update, unsubscribeCommand.FindStringSubmatch(update.Message.Text))
+
+	if !s.releases[1].subscribed(42) || !s.releases[2].subscribed(42) {
+		t.Fatalf("releases = %+v, want no unsubscription before picking", s.releases)
+	}
+	sent := tg.sent("sendMessage")
+	if len(sent) != 1 || sent[0].Params.Get("text") != "Found multiple subscriptions, which one?" {
+		t.Fatalf("sent %+v, want a choice", sent)
+	}
+	var markup telegram.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(sent[0].Params.Get("reply_markup")), &markup); err != nil {
+		t.Fatal(err)
+	}
+	var choices []string
+	for _, row := range markup.InlineKeyboard {
+		choices = append(choices, row[0].Text+"="+*row[0].CallbackData)
+	}
+	if got, want := strings.Join(choices, ", "), "Dune: Part One=pick:unsubscribe:1, Dune: Part Two=pick:unsubscribe:2"; got != want {
+		t.Fatalf("choices = %s, want %s", got, want)
+	}
+
+	query := &telegram.CallbackQuery{Message: &telegram.Message{Chat: &telegram.Chat{ID: 42}}, Data: "pick:unsubscribe:2"}
+	if answer := handlePickSubscriptionCallback(ctx, query, "unsubscribe:2"); answer != "" {
+		t.Errorf("answer = %q, want none", answer)
+	}
+	if !s.releases[1].subscribed(42) || s.releases[2].subscribed(42) {
+		t.Errorf("releases = %+v, want only the picked one unsubscribed", s.releases)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleAdminMigrate creates the Subscription entities of every movie release
+// not migrated to the latest layout yet, see migrateRelease. Each release is
+// migrated on its own: the migration can be run again after a partial
+// failure or while the bot is serving, migrated releases are skipped.
+func handleAdminMigrate(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+	ctx := withRequestID(r.Context(), newRequestID())
+
+	records, err := store.Releases(ctx)
+	if err != nil {
+		logf(ctx, "failed to get all subscriptions: %s", err)
+		http.Error(w, "failed to get movie releases", http.StatusServiceUnavailable)
+		return
+	}
+
+	migrated, skipped := 0, 0
+	for _, record := range records {
+		if ctx.Err() != nil {
+			break
+		}
+		if record.SubscriptionsVersion >= subscriptionsVersion {
+			skipped++
+			continue
+		}
+		ok, err := store.MigrateRelease(ctx, record.ID)
+		if err != nil {
+			logf(ctx, "migration stopped: migrated=%d skipped=%d: %s", migrated, skipped, err)
+			http.Error(w, fmt.Sprintf("migration stopped after %d releases, run it again: %s", migrated, err), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			migrated++
+		} else {
+			skipped++
+		}
+	}
+
+	if ctx.Err() != nil {
+		logf(ctx, "migration interrupted: migrated=%d skipped=%d: %s", migrated, skipped, ctx.Err())
+		http.Error(w, fmt.Sprintf("migration interrupted after %d releases, run it again", migrated), http.StatusServiceUnavailable)
+		return
+	}
+
+	logf(ctx, "migration done: migrated=%d skipped=%d", migrated, skipped)
+	fmt.Fprintf(w, "migrated=%d skipped=%d total=%d\n", migrated, skipped, len(records))
+}
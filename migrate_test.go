@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestChangedSubscribers(t *testing.T) {
+	stored := MovieRelease{ID: 1, Subscribers: []Subscriber{
+		{ChatID: 1},
+		{ChatID: 2, RemindDays: 3},
+		{ChatID: 3, SeenTrailers: []string{"a"}},
+	}}
+	release := MovieRelease{ID: 1, Subscribers: []Subscriber{
+		{ChatID: 1},
+		{ChatID: 3, SeenTrailers: []string{"a", "b"}},
+		{ChatID: 4},
+	}}
+
+	changed, removed := changedSubscribers(stored, release)
+	want := []Subscriber{{ChatID: 3, SeenTrailers: []string{"a", "b"}}, {ChatID: 4}}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %+v, want %+v", changed, want)
+	}
+	if !reflect.DeepEqual(removed, []int64{2}) {
+		t.Errorf("removed = %v, want [2]", removed)
+	}
+
+	if changed, removed := changedSubscribers(stored, stored.clone()); len(changed) != 0 || len(removed) != 0 {
+		t.Errorf("unchanged release wrote %+v and removed %v, want nothing", changed, removed)
+	}
+	if changed, _ := changedSubscribers(MovieRelease{}, release); len(changed) != 3 {
+		t.Errorf("new release wrote %d subscribers, want all 3", len(changed))
+	}
+}
+
+func TestHandleAdminMigrate(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	previous := adminToken
+	adminToken = "secret"
+	t.Cleanup(func() { adminToken = previous })
+
+	for _, r := range []MovieRelease{{ID: 1}, {ID: 2}} {
+		if err := store.PutRelease(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stored by an older version of the bot
+	legacy := s.releases[2]
+	legacy.SubscriptionsVersion = 0
+	s.releases[2] = legacy
+
+	migrate := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/migrate", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handleAdminMigrate(w, req)
+		return w
+	}
+
+	if w := migrate(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "migrated=1 skipped=1") {
+		t.Errorf("first run = %d %q, want the legacy release migrated", w.Code, w.Body.String())
+	}
+	if s.releases[2].SubscriptionsVersion != subscriptionsVersion {
+		t.Error("legacy release not marked migrated")
+	}
+	if w := migrate(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "migrated=0 skipped=2") {
+		t.Errorf("second run = %d %q, want everything skipped", w.Code, w.Body.String())
+	}
+
+	s.fail(errors.New("datastore down"))
+	if w := migrate(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("run during an outage = %d, want 503", w.Code)
+	}
+}
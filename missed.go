@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// defaultMissedReleaseDays is how many days after a release a missed reminder
+// is still sent, unless MISSED_RELEASE_DAYS is set.
+const defaultMissedReleaseDays = 14
+
+var missedReleaseWindow = parseMissedReleaseWindow("")
+
+// parseMissedReleaseWindow parses how long after a release a missed reminder
+// is still sent, in days. Zero never sends them.
+func parseMissedReleaseWindow(days string) time.Duration {
+	n := defaultMissedReleaseDays
+	if days != "" {
+		parsed, err := strconv.Atoi(days)
+		if err != nil || parsed < 0 {
+			log.Printf("WARNING: invalid MISSED_RELEASE_DAYS %q, using %d days", days, defaultMissedReleaseDays)
+		} else {
+			n = parsed
+		}
+	}
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// releaseMissed returns whether the release date of the record passed
+// without its subscriber being reminded, e.g. because the notify job didn't
+// run during the reminder window. send tells whether the release is recent
+// enough for a late notification, see missedReleaseWindow, otherwise the
+// subscriber should only be marked notified.
+func releaseMissed(record MovieRelease, now time.Time) (missed, send bool) {
+	if record.ReleaseDate.IsZero() || record.ReleaseDate.After(now) {
+		return false, false
+	}
+	return true, now.Sub(record.ReleaseDate) <= missedReleaseWindow
+}
+
+// missedReleaseText is the late notification of a release.
+func missedReleaseText(record MovieRelease) string {
+	return fmt.Sprintf("%s was released on %s (you may have missed the reminder).", displayTitle(record.MovieTitle, record.ID), formatReleaseDate(record.ReleaseDate))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMissedReleaseWindow(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		days string
+		want time.Duration
+	}{
+		{"", defaultMissedReleaseDays * day},
+		{"3", 3 * day},
+		{"0", 0},
+		{"-1", defaultMissedReleaseDays * day},
+		{"soon", defaultMissedReleaseDays * day},
+	}
+	for _, tt := range tests {
+		if got := parseMissedReleaseWindow(tt.days); got != tt.want {
+			t.Errorf("parseMissedReleaseWindow(%q) = %s, want %s", tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestReleaseMissed(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		date       time.Time
+		wantMissed bool
+		wantSend   bool
+	}{
+		{"upcoming", now.AddDate(0, 0, 3), false, false},
+		{"undated", time.Time{}, false, false},
+		{"recently released", now.AddDate(0, 0, -3), true, true},
+		{"long released", now.AddDate(0, -2, 0), true, false},
+	}
+	for _, tt := range tests {
+		missed, send := releaseMissed(MovieRelease{ReleaseDate: tt.date}, now)
+		if missed != tt.wantMissed || send != tt.wantSend {
+			t.Errorf("%s: releaseMissed() = %t, %t, want %t, %t", tt.name, missed, send, tt.wantMissed, tt.wantSend)
+		}
+	}
+}
+
+func TestNotifyReleasesMissedReminder(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	releases := []MovieRelease{
+		{ID: 1, MovieTitle: "Dune", ReleaseDate: now.AddDate(0, 0, -5), Subscribers: []Subscriber{{ChatID: 42}}},
+		{ID: 2, MovieTitle: "Alien", ReleaseDate: now.AddDate(0, -2, 0), Subscribers: []Subscriber{{ChatID: 43}}},
+	}
+	for _, release := range releases {
+		if err := store.PutRelease(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifyReleases(ctx)
+
+	texts := tg.texts(42)
+	if len(texts) != 1 || !strings.Contains(texts[0], "Dune was released on") || !strings.Contains(texts[0], "you may have missed the reminder") {
+		t.Errorf("sent %q, want the missed reminder", texts)
+	}
+	if texts := tg.texts(43); len(texts) != 0 {
+		t.Errorf("sent %q about a long released movie, want nothing", texts)
+	}
+	for _, id := range []int64{1, 2} {
+		if !s.releases[id].Subscribers[0].Notified {
+			t.Errorf("release %d not marked notified", id)
+		}
+	}
+
+	notifyReleases(ctx)
+	if texts := tg.texts(42); len(texts) != 1 {
+		t.Errorf("sent %d messages after a second run, want no new one", len(texts))
+	}
+}
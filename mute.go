@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// maxMutedWords bounds the number of muted words of a chat.
+const maxMutedWords = 20
+
+// containsWord returns whether the lowercased text contains the word, as a
+// whole word.
+func containsWord(text, word string) bool {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionsBot returns whether the lowercased text mentions the bot by its
+// username.
+func mentionsBot(text string) bool {
+	return bot.Self.UserName != "" && strings.Contains(text, "@"+strings.ToLower(bot.Self.UserName))
+}
+
+// ignoredAsMuted returns whether the group message must be ignored because it
+// contains a word muted in the chat and doesn't mention the bot. Messages are
+// not ignored when the preferences cannot be read.
+func ignoredAsMuted(ctx context.Context, msg *telegram.Message, text string) bool {
+	if msg.Chat.IsPrivate() || mentionsBot(text) {
+		return false
+	}
+
+	prefs, err := store.Prefs(ctx, msg.Chat.ID)
+	if err != nil {
+		logf(ctx, "failed to get user prefs, not checking muted words: %s", err)
+		return false
+	}
+	for _, word := range prefs.MutedWords {
+		if containsWord(text, word) {
+			logf(ctx, "ignoring message containing muted word %q", word)
+			return true
+		}
+	}
+	return false
+}
+
+// handleMuteWord adds the word to, or removes it from, the muted words of the
+// chat. Only admins can change them in groups.
+func handleMuteWord(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	mute := matches[1] == "mute"
+	word := matches[2]
+
+	if !requireGroupAdmin(ctx, update.Message, "change the muted words") {
+		return
+	}
+
+	var words []string
+	full := false
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		words = nil
+		for _, w := range prefs.MutedWords {
+			if w != word {
+				words = append(words, w)
+			}
+		}
+		if mute {
+			if len(words) >= maxMutedWords {
+				full = true
+				return errSkipUpdate
+			}
+			words = append(words, word)
+		}
+		prefs.MutedWords = words
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	if full {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You can't mute more words, unmute one first."))
+		return
+	}
+
+	text := "I'll ignore messages containing " + word + " unless you mention me."
+	if !mute {
+		text = word + " isn't muted anymore."
+	}
+	if len(words) > 0 {
+		text += "\nMuted words: " + strings.Join(words, ", ")
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Notifier delivers notifications to the chats of subscribers.
+type Notifier interface {
+	// Notify sends the notification text to the chat.
+	Notify(ctx context.Context, chatID int64, text string) error
+}
+
+// telegramNotifier delivers notifications as Telegram messages, without
+// sound or vibration when silent is set. With a thread they are posted in
+// that forum topic, or in the general thread if it is gone.
+type telegramNotifier struct {
+	silent bool
+	thread int
+}
+
+func (n telegramNotifier) Notify(ctx context.Context, chatID int64, text string) error {
+	if n.thread != 0 {
+		err := sendTopicMsg(ctx, chatID, n.thread, text, n.silent)
+		if err == nil {
+			return nil
+		}
+		logf(ctx, "failed to notify in topic %d, falling back to the general thread of chat %d: %s", n.thread, chatID, err)
+	}
+
+	msg := telegram.NewMessage(chatID, text)
+	msg.DisableNotification = n.silent
+	_, err := trySendMsg(ctx, msg)
+	return err
+}
+
+// noopNotifier only logs notifications, for dry runs.
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(ctx context.Context, chatID int64, text string) error {
+	logf(ctx, "dry run, not notifying chat %d: %q", chatID, text)
+	return nil
+}
+
+// defaultChannel is the notification channel of chats that didn't choose one.
+const defaultChannel = "telegram"
+
+// notifiers holds the available notification channels, by name. All of them
+// are replaced by noopNotifier when NOTIFY_DRY_RUN is set.
+var notifiers = map[string]Notifier{
+	defaultChannel: telegramNotifier{},
+}
+
+// setDryRun makes every channel log notifications instead of sending them.
+func setDryRun() {
+	for name := range notifiers {
+		notifiers[name] = noopNotifier{}
+	}
+}
+
+// channelNames returns the names of the available channels, sorted.
+func channelNames() []string {
+	var names []string
+	for name := range notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// chatNotifier returns the notifier of the channel chosen in prefs, or of the
+// default channel if it is not available anymore. Telegram notifications
+// honor the silent preference of the chat.
+func chatNotifier(prefs UserPrefs) Notifier {
+	n, ok := notifiers[prefs.NotifyChannel]
+	if !ok {
+		n = notifiers[defaultChannel]
+	}
+	if t, ok := n.(telegramNotifier); ok {
+		t.silent = prefs.SilentNotifications
+		return t
+	}
+	return n
+}
+
+// inThread returns the notifier posting in the forum topic of the chat,
+// channels other than Telegram don't have topics.
+func inThread(n Notifier, thread int) Notifier {
+	if t, ok := n.(telegramNotifier); ok {
+		t.thread = thread
+		return t
+	}
+	return n
+}
+
+func handleSilent(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	silent := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.SilentNotifications = silent
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	if silent {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Notifications will arrive silently, without sound or vibration. 🤫"))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Notifications will arrive with sound again. 🔔"))
+}
+
+func handleNotifyChannel(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	channel := strings.TrimSpace(matches[1])
+
+	if _, ok := notifiers[channel]; !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I can only notify you via "+strings.Join(channelNames(), ", ")+" for now."))
+		return
+	}
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.NotifyChannel = channel
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Notifications will be sent via "+channel+"."))
+}
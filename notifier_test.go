@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records the notifications it is asked to deliver, and refuses
+// those to the chats of failing.
+type fakeNotifier struct {
+	mu      sync.Mutex
+	sent    map[int64][]string
+	failing map[int64]bool
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, chatID int64, text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.failing[chatID] {
+		return errors.New("unreachable")
+	}
+	n.sent[chatID] = append(n.sent[chatID], text)
+	return nil
+}
+
+// useFakeNotifier makes a new fakeNotifier available as the "fake" channel
+// for the test.
+func useFakeNotifier(t *testing.T) *fakeNotifier {
+	t.Helper()
+	n := &fakeNotifier{sent: map[int64][]string{}, failing: map[int64]bool{}}
+	notifiers["fake"] = n
+	t.Cleanup(func() { delete(notifiers, "fake") })
+	return n
+}
+
+func TestChatNotifier(t *testing.T) {
+	fake := useFakeNotifier(t)
+
+	if n := chatNotifier(UserPrefs{NotifyChannel: "fake"}); n != fake {
+		t.Errorf("chatNotifier(fake) = %#v, want the fake notifier", n)
+	}
+	if n := chatNotifier(UserPrefs{NotifyChannel: "carrier pigeon", SilentNotifications: true}); n != (telegramNotifier{silent: true}) {
+		t.Errorf("chatNotifier(unknown) = %#v, want a silent telegram notifier", n)
+	}
+}
+
+func TestSendNotificationUsesChatChannel(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	fake := useFakeNotifier(t)
+	ctx := context.Background()
+
+	if err := store.PutPrefs(ctx, UserPrefs{ChatID: 42, NotifyChannel: "fake"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendNotification(ctx, Subscriber{ChatID: 42}, "Dune comes out tomorrow", notificationLog(notificationReminder, 1, "Dune")); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.sent[42]; len(got) != 1 || got[0] != "Dune comes out tomorrow" {
+		t.Errorf("fake notifier got %q, want the notification", got)
+	}
+	if calls := tg.sent("sendMessage"); len(calls) != 0 {
+		t.Errorf("sent %d telegram messages, want none", len(calls))
+	}
+	if logs := s.logs; len(logs) != 1 {
+		t.Errorf("logs = %+v, want the notification logged", logs)
+	}
+
+	fake.failing[42] = true
+	if err := sendNotification(ctx, Subscriber{ChatID: 42}, "Dune is out", notificationLog(notificationReleased, 1, "Dune")); err == nil {
+		t.Error("sendNotification() = nil, want the error of the notifier")
+	}
+	if logs := s.logs; len(logs) != 1 {
+		t.Errorf("logs = %+v, want the failed notification not logged", logs)
+	}
+}
+
+func TestNotifyReleasesLeavesFailedPending(t *testing.T) {
+	s := useMemStore(t)
+	useFakeTelegram(t)
+	useFakeTMDB(t)
+	fake := useFakeNotifier(t)
+	ctx := context.Background()
+
+	for _, chatID := range []int64{1, 2} {
+		if err := store.PutPrefs(ctx, UserPrefs{ChatID: chatID, NotifyChannel: "fake"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release := MovieRelease{
+		ID:          10,
+		MovieTitle:  "Dune",
+		ReleaseDate: time.Now().AddDate(0, 0, 2),
+		Subscribers: []Subscriber{{ChatID: 1}, {ChatID: 2}},
+	}
+	if err := store.PutRelease(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+	fake.failing[1] = true
+
+	notifyReleases(ctx)
+
+	if len(fake.sent[2]) != 1 {
+		t.Errorf("chat 2 got %q, want its reminder despite the failure of chat 1", fake.sent[2])
+	}
+	for _, sub := range s.releases[10].Subscribers {
+		if want := sub.ChatID == 2; sub.Notified != want {
+			t.Errorf("chat %d notified = %v, want %v", sub.ChatID, sub.Notified, want)
+		}
+	}
+}
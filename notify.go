@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+func handleTaskNotify(w http.ResponseWriter, r *http.Request) {
+	ctx := withRequestID(r.Context(), newRequestID())
+	runAsLeader(ctx, "notify", func(ctx context.Context) {
+		notifyReleases(ctx)
+		notifySeasons(ctx)
+		sendMonthlySummaries(ctx)
+	})
+}
+
+// notifyReleases sends the notifications that came due to every subscriber.
+// All the notifications due to a chat in the same run are combined into a
+// single message. Subscribers whose reminder was missed, e.g. while the job
+// was down, are told late about the release, see releaseMissed.
+func notifyReleases(ctx context.Context) {
+	sendReleaseNotifications(ctx, false)
+}
+
+// notifyScheduledReleases is like notifyReleases for the chats with a
+// delivery time only.
+func notifyScheduledReleases(ctx context.Context) {
+	sendReleaseNotifications(ctx, true)
+}
+
+func sendReleaseNotifications(ctx context.Context, scheduledOnly bool) {
+	records, err := store.Releases(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all subscriptions")
+		return
+	}
+	users, err := chatUsers(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get users")
+		return
+	}
+	if _, _, err := deliverNotifications(ctx, records, 0, users, scheduledOnly, time.Now()); err != nil {
+		jobStoreFailed(ctx, err, "failed to deliver notifications")
+	}
+}
+
+// deliverNotifications sends the notifications due to the subscribers of the
+// records, only those of the chat chatID unless it is 0, routed to the
+// linked chats of users. It returns how many notifications were sent, and
+// how many failed to be and stay pending. The pause of the chats whose
+// backlog was delivered ends, see endPause.
+func deliverNotifications(ctx context.Context, records []MovieRelease, chatID int64, users map[int64]User, scheduledOnly bool, now time.Time) (sent, failed int, err error) {
+	prefs := map[int64]UserPrefs{}
+	// resumed holds the chats whose pause ended, see endPause
+	resumed := map[int64]bool{}
+	// titles holds the titles localized during the run, by release and
+	// language
+	titles := map[string]string{}
+	titleFor := func(record MovieRelease, p UserPrefs) string {
+		key := fmt.Sprintf("%d-%s", record.ID, p.language())
+		title, ok := titles[key]
+		if !ok {
+			title = releaseTitle(ctx, record, p)
+			titles[key] = title
+		}
+		return title
+	}
+
+	var pending, skipped []pendingNotification
+	for _, record := range records {
+		for _, sub := range record.Subscribers {
+			if sub.Notified || (chatID != 0 && sub.ChatID != chatID) {
+				continue
+			}
+
+			p, ok := prefs[sub.ChatID]
+			if !ok {
+				p, err = store.Prefs(ctx, sub.ChatID)
+				if err != nil {
+					return sent, failed, errors.Wrap(err, "failed to get user prefs")
+				}
+				prefs[sub.ChatID] = p
+			}
+			if _, scheduled := p.deliveryTime(); scheduledOnly && !scheduled {
+				continue
+			}
+			// Paused notifications are left pending, they are sent once the
+			// pause is over.
+			if p.notificationsPaused(now) {
+				continue
+			}
+			if !p.PausedAt.IsZero() {
+				resumed[sub.ChatID] = true
+			}
+
+			// Decided on the stored title, only the notifications sent are
+			// localized
+			localized := sub.regional(record)
+			text, kind, ok := notificationText(localized, sub, p, now)
+			if !ok {
+				missed, send := releaseMissed(localized, now)
+				if !missed {
+					continue
+				}
+				if !send {
+					skipped = append(skipped, pendingNotification{releaseID: record.ID, sub: sub, log: notificationLog(notificationMissed, record.ID, localized.MovieTitle)})
+					continue
+				}
+				localized.MovieTitle = titleFor(record, p)
+				pending = append(pending, pendingNotification{releaseID: record.ID, sub: sub, text: missedReleaseText(localized), log: notificationLog(notificationMissed, record.ID, localized.MovieTitle)})
+				continue
+			}
+			if p.LightNotifications && sub.queriedRecently(now) {
+				skipped = append(skipped, pendingNotification{releaseID: record.ID, sub: sub, text: text, log: notificationLog(kind, record.ID, localized.MovieTitle)})
+				continue
+			}
+			localized.MovieTitle = titleFor(record, p)
+			text, kind, _ = notificationText(localized, sub, p, now)
+			pending = append(pending, pendingNotification{releaseID: record.ID, sub: sub, text: text, log: notificationLog(kind, record.ID, localized.MovieTitle)})
+		}
+	}
+
+	for _, n := range skipped {
+		err := updateSubscriber(ctx, n.releaseID, n.sub.ChatID, func(sub *Subscriber) {
+			sub.Notified = true
+		})
+		if err != nil {
+			return sent, failed, errors.Wrapf(err, "failed to update movie release: id=%d", n.releaseID)
+		}
+		logf(ctx, "skipped notification of a recently searched or long released movie: id=%d chat_id=%d", n.releaseID, n.sub.ChatID)
+	}
+
+	for _, group := range splitNotifications(coalesceNotifications(routeNotifications(pending, users))) {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping notify job: %s", ctx.Err())
+			return sent, failed, nil
+		}
+
+		logs := make([]NotificationLog, len(group))
+		for i, n := range group {
+			logs[i] = n.log
+		}
+		if err := sendNotification(ctx, group[0].sub, combineNotifications(group), logs...); err != nil {
+			logf(ctx, "failed to send notification, it stays pending: %s", err)
+			failed += len(group)
+			// Still paused for what wasn't delivered
+			for _, n := range group {
+				delete(resumed, n.sub.ChatID)
+				for _, id := range n.notifiedChats() {
+					delete(resumed, id)
+				}
+			}
+			continue
+		}
+
+		for _, n := range group {
+			for _, id := range n.notifiedChats() {
+				err := updateSubscriber(ctx, n.releaseID, id, func(sub *Subscriber) {
+					sub.Notified = true
+				})
+				if err != nil {
+					return sent, failed, errors.Wrapf(err, "failed to update movie release: id=%d", n.releaseID)
+				}
+			}
+		}
+		sent += len(group)
+	}
+
+	// The backlog of the chats whose pause ended was delivered above
+	for id := range resumed {
+		if err := endPause(ctx, id, now); err != nil {
+			return sent, failed, errors.Wrapf(err, "failed to end pause: chat_id=%d", id)
+		}
+	}
+	return sent, failed, nil
+}
+
+// pendingNotification is a notification due to a subscriber of a release.
+type pendingNotification struct {
+	releaseID int64
+	sub       Subscriber
+	text      string
+	log       NotificationLog
+	// marks are the subscribers marked notified once sent, the one of sub
+	// when nil. See routeNotifications.
+	marks []int64
+}
+
+// notifiedChats returns the chats whose subscriber is marked notified once
+// the notification is sent.
+func (n pendingNotification) notifiedChats() []int64 {
+	if n.marks == nil {
+		return []int64{n.sub.ChatID}
+	}
+	return n.marks
+}
+
+// coalesceNotifications groups the notifications by recipient, keeping the
+// order in which they were first seen. Each forum topic of a chat is its own
+// recipient.
+func coalesceNotifications(pending []pendingNotification) [][]pendingNotification {
+	type recipient struct {
+		chatID, notifyChatID int64
+		thread               int
+	}
+
+	var groups [][]pendingNotification
+	index := map[recipient]int{}
+	for _, n := range pending {
+		r := recipient{n.sub.ChatID, n.sub.NotifyChatID, n.sub.ThreadID}
+		i, ok := index[r]
+		if !ok {
+			i = len(groups)
+			index[r] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], n)
+	}
+	return groups
+}
+
+// maxMessageLength is the maximum length of a Telegram message, in
+// characters.
+const maxMessageLength = 4096
+
+// splitNotifications splits the groups whose combined message would be
+// longer than maxMessageLength, so that each group fits in one message. A
+// notification too long on its own stays alone in its group.
+func splitNotifications(groups [][]pendingNotification) [][]pendingNotification {
+	var split [][]pendingNotification
+	for _, group := range groups {
+		start := 0
+		for end := 1; end <= len(group); end++ {
+			if end-start > 1 && utf8.RuneCountInString(combineNotifications(group[start:end])) > maxMessageLength {
+				split = append(split, group[start:end-1])
+				start = end - 1
+			}
+		}
+		split = append(split, group[start:])
+	}
+	return split
+}
+
+// combineNotifications returns the text of a single message holding all the
+// notifications of the group.
+func combineNotifications(group []pendingNotification) string {
+	if len(group) == 1 {
+		return group[0].text
+	}
+	text := fmt.Sprintf("%d updates about your subscriptions:\n", len(group))
+	for _, n := range group {
+		text += "- " + n.text + "\n"
+	}
+	return text
+}
+
+// notificationsPaused returns whether release notifications are paused at
+// the given time.
+func (p UserPrefs) notificationsPaused(now time.Time) bool {
+	if !p.NotificationsPaused {
+		return false
+	}
+	return p.PausedUntil.IsZero() || now.Before(p.PausedUntil)
+}
+
+// notificationText returns the notification due to a subscriber of the
+// release that hasn't been notified yet, if any. Releases coming out within
+// the reminder offset of the subscriber are announced, or once its delivery
+// time came for chats having one, see UserPrefs.deliveryDue. Releases that
+// came out since the chat paused its notifications are reported as missed.
+func notificationText(record MovieRelease, sub Subscriber, prefs UserPrefs, now time.Time) (text, kind string, ok bool) {
+	remindFrom := now.Add(time.Duration(sub.remindDays()) * 24 * time.Hour)
+	upcoming := record.ReleaseDate.After(now) && record.ReleaseDate.Before(remindFrom)
+	days := int(math.Ceil(record.ReleaseDate.Sub(now).Hours() / 24))
+	if due, scheduled := prefs.deliveryDue(record.ReleaseDate, sub.remindDays()); scheduled {
+		upcoming = record.ReleaseDate.After(now) && !now.Before(due)
+		days = daysUntil(record.ReleaseDate, now, prefs.location())
+	}
+	if upcoming {
+		data := notificationData{
+			Title: displayTitle(record.MovieTitle, record.ID),
+			Days:  days,
+			Date:  formatReleaseDate(record.ReleaseDate),
+		}
+		return withFirstRegion(renderNotification(templateUpcoming, prefs, data), sub), notificationReminder, true
+	}
+
+	if !prefs.PausedAt.IsZero() && record.ReleaseDate.After(prefs.PausedAt) && !record.ReleaseDate.After(now) {
+		data := notificationData{
+			Title: displayTitle(record.MovieTitle, record.ID),
+			Date:  formatReleaseDate(record.ReleaseDate),
+		}
+		return withFirstRegion(renderNotification(templateReleased, prefs, data), sub), notificationReleased, true
+	}
+
+	return "", "", false
+}
+
+// sendNotification sends a notification to the subscriber, via the channel
+// chosen by its chat and in the chat it chose to receive notifications in if
+// any. If that chat cannot be reached anymore the notification falls back to
+// the chat used to subscribe. The logs tell what the notification is about,
+// they are stored for the chat once sent, see handleNotificationHistory.
+// Notifications refused because the bot was blocked are counted instead, see
+// cleanupBlockedChats. An error means nothing was delivered, callers log it
+// and leave the notification pending.
+func sendNotification(ctx context.Context, sub Subscriber, text string, logs ...NotificationLog) error {
+	prefs, err := store.Prefs(ctx, sub.ChatID)
+	if err != nil {
+		logf(ctx, "failed to get user prefs, using the default channel: %s", err)
+		prefs = UserPrefs{ChatID: sub.ChatID}
+	}
+	n := chatNotifier(prefs)
+
+	if sub.NotifyChatID != 0 && sub.NotifyChatID != sub.ChatID {
+		err := n.Notify(ctx, sub.NotifyChatID, text)
+		if err == nil {
+			recordNotifications(ctx, sub.ChatID, logs)
+			return nil
+		}
+		logf(ctx, "failed to notify in chat %d, falling back to chat %d: %s", sub.NotifyChatID, sub.ChatID, err)
+	}
+	err = inThread(n, sub.ThreadID).Notify(ctx, sub.ChatID, text)
+	if to, ok := migratedChatID(err); ok {
+		// The group was upgraded to a supergroup, its data follows
+		logf(ctx, "chat %d migrated to %d, moving its data", sub.ChatID, to)
+		if err := migrateChat(ctx, sub.ChatID, to); err != nil {
+			logf(ctx, "failed to migrate chat %d to %d: %s", sub.ChatID, to, err)
+		}
+		sub.ChatID = to
+		err = inThread(n, sub.ThreadID).Notify(ctx, sub.ChatID, text)
+	}
+	if chatBlocked(err) {
+		// Nothing was sent, the chat is cleaned up if it keeps refusing
+		recordRefused(ctx, sub.ChatID, true)
+		return errors.Wrapf(err, "chat %d can't be notified anymore", sub.ChatID)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to notify chat %d", sub.ChatID)
+	}
+	recordRefused(ctx, sub.ChatID, false)
+	recordNotifications(ctx, sub.ChatID, logs)
+	return nil
+}
+
+func handlePause(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	now := time.Now()
+
+	var until time.Time
+	if matches[1] != "" {
+		t, err := time.Parse("2006-01-02", matches[1])
+		if err != nil {
+			sendMsg(ctx, telegram.NewMessage(chatID, "That doesn't look like a date, use the format 2006-01-02."))
+			return
+		}
+		if !t.After(now) {
+			sendMsg(ctx, telegram.NewMessage(chatID, "That date is already in the past."))
+			return
+		}
+		until = t
+	}
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		// Extending a running pause keeps its start, so that nothing that came
+		// out in the meantime is forgotten.
+		if !prefs.notificationsPaused(now) {
+			prefs.PausedAt = now
+		}
+		prefs.NotificationsPaused = true
+		prefs.PausedUntil = until
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "Notifications are paused until you send \"resume notifications\"."
+	if !until.IsZero() {
+		text = fmt.Sprintf("Notifications are paused until %s.", formatReleaseDate(until))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text+" I'll catch you up on everything you missed then."))
+}
+
+func handleResume(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+	now := time.Now()
+
+	paused := true
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		if !prefs.NotificationsPaused {
+			paused = false
+			return errSkipUpdate
+		}
+		prefs.NotificationsPaused = false
+		prefs.PausedUntil = time.Time{}
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	if !paused {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Notifications aren't paused."))
+		return
+	}
+
+	sent, failed, err := deliverBacklog(ctx, chatID, now)
+	if err != nil {
+		// What's left is delivered by the next notify job
+		storeFailed(ctx, chatID, err, "failed to deliver notifications backlog")
+		return
+	}
+	// What failed to be sent is still reported as missed by the next notify
+	// job
+	if failed == 0 {
+		if err := endPause(ctx, chatID, now); err != nil {
+			storeFailed(ctx, chatID, err, "failed to save user prefs")
+			return
+		}
+	}
+
+	text := "Notifications resumed, nothing came due while they were paused."
+	if sent > 0 {
+		text = fmt.Sprintf("Notifications resumed, I sent you the %d you missed.", sent)
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+// endPause forgets the start of the pause of the chat once its backlog is
+// delivered, so that the releases that came out meanwhile aren't reported as
+// missed again. Nothing changes if the chat paused again meanwhile.
+func endPause(ctx context.Context, chatID int64, now time.Time) error {
+	return store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		if prefs.PausedAt.IsZero() || prefs.notificationsPaused(now) {
+			return errSkipUpdate
+		}
+		prefs.NotificationsPaused = false
+		prefs.PausedUntil = time.Time{}
+		prefs.PausedAt = time.Time{}
+		return nil
+	})
+}
+
+// deliverBacklog sends the notifications of the chat that came due while its
+// notifications were paused, like the notify job would. It returns how many
+// were sent, and how many stay pending after failing to be.
+func deliverBacklog(ctx context.Context, chatID int64, now time.Time) (sent, failed int, err error) {
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get subscriptions")
+	}
+	users := map[int64]User{}
+	user, err := store.ChatUser(ctx, chatID)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get user of chat")
+	}
+	if user.UserID != 0 {
+		users[chatID] = user
+	}
+	return deliverNotifications(ctx, subscriptions, chatID, users, false, now)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandleResumeEndsPause(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	prefs := UserPrefs{ChatID: 42, NotificationsPaused: true, PausedAt: now.AddDate(0, 0, -10)}
+	if err := store.PutPrefs(ctx, prefs); err != nil {
+		t.Fatal(err)
+	}
+	release := MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: now.AddDate(0, 0, -2), Subscribers: []Subscriber{{ChatID: 42}}}
+	if err := store.PutRelease(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+
+	handleResume(ctx, testMessage(42, "resume notifications"))
+
+	texts := tg.texts(42)
+	if len(texts) != 2 || !strings.Contains(texts[0], "Dune came out") {
+		t.Fatalf("messages = %q, want the missed release then the confirmation", texts)
+	}
+	if got := s.prefs[42]; got.NotificationsPaused || !got.PausedAt.IsZero() {
+		t.Errorf("prefs = %+v, want the pause ended", got)
+	}
+
+	// A release notified late later on isn't reported as missed during the
+	// pause anymore
+	later := MovieRelease{ID: 2, MovieTitle: "Alien", ReleaseDate: now.Add(-time.Hour)}
+	if _, _, ok := notificationText(later, Subscriber{ChatID: 42}, s.prefs[42], now); ok {
+		t.Error("notificationText() reported a release as missed after the pause ended")
+	}
+}
+
+func TestHandleResumeCoalescesBacklog(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := store.PutPrefs(ctx, UserPrefs{ChatID: 42, NotificationsPaused: true, PausedAt: now.AddDate(0, 0, -10)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, release := range []MovieRelease{
+		{ID: 1, MovieTitle: "Dune", ReleaseDate: now.AddDate(0, 0, -2), Subscribers: []Subscriber{{ChatID: 42}}},
+		// Out in the region of the subscription, not worldwide yet
+		{ID: 2, MovieTitle: "Alien", ReleaseDate: now.AddDate(0, 2, 0), Subscribers: []Subscriber{{ChatID: 42, Region: "DE", RegionDate: now.AddDate(0, 0, -3)}}},
+		{ID: 3, MovieTitle: "Heat", ReleaseDate: now.AddDate(0, 0, -2), Subscribers: []Subscriber{{ChatID: 43}}},
+	} {
+		if err := store.PutRelease(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handleResume(ctx, testMessage(42, "resume notifications"))
+
+	texts := tg.texts(42)
+	if len(texts) != 2 || !strings.Contains(texts[0], "2 updates") || !strings.Contains(texts[0], "Dune") || !strings.Contains(texts[0], "Alien") {
+		t.Fatalf("messages = %q, want the missed releases in one message then the confirmation", texts)
+	}
+	if texts[1] != "Notifications resumed, I sent you the 2 you missed." {
+		t.Errorf("confirmation = %q, want both counted", texts[1])
+	}
+	if s.releases[3].Subscribers[0].Notified || len(tg.texts(43)) != 0 {
+		t.Error("notified another chat along with the backlog")
+	}
+}
+
+func TestNotifyReleasesCoalescesSameDay(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+
+	day := time.Now().AddDate(0, 0, 2)
+	for i, title := range []string{"Dune", "Alien", "Heat"} {
+		release := MovieRelease{ID: int64(i + 1), MovieTitle: title, ReleaseDate: day, Subscribers: []Subscriber{{ChatID: 42}}}
+		if err := store.PutRelease(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifyReleases(ctx)
+
+	texts := tg.texts(42)
+	if len(texts) != 1 {
+		t.Fatalf("sent %d messages, want the 3 releases in one: %q", len(texts), texts)
+	}
+	for _, title := range []string{"3 updates", "Dune", "Alien", "Heat"} {
+		if !strings.Contains(texts[0], title) {
+			t.Errorf("message %q doesn't mention %s", texts[0], title)
+		}
+	}
+	for id, rec := range s.releases {
+		if !rec.Subscribers[0].Notified {
+			t.Errorf("release %d not marked notified", id)
+		}
+	}
+
+	notifyReleases(ctx)
+	if texts := tg.texts(42); len(texts) != 1 {
+		t.Errorf("sent %d messages after a second run, want no new one", len(texts))
+	}
+}
+
+func TestNotifyReleasesFailedSendStaysPending(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+	ctx := context.Background()
+
+	day := time.Now().AddDate(0, 0, 2)
+	for i, title := range []string{"Dune", "Alien"} {
+		release := MovieRelease{ID: int64(i + 1), MovieTitle: title, ReleaseDate: day, Subscribers: []Subscriber{{ChatID: 42}}}
+		if err := store.PutRelease(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tg.fail = func(c telegramCall) string { return "Too Many Requests: retry after 5" }
+
+	notifyReleases(ctx)
+
+	for id, rec := range s.releases {
+		if rec.Subscribers[0].Notified {
+			t.Errorf("release %d marked notified, the message wasn't sent", id)
+		}
+	}
+}
+
+func TestSplitNotifications(t *testing.T) {
+	var group []pendingNotification
+	for i := 0; i < 100; i++ {
+		group = append(group, pendingNotification{releaseID: int64(i), text: fmt.Sprintf("%03d %s", i, strings.Repeat("é", 100))})
+	}
+	long := pendingNotification{releaseID: 100, text: strings.Repeat("x", maxMessageLength+1)}
+
+	split := splitNotifications([][]pendingNotification{group, {long}})
+	if len(split) < 4 {
+		t.Fatalf("split into %d groups, want the long group split in several", len(split))
+	}
+	var ids []int64
+	for _, g := range split[:len(split)-1] {
+		if n := len([]rune(combineNotifications(g))); n > maxMessageLength {
+			t.Errorf("combined message is %d characters long, want at most %d", n, maxMessageLength)
+		}
+		for _, n := range g {
+			ids = append(ids, n.releaseID)
+		}
+	}
+	if len(ids) != 100 {
+		t.Errorf("split groups hold %d notifications, want all 100 in order", len(ids))
+	}
+	for i, id := range ids {
+		if id != int64(i) {
+			t.Fatalf("notification %d is release %d, want the order kept", i, id)
+		}
+	}
+	if last := split[len(split)-1]; len(last) != 1 || last[0].releaseID != 100 {
+		t.Errorf("last group = %+v, want the too long notification alone", last)
+	}
+}
+
+func TestNotifyReleasesLocalizesSentOnly(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	f := useFakeTMDB(t)
+	ctx := context.Background()
+
+	f.route("/movie/10", MovieAPIResult{ID: 10, Title: "Dune: Der Wüstenplanet"})
+	f.route("/movie/20", MovieAPIResult{ID: 20, Title: "Alien - Das unheimliche Wesen"})
+	releases := []MovieRelease{
+		{ID: 10, MovieTitle: "Dune", ReleaseDate: time.Now().AddDate(0, 0, 2), Subscribers: []Subscriber{{ChatID: 1}, {ChatID: 2}}},
+		{ID: 20, MovieTitle: "Alien", ReleaseDate: time.Now().AddDate(0, 2, 0), Subscribers: []Subscriber{{ChatID: 1}, {ChatID: 2}}},
+	}
+	for _, r := range releases {
+		if err := store.PutRelease(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifyReleases(ctx)
+
+	for _, chatID := range []int64{1, 2} {
+		texts := tg.texts(chatID)
+		if len(texts) != 1 || !strings.Contains(texts[0], "Dune: Der Wüstenplanet") {
+			t.Errorf("chat %d got %q, want the reminder with the German title", chatID, texts)
+		}
+	}
+	if n := f.requests("/movie/10"); n != 1 {
+		t.Errorf("localized the sent release %d times, want once for both chats", n)
+	}
+	if n := f.requests("/movie/20"); n != 0 {
+		t.Errorf("localized the release not due %d times, want none", n)
+	}
+	if got := s.releases[10].MovieTitle; got != "Dune" {
+		t.Errorf("stored title = %q, want it kept", got)
+	}
+}
+
+// TestNotifyReleasesConcurrentCommands runs commands while notifications are
+// sent, for `go test -race` to catch state shared without synchronization.
+func TestNotifyReleasesConcurrentCommands(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+
+	day := time.Now().AddDate(0, 0, 2)
+	for i, title := range []string{"Dune", "Alien", "Heat"} {
+		release := MovieRelease{ID: int64(i + 1), MovieTitle: title, ReleaseDate: day, Subscribers: []Subscriber{{ChatID: 42}}}
+		if err := store.PutRelease(ctx, release); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 1, "title": "Dune", "release_date": day.Format("2006-01-02")},
+		},
+	})
+
+	const chats = 10
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		notifyReleases(ctx)
+	}()
+	for i := 0; i < chats; i++ {
+		chatID := int64(201 + i)
+		for _, text := range []string{"subscribe to dune", "releases dune", "list subscriptions", "set region us"} {
+			wg.Add(1)
+			go func(text string) {
+				defer wg.Done()
+				handleUpdate(testMessage(chatID, text))
+			}(text)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handleUpdate(testMessage(42, "list subscriptions"))
+		}()
+	}
+	wg.Wait()
+
+	var notifications int
+	for _, text := range tg.texts(42) {
+		if strings.Contains(text, "3 updates") {
+			notifications++
+		}
+	}
+	if notifications != 1 {
+		t.Errorf("sent %d notifications to chat 42, want 1", notifications)
+	}
+	dune := s.releases[1]
+	for i := 0; i < chats; i++ {
+		if chatID := int64(201 + i); !dune.subscribed(chatID) {
+			t.Errorf("chat %d not subscribed to Dune", chatID)
+		}
+	}
+	for _, sub := range dune.Subscribers {
+		if sub.ChatID == 42 && !sub.Notified {
+			t.Error("chat 42 not marked notified of Dune")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// listOrderSoonest lists the next releases first, unknown dates last.
+	listOrderSoonest = "soonest"
+	// listOrderAdded lists the latest subscriptions first.
+	listOrderAdded = "added"
+	// listOrderAlpha lists subscriptions by title.
+	listOrderAlpha = "alpha"
+)
+
+// listOrder returns how the chat lists its subscriptions.
+func (p UserPrefs) listOrder() string {
+	if p.ListOrder == "" {
+		return listOrderSoonest
+	}
+	return p.ListOrder
+}
+
+// subscribedAt returns when the chat subscribed to the release, zero if
+// unknown.
+func subscribedAt(rec MovieRelease, chatID int64) time.Time {
+	for _, sub := range rec.Subscribers {
+		if sub.ChatID == chatID {
+			return sub.CreatedAt
+		}
+	}
+	return time.Time{}
+}
+
+// sortSubscriptions returns a copy of the subscriptions of the chat sorted in
+// the given order. Ties keep the stored order.
+func sortSubscriptions(subscriptions []MovieRelease, chatID int64, order string) []MovieRelease {
+	sorted := append([]MovieRelease(nil), subscriptions...)
+	var less func(a, b MovieRelease) bool
+	switch order {
+	case listOrderAdded:
+		less = func(a, b MovieRelease) bool {
+			return subscribedAt(a, chatID).After(subscribedAt(b, chatID))
+		}
+	case listOrderAlpha:
+		less = func(a, b MovieRelease) bool {
+			return strings.ToLower(a.MovieTitle) < strings.ToLower(b.MovieTitle)
+		}
+	default:
+		less = func(a, b MovieRelease) bool {
+			if a.ReleaseDate.IsZero() != b.ReleaseDate.IsZero() {
+				return b.ReleaseDate.IsZero()
+			}
+			return a.ReleaseDate.Before(b.ReleaseDate)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
+}
+
+func handleListOrder(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	order := matches[1]
+
+	switch order {
+	case listOrderSoonest, listOrderAdded, listOrderAlpha:
+	default:
+		sendMsg(ctx, telegram.NewMessage(chatID, "I can list your subscriptions by soonest, added or alpha."))
+		return
+	}
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.ListOrder = order
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := map[string]string{
+		listOrderSoonest: "Subscriptions will be listed soonest release first.",
+		listOrderAdded:   "Subscriptions will be listed latest added first.",
+		listOrderAlpha:   "Subscriptions will be listed alphabetically.",
+	}[order]
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
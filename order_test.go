@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSortSubscriptions(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 5, d, 0, 0, 0, 0, time.UTC) }
+	sub := func(added int) []Subscriber {
+		return []Subscriber{{ChatID: 7, CreatedAt: day(added)}, {ChatID: 42, CreatedAt: day(added)}}
+	}
+	subscriptions := []MovieRelease{
+		{ID: 1, MovieTitle: "heat", ReleaseDate: day(20), Subscribers: sub(3)},
+		{ID: 2, MovieTitle: "Alien", Subscribers: sub(1)},
+		{ID: 3, MovieTitle: "Dune", ReleaseDate: day(10), Subscribers: sub(2)},
+		{ID: 4, MovieTitle: "Ran", Subscribers: []Subscriber{{ChatID: 42}}},
+	}
+
+	tests := []struct {
+		order string
+		want  []int64
+	}{
+		{listOrderSoonest, []int64{3, 1, 2, 4}},
+		{listOrderAdded, []int64{1, 3, 2, 4}},
+		{listOrderAlpha, []int64{2, 3, 1, 4}},
+		{"", []int64{3, 1, 2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			sorted := sortSubscriptions(subscriptions, 42, tt.order)
+			var got []int64
+			for _, rec := range sorted {
+				got = append(got, rec.ID)
+			}
+			if !equalIDs(got, tt.want) {
+				t.Errorf("sortSubscriptions(%q) = %v, want %v", tt.order, got, tt.want)
+			}
+		})
+	}
+	if subscriptions[0].ID != 1 {
+		t.Error("sortSubscriptions() sorted the subscriptions in place")
+	}
+}
+
+func TestHandleListOrder(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+
+	if got := s.prefs[42].listOrder(); got != listOrderSoonest {
+		t.Errorf("default order = %q, want soonest", got)
+	}
+	handleListOrder(ctx, testMessage(42, "set list order random"), []string{"", "random"})
+	if got := s.prefs[42].ListOrder; got != "" {
+		t.Errorf("saved order %q, want unknown orders rejected", got)
+	}
+	handleListOrder(ctx, testMessage(42, "set list order alpha"), []string{"", "alpha"})
+	if got := s.prefs[42].listOrder(); got != listOrderAlpha {
+		t.Errorf("order = %q, want alpha", got)
+	}
+	if texts := tg.texts(42); len(texts) != 2 {
+		t.Errorf("sent %q, want a reply to each command", texts)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const callbackDeleteData = "deletedata"
+
+// dataExport is the document sent by the "my data" command.
+type dataExport struct {
+	ChatID        int64                `json:"chat_id"`
+	ExportedAt    time.Time            `json:"exported_at"`
+	Preferences   UserPrefs            `json:"preferences"`
+	Subscriptions []subscriptionExport `json:"subscriptions"`
+	Seasons       []seasonExport       `json:"seasons"`
+	Searches      []SearchedMovie      `json:"searches"`
+	LinkedChats   []int64              `json:"linked_chats,omitempty"`
+	Notifications []NotificationLog    `json:"notifications"`
+	Following     []FollowedPerson     `json:"following"`
+}
+
+type subscriptionExport struct {
+	MovieID     int64      `json:"movie_id"`
+	Title       string     `json:"title"`
+	ReleaseDate time.Time  `json:"release_date"`
+	Subscriber  Subscriber `json:"subscriber"`
+}
+
+type seasonExport struct {
+	ShowID     int64      `json:"show_id"`
+	ShowName   string     `json:"show_name"`
+	Season     int        `json:"season"`
+	Subscriber Subscriber `json:"subscriber"`
+}
+
+func handleMyData(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+	seasons, err := chatSeasons(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get seasons")
+		return
+	}
+
+	searches, err := chatSearches(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get searches")
+		return
+	}
+
+	user, err := store.ChatUser(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user")
+		return
+	}
+	notifications, err := chatNotificationLogs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get notification logs")
+		return
+	}
+	following, err := store.FollowedPeople(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get followed people")
+		return
+	}
+
+	// Even encrypted, the TMDB key has no place in an export
+	prefs.TMDBKey = nil
+	export := dataExport{
+		ChatID:        chatID,
+		ExportedAt:    time.Now().UTC(),
+		Preferences:   prefs,
+		Searches:      searches,
+		LinkedChats:   user.ChatIDs,
+		Notifications: notifications,
+		Following:     following,
+	}
+	for _, rec := range subscriptions {
+		for _, sub := range rec.Subscribers {
+			if sub.ChatID != chatID {
+				continue
+			}
+			export.Subscriptions = append(export.Subscriptions, subscriptionExport{
+				MovieID:     rec.ID,
+				Title:       rec.MovieTitle,
+				ReleaseDate: rec.ReleaseDate,
+				Subscriber:  sub,
+			})
+		}
+	}
+
+	for _, rec := range seasons {
+		for _, sub := range rec.Subscribers {
+			if sub.ChatID != chatID {
+				continue
+			}
+			export.Seasons = append(export.Seasons, seasonExport{
+				ShowID:     rec.ShowID,
+				ShowName:   rec.ShowName,
+				Season:     rec.Season,
+				Subscriber: sub,
+			})
+		}
+	}
+
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		fatalf(ctx, "failed to encode data export: %s", err)
+	}
+
+	doc := telegram.NewDocumentUpload(chatID, telegram.FileBytes{Name: "my-data.json", Bytes: b})
+	doc.Caption = "Here is everything I know about this chat 🗂"
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.Send(doc); err != nil {
+		fatalf(ctx, "failed to send data export: %s", err)
+	}
+}
+
+// handleDeleteMyData asks to confirm deleting the data of the chat, only
+// admins can delete that of a group.
+func handleDeleteMyData(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+	if update.Message.From == nil {
+		return
+	}
+	if !requireGroupAdmin(ctx, update.Message, "delete the data of this chat") {
+		return
+	}
+
+	// Only the user who asked can confirm
+	data := fmt.Sprintf("%s:%d", callbackDeleteData, update.Message.From.ID)
+	msgConfig := telegram.NewMessage(chatID, "This deletes all your subscriptions and settings, it cannot be undone. Are you sure?")
+	msgConfig.ReplyMarkup = telegram.NewInlineKeyboardMarkup(
+		telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("Yes, delete everything", data)),
+	)
+	sendMsg(ctx, msgConfig)
+}
+
+// handleDeleteDataCallback deletes the data of the chat once the user
+// confirmed. It returns the callback answer.
+func handleDeleteDataCallback(ctx context.Context, query *telegram.CallbackQuery, arg string) string {
+	chatID := query.Message.Chat.ID
+
+	userID, err := strconv.Atoi(arg)
+	if err != nil || query.From == nil || query.From.ID != userID {
+		return "Only the person who asked can confirm."
+	}
+	// The asker may have lost their admin rights since
+	if !query.Message.Chat.IsPrivate() {
+		admin, err := isChatAdmin(chatID, query.From.ID)
+		if err != nil {
+			logf(ctx, "failed to get chat membership: %s", err)
+			return "I couldn't check whether you are an admin of this group, try again later."
+		}
+		if !admin {
+			return "Only group admins can delete the data of this chat."
+		}
+	}
+
+	if err := deleteChatData(ctx, chatID); err != nil {
+		return callbackStoreFailed(ctx, err, "failed to delete chat data")
+	}
+
+	edit := telegram.NewEditMessageText(chatID, query.Message.MessageID, "All your data has been deleted. 👋")
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.Send(edit); err != nil {
+		logf(ctx, "failed to edit confirmation message: %s", err)
+	}
+	return "Deleted"
+}
+
+// deleteChatData removes every entity storing data about the chat. Each
+// subscription is removed in its own transaction, running it again after a
+// failure finishes the job.
+func deleteChatData(ctx context.Context, chatID int64) error {
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get subscriptions")
+	}
+	for _, rec := range subscriptions {
+		if err := unsubscribeChat(ctx, chatID, rec.ID); err != nil {
+			return errors.Wrap(err, "failed to delete subscription")
+		}
+	}
+
+	seasons, err := chatSeasons(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get seasons")
+	}
+	for _, rec := range seasons {
+		if err := untrackSeason(ctx, chatID, rec); err != nil {
+			return errors.Wrap(err, "failed to delete season subscription")
+		}
+	}
+
+	searches, err := chatSearches(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get searches")
+	}
+	if err := store.DeleteSearches(ctx, searches); err != nil {
+		return errors.Wrap(err, "failed to delete searches")
+	}
+
+	link, err := store.CalendarLink(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get calendar link")
+	}
+	if calendar != nil && len(link.Token) > 0 {
+		if err := revokeCalendar(ctx, link); err != nil {
+			logf(ctx, "failed to revoke calendar token: chat_id=%d: %s", chatID, err)
+		}
+	}
+	if err := store.DeleteCalendarLink(ctx, chatID); err != nil {
+		return errors.Wrap(err, "failed to delete calendar link")
+	}
+
+	following, err := store.FollowedPeople(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get followed people")
+	}
+	for _, p := range following {
+		if err := store.DeleteFollowedPerson(ctx, chatID, p.PersonID); err != nil {
+			return errors.Wrap(err, "failed to delete followed person")
+		}
+	}
+
+	if err := unlinkChatUser(ctx, chatID); err != nil {
+		return err
+	}
+
+	if err := store.DeleteChatTransfers(ctx, chatID); err != nil {
+		return errors.Wrap(err, "failed to delete transfers")
+	}
+
+	if _, err := store.DeleteNotificationLogs(ctx, chatID, time.Now()); err != nil {
+		return errors.Wrap(err, "failed to delete notification logs")
+	}
+
+	if err := store.DeletePrefs(ctx, chatID); err != nil {
+		return errors.Wrap(err, "failed to delete user prefs")
+	}
+
+	logf(ctx, "deleted data of chat %d", chatID)
+	return nil
+}
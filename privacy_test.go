@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestHandleDeleteMyDataGroup(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	update := testMessage(7, "delete my data")
+	update.Message.Chat = &telegram.Chat{ID: -100, Type: "group"}
+
+	tg.results["getChatMember"] = `{"user":{"id":7},"status":"member"}`
+	handleUpdate(update)
+	if texts := tg.texts(-100); len(texts) != 1 || texts[0] != "Only group admins can delete the data of this chat." {
+		t.Errorf("sent %q, want the member refused", texts)
+	}
+
+	// The confirmation of an admin who lost their rights since is refused
+	query := &telegram.CallbackQuery{From: &telegram.User{ID: 7}, Message: &telegram.Message{MessageID: 2, Chat: update.Message.Chat}}
+	if answer := handleDeleteDataCallback(context.Background(), query, "7"); answer != "Only group admins can delete the data of this chat." {
+		t.Errorf("answer = %q, want the member refused", answer)
+	}
+}
+
+func TestDeleteChatData(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(transferTTL)
+	for code, transfer := range map[string]Transfer{
+		"from": {FromChatID: 42, ToChatID: 43, ExpiresAt: expiresAt},
+		"to":   {FromChatID: 44, ToChatID: 42, ExpiresAt: expiresAt},
+		"else": {FromChatID: 44, ToChatID: 43, ExpiresAt: expiresAt},
+	} {
+		s.transfers[code] = transfer
+	}
+	if err := store.PutPrefs(ctx, UserPrefs{ChatID: 42, Posters: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteChatData(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.prefs[42]; ok {
+		t.Error("prefs of the chat kept")
+	}
+	if _, ok := s.transfers["else"]; len(s.transfers) != 1 || !ok {
+		t.Errorf("transfers = %+v, want only the one of other chats kept", s.transfers)
+	}
+}
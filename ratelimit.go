@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// tmdbLowQuota is the number of remaining requests under which cached
+	// responses are preferred and outgoing requests are paced.
+	tmdbLowQuota = 5
+	// tmdbCacheMaxStale is how old a cached response can be when served
+	// under low quota.
+	tmdbCacheMaxStale = time.Hour
+	// tmdbCacheSize bounds the number of cached responses.
+	tmdbCacheSize = 500
+)
+
+var (
+	tmdbQuotaRemaining = expvar.NewInt("tmdb_rate_limit_remaining")
+	tmdbQuotaWaits     = expvar.NewInt("tmdb_rate_limit_waits")
+	tmdbCacheHits      = expvar.NewInt("tmdb_cache_hits")
+)
+
+// tmdbQuota tracks the TMDB rate limit from the headers of the latest
+// response.
+type tmdbQuota struct {
+	mu        sync.Mutex
+	known     bool
+	remaining int
+	reset     time.Time
+}
+
+// update records the rate limit headers of a response. A 429 response
+// exhausts the quota until its Retry-After delay.
+func (q *tmdbQuota) update(res *http.Response, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After"))
+		if err != nil || retryAfter < 1 {
+			retryAfter = 1
+		}
+		q.known, q.remaining, q.reset = true, 0, now.Add(time.Duration(retryAfter)*time.Second)
+		tmdbQuotaRemaining.Set(0)
+		return
+	}
+
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	q.known, q.remaining, q.reset = true, remaining, time.Unix(reset, 0)
+	tmdbQuotaRemaining.Set(int64(remaining))
+}
+
+// low returns whether few requests are left before the quota resets.
+func (q *tmdbQuota) low(now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.known && now.Before(q.reset) && q.remaining < tmdbLowQuota
+}
+
+// delay returns how long to wait before sending the next request: the
+// remaining time until the reset spread over the remaining requests under
+// low quota, the whole time until the reset once exhausted.
+func (q *tmdbQuota) delay(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.known || !now.Before(q.reset) || q.remaining >= tmdbLowQuota {
+		return 0
+	}
+	untilReset := q.reset.Sub(now)
+	if q.remaining <= 0 {
+		return untilReset
+	}
+	return untilReset / time.Duration(q.remaining+1)
+}
+
+// wait paces the caller according to the quota, see delay.
+func (q *tmdbQuota) wait(ctx context.Context) error {
+	d := q.delay(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	tmdbQuotaWaits.Add(1)
+	logf(ctx, "tmdb quota low, waiting %s", d)
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tmdbCache keeps recent TMDB responses in memory, keyed by request URL, API
+// key included.
+type tmdbCache struct {
+	mu      sync.Mutex
+	entries map[string]tmdbCacheEntry
+}
+
+type tmdbCacheEntry struct {
+	body    []byte
+	fetched time.Time
+}
+
+func newTMDBCache() *tmdbCache {
+	return &tmdbCache{entries: map[string]tmdbCacheEntry{}}
+}
+
+// get returns the cached response for the key if it is younger than maxAge.
+// The body is shared with concurrent readers and must not be modified.
+func (c *tmdbCache) get(key string, maxAge time.Duration, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || now.Sub(e.fetched) > maxAge {
+		return nil, false
+	}
+	tmdbCacheHits.Add(1)
+	return e.body, true
+}
+
+func (c *tmdbCache) put(key string, body []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= tmdbCacheSize {
+		// Drop expired entries, then anything if still full
+		for k, e := range c.entries {
+			if now.Sub(e.fetched) > tmdbCacheMaxStale {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < tmdbCacheSize {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = tmdbCacheEntry{body: body, fetched: now}
+}
+
+// drop forgets the cached response for the key, if any.
+func (c *tmdbCache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
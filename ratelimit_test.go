@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTMDBQuota(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	tests := []struct {
+		name      string
+		status    int
+		header    map[string]string
+		wantLow   bool
+		wantDelay time.Duration
+	}{
+		{"no headers", http.StatusOK, nil, false, 0},
+		{"plenty left", http.StatusOK, map[string]string{"X-RateLimit-Remaining": "30", "X-RateLimit-Reset": "1000010"}, false, 0},
+		{"few left", http.StatusOK, map[string]string{"X-RateLimit-Remaining": "4", "X-RateLimit-Reset": "1000010"}, true, 2 * time.Second},
+		{"exhausted", http.StatusOK, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1000010"}, true, 10 * time.Second},
+		{"reset passed", http.StatusOK, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "999990"}, false, 0},
+		{"too many requests", http.StatusTooManyRequests, map[string]string{"Retry-After": "3"}, true, 3 * time.Second},
+		{"too many requests without delay", http.StatusTooManyRequests, nil, true, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for k, v := range tt.header {
+				res.Header.Set(k, v)
+			}
+			q := &tmdbQuota{}
+			q.update(res, now)
+			if got := q.low(now); got != tt.wantLow {
+				t.Errorf("low() = %v, want %v", got, tt.wantLow)
+			}
+			if got := q.delay(now); got != tt.wantDelay {
+				t.Errorf("delay() = %s, want %s", got, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestTMDBCacheUnderLowQuota(t *testing.T) {
+	f := useFakeTMDB(t)
+	ctx := context.Background()
+	f.route("/movie/1", MovieAPIResult{ID: 1, Title: "Dune"})
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	f.setHeader("X-RateLimit-Reset", reset)
+	f.setHeader("X-RateLimit-Remaining", "30")
+
+	get := func(ctx context.Context) {
+		t.Helper()
+		var m MovieAPIResult
+		if err := tmdb.get(ctx, "/movie/1", nil, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Title != "Dune" {
+			t.Fatalf("title = %q, want Dune", m.Title)
+		}
+	}
+
+	// Fresh responses while the quota is fine
+	get(ctx)
+	get(ctx)
+	if n := f.requests("/movie/1"); n != 2 {
+		t.Fatalf("sent %d requests, want the cache bypassed", n)
+	}
+
+	f.setHeader("X-RateLimit-Remaining", "2")
+	get(ctx)
+	get(ctx)
+	if n := f.requests("/movie/1"); n != 3 {
+		t.Errorf("sent %d requests, want the cached response served under low quota", n)
+	}
+
+	// A chat key has its own quota and never gets the shared responses
+	get(context.WithValue(ctx, tmdbKeyKey, "chat-key"))
+	if n := f.requests("/movie/1"); n != 4 {
+		t.Errorf("sent %d requests, want the chat key request sent", n)
+	}
+	if got := f.keys[len(f.keys)-1]; got != "chat-key" {
+		t.Errorf("last request used key %q, want the chat key", got)
+	}
+}
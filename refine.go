@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// refineWindow is how long after a search another search of the same title
+// edits its results message instead of sending a new one.
+const refineWindow = 2 * time.Minute
+
+type searchMessage struct {
+	title     string
+	messageID int
+	sent      time.Time
+}
+
+// searchMessages remembers the latest results message of each chat, so that
+// refining a search, e.g. with a year, updates it in place.
+type searchMessages struct {
+	mu     sync.Mutex
+	latest map[int64]searchMessage
+}
+
+var resultMessages = &searchMessages{latest: map[int64]searchMessage{}}
+
+// refined returns the results message to edit if the search of the title
+// refines the latest search of the chat.
+func (s *searchMessages) refined(chatID int64, title string, now time.Time) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.latest[chatID]
+	if !ok || now.Sub(m.sent) > refineWindow || m.title != normalizeTitle(title) {
+		return 0, false
+	}
+	return m.messageID, true
+}
+
+func (s *searchMessages) record(chatID int64, title string, messageID int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, m := range s.latest {
+		if now.Sub(m.sent) > refineWindow {
+			delete(s.latest, id)
+		}
+	}
+	s.latest[chatID] = searchMessage{title: normalizeTitle(title), messageID: messageID, sent: now}
+}
+
+// forget stops refining the latest search of the chat, once the chat moved
+// on to another command.
+func (s *searchMessages) forget(chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.latest, chatID)
+}
+
+// editResults replaces the text and buttons of a results message.
+func editResults(ctx context.Context, chatID int64, messageID int, text string, keyboard *telegram.InlineKeyboardMarkup) error {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	edit := telegram.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = keyboard
+	_, err := bot.Send(edit)
+	return errors.Wrapf(err, "failed to edit message %d in chat %d", messageID, chatID)
+}
+
+// sendRefinable sends the results message of the search of the title, or
+// edits the previous one when the search refines it. A new message is sent
+// if the edit fails, e.g. because the previous message was deleted.
+func sendRefinable(ctx context.Context, chatID int64, title, text string, keyboard *telegram.InlineKeyboardMarkup) {
+	now := time.Now()
+	if messageID, ok := resultMessages.refined(chatID, title, now); ok {
+		err := editResults(ctx, chatID, messageID, text, keyboard)
+		if err == nil {
+			resultMessages.record(chatID, title, messageID, now)
+			return
+		}
+		logf(ctx, "failed to edit previous results, sending new ones: %s", err)
+	}
+
+	msgConfig := telegram.NewMessage(chatID, text)
+	if keyboard != nil {
+		msgConfig.ReplyMarkup = *keyboard
+	}
+	sent, err := trySendMsg(ctx, msgConfig)
+	if err != nil {
+		logf(ctx, "failed to send results: %s", err)
+		return
+	}
+	resultMessages.record(chatID, title, sent.MessageID, now)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// handleTaskRefresh re-fetches every tracked movie from TMDB to keep the stored
+// title and release date up to date, and notifies subscribers about newly
+// published trailers. Countdown messages are updated daily. Tracked TV show
+// seasons are refreshed as well, chats opted in to surprise notifications are
+// told about the movies they searched for getting a release date, connected
+// Google Calendars are synced, chats are told about the new movies of the
+// people they follow, and the records of movies released long ago, old
+// notification logs, expired transfers and the data of chats that blocked the
+// bot are cleaned up, see cleanupReleases and cleanupBlockedChats.
+// Only one instance runs the job at a time, see runAsLeader.
+func handleTaskRefresh(w http.ResponseWriter, r *http.Request) {
+	ctx := withRequestID(r.Context(), newRequestID())
+	runAsLeader(ctx, "refresh", func(ctx context.Context) {
+		refreshReleases(ctx)
+		refreshSeasons(ctx)
+		refreshSearches(ctx)
+		refreshCalendars(ctx)
+		refreshFollowedPeople(ctx)
+		cleanupReleases(ctx)
+		cleanupNotificationLogs(ctx)
+		cleanupTransfers(ctx)
+		cleanupBlockedChats(ctx)
+	})
+}
+
+// refreshReleases re-fetches every tracked movie, see handleTaskRefresh.
+func refreshReleases(ctx context.Context) {
+	records, err := store.Releases(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all subscriptions")
+		return
+	}
+
+	prefs := map[int64]UserPrefs{}
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping refresh job: %s", ctx.Err())
+			return
+		}
+		if len(record.Subscribers) == 0 {
+			continue
+		}
+
+		refresh, err := refreshRelease(ctx, record, prefs)
+		if err != nil {
+			jobStoreFailed(ctx, err, fmt.Sprintf("failed to refresh movie release: id=%d", record.ID))
+			return
+		}
+		if refresh.tmdbErr != nil {
+			logf(ctx, "failed to refresh movie release: id=%d: %s", record.ID, refresh.tmdbErr)
+		}
+	}
+}
+
+// releaseRefresh is the outcome of refreshRelease.
+type releaseRefresh struct {
+	// changes are the changes made to the stored release, see applyDetails.
+	changes []ReleaseChange
+	// remapped is whether TMDB didn't know the movie anymore, see
+	// remapRelease.
+	remapped bool
+	// tmdbErr is the error met fetching the movie from TMDB, the release is
+	// then left as is until the next refresh.
+	tmdbErr error
+}
+
+// refreshRelease re-fetches the movie of the record from TMDB and updates the
+// stored release: its details, the regional release dates of its
+// subscribers, and their trailers, streaming providers and countdowns. A
+// movie TMDB doesn't know anymore is remapped. prefs caches the preferences
+// of the chats across calls. Only store errors are returned.
+func refreshRelease(ctx context.Context, record MovieRelease, prefs map[int64]UserPrefs) (releaseRefresh, error) {
+	var appendToResponse []string
+	if featureEnabled(featureTrailers) {
+		appendToResponse = append(appendToResponse, "videos")
+	}
+	if featureEnabled(featureStreaming) {
+		appendToResponse = append(appendToResponse, "watch/providers")
+	}
+
+	details, err := movieDetails(ctx, record.ID, appendToResponse...)
+	if errors.Cause(err) == errTMDBNotFound {
+		if err := remapRelease(ctx, record); err != nil {
+			return releaseRefresh{}, errors.Wrap(err, "failed to remap movie release")
+		}
+		return releaseRefresh{remapped: true}, nil
+	}
+	if err != nil {
+		return releaseRefresh{tmdbErr: err}, nil
+	}
+
+	now := time.Now()
+	record.MissingSince = time.Time{}
+	applyDetails(&record, details, now)
+	dates := regionDates(ctx, record)
+	applyRegionDates(&record, dates)
+
+	refreshed := map[int64]Subscriber{}
+	for _, sub := range record.Subscribers {
+		p, ok := prefs[sub.ChatID]
+		if !ok {
+			p, err = store.Prefs(ctx, sub.ChatID)
+			if err != nil {
+				return releaseRefresh{}, errors.Wrap(err, "failed to get user prefs")
+			}
+			prefs[sub.ChatID] = p
+		}
+		after := sub
+		if featureEnabled(featureTrailers) && (sub.Trailers || p.Trailers) {
+			after = notifyNewTrailers(ctx, record, after, details.OfficialTrailers())
+		}
+		if featureEnabled(featureStreaming) && p.LeavingStreaming {
+			after = notifyLeavingStreaming(ctx, record, after, p.region(), details.StreamingProviders(p.region()))
+		}
+		refreshed[sub.ChatID] = updateCountdown(ctx, record, after, p, now)
+	}
+
+	// The calls above are slow, the changes are made to the release as
+	// stored now so that the commands served meanwhile aren't undone
+	var refresh releaseRefresh
+	before := record
+	err = store.UpdateRelease(ctx, record.ID, func(tx *MovieRelease) error {
+		if tx.ID == 0 {
+			return errSkipUpdate
+		}
+		tx.MissingSince = time.Time{}
+		refresh.changes = applyDetails(tx, details, now)
+		applyRegionDates(tx, dates)
+		for i, sub := range tx.Subscribers {
+			after, ok := refreshed[sub.ChatID]
+			if !ok {
+				continue
+			}
+			for _, b := range before.Subscribers {
+				if b.ChatID == sub.ChatID {
+					tx.Subscribers[i] = mergeRefreshed(sub, b, after)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return releaseRefresh{}, errors.Wrap(err, "failed to update movie release")
+	}
+	return refresh, nil
+}
+
+// mergeRefreshed applies to sub, the subscriber as stored, the changes made
+// by the refresh job from before to after: the trailers seen, the streaming
+// providers listed and the countdown ended on release day. Changes made to sub
+// meanwhile are kept.
+func mergeRefreshed(sub, before, after Subscriber) Subscriber {
+	sub.TrailersSeeded = sub.TrailersSeeded || after.TrailersSeeded
+	seen := map[string]bool{}
+	for _, key := range sub.SeenTrailers {
+		seen[key] = true
+	}
+	for _, key := range after.SeenTrailers {
+		if !seen[key] {
+			sub.SeenTrailers = append(sub.SeenTrailers, key)
+		}
+	}
+
+	sub.StreamingRegion = after.StreamingRegion
+	sub.StreamingProviders = after.StreamingProviders
+
+	// Unless the countdown was stopped or restarted meanwhile
+	if sub.CountdownMessageID == before.CountdownMessageID {
+		sub.CountdownMessageID = after.CountdownMessageID
+		sub.CountdownPinned = after.CountdownPinned
+	}
+	return sub
+}
+
+// refreshDiff is the response of /admin/refresh.
+type refreshDiff struct {
+	MovieID int64 `json:"movie_id"`
+	// Remapped is true when TMDB didn't know the movie anymore, see
+	// remapRelease.
+	Remapped bool            `json:"remapped"`
+	Changes  []refreshChange `json:"changes"`
+}
+
+type refreshChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// handleAdminRefresh refreshes a single stored movie as the refresh job
+// does, see refreshRelease, for debugging. It responds with the changes made.
+func handleAdminRefresh(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+	ctx := withRequestID(r.Context(), newRequestID())
+
+	id, err := strconv.ParseInt(r.FormValue("movieID"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid movieID", http.StatusBadRequest)
+		return
+	}
+
+	record, err := store.Release(ctx, id)
+	if err != nil {
+		logf(ctx, "failed to get movie release: id=%d: %s", id, err)
+		http.Error(w, "failed to get movie release: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if record.ID == 0 {
+		http.Error(w, fmt.Sprintf("movie %d isn't stored", id), http.StatusNotFound)
+		return
+	}
+
+	refresh, err := refreshRelease(ctx, record, map[int64]UserPrefs{})
+	if err != nil {
+		logf(ctx, "failed to refresh movie release: id=%d: %s", id, err)
+		http.Error(w, "failed to refresh movie release: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if refresh.tmdbErr != nil {
+		logf(ctx, "failed to refresh movie release: id=%d: %s", id, refresh.tmdbErr)
+		http.Error(w, fmt.Sprintf("failed to get movie %d from TMDB: %s", id, refresh.tmdbErr), http.StatusBadGateway)
+		return
+	}
+
+	diff := refreshDiff{MovieID: id, Remapped: refresh.remapped, Changes: []refreshChange{}}
+	for _, c := range refresh.changes {
+		diff.Changes = append(diff.Changes, refreshChange{Field: c.Field, From: c.From, To: c.To})
+	}
+	logf(ctx, "refreshed movie release: id=%d changes=%d remapped=%t", id, len(refresh.changes), refresh.remapped)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// applyDetails updates the record with the fresh details fetched from TMDB and
+// records the changes in its history. It returns the changes made.
+func applyDetails(record *MovieRelease, details MovieDetails, now time.Time) []ReleaseChange {
+	var changes []ReleaseChange
+
+	if details.Title != "" && details.Title != record.MovieTitle {
+		changes = append(changes, ReleaseChange{
+			Field:     "title",
+			From:      record.MovieTitle,
+			To:        details.Title,
+			ChangedAt: now,
+		})
+		record.MovieTitle = details.Title
+	}
+	if !details.ReleaseTime.IsZero() && !details.ReleaseTime.Equal(record.ReleaseDate) {
+		changes = append(changes, ReleaseChange{
+			Field:     "date",
+			From:      formatReleaseDate(record.ReleaseDate),
+			To:        formatReleaseDate(details.ReleaseTime),
+			ChangedAt: now,
+		})
+		record.ReleaseDate = details.ReleaseTime
+	}
+	if details.Status != "" && details.Status != record.Status {
+		if record.Status != "" {
+			changes = append(changes, ReleaseChange{
+				Field:     "status",
+				From:      record.Status,
+				To:        details.Status,
+				ChangedAt: now,
+			})
+		}
+		record.Status = details.Status
+	}
+	if details.Runtime > 0 {
+		record.Runtime = details.Runtime
+	}
+	if details.PosterPath != "" {
+		record.PosterPath = details.PosterPath
+	}
+
+	record.addHistory(changes...)
+	return changes
+}
+
+// notifyNewTrailers sends the trailers the subscriber hasn't seen yet and
+// returns the subscriber with its seen trailers updated. The trailers already
+// published when the subscriber opted in are recorded without notification.
+func notifyNewTrailers(ctx context.Context, record MovieRelease, sub Subscriber, trailers []Video) Subscriber {
+	seen := map[string]bool{}
+	for _, key := range sub.SeenTrailers {
+		seen[key] = true
+	}
+
+	for _, t := range trailers {
+		if seen[t.Key] {
+			continue
+		}
+		if sub.TrailersSeeded {
+			text := fmt.Sprintf("New trailer for %s! 🎬\n%s", record.MovieTitle, t.URL())
+			if err := sendNotification(ctx, sub, text, notificationLog(notificationTrailer, record.ID, record.MovieTitle)); err != nil {
+				// Left unseen, the next refresh sends it again
+				logf(ctx, "failed to send trailer notification: id=%d: %s", record.ID, err)
+				continue
+			}
+		}
+		sub.SeenTrailers = append(sub.SeenTrailers, t.Key)
+	}
+	sub.TrailersSeeded = true
+
+	return sub
+}
+
+func handleTrailers(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+	title := strings.TrimSpace(matches[2])
+
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+
+	// Global preference
+	if title == "" {
+		err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+			prefs.Trailers = enabled
+			return nil
+		})
+		if err != nil {
+			storeFailed(ctx, chatID, err, "failed to save user prefs")
+			return
+		}
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Trailer notifications are %s for all your subscriptions.", state)))
+		return
+	}
+
+	// Preference for a single subscription
+	action := "trailersoff"
+	if enabled {
+		action = "trailerson"
+	}
+	actOnSubscription(ctx, chatID, title, action)
+}
+
+func trailersAction(ctx context.Context, chatID int64, rec MovieRelease, enabled bool) {
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	err := updateSubscriber(ctx, rec.ID, chatID, func(sub *Subscriber) {
+		sub.Trailers = enabled
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to update subscription")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Trailer notifications are %s for %s.", state, rec.MovieTitle)))
+}
+
+func handleHistory(ctx context.Context, update telegram.Update, matches []string) {
+	actOnSubscription(ctx, update.Message.Chat.ID, strings.TrimSpace(matches[1]), "history")
+}
+
+func historyAction(ctx context.Context, chatID int64, rec MovieRelease) {
+	if len(rec.History) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Nothing changed for "+rec.MovieTitle+" since you subscribed."))
+		return
+	}
+	text := "Changes to " + rec.MovieTitle + ":\n"
+	for _, c := range rec.History {
+		text += fmt.Sprintf("- %s moved from %s to %s on %s\n", c.Field, c.From, c.To, c.ChangedAt.Format("2 Jan 2006"))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefreshReleasesKeepsConcurrentChanges(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+
+	release := MovieRelease{
+		ID:          1,
+		MovieTitle:  "Dune",
+		ReleaseDate: time.Now().AddDate(0, 1, 0),
+		Subscribers: []Subscriber{{ChatID: 42, Trailers: true, TrailersSeeded: true}},
+	}
+	if err := store.PutRelease(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+	api.route("/movie/1", map[string]interface{}{
+		"id":           1,
+		"title":        "Dune: Part One",
+		"release_date": release.ReleaseDate.Format("2006-01-02"),
+		"videos": map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"key": "n9xhJrPXop4", "site": "YouTube", "type": "Trailer", "official": true},
+			},
+		},
+	})
+
+	// Another chat subscribes while the trailer notification is sent
+	tg.fail = func(call telegramCall) string {
+		if call.Method == "sendMessage" {
+			err := store.UpdateRelease(ctx, 1, func(tx *MovieRelease) error {
+				tx.Subscribers = append(tx.Subscribers, Subscriber{ChatID: 7})
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		return ""
+	}
+
+	refreshReleases(ctx)
+
+	if texts := tg.texts(42); len(texts) != 1 {
+		t.Fatalf("sent %d trailer notifications, want 1: %q", len(texts), texts)
+	}
+	got := s.releases[1]
+	if got.MovieTitle != "Dune: Part One" {
+		t.Errorf("title = %q, want the refreshed one", got.MovieTitle)
+	}
+	if len(got.Subscribers) != 2 {
+		t.Fatalf("subscribers = %+v, want the one added during the refresh kept", got.Subscribers)
+	}
+	if seen := got.Subscribers[0].SeenTrailers; len(seen) != 1 || seen[0] != "n9xhJrPXop4" {
+		t.Errorf("seen trailers = %q, want the notified trailer", seen)
+	}
+}
+
+func TestMergeRefreshedCountdown(t *testing.T) {
+	before := Subscriber{ChatID: 42, CountdownMessageID: 10, CountdownPinned: true}
+	ended := Subscriber{ChatID: 42}
+
+	if got := mergeRefreshed(before, before, ended); got.CountdownMessageID != 0 || got.CountdownPinned {
+		t.Errorf("countdown = %d, %t, want it ended", got.CountdownMessageID, got.CountdownPinned)
+	}
+
+	// Restarted by the chat while the refresh ended the previous one
+	restarted := Subscriber{ChatID: 42, CountdownMessageID: 11}
+	if got := mergeRefreshed(restarted, before, ended); got.CountdownMessageID != 11 {
+		t.Errorf("countdown message = %d, want the restarted one kept", got.CountdownMessageID)
+	}
+}
+
+func TestHandleAdminRefresh(t *testing.T) {
+	s := useMemStore(t)
+	useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+	previous := adminToken
+	adminToken = "secret"
+	t.Cleanup(func() { adminToken = previous })
+
+	release := time.Date(2031, 9, 15, 0, 0, 0, 0, time.UTC)
+	deRelease := time.Date(2031, 10, 21, 0, 0, 0, 0, time.UTC)
+	err := store.PutRelease(ctx, MovieRelease{
+		ID:          1,
+		MovieTitle:  "Dune",
+		ReleaseDate: release,
+		Subscribers: []Subscriber{{ChatID: 42, Region: "DE", DatePolicy: datePolicyRegion}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.route("/movie/1", map[string]interface{}{"id": 1, "title": "Dune: Part One", "release_date": "2031-09-15"})
+	api.route("/movie/1/release_dates", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"iso_3166_1": "DE", "release_dates": []map[string]interface{}{{"release_date": deRelease.Format(time.RFC3339)}}},
+		},
+	})
+
+	refresh := func(movieID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/refresh?movieID="+movieID, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handleAdminRefresh(w, req)
+		return w
+	}
+
+	if w := refresh("5"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown movie = %d %q, want 404", w.Code, w.Body.String())
+	}
+	if n := api.requests("/movie/5"); n != 0 {
+		t.Errorf("requested an unknown movie %d times from TMDB, want none", n)
+	}
+
+	w := refresh("1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh = %d %q, want 200", w.Code, w.Body.String())
+	}
+	var diff refreshDiff
+	if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].To != "Dune: Part One" || diff.Remapped {
+		t.Errorf("diff = %+v, want the title change", diff)
+	}
+	got := s.releases[1]
+	if got.MovieTitle != "Dune: Part One" {
+		t.Errorf("title = %q, want the refreshed one", got.MovieTitle)
+	}
+	if date := got.Subscribers[0].RegionDate; !date.Equal(deRelease) {
+		t.Errorf("regional date = %s, want the one in DE %s", date, deRelease)
+	}
+}
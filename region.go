@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// defaultRegion is the region used by chats that didn't set one.
+const defaultRegion = "DE"
+
+// regionAliases maps lowercased country names and ISO 3166-1 alpha-3 codes
+// to the alpha-2 region code used by TMDB.
+var regionAliases = map[string]string{
+	"germany":        "DE",
+	"deutschland":    "DE",
+	"deu":            "DE",
+	"united states":  "US",
+	"usa":            "US",
+	"america":        "US",
+	"united kingdom": "GB",
+	"uk":             "GB",
+	"great britain":  "GB",
+	"britain":        "GB",
+	"england":        "GB",
+	"gbr":            "GB",
+	"france":         "FR",
+	"fra":            "FR",
+	"japan":          "JP",
+	"jpn":            "JP",
+}
+
+// supportedRegions returns the codes of the supported regions, sorted.
+func supportedRegions() []string {
+	var codes []string
+	for code := range regionToEmoji {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// normalizeRegion maps user input such as "de", "Germany" or "deu" to the
+// canonical region code, e.g. "DE". Unknown regions are rejected.
+func normalizeRegion(input string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(input))
+	s = strings.Replace(s, ".", "", -1)
+	s = strings.Join(strings.Fields(s), " ")
+
+	code := strings.ToUpper(s)
+	if alias, ok := regionAliases[s]; ok {
+		code = alias
+	}
+	if _, ok := regionToEmoji[code]; !ok {
+		return "", errors.Errorf("unknown region %q, supported regions are %s", input, strings.Join(supportedRegions(), ", "))
+	}
+	return code, nil
+}
+
+// regionModifier matches the trailing region clause of a subscribe command,
+// e.g. "dune in us".
+var regionModifier = regexp.MustCompile(`^(.+) in ([\pL .]+)$`)
+
+// regionCode matches input that can only be meant as a region code.
+var regionCode = regexp.MustCompile(`^[a-z]{2}$`)
+
+// extractRegion removes the trailing "in <region>" clause from the text and
+// returns the region, empty if there is none. Clauses that aren't a region
+// are part of the title, e.g. "once upon a time in hollywood", unless they
+// look like a region code.
+func extractRegion(text string) (string, string, error) {
+	m := regionModifier.FindStringSubmatch(text)
+	if m == nil {
+		return text, "", nil
+	}
+	code, err := normalizeRegion(m[2])
+	if err != nil {
+		if regionCode.MatchString(strings.TrimSpace(m[2])) {
+			return text, "", err
+		}
+		return text, "", nil
+	}
+	return strings.TrimSpace(m[1]), code, nil
+}
+
+// regional returns the record with the release date the subscriber follows:
+// the one of its region if it chose one, zero while unknown there. With a
+// region group or a date policy the worldwide date is followed until their
+// region has a date.
+func (s Subscriber) regional(record MovieRelease) MovieRelease {
+	if s.Region == "" || (s.DatePolicy != "" && s.RegionDate.IsZero()) {
+		return record
+	}
+	record.ReleaseDate = s.RegionDate
+	return record
+}
+
+// regionDates returns the regional release dates of the movie if one of its
+// subscribers follows a region or a region group, nil otherwise. Called by
+// the refresh job, see applyRegionDates.
+func regionDates(ctx context.Context, record MovieRelease) map[string]time.Time {
+	for _, sub := range record.Subscribers {
+		if sub.Region == "" && len(sub.Regions) == 0 && sub.DatePolicy == "" {
+			continue
+		}
+		dates, err := movieReleaseDates(ctx, record.ID)
+		if err != nil {
+			logf(ctx, "failed to refresh regional release dates: id=%d: %s", record.ID, err)
+			return nil
+		}
+		return dates
+	}
+	return nil
+}
+
+// applyRegionDates updates the release dates of the subscribers following
+// a region or a region group. Dates missing from TMDB are kept.
+func applyRegionDates(record *MovieRelease, dates map[string]time.Time) {
+	if dates == nil {
+		return
+	}
+	for i, sub := range record.Subscribers {
+		if len(sub.Regions) > 0 {
+			if earliest, ok := earliestRegionDate(dates, sub.Regions); ok {
+				record.Subscribers[i].Region = earliest.Region
+				record.Subscribers[i].RegionDate = earliest.Date
+			}
+			continue
+		}
+		if sub.DatePolicy == datePolicyEarliest {
+			if earliest := policyRegionDate(datePolicyEarliest, dates, ""); earliest.Region != "" {
+				record.Subscribers[i].Region = earliest.Region
+				record.Subscribers[i].RegionDate = earliest.Date
+			}
+			continue
+		}
+		if d, ok := dates[sub.Region]; ok && sub.Region != "" {
+			record.Subscribers[i].RegionDate = d
+		}
+	}
+}
+
+// maxRegionGroup is the largest region group of a chat.
+const maxRegionGroup = 5
+
+// parseRegionGroup parses a list of regions separated by commas or spaces,
+// e.g. "us, gb, germany". Unknown regions are rejected, duplicates dropped.
+func parseRegionGroup(input string) ([]string, error) {
+	var group []string
+	seen := map[string]bool{}
+	for _, r := range strings.FieldsFunc(input, func(c rune) bool { return c == ',' || c == ' ' }) {
+		code, err := normalizeRegion(r)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[code] {
+			seen[code] = true
+			group = append(group, code)
+		}
+	}
+	if len(group) == 0 {
+		return nil, errors.New("no region given")
+	}
+	if len(group) > maxRegionGroup {
+		return nil, errors.Errorf("too many regions, at most %d", maxRegionGroup)
+	}
+	return group, nil
+}
+
+// earliestRegionDate returns the earliest release date among the regions,
+// ok is false when none of them has one. Ties go to the region listed first.
+func earliestRegionDate(dates map[string]time.Time, regions []string) (regionDate, bool) {
+	compared := compareReleaseDates(dates, regions)
+	if len(compared) == 0 {
+		return regionDate{}, false
+	}
+	return compared[0], true
+}
+
+// groupRegionDate returns the earliest release date of the movie among the
+// region group. TMDB failures are only logged, the date is then computed by
+// the next refresh.
+func groupRegionDate(ctx context.Context, movieID int64, regions []string) regionDate {
+	regional := regionDate{Group: regions}
+	dates, err := movieReleaseDates(ctx, movieID)
+	if err != nil {
+		logf(ctx, "failed to get release dates for region group: id=%d: %s", movieID, err)
+		return regional
+	}
+	if earliest, ok := earliestRegionDate(dates, regions); ok {
+		regional.Region, regional.Date = earliest.Region, earliest.Date
+	}
+	return regional
+}
+
+// withFirstRegion adds to the notification which region of the group of the
+// subscriber releases the movie first, if it follows one or the earliest date
+// policy.
+func withFirstRegion(text string, sub Subscriber) string {
+	if (len(sub.Regions) < 2 && sub.DatePolicy != datePolicyEarliest) || sub.Region == "" {
+		return text
+	}
+	return text + "\nFirst out in " + regionLabel(sub.Region) + " " + sub.Region + "."
+}
+
+// regionalSubscriptions returns the subscriptions of the chat with the
+// release dates it follows, see Subscriber.regional.
+func regionalSubscriptions(subscriptions []MovieRelease, chatID int64) []MovieRelease {
+	regional := make([]MovieRelease, 0, len(subscriptions))
+	for _, rec := range subscriptions {
+		for _, sub := range rec.Subscribers {
+			if sub.ChatID == chatID {
+				rec = sub.regional(rec)
+				break
+			}
+		}
+		regional = append(regional, rec)
+	}
+	return regional
+}
+
+// regionalDateText tells which regional release date a subscription follows.
+func regionalDateText(regional regionDate) string {
+	label := regionLabel(regional.Region) + " " + regional.Region
+	if regional.Date.IsZero() {
+		return "There's no release date in " + label + " yet, I'll follow it once announced."
+	}
+	return "I'll follow the release date in " + label + ": " + formatReleaseDate(regional.Date) + "."
+}
+
+// regionLabel returns the flag of the region, or its code if unknown.
+func regionLabel(region string) string {
+	if emoji, ok := regionToEmoji[region]; ok {
+		return emoji
+	}
+	return region
+}
+
+// regionLanguages maps regions to the TMDB language titles are displayed in.
+var regionLanguages = map[string]string{
+	"DE": "de-DE",
+	"US": "en-US",
+	"GB": "en-GB",
+	"FR": "fr-FR",
+	"JP": "ja-JP",
+}
+
+// language returns the TMDB language of the chat region, or an empty string
+// for the TMDB default.
+func (p UserPrefs) language() string {
+	return regionLanguages[p.region()]
+}
+
+// region returns the region of the chat.
+func (p UserPrefs) region() string {
+	if p.Region != "" {
+		return p.Region
+	}
+	return defaultRegion
+}
+
+func handleSetRegion(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	code, err := normalizeRegion(matches[1])
+	if err != nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Sorry, I don't know that region. Supported regions are "+strings.Join(supportedRegions(), ", ")+"."))
+		return
+	}
+
+	var prefs UserPrefs
+	err = store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.Region = code
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	// Subscriptions following the date of the region move along
+	if prefs.datePolicy() == datePolicyRegion {
+		if updated, err := applyDatePolicy(ctx, chatID, prefs); err != nil {
+			logf(ctx, "failed to apply date policy to new region: updated=%d: %s", updated, err)
+		}
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Region set to "+regionLabel(code)+" "+code+"."))
+}
+
+func handleSetRegionGroup(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	group, err := parseRegionGroup(matches[1])
+	if err != nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Sorry, I can't use these regions: %s.", err)))
+		return
+	}
+
+	err = store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.Regions = group
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	labels := make([]string, len(group))
+	for i, code := range group {
+		labels[i] = regionLabel(code) + " " + code
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "New subscriptions will follow the earliest release date in "+strings.Join(labels, ", ")+"."))
+}
+
+func handleClearRegionGroup(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.Regions = nil
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, "New subscriptions will follow the worldwide release date."))
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeRegion(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"de", "DE", false},
+		{"DE", "DE", false},
+		{" Germany ", "DE", false},
+		{"deu", "DE", false},
+		{"U.S.A.", "US", false},
+		{"united   states", "US", false},
+		{"uk", "GB", false},
+		{"jpn", "JP", false},
+		{"xx", "", true},
+		{"narnia", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeRegion(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeRegion(%q) = %q, %v, want %q, error %t", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractRegion(t *testing.T) {
+	tests := []struct {
+		text       string
+		wantTitle  string
+		wantRegion string
+		wantErr    bool
+	}{
+		{"dune", "dune", "", false},
+		{"dune in us", "dune", "US", false},
+		{"dune in germany", "dune", "DE", false},
+		{"once upon a time in hollywood", "once upon a time in hollywood", "", false},
+		{"dune in xx", "", "", true},
+	}
+	for _, tt := range tests {
+		title, region, err := extractRegion(tt.text)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("extractRegion(%q) = %q, %q, want an error", tt.text, title, region)
+			}
+			continue
+		}
+		if err != nil || title != tt.wantTitle || region != tt.wantRegion {
+			t.Errorf("extractRegion(%q) = %q, %q, %v, want %q, %q", tt.text, title, region, err, tt.wantTitle, tt.wantRegion)
+		}
+	}
+}
+
+func TestHandleSubscribeRegion(t *testing.T) {
+	// Confirmations sent right away, not batched with those of other tests
+	previous := subscribeConfirmations
+	subscribeConfirmations = &confirmationBatcher{chats: map[int64]*chatConfirmations{}}
+	t.Cleanup(func() { subscribeConfirmations = previous })
+
+	usRelease := time.Now().AddDate(0, 4, 0).UTC().Truncate(24 * time.Hour)
+	tests := []struct {
+		chatID     int64
+		text       string
+		wantRegion string
+		wantDate   time.Time
+	}{
+		{101, "subscribe to dune", "", time.Time{}},
+		{102, "subscribe to dune in us", "US", usRelease},
+	}
+	for _, tt := range tests {
+		s := useMemStore(t)
+		// Subscriptions without a region follow the top-level date
+		s.prefs[tt.chatID] = UserPrefs{ChatID: tt.chatID, DatePolicy: datePolicyPrimary}
+		tg := useFakeTelegram(t)
+		api := useFakeTMDB(t)
+		api.route("/search/movie", map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"id": 438631, "title": "Dune", "release_date": time.Now().AddDate(0, 3, 0).Format("2006-01-02")},
+			},
+		})
+		api.route("/movie/438631/release_dates", map[string]interface{}{
+			"results": []map[string]interface{}{
+				{"iso_3166_1": "US", "release_dates": []map[string]interface{}{
+					{"release_date": usRelease.Format(time.RFC3339)},
+				}},
+			},
+		})
+
+		update := testMessage(tt.chatID, tt.text)
+		handleSubscribe(context.Background(), update, subscribeCommand.FindStringSubmatch(update.Message.Text))
+
+		subs := s.releases[438631].Subscribers
+		if len(subs) != 1 {
+			t.Fatalf("%q: subscribers = %+v, want one", tt.text, subs)
+		}
+		if subs[0].Region != tt.wantRegion || !subs[0].RegionDate.Equal(tt.wantDate) {
+			t.Errorf("%q: subscription follows %q %s, want %q %s", tt.text, subs[0].Region, subs[0].RegionDate, tt.wantRegion, tt.wantDate)
+		}
+		texts := tg.texts(tt.chatID)
+		if got := len(texts) == 1 && strings.Contains(texts[0], "I'll follow the release date in"); got != (tt.wantRegion != "") {
+			t.Errorf("%q: messages = %q, want the regional date mentioned: %t", tt.text, texts, tt.wantRegion != "")
+		}
+		if n := api.requests("/movie/438631/release_dates"); (n > 0) != (tt.wantRegion != "") {
+			t.Errorf("%q: requested the release dates %d times", tt.text, n)
+		}
+	}
+}
+
+func TestHandleSubscribeUnknownRegion(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	useFakeTMDB(t)
+
+	update := testMessage(42, "subscribe to dune in xx")
+	handleSubscribe(context.Background(), update, subscribeCommand.FindStringSubmatch(update.Message.Text))
+
+	if len(s.releases) != 0 {
+		t.Errorf("releases = %+v, want no subscription", s.releases)
+	}
+	if texts := tg.texts(42); len(texts) != 1 || !strings.HasPrefix(texts[0], "Sorry, I don't know that region.") {
+		t.Errorf("messages = %q, want the region refused", texts)
+	}
+}
+
+func TestParseRegionGroup(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"us, gb, germany", []string{"US", "GB", "DE"}, false},
+		{"us gb", []string{"US", "GB"}, false},
+		{"us, usa, US", []string{"US"}, false},
+		{"us, xx", nil, true},
+		{" , ", nil, true},
+		{"us, gb, de, fr, jp", []string{"US", "GB", "DE", "FR", "JP"}, false},
+		{"us, gb, de, fr, jp, usa", []string{"US", "GB", "DE", "FR", "JP"}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRegionGroup(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRegionGroup(%q) error = %v, wantErr %t", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRegionGroup(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEarliestRegionDate(t *testing.T) {
+	march := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	dates := map[string]time.Time{"US": april, "GB": march, "DE": march}
+
+	tests := []struct {
+		regions    []string
+		wantRegion string
+		wantDate   time.Time
+		wantOK     bool
+	}{
+		{[]string{"US", "GB"}, "GB", march, true},
+		{[]string{"DE", "GB"}, "DE", march, true},
+		{[]string{"GB", "DE"}, "GB", march, true},
+		{[]string{"FR", "US"}, "US", april, true},
+		{[]string{"FR", "JP"}, "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := earliestRegionDate(dates, tt.regions)
+		if ok != tt.wantOK || got.Region != tt.wantRegion || !got.Date.Equal(tt.wantDate) {
+			t.Errorf("earliestRegionDate(%q) = %q %s %t, want %q %s %t", tt.regions, got.Region, got.Date, ok, tt.wantRegion, tt.wantDate, tt.wantOK)
+		}
+	}
+}
+
+func TestApplyRegionDates(t *testing.T) {
+	march := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	may := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	newRecord := func() MovieRelease {
+		return MovieRelease{Subscribers: []Subscriber{
+			{ChatID: 1, Regions: []string{"US", "GB"}, Region: "US", RegionDate: may},
+			{ChatID: 2, Region: "DE", RegionDate: may},
+			{ChatID: 3, Region: "FR", RegionDate: may},
+			{ChatID: 4, Regions: []string{"FR", "JP"}, Region: "FR", RegionDate: may},
+		}}
+	}
+
+	record := newRecord()
+	applyRegionDates(&record, map[string]time.Time{"US": april, "GB": march, "DE": april})
+	want := []struct {
+		region string
+		date   time.Time
+	}{
+		{"GB", march},
+		{"DE", april},
+		{"FR", may},
+		{"FR", may},
+	}
+	for i, w := range want {
+		sub := record.Subscribers[i]
+		if sub.Region != w.region || !sub.RegionDate.Equal(w.date) {
+			t.Errorf("chat %d follows %q %s, want %q %s", sub.ChatID, sub.Region, sub.RegionDate, w.region, w.date)
+		}
+	}
+
+	record = newRecord()
+	applyRegionDates(&record, nil)
+	if !reflect.DeepEqual(record, newRecord()) {
+		t.Errorf("without dates, subscribers = %+v, want them unchanged", record.Subscribers)
+	}
+}
+
+func TestWithFirstRegion(t *testing.T) {
+	tests := []struct {
+		sub  Subscriber
+		want string
+	}{
+		{Subscriber{Regions: []string{"US", "GB"}, Region: "GB"}, "Out today.\nFirst out in 🇬🇧 GB."},
+		{Subscriber{Regions: []string{"US"}, Region: "US"}, "Out today."},
+		{Subscriber{Region: "US"}, "Out today."},
+		{Subscriber{DatePolicy: datePolicyEarliest, Region: "JP"}, "Out today.\nFirst out in 🇯🇵 JP."},
+		{Subscriber{Regions: []string{"US", "GB"}}, "Out today."},
+	}
+	for _, tt := range tests {
+		if got := withFirstRegion("Out today.", tt.sub); got != tt.want {
+			t.Errorf("withFirstRegion(%+v) = %q, want %q", tt.sub, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// regionTimezones are the default timezones of the supported regions.
+var regionTimezones = map[string]string{
+	"DE": "Europe/Berlin",
+	"US": "America/New_York",
+	"GB": "Europe/London",
+	"FR": "Europe/Paris",
+	"JP": "Asia/Tokyo",
+}
+
+// location returns the timezone of the chat: the one it set, else the one of
+// its region, else UTC.
+func (p UserPrefs) location() *time.Location {
+	name := p.Timezone
+	if name == "" {
+		name = regionTimezones[p.region()]
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// startOfDay returns midnight of the day of t, in the location of t.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// relativeRange returns the first and last days, both included, of the
+// period described by a phrase such as "this weekend" or "next month",
+// relative to now. Weeks start on Monday. Periods already started begin
+// today.
+func relativeRange(phrase string, now time.Time) (from, to time.Time, ok bool) {
+	today := startOfDay(now)
+	// Days since Monday, Sunday being the last day of the week
+	weekday := (int(today.Weekday()) + 6) % 7
+	monday := today.AddDate(0, 0, -weekday)
+
+	switch phrase {
+	case "this weekend":
+		from, to = monday.AddDate(0, 0, 5), monday.AddDate(0, 0, 6)
+	case "this week":
+		from, to = monday, monday.AddDate(0, 0, 6)
+	case "next week":
+		from, to = monday.AddDate(0, 0, 7), monday.AddDate(0, 0, 13)
+	case "this month":
+		first := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+		from, to = first, first.AddDate(0, 1, -1)
+	case "next month":
+		first := time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, today.Location())
+		from, to = first, first.AddDate(0, 1, -1)
+	default:
+		return time.Time{}, time.Time{}, false
+	}
+
+	if from.Before(today) {
+		from = today
+	}
+	return from, to, true
+}
+
+func handleComingOut(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	phrase := matches[1]
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+
+	from, to, ok := relativeRange(phrase, time.Now().In(prefs.location()))
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I don't understand when that is."))
+		return
+	}
+
+	results, err := discoverReleases(ctx, prefs.region(), 0, from, to)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to discover movies")
+		return
+	}
+	if len(results) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Nothing coming out "+phrase+" 🤷"))
+		return
+	}
+
+	// The first page holds the most popular movies of the period, listed
+	// soonest first
+	sort.SliceStable(results, func(i, j int) bool { return results[i].ReleaseTime.Before(results[j].ReleaseTime) })
+	text := fmt.Sprintf("Coming out %s (%s to %s) 🍿:\n", phrase, prefs.formatDate(from), prefs.formatDate(to))
+	for _, m := range results {
+		text += fmt.Sprintf("- %s (%s)\n", displayTitle(m.Title, m.ID), prefs.formatDate(m.ReleaseTime))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+func handleSetTimezone(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	// Timezone names are case sensitive, use them as typed
+	fields := strings.Fields(update.Message.Text)
+	name := fields[len(fields)-1]
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I don't know that timezone, use a name such as Europe/Berlin or America/New_York."))
+		return
+	}
+
+	var prefs UserPrefs
+	err = store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.Timezone = loc.String()
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	now := time.Now().In(loc)
+	clock := timeOfDay(now.Hour()*60 + now.Minute())
+	sendMsg(ctx, telegram.NewMessage(chatID, "Timezone set to "+loc.String()+", it's "+prefs.formatTimeOfDay(clock)+" there."))
+}
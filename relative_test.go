@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelativeRange(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	// A Wednesday ending the year, and a Sunday starting a month
+	newYearsEve := day("2025-12-31").Add(15 * time.Hour)
+	sunday := day("2026-02-01").Add(23 * time.Hour)
+
+	tests := []struct {
+		phrase   string
+		now      time.Time
+		from, to string
+	}{
+		{"this weekend", newYearsEve, "2026-01-03", "2026-01-04"},
+		{"this week", newYearsEve, "2025-12-31", "2026-01-04"},
+		{"next week", newYearsEve, "2026-01-05", "2026-01-11"},
+		{"this month", newYearsEve, "2025-12-31", "2025-12-31"},
+		{"next month", newYearsEve, "2026-01-01", "2026-01-31"},
+		{"this weekend", sunday, "2026-02-01", "2026-02-01"},
+		{"this week", sunday, "2026-02-01", "2026-02-01"},
+		{"next week", sunday, "2026-02-02", "2026-02-08"},
+		{"this month", sunday, "2026-02-01", "2026-02-28"},
+		{"next month", sunday, "2026-03-01", "2026-03-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phrase+" "+tt.now.Weekday().String(), func(t *testing.T) {
+			from, to, ok := relativeRange(tt.phrase, tt.now)
+			if !ok {
+				t.Fatalf("relativeRange(%q) not ok", tt.phrase)
+			}
+			if !from.Equal(day(tt.from)) || !to.Equal(day(tt.to)) {
+				t.Errorf("relativeRange(%q) = %s to %s, want %s to %s", tt.phrase, from.Format("2006-01-02"), to.Format("2006-01-02"), tt.from, tt.to)
+			}
+		})
+	}
+
+	if _, _, ok := relativeRange("some day", newYearsEve); ok {
+		t.Error("relativeRange(\"some day\") ok, want unknown phrases rejected")
+	}
+}
+
+func TestHandleComingOutSoonestFirst(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	f := useFakeTMDB(t)
+
+	now := time.Now().UTC()
+	first := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	date := func(days int) string { return first.AddDate(0, 0, days).Format("2006-01-02") }
+	// By popularity, as TMDB sorts them
+	f.route("/discover/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 1, "title": "Heat", "release_date": date(20)},
+			{"id": 2, "title": "Dune", "release_date": date(2)},
+			{"id": 3, "title": "Alien", "release_date": date(10)},
+		},
+	})
+
+	handleComingOut(context.Background(), testMessage(42, "releases coming out next month"), []string{"", "next month"})
+
+	texts := tg.texts(42)
+	if len(texts) != 1 {
+		t.Fatalf("sent %q, want one list", texts)
+	}
+	dune, alien, heat := strings.Index(texts[0], "Dune"), strings.Index(texts[0], "Alien"), strings.Index(texts[0], "Heat")
+	if dune < 0 || !(dune < alien && alien < heat) {
+		t.Errorf("list = %q, want Dune, Alien then Heat", texts[0])
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// callbackRemoveReleased unsubscribes the chat from the movies already out.
+const callbackRemoveReleased = "removereleased"
+
+// releasedAgo returns how long ago the movie came out, e.g. "released 5 days
+// ago", counted in days in the user's timezone. ok is false for movies not
+// out yet or without a release date.
+func releasedAgo(release, now time.Time, loc *time.Location) (string, bool) {
+	if release.IsZero() {
+		return "", false
+	}
+	days := -daysUntil(release, now, loc)
+	switch {
+	case days < 0:
+		return "", false
+	case days == 0:
+		return "released today", true
+	case days == 1:
+		return "released yesterday", true
+	default:
+		return fmt.Sprintf("released %d days ago", days), true
+	}
+}
+
+// subscriptionStatus returns what the list of subscriptions shows next to
+// the title: the release date, or how long ago the movie came out. Records
+// soon deleted by cleanupReleases say when.
+func subscriptionStatus(rec MovieRelease, prefs UserPrefs, now time.Time) string {
+	loc := prefs.location()
+	ago, ok := releasedAgo(rec.ReleaseDate, now, loc)
+	if !ok {
+		return prefs.formatDate(rec.ReleaseDate)
+	}
+	for _, sub := range rec.Subscribers {
+		if !sub.Notified {
+			return ago
+		}
+	}
+	switch left := daysUntil(rec.ReleaseDate.Add(releaseRetention), now, loc); {
+	case left <= 0:
+		return ago + ", removed soon"
+	case left == 1:
+		return ago + ", removed tomorrow"
+	default:
+		return fmt.Sprintf("%s, removed in %d days", ago, left)
+	}
+}
+
+// releasedSubscriptions returns the subscriptions whose movie is out.
+func releasedSubscriptions(subscriptions []MovieRelease, prefs UserPrefs, now time.Time) []MovieRelease {
+	var released []MovieRelease
+	for _, rec := range subscriptions {
+		if _, ok := releasedAgo(rec.ReleaseDate, now, prefs.location()); ok {
+			released = append(released, rec)
+		}
+	}
+	return released
+}
+
+// removeReleasedKeyboard offers to unsubscribe from the count movies already
+// out, tracking them is done.
+func removeReleasedKeyboard(count int) telegram.InlineKeyboardMarkup {
+	label := fmt.Sprintf("Remove the %d released movies 🧹", count)
+	if count == 1 {
+		label = "Remove the released movie 🧹"
+	}
+	return telegram.NewInlineKeyboardMarkup(
+		telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData(label, callbackRemoveReleased+":all")),
+	)
+}
+
+// removeReleased unsubscribes the chat from the movies already out, each in
+// its own transaction. It returns how many were removed.
+func removeReleased(ctx context.Context, chatID int64) (int, error) {
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get subscriptions")
+	}
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get user prefs")
+	}
+
+	released := releasedSubscriptions(regionalSubscriptions(subscriptions, chatID), prefs, time.Now())
+	for i, rec := range released {
+		if err := unsubscribeChat(ctx, chatID, rec.ID); err != nil {
+			return i, errors.Wrap(err, "failed to unsubscribe from movie release")
+		}
+	}
+	return len(released), nil
+}
+
+// handleRemoveReleasedCallback unsubscribes the chat from the movies already
+// out. It returns the callback answer.
+func handleRemoveReleasedCallback(ctx context.Context, query *telegram.CallbackQuery) string {
+	chatID := query.Message.Chat.ID
+
+	removed, err := removeReleased(ctx, chatID)
+	if err != nil {
+		return callbackStoreFailed(ctx, err, fmt.Sprintf("failed to remove released subscriptions: removed=%d", removed))
+	}
+
+	text := fmt.Sprintf("Removed %d released movies from your subscriptions. 🧹", removed)
+	if removed == 1 {
+		text = "Removed the released movie from your subscriptions. 🧹"
+	}
+	edit := telegram.NewEditMessageText(chatID, query.Message.MessageID, text)
+	defer trackTime(ctx, timingTelegram, time.Now())
+	if _, err := bot.Send(edit); err != nil {
+		logf(ctx, "failed to edit subscriptions message: %s", err)
+	}
+	return "Removed"
+}
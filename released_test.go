@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReleasedAgo(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	losAngeles, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		release time.Time
+		now     time.Time
+		loc     *time.Location
+		want    string
+		wantOK  bool
+	}{
+		{"today", release, time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC), time.UTC, "released today", true},
+		{"already tomorrow in tokyo", release, time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC), tokyo, "released yesterday", true},
+		{"still the day before in los angeles", release, time.Date(2026, 10, 14, 2, 0, 0, 0, time.UTC), losAngeles, "", false},
+		{"days ago", release, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), time.UTC, "released 5 days ago", true},
+		{"upcoming", release, time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC), time.UTC, "", false},
+		{"undated", time.Time{}, time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC), time.UTC, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := releasedAgo(tt.release, tt.now, tt.loc)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: releasedAgo() = %q, %t, want %q, %t", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSubscriptionStatus(t *testing.T) {
+	previous := releaseRetention
+	releaseRetention = 30 * 24 * time.Hour
+	t.Cleanup(func() { releaseRetention = previous })
+
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	prefs := UserPrefs{ChatID: 42, Timezone: "UTC"}
+	daysAgo := func(days int) time.Time {
+		return time.Date(2026, 10, 14-days, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name     string
+		date     time.Time
+		notified bool
+		want     string
+	}{
+		{"upcoming", daysAgo(-3), false, prefs.formatDate(daysAgo(-3))},
+		{"not notified yet", daysAgo(5), false, "released 5 days ago"},
+		{"notified", daysAgo(5), true, "released 5 days ago, removed in 25 days"},
+		{"removed tomorrow", daysAgo(29), true, "released 29 days ago, removed tomorrow"},
+		{"past retention", daysAgo(31), true, "released 31 days ago, removed soon"},
+	}
+	for _, tt := range tests {
+		rec := MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: tt.date, Subscribers: []Subscriber{{ChatID: 42, Notified: tt.notified}}}
+		if got := subscriptionStatus(rec, prefs, now); got != tt.want {
+			t.Errorf("%s: subscriptionStatus() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveReleased(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	now := time.Now()
+	for _, rec := range []MovieRelease{
+		{ID: 1, MovieTitle: "Dune", ReleaseDate: now.AddDate(0, 0, -5), Subscribers: []Subscriber{{ChatID: 42}, {ChatID: 43}}},
+		{ID: 2, MovieTitle: "Alien", ReleaseDate: now.AddDate(0, 0, 5), Subscribers: []Subscriber{{ChatID: 42}}},
+		{ID: 3, MovieTitle: "Heat", Subscribers: []Subscriber{{ChatID: 42}}},
+	} {
+		if err := store.PutRelease(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := removeReleased(ctx, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removeReleased() = %d, want 1", removed)
+	}
+	if s.releases[1].subscribed(42) || !s.releases[1].subscribed(43) {
+		t.Errorf("subscribers of the released movie = %+v, want only chat 42 removed", s.releases[1].Subscribers)
+	}
+	if !s.releases[2].subscribed(42) || !s.releases[3].subscribed(42) {
+		t.Error("unsubscribed from a movie not out yet")
+	}
+}
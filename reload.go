@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultImageBaseURL is the base URL of TMDB images until the configuration
+// is fetched, see loadTMDBConfiguration.
+const defaultImageBaseURL = "https://image.tmdb.org/t/p/"
+
+// tmdbImages holds the image configuration of TMDB, fetched at startup and
+// on /admin/reload.
+var tmdbImages = struct {
+	mu      sync.Mutex
+	baseURL string
+}{baseURL: defaultImageBaseURL}
+
+// tmdbImageURL returns the URL of the TMDB image at path in the given size,
+// e.g. "w185".
+func tmdbImageURL(size, path string) string {
+	tmdbImages.mu.Lock()
+	defer tmdbImages.mu.Unlock()
+	return tmdbImages.baseURL + size + path
+}
+
+// loadTMDBConfiguration fetches the TMDB configuration into tmdbImages. The
+// previous configuration is kept on failure.
+func loadTMDBConfiguration(ctx context.Context) error {
+	var data struct {
+		Images struct {
+			SecureBaseURL string `json:"secure_base_url"`
+		} `json:"images"`
+	}
+	tmdb.forget("/configuration", nil)
+	if err := tmdb.get(ctx, "/configuration", nil, &data); err != nil {
+		return errors.Wrap(err, "failed to get tmdb configuration")
+	}
+	baseURL := data.Images.SecureBaseURL
+	if !strings.HasPrefix(baseURL, "https://") {
+		return errors.Errorf("unexpected image base url %q", baseURL)
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	tmdbImages.mu.Lock()
+	tmdbImages.baseURL = baseURL
+	tmdbImages.mu.Unlock()
+	return nil
+}
+
+// reloadResult is the outcome of reloading one cache, see handleAdminReload.
+type reloadResult struct {
+	Cache string `json:"cache"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleAdminReload fetches the TMDB configuration and genres again, for
+// when they changed or failed to load at startup. It responds with the
+// outcome for each cache, with a 502 status if any failed.
+func handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if !requireAdminToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := withRequestID(r.Context(), newRequestID())
+
+	reloads := []struct {
+		cache  string
+		reload func(ctx context.Context) error
+	}{
+		{"configuration", loadTMDBConfiguration},
+		{"genres", reloadGenres},
+	}
+
+	status := http.StatusOK
+	var results []reloadResult
+	for _, c := range reloads {
+		res := reloadResult{Cache: c.cache, OK: true}
+		if err := c.reload(ctx); err != nil {
+			logf(ctx, "failed to reload cache: cache=%s: %s", c.cache, err)
+			res.OK, res.Error = false, err.Error()
+			status = http.StatusBadGateway
+		}
+		results = append(results, res)
+	}
+	logf(ctx, "reloaded caches: %+v", results)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
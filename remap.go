@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxRemapAttempts is how many times the subscribers of a release are moved
+// to its replacement before giving up until the next refresh, when chats keep
+// subscribing to it meanwhile.
+const maxRemapAttempts = 3
+
+// remapMatch returns the search result replacing a release TMDB doesn't know
+// anymore. Only a confident match is returned: the single other result with
+// the same title, released the same year when the year of the release is
+// known.
+func remapMatch(record MovieRelease, results MovieAPIResults) (MovieAPIResult, bool) {
+	title := normalizeTitle(record.MovieTitle)
+
+	var matches MovieAPIResults
+	for _, res := range results {
+		if res.ID == record.ID || normalizeTitle(res.Title) != title {
+			continue
+		}
+		if !record.ReleaseDate.IsZero() && (res.ReleaseTime.IsZero() || res.ReleaseTime.Year() != record.ReleaseDate.Year()) {
+			continue
+		}
+		matches = append(matches, res)
+	}
+	if len(matches) != 1 {
+		return MovieAPIResult{}, false
+	}
+	return matches[0], true
+}
+
+// remapRelease handles a release TMDB answers 404 for, usually because the
+// entry was merged into another one. The subscribers are moved to the entry
+// found by searching the stored title and year, if confident, see remapMatch.
+// Otherwise the release is flagged as missing and its subscribers are told
+// once. Only store errors are returned, TMDB failures are retried by the next
+// refresh.
+func remapRelease(ctx context.Context, record MovieRelease) error {
+	var year string
+	if !record.ReleaseDate.IsZero() {
+		year = strconv.Itoa(record.ReleaseDate.Year())
+	}
+	results, err := queryMovies(ctx, record.MovieTitle, year)
+	if err != nil {
+		logf(ctx, "failed to search replacement of movie release: id=%d: %s", record.ID, err)
+		return nil
+	}
+
+	match, ok := remapMatch(record, results)
+	if !ok {
+		return flagMissingRelease(ctx, record)
+	}
+
+	// The subscribers are read again within the deletion, which only
+	// happens once they all follow the match: chats subscribing meanwhile
+	// are moved by the next attempt
+	subscribers := record.Subscribers
+	for attempt := 1; ; attempt++ {
+		subscribed, err := moveSubscribers(ctx, match, subscribers)
+		if err != nil {
+			return err
+		}
+		var stored MovieRelease
+		deleted, err := store.DeleteRelease(ctx, record.ID, func(release MovieRelease) bool {
+			stored = release
+			for _, sub := range release.Subscribers {
+				if !subscribed[sub.ChatID] {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if deleted {
+			subscribers = stored.Subscribers
+			break
+		}
+		if stored.ID == 0 {
+			logf(ctx, "movie release deleted while remapped: from=%d to=%d", record.ID, match.ID)
+			return nil
+		}
+		if attempt == maxRemapAttempts {
+			logf(ctx, "failed to remap movie release, subscribers keep changing: from=%d to=%d", record.ID, match.ID)
+			return nil
+		}
+		subscribers = stored.Subscribers
+	}
+
+	logf(ctx, "remapped movie release: from=%d to=%d subscribers=%d", record.ID, match.ID, len(subscribers))
+	text := fmt.Sprintf("TMDB replaced its entry for %s, your subscription now follows %s.\n%s", record.MovieTitle, match.Title, tmdbMovieURL(match.ID))
+	for _, sub := range subscribers {
+		if err := sendNotification(ctx, sub, text, notificationLog(notificationReplaced, match.ID, match.Title)); err != nil {
+			logf(ctx, "failed to send remapped release notification: id=%d: %s", match.ID, err)
+		}
+	}
+	return nil
+}
+
+// moveSubscribers adds the subscribers to the release of the match, creating
+// it if needed. It returns the chats subscribed to it.
+func moveSubscribers(ctx context.Context, match MovieAPIResult, subscribers []Subscriber) (map[int64]bool, error) {
+	var subscribed map[int64]bool
+	err := store.UpdateRelease(ctx, match.ID, func(txRelease *MovieRelease) error {
+		if txRelease.ID == 0 {
+			*txRelease = newMovieRelease(match)
+		}
+		subscribed = map[int64]bool{}
+		for _, sub := range txRelease.Subscribers {
+			subscribed[sub.ChatID] = true
+		}
+		for _, sub := range subscribers {
+			if !subscribed[sub.ChatID] {
+				txRelease.Subscribers = append(txRelease.Subscribers, sub)
+				subscribed[sub.ChatID] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to move subscribers to movie release %d", match.ID)
+	}
+	return subscribed, nil
+}
+
+// flagMissingRelease records that TMDB doesn't know the release anymore and
+// tells its subscribers, once.
+func flagMissingRelease(ctx context.Context, record MovieRelease) error {
+	if !record.MissingSince.IsZero() {
+		return nil
+	}
+	err := store.UpdateRelease(ctx, record.ID, func(txRelease *MovieRelease) error {
+		if txRelease.ID == 0 || !txRelease.MissingSince.IsZero() {
+			return errSkipUpdate
+		}
+		txRelease.MissingSince = time.Now()
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to flag movie release %d as missing", record.ID)
+	}
+
+	logf(ctx, "movie release missing from tmdb: id=%d", record.ID)
+	text := fmt.Sprintf("I can't find %s on TMDB anymore, so I can't follow its release. If it is listed under another entry, search for it with \"releases %s\" and subscribe again.", record.MovieTitle, record.MovieTitle)
+	for _, sub := range record.Subscribers {
+		if err := sendNotification(ctx, sub, text, notificationLog(notificationMissing, record.ID, record.MovieTitle)); err != nil {
+			logf(ctx, "failed to send missing release notification: id=%d: %s", record.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemapMatch(t *testing.T) {
+	released := time.Date(2021, 9, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		record  MovieRelease
+		results MovieAPIResults
+		wantID  int64
+		wantOK  bool
+	}{
+		{
+			"same title and year",
+			MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: released},
+			MovieAPIResults{{ID: 1, Title: "Dune", ReleaseTime: released}, {ID: 2, Title: "Dune", ReleaseTime: released.AddDate(0, 1, 0)}},
+			2, true,
+		},
+		{
+			"title ignoring case and punctuation",
+			MovieRelease{ID: 1, MovieTitle: "Dune: Part Two"},
+			MovieAPIResults{{ID: 2, Title: "dune part two"}},
+			2, true,
+		},
+		{
+			"other year",
+			MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: released},
+			MovieAPIResults{{ID: 2, Title: "Dune", ReleaseTime: released.AddDate(-37, 0, 0)}},
+			0, false,
+		},
+		{
+			"undated result for a dated release",
+			MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: released},
+			MovieAPIResults{{ID: 2, Title: "Dune"}},
+			0, false,
+		},
+		{
+			"other title",
+			MovieRelease{ID: 1, MovieTitle: "Dune"},
+			MovieAPIResults{{ID: 2, Title: "Dune: Part Two"}},
+			0, false,
+		},
+		{
+			"several matches",
+			MovieRelease{ID: 1, MovieTitle: "Dune"},
+			MovieAPIResults{{ID: 2, Title: "Dune"}, {ID: 3, Title: "Dune"}},
+			0, false,
+		},
+		{
+			"only itself",
+			MovieRelease{ID: 1, MovieTitle: "Dune"},
+			MovieAPIResults{{ID: 1, Title: "Dune"}},
+			0, false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := remapMatch(tt.record, tt.results)
+			if ok != tt.wantOK || got.ID != tt.wantID {
+				t.Errorf("remapMatch() = %d, %t, want %d, %t", got.ID, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRemapReleaseMovesSubscribedMeanwhile(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 2, "title": "Dune", "release_date": "2021-09-15"},
+		},
+	})
+
+	record := MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: time.Date(2021, 9, 15, 0, 0, 0, 0, time.UTC), Subscribers: []Subscriber{{ChatID: 42}}}
+	if err := store.PutRelease(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	// Subscribed after the refresh read the release
+	if _, err := subscribeChat(ctx, 43, record, 0, regionDate{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remapRelease(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.releases[1]; ok {
+		t.Error("replaced release not deleted")
+	}
+	for _, chatID := range []int64{42, 43} {
+		if !s.releases[2].subscribed(chatID) {
+			t.Errorf("chat %d not moved to the replacing release", chatID)
+		}
+		if texts := tg.texts(chatID); len(texts) == 0 || !strings.HasPrefix(texts[len(texts)-1], "TMDB replaced its entry for Dune") {
+			t.Errorf("chat %d sent %q, want the remap notified", chatID, texts)
+		}
+	}
+}
+
+func TestRemapReleaseWithoutMatch(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 2, "title": "Dune: Part Two", "release_date": "2024-02-27"},
+		},
+	})
+
+	record := MovieRelease{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: 42}}}
+	if err := store.PutRelease(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := remapRelease(ctx, s.releases[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.releases[1].MissingSince.IsZero() {
+		t.Error("release not flagged as missing")
+	}
+	if texts := tg.texts(42); len(texts) != 1 || !strings.HasPrefix(texts[0], "I can't find Dune on TMDB anymore") {
+		t.Errorf("sent %q, want the missing release told once", texts)
+	}
+}
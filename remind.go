@@ -0,0 +1,70 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRemindDays is how many days before the release subscribers are
+	// notified unless they asked otherwise.
+	defaultRemindDays = 7
+	// maxRemindDays caps the reminder offset of a subscription.
+	maxRemindDays = 180
+)
+
+// remindModifier matches the "remind <n> <unit> before" modifier of the
+// subscribe command.
+var remindModifier = regexp.MustCompile(` remind (\S+) (\S+) before$`)
+
+// remindUnitDays maps the units accepted by the remind modifier to a number
+// of days.
+var remindUnitDays = map[string]int{
+	"day":    1,
+	"days":   1,
+	"week":   7,
+	"weeks":  7,
+	"month":  30,
+	"months": 30,
+}
+
+// extractRemindDays parses and removes the remind modifier from text. It
+// returns zero days when there is no modifier.
+func extractRemindDays(text string) (string, int, error) {
+	m := remindModifier.FindStringSubmatch(text)
+	if m == nil {
+		return text, 0, nil
+	}
+	days, err := parseRemindOffset(m[1], m[2])
+	if err != nil {
+		return text, 0, err
+	}
+	return remindModifier.ReplaceAllString(text, ""), days, nil
+}
+
+// parseRemindOffset converts an offset such as "2 weeks" to a number of days.
+func parseRemindOffset(amount, unit string) (int, error) {
+	n, err := strconv.Atoi(amount)
+	if err != nil || n < 1 {
+		return 0, errors.Errorf("%q is not a positive number", amount)
+	}
+	unitDays, ok := remindUnitDays[unit]
+	if !ok {
+		return 0, errors.Errorf("unknown unit %q, use days, weeks or months", unit)
+	}
+	if n > maxRemindDays/unitDays {
+		return 0, errors.Errorf("that's too early, I can remind you at most %d days before", maxRemindDays)
+	}
+	return n * unitDays, nil
+}
+
+// remindDays returns how many days before the release the subscriber is
+// notified.
+func (s Subscriber) remindDays() int {
+	if s.RemindDays > 0 {
+		return s.RemindDays
+	}
+	return defaultRemindDays
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExtractRemindDays(t *testing.T) {
+	tests := []struct {
+		text     string
+		wantText string
+		wantDays int
+		wantErr  bool
+	}{
+		{"dune", "dune", 0, false},
+		{"dune remind 2 weeks before", "dune", 14, false},
+		{"dune remind 1 month before", "dune", 30, false},
+		{"dune remind 10 days before", "dune", 10, false},
+		{"dune remind 1 day before", "dune", 1, false},
+		{"dune remind 2 fortnights before", "", 0, true},
+		{"dune remind two weeks before", "", 0, true},
+		{"dune remind 0 days before", "", 0, true},
+		{"dune remind 7 months before", "", 0, true},
+	}
+	for _, tt := range tests {
+		text, days, err := extractRemindDays(tt.text)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("extractRemindDays(%q) = %d days, want an error", tt.text, days)
+			}
+			continue
+		}
+		if err != nil || text != tt.wantText || days != tt.wantDays {
+			t.Errorf("extractRemindDays(%q) = %q, %d, %v, want %q, %d", tt.text, text, days, err, tt.wantText, tt.wantDays)
+		}
+	}
+}
+
+func TestSubscribeChatNewOffsetResetsNotified(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	release := MovieRelease{
+		ID:          1,
+		MovieTitle:  "Dune",
+		ReleaseDate: time.Now().AddDate(0, 0, 5),
+		Subscribers: []Subscriber{{ChatID: 42, Notified: true}},
+	}
+	if err := store.PutRelease(ctx, release); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := subscribeChat(ctx, 42, release, 2, regionDate{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	sub := s.releases[1].Subscribers[0]
+	if sub.RemindDays != 2 || sub.Notified {
+		t.Errorf("subscriber = %+v, want reminded 2 days before and not notified yet", sub)
+	}
+
+	// Subscribing again without an offset keeps the state
+	s.releases[1].Subscribers[0].Notified = true
+	if _, err := subscribeChat(ctx, 42, release, 0, regionDate{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if sub := s.releases[1].Subscribers[0]; !sub.Notified {
+		t.Errorf("subscriber = %+v, want still notified", sub)
+	}
+}
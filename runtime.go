@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// fillRuntimes fetches the runtime of the releases the refresh job didn't get
+// one for yet, and stores it on their records, see fetchAll. Releases TMDB has
+// no runtime for are left unchanged. It returns how many runtimes couldn't be
+// fetched, failures are only logged.
+func fillRuntimes(ctx context.Context, subscriptions []MovieRelease) ([]MovieRelease, int) {
+	errs := fetchAll(ctx, len(subscriptions), func(ctx context.Context, i int) error {
+		rec := subscriptions[i]
+		if rec.Runtime > 0 {
+			return nil
+		}
+		details, err := movieDetails(ctx, rec.ID)
+		if err != nil {
+			return err
+		}
+		if details.Runtime <= 0 {
+			return nil
+		}
+		subscriptions[i].Runtime = details.Runtime
+
+		err = store.UpdateRelease(ctx, rec.ID, func(record *MovieRelease) error {
+			if record.ID == 0 || record.Runtime == details.Runtime {
+				return errSkipUpdate
+			}
+			record.Runtime = details.Runtime
+			return nil
+		})
+		if err != nil {
+			logf(ctx, "failed to store movie runtime: id=%d: %s", rec.ID, err)
+		}
+		return nil
+	})
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			logf(ctx, "failed to get movie runtime: id=%d: %s", subscriptions[i].ID, err)
+			failed++
+		}
+	}
+	return subscriptions, failed
+}
+
+func handleTotalRuntime(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+	subscriptions = regionalSubscriptions(subscriptions, chatID)
+
+	now := time.Now()
+	var upcoming []MovieRelease
+	for _, rec := range subscriptions {
+		if rec.ReleaseDate.IsZero() || rec.ReleaseDate.After(now) {
+			upcoming = append(upcoming, rec)
+		}
+	}
+	if len(upcoming) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You aren't subscribed to any upcoming movie."))
+		return
+	}
+
+	filled, unavailable := fillRuntimes(ctx, upcoming)
+	total, missing := 0, 0
+	for _, rec := range filled {
+		if rec.Runtime <= 0 {
+			missing++
+			continue
+		}
+		total += rec.Runtime
+	}
+	missing -= unavailable
+
+	counted := len(upcoming) - missing - unavailable
+	if counted == 0 {
+		text := fmt.Sprintf("You're tracking %d movies, none of them has a known runtime yet.", len(upcoming))
+		if unavailable > 0 {
+			text = "I can't get the runtimes of your movies right now, try again later 🤷"
+		}
+		sendMsg(ctx, telegram.NewMessage(chatID, text))
+		return
+	}
+	text := fmt.Sprintf("You're tracking %d movies totaling %s. 🍿", counted, formatRuntime(total))
+	if missing > 0 {
+		text += fmt.Sprintf("\n%d more don't have a known runtime yet.", missing)
+	}
+	if unavailable > 0 {
+		text += fmt.Sprintf("\n%d more left out, their details are unavailable right now.", unavailable)
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
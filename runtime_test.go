@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandleTotalRuntimePartialFailure(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	ctx := context.Background()
+
+	day := time.Now().AddDate(0, 2, 0)
+	for _, rec := range []MovieRelease{
+		{ID: 1, MovieTitle: "Dune", ReleaseDate: day},
+		// TMDB fails for this one
+		{ID: 2, MovieTitle: "Alien", ReleaseDate: day},
+		{ID: 3, MovieTitle: "Heat", ReleaseDate: day},
+		{ID: 4, MovieTitle: "Tron", ReleaseDate: day, Runtime: 120},
+	} {
+		rec.Subscribers = []Subscriber{{ChatID: 42}}
+		if err := store.PutRelease(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api.route("/movie/1", map[string]interface{}{"id": 1, "title": "Dune", "runtime": 155})
+	api.route("/movie/3", map[string]interface{}{"id": 3, "title": "Heat", "runtime": 0})
+
+	handleTotalRuntime(ctx, testMessage(42, "total runtime"))
+
+	want := "You're tracking 2 movies totaling " + formatRuntime(275) + ". 🍿\n1 more don't have a known runtime yet.\n1 more left out, their details are unavailable right now."
+	if texts := tg.texts(42); len(texts) != 1 || texts[0] != want {
+		t.Errorf("sent %q, want %q", texts, want)
+	}
+	if n := api.requests("/movie/4"); n != 0 {
+		t.Errorf("fetched the details of a movie with a known runtime %d times", n)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// searchRetention is how long the searches of chats opted in to surprise
+// notifications are kept.
+const searchRetention = 30 * 24 * time.Hour
+
+// recordSearches records the undated movies among the search results when the
+// chat opted in to surprise notifications. Failures are only logged, the
+// search itself already succeeded.
+func recordSearches(ctx context.Context, chatID int64, results MovieAPIResults) {
+	var undated []SearchedMovie
+	now := time.Now()
+	for _, m := range results {
+		if m.ReleaseTime.IsZero() {
+			undated = append(undated, SearchedMovie{
+				ChatID:     chatID,
+				MovieID:    m.ID,
+				MovieTitle: m.Title,
+				SearchedAt: now,
+			})
+		}
+	}
+	if len(undated) == 0 {
+		return
+	}
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		logf(ctx, "failed to get user prefs, search not recorded: %s", err)
+		return
+	}
+	if !prefs.SurpriseNotifications {
+		return
+	}
+	if err := store.PutSearches(ctx, undated); err != nil {
+		logf(ctx, "failed to record searches: %s", err)
+	}
+}
+
+// chatSearches returns the searches recorded for the chat.
+func chatSearches(ctx context.Context, chatID int64) ([]SearchedMovie, error) {
+	searches, err := store.Searches(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var chat []SearchedMovie
+	for _, s := range searches {
+		if s.ChatID == chatID {
+			chat = append(chat, s)
+		}
+	}
+	return chat, nil
+}
+
+// refreshSearches notifies the chats when a movie they searched for in the
+// last searchRetention gets a release date, unless they subscribed to it
+// since. Notified and expired searches are deleted, those of paused chats
+// are kept for later.
+func refreshSearches(ctx context.Context) {
+	searches, err := store.Searches(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all searches")
+		return
+	}
+	if len(searches) == 0 {
+		return
+	}
+
+	records, err := store.Releases(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all subscriptions")
+		return
+	}
+	subscribed := map[string]bool{}
+	for _, rec := range records {
+		for _, sub := range rec.Subscribers {
+			subscribed[fmt.Sprintf("%d-%d", sub.ChatID, rec.ID)] = true
+		}
+	}
+
+	now := time.Now()
+	byMovie := map[int64][]SearchedMovie{}
+	var done []SearchedMovie
+	for _, s := range searches {
+		if now.Sub(s.SearchedAt) > searchRetention || subscribed[fmt.Sprintf("%d-%d", s.ChatID, s.MovieID)] {
+			done = append(done, s)
+			continue
+		}
+		byMovie[s.MovieID] = append(byMovie[s.MovieID], s)
+	}
+
+	prefs := map[int64]UserPrefs{}
+	for movieID, movieSearches := range byMovie {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping searches refresh: %s", ctx.Err())
+			break
+		}
+
+		details, err := movieDetails(ctx, movieID)
+		if err != nil {
+			logf(ctx, "failed to refresh searched movie: id=%d: %s", movieID, err)
+			continue
+		}
+		if details.ReleaseTime.IsZero() {
+			continue
+		}
+
+		for _, s := range movieSearches {
+			p, ok := prefs[s.ChatID]
+			if !ok {
+				p, err = store.Prefs(ctx, s.ChatID)
+				if err != nil {
+					jobStoreFailed(ctx, err, "failed to get user prefs")
+					return
+				}
+				prefs[s.ChatID] = p
+			}
+			if p.notificationsPaused(now) {
+				continue
+			}
+			if p.SurpriseNotifications {
+				text := fmt.Sprintf("Surprise! %s, which you searched for recently, now comes out on %s 🎉\nSend \"subscribe to %s\" to get notified.", details.Title, p.formatDate(details.ReleaseTime), details.Title)
+				if err := sendNotification(ctx, Subscriber{ChatID: s.ChatID, NotifyChatID: p.NotifyChatID}, text, notificationLog(notificationSurprise, details.ID, details.Title)); err != nil {
+					// The search is kept, the next refresh tells it again
+					logf(ctx, "failed to send surprise notification: id=%d: %s", details.ID, err)
+					continue
+				}
+			}
+			done = append(done, s)
+		}
+	}
+
+	if err := store.DeleteSearches(ctx, done); err != nil {
+		jobStoreFailed(ctx, err, "failed to delete searches")
+		return
+	}
+	logf(ctx, "refreshed searches: searches=%d done=%d", len(searches), len(done))
+}
+
+func handleSurpriseNotifications(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.SurpriseNotifications = enabled
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	if enabled {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Surprise notifications are on. I'll remember the movies without a release date you search for during %d days and tell you when they get one. Send \"surprise notifications off\" to stop.", int(searchRetention.Hours()/24))))
+		return
+	}
+
+	// Forget the searches right away
+	searches, err := chatSearches(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get searches")
+		return
+	}
+	if err := store.DeleteSearches(ctx, searches); err != nil {
+		storeFailed(ctx, chatID, err, "failed to delete searches")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Surprise notifications are off, I forgot your searches."))
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// bestShowMatch returns the show best matching the searched name: the most
+// popular exact name match if there is one, the most popular result
+// otherwise. With anime set only animated shows are considered, Japanese ones
+// first.
+func bestShowMatch(results []TVAPIResult, name string, anime bool) (TVAPIResult, bool) {
+	var best TVAPIResult
+	found := false
+	score := func(r TVAPIResult) int {
+		s := 0
+		if strings.ToLower(r.Name) == name || strings.ToLower(r.OriginalName) == name {
+			s += 2
+		}
+		if anime {
+			for _, c := range r.OriginCountry {
+				if c == "JP" {
+					s++
+				}
+			}
+		}
+		return s
+	}
+	for _, r := range results {
+		if anime && !r.HasGenre(tvGenreAnimation) {
+			continue
+		}
+		if !found || score(r) > score(best) || (score(r) == score(best) && r.Popularity > best.Popularity) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// applySeason updates the stored season with the one fetched from TMDB. It
+// returns whether the season got announced, i.e. it now has a premiere date.
+func applySeason(record *SeasonRelease, season TVSeason) bool {
+	announced := false
+	premiere := parseAirDate(season.AirDate)
+	if !premiere.IsZero() {
+		announced = record.PremiereDate.IsZero()
+		record.PremiereDate = premiere
+	}
+
+	if len(season.Episodes) > 0 {
+		var episodes []SeasonEpisode
+		for _, e := range season.Episodes {
+			episodes = append(episodes, SeasonEpisode{Number: e.EpisodeNumber, AirDate: parseAirDate(e.AirDate)})
+		}
+		record.Episodes = episodes
+	}
+	return announced
+}
+
+// lastAiredEpisode returns the number of the latest episode aired before now.
+func (r SeasonRelease) lastAiredEpisode(now time.Time) int {
+	last := 0
+	for _, e := range r.Episodes {
+		if !e.AirDate.IsZero() && e.AirDate.Before(now) && e.Number > last {
+			last = e.Number
+		}
+	}
+	return last
+}
+
+// fetchSeason returns the season of the show from TMDB with its episodes. ok
+// is false if the season doesn't exist yet.
+func fetchSeason(ctx context.Context, showID int64, number int) (season TVSeason, ok bool, err error) {
+	show, err := tvShow(ctx, showID)
+	if err != nil {
+		return TVSeason{}, false, err
+	}
+	if _, ok := show.Season(number); !ok {
+		return TVSeason{}, false, nil
+	}
+	season, err = tvSeason(ctx, showID, number)
+	if err != nil {
+		return TVSeason{}, false, err
+	}
+	return season, true, nil
+}
+
+func handleTrackSeason(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	anime := matches[1] == "anime"
+	name := strings.TrimSpace(matches[2])
+	number, err := strconv.Atoi(matches[3])
+	if err != nil || number < 1 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Which season? Use \"track anime <title> season <n>\"."))
+		return
+	}
+
+	results, err := searchTV(ctx, name)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to search tv shows")
+		return
+	}
+	show, ok := bestShowMatch(results, name, anime)
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "No show found 🤓"))
+		return
+	}
+
+	trackSeason(ctx, chatID, show, number)
+}
+
+// trackSeason subscribes the chat to the season of the show and tells it when
+// the season premieres.
+func trackSeason(ctx context.Context, chatID int64, show TVAPIResult, number int) {
+	season, exists, err := fetchSeason(ctx, show.ID, number)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get tv season")
+		return
+	}
+
+	now := time.Now()
+	record := SeasonRelease{ShowID: show.ID, ShowName: show.Name, Season: number}
+	if exists {
+		applySeason(&record, season)
+		if !record.PremiereDate.IsZero() && record.PremiereDate.Before(now) && record.lastAiredEpisode(now) >= len(record.Episodes) {
+			sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Season %d of %s already aired, it premiered on %s.", number, show.Name, formatReleaseDate(record.PremiereDate))))
+			return
+		}
+	}
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+	err = store.UpdateSeason(ctx, show.ID, number, func(tx *SeasonRelease) error {
+		subscribers := tx.Subscribers
+		*tx = record
+		tx.Subscribers = subscribers
+		for _, sub := range tx.Subscribers {
+			if sub.ChatID == chatID {
+				return nil
+			}
+		}
+		tx.Subscribers = append(tx.Subscribers, Subscriber{
+			ChatID:       chatID,
+			NotifyChatID: prefs.NotifyChatID,
+			// Only episodes airing from now on are notified
+			LastEpisode: record.lastAiredEpisode(now),
+			// The premiere is already behind, only episodes are left
+			Notified: !record.PremiereDate.IsZero() && record.PremiereDate.Before(now),
+		})
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to track season")
+		return
+	}
+
+	text := fmt.Sprintf("Tracking season %d of %s, it premieres on %s.", number, show.Name, formatReleaseDate(record.PremiereDate))
+	switch {
+	case !exists || record.PremiereDate.IsZero():
+		text = fmt.Sprintf("Season %d of %s isn't announced yet, I'll let you know as soon as it is.", number, show.Name)
+	case record.PremiereDate.Before(now):
+		text = fmt.Sprintf("Tracking the upcoming episodes of season %d of %s.", number, show.Name)
+	}
+	if !prefs.SeasonEpisodes {
+		text += " Send \"season episodes on\" to be notified about every episode."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+// latestSeason returns the number of the latest season TMDB lists for the
+// show, specials excluded.
+func latestSeason(show TVShow) int {
+	latest := 0
+	for _, season := range show.Seasons {
+		if season.SeasonNumber > latest {
+			latest = season.SeasonNumber
+		}
+	}
+	return latest
+}
+
+// showEnded returns whether no new season of the show is expected.
+func showEnded(show TVShow) bool {
+	return show.Status == "Ended" || show.Status == "Canceled"
+}
+
+// handleTrackNextSeason tracks the season following the latest one TMDB
+// knows about, so that the chat is told once it gets announced.
+func handleTrackNextSeason(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	name := strings.TrimSpace(matches[1])
+
+	results, err := searchTV(ctx, name)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to search tv shows")
+		return
+	}
+	match, ok := bestShowMatch(results, name, false)
+	if !ok {
+		sendMsg(ctx, telegram.NewMessage(chatID, "No show found 🤓"))
+		return
+	}
+
+	show, err := tvShow(ctx, match.ID)
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get tv show")
+		return
+	}
+	if showEnded(show) {
+		sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("%s has %s, no new season is expected.", show.Name, strings.ToLower(show.Status))))
+		return
+	}
+
+	// A latest season announced without a date yet, or premiering in the
+	// future, is the next one
+	next := latestSeason(show) + 1
+	if latest, ok := show.Season(next - 1); ok && next > 1 {
+		if premiere := parseAirDate(latest.AirDate); premiere.IsZero() || premiere.After(time.Now()) {
+			next--
+		}
+	}
+	trackSeason(ctx, chatID, match, next)
+}
+
+func handleSeasonEpisodes(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	var prefs UserPrefs
+	err := store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.SeasonEpisodes = matches[1] == "on"
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "You'll be notified about season premieres only."
+	if prefs.SeasonEpisodes {
+		text = "You'll be notified about every episode of the seasons you track."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
+
+// refreshSeasons re-fetches every tracked season from TMDB and tells
+// subscribers when a season they track gets announced.
+func refreshSeasons(ctx context.Context) {
+	seasons, err := store.Seasons(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all seasons")
+		return
+	}
+
+	for _, record := range seasons {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping refresh job: %s", ctx.Err())
+			return
+		}
+		if len(record.Subscribers) == 0 {
+			continue
+		}
+
+		season, exists, err := fetchSeason(ctx, record.ShowID, record.Season)
+		if err != nil {
+			logf(ctx, "failed to refresh season: show_id=%d season=%d: %s", record.ShowID, record.Season, err)
+			continue
+		}
+		if !exists {
+			endTrackingIfEnded(ctx, record)
+			continue
+		}
+
+		if applySeason(&record, season) {
+			text := fmt.Sprintf("Season %d of %s has been announced, it premieres on %s! 📺", record.Season, record.ShowName, formatReleaseDate(record.PremiereDate))
+			if !record.PremiereDate.After(time.Now()) {
+				// TMDB only listed it once it aired
+				text = fmt.Sprintf("Season %d of %s premiered on %s. 📺", record.Season, record.ShowName, formatReleaseDate(record.PremiereDate))
+			}
+			for _, sub := range record.Subscribers {
+				if err := sendNotification(ctx, sub, text, seasonLog(notificationSeason, record)); err != nil {
+					logf(ctx, "failed to send season announcement: show_id=%d season=%d: %s", record.ShowID, record.Season, err)
+				}
+			}
+		}
+
+		// Applied to the season as stored now, chats may have started or
+		// stopped tracking it meanwhile
+		err = store.UpdateSeason(ctx, record.ShowID, record.Season, func(tx *SeasonRelease) error {
+			if tx.ShowID == 0 {
+				return errSkipUpdate
+			}
+			applySeason(tx, season)
+			return nil
+		})
+		if err != nil {
+			jobStoreFailed(ctx, err, fmt.Sprintf("failed to update season: show_id=%d season=%d", record.ShowID, record.Season))
+			return
+		}
+	}
+}
+
+// endTrackingIfEnded tells the subscribers of a season that doesn't exist
+// when the show ended, and removes them since the season will never come.
+func endTrackingIfEnded(ctx context.Context, record SeasonRelease) {
+	show, err := tvShow(ctx, record.ShowID)
+	if err != nil {
+		logf(ctx, "failed to get tv show: show_id=%d: %s", record.ShowID, err)
+		return
+	}
+	if !showEnded(show) {
+		return
+	}
+
+	text := fmt.Sprintf("%s has %s, season %d won't come. I stopped tracking it.", record.ShowName, strings.ToLower(show.Status), record.Season)
+	told := map[int64]bool{}
+	for _, sub := range record.Subscribers {
+		if err := sendNotification(ctx, sub, text, seasonLog(notificationShowEnded, record)); err != nil {
+			logf(ctx, "failed to send show ended notification: show_id=%d season=%d: %s", record.ShowID, record.Season, err)
+		}
+		told[sub.ChatID] = true
+	}
+
+	// Only the chats told are removed, the next refresh tells those that
+	// started tracking it meanwhile
+	err = store.UpdateSeason(ctx, record.ShowID, record.Season, func(tx *SeasonRelease) error {
+		if tx.ShowID == 0 {
+			return errSkipUpdate
+		}
+		var remaining []Subscriber
+		for _, sub := range tx.Subscribers {
+			if !told[sub.ChatID] {
+				remaining = append(remaining, sub)
+			}
+		}
+		tx.Subscribers = remaining
+		return nil
+	})
+	if err != nil {
+		jobStoreFailed(ctx, err, fmt.Sprintf("failed to update season: show_id=%d season=%d", record.ShowID, record.Season))
+	}
+}
+
+// notifySeasons sends the season premiere and episode notifications that came
+// due.
+func notifySeasons(ctx context.Context) {
+	seasons, err := store.Seasons(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all seasons")
+		return
+	}
+
+	now := time.Now()
+	prefs := map[int64]UserPrefs{}
+
+	for _, record := range seasons {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping notify job: %s", ctx.Err())
+			return
+		}
+		// reminded holds the chats reminded of the premiere, episodes the
+		// latest episode notified to each chat
+		reminded := map[int64]bool{}
+		episodes := map[int64]int{}
+
+		for _, sub := range record.Subscribers {
+			p, ok := prefs[sub.ChatID]
+			if !ok {
+				p, err = store.Prefs(ctx, sub.ChatID)
+				if err != nil {
+					jobStoreFailed(ctx, err, "failed to get user prefs")
+					return
+				}
+				prefs[sub.ChatID] = p
+			}
+			if p.notificationsPaused(now) {
+				continue
+			}
+
+			if p.SeasonEpisodes {
+				// Episodes airing within a day, in order. Those already aired
+				// are skipped, the chat may have turned episodes on late.
+				for _, e := range record.Episodes {
+					if e.Number <= sub.LastEpisode || !e.AirDate.After(now) || e.AirDate.After(now.Add(24*time.Hour)) {
+						continue
+					}
+					text := fmt.Sprintf("Episode %d of %s season %d airs on %s. 📺", e.Number, record.ShowName, record.Season, formatReleaseDate(e.AirDate))
+					if err := sendNotification(ctx, sub, text, seasonLog(notificationEpisode, record)); err != nil {
+						logf(ctx, "failed to send episode notification: show_id=%d season=%d: %s", record.ShowID, record.Season, err)
+						break
+					}
+					sub.LastEpisode = e.Number
+					episodes[sub.ChatID] = e.Number
+				}
+				continue
+			}
+
+			remindFrom := now.Add(time.Duration(sub.remindDays()) * 24 * time.Hour)
+			if sub.Notified || record.PremiereDate.IsZero() || !record.PremiereDate.After(now) || !record.PremiereDate.Before(remindFrom) {
+				continue
+			}
+			days := int(math.Ceil(record.PremiereDate.Sub(now).Hours() / 24))
+			text := fmt.Sprintf("Season %d of %s premieres in %d days. 📺", record.Season, record.ShowName, days)
+			if err := sendNotification(ctx, sub, text, seasonLog(notificationReminder, record)); err != nil {
+				logf(ctx, "failed to send season reminder: show_id=%d season=%d: %s", record.ShowID, record.Season, err)
+				continue
+			}
+			reminded[sub.ChatID] = true
+		}
+
+		if len(reminded) == 0 && len(episodes) == 0 {
+			continue
+		}
+		err := store.UpdateSeason(ctx, record.ShowID, record.Season, func(tx *SeasonRelease) error {
+			if tx.ShowID == 0 {
+				return errSkipUpdate
+			}
+			for i, sub := range tx.Subscribers {
+				if reminded[sub.ChatID] {
+					tx.Subscribers[i].Notified = true
+				}
+				if last := episodes[sub.ChatID]; last > sub.LastEpisode {
+					tx.Subscribers[i].LastEpisode = last
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			jobStoreFailed(ctx, err, fmt.Sprintf("failed to update season: show_id=%d season=%d", record.ShowID, record.Season))
+			return
+		}
+	}
+}
+
+// chatSeasons returns the seasons the chat tracks.
+func chatSeasons(ctx context.Context, chatID int64) ([]SeasonRelease, error) {
+	seasons, err := store.Seasons(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracked []SeasonRelease
+	for _, rec := range seasons {
+		for _, sub := range rec.Subscribers {
+			if sub.ChatID == chatID {
+				tracked = append(tracked, rec)
+				break
+			}
+		}
+	}
+	return tracked, nil
+}
+
+// untrackSeason removes the chat from the subscribers of the season.
+func untrackSeason(ctx context.Context, chatID int64, rec SeasonRelease) error {
+	return store.UpdateSeason(ctx, rec.ShowID, rec.Season, func(tx *SeasonRelease) error {
+		var subscribers []Subscriber
+		for _, sub := range tx.Subscribers {
+			if sub.ChatID != chatID {
+				subscribers = append(subscribers, sub)
+			}
+		}
+		if len(subscribers) == len(tx.Subscribers) {
+			return errSkipUpdate
+		}
+		tx.Subscribers = subscribers
+		return nil
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifySeasonsKeepsConcurrentChanges(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+
+	record := SeasonRelease{
+		ShowID:       1,
+		ShowName:     "Severance",
+		Season:       2,
+		PremiereDate: time.Now().AddDate(0, 0, 3),
+		Subscribers:  []Subscriber{{ChatID: 42}},
+	}
+	if err := store.PutSeason(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another chat starts tracking the season while the reminder is sent
+	tg.fail = func(call telegramCall) string {
+		err := store.UpdateSeason(ctx, 1, 2, func(tx *SeasonRelease) error {
+			tx.Subscribers = append(tx.Subscribers, Subscriber{ChatID: 7})
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return ""
+	}
+
+	notifySeasons(ctx)
+
+	got := s.seasons[memSeasonKey(1, 2)].Subscribers
+	if len(got) != 2 {
+		t.Fatalf("subscribers = %+v, want the chat tracking it meanwhile kept", got)
+	}
+	if !got[0].Notified || got[1].Notified {
+		t.Errorf("subscribers = %+v, want only the reminded one notified", got)
+	}
+}
+
+func TestNotifySeasonsEpisodes(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, chatID := range []int64{42, 43} {
+		s.prefs[chatID] = UserPrefs{ChatID: chatID, SeasonEpisodes: true}
+	}
+	record := SeasonRelease{
+		ShowID:       1,
+		ShowName:     "Severance",
+		Season:       2,
+		PremiereDate: now.AddDate(0, 0, -7),
+		Episodes: []SeasonEpisode{
+			{Number: 1, AirDate: now.AddDate(0, 0, -7)},
+			{Number: 2, AirDate: now.Add(12 * time.Hour)},
+			{Number: 3, AirDate: now.AddDate(0, 0, 7)},
+		},
+		Subscribers: []Subscriber{{ChatID: 42}, {ChatID: 43}},
+	}
+	if err := store.PutSeason(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	tg.fail = func(call telegramCall) string {
+		if call.Params.Get("chat_id") == "43" {
+			return "Internal Server Error"
+		}
+		return ""
+	}
+
+	notifySeasons(ctx)
+
+	// The episode already aired isn't notified
+	if texts := tg.texts(42); len(texts) != 1 || !strings.HasPrefix(texts[0], "Episode 2 of Severance season 2") {
+		t.Errorf("sent %q, want only the episode airing within a day", texts)
+	}
+	got := s.seasons[memSeasonKey(1, 2)].Subscribers
+	if got[0].LastEpisode != 2 || got[0].Notified {
+		t.Errorf("notified chat = %+v, want the episode marked and no premiere reminder", got[0])
+	}
+	if got[1].LastEpisode != 0 || got[1].Notified {
+		t.Errorf("chat the send failed to = %+v, want nothing marked", got[1])
+	}
+}
+
+func TestHandleTrackNextSeason(t *testing.T) {
+	past := time.Now().AddDate(-1, 0, 0).Format("2006-01-02")
+	future := time.Now().AddDate(0, 2, 0).Format("2006-01-02")
+	tests := []struct {
+		name    string
+		status  string
+		seasons []TVSeason
+		want    int
+	}{
+		{"latest aired", "Returning Series", []TVSeason{{SeasonNumber: 1, AirDate: past}, {SeasonNumber: 2, AirDate: past}}, 3},
+		{"latest upcoming", "Returning Series", []TVSeason{{SeasonNumber: 1, AirDate: past}, {SeasonNumber: 2, AirDate: future}}, 2},
+		{"latest announced without a date", "Returning Series", []TVSeason{{SeasonNumber: 1, AirDate: past}, {SeasonNumber: 2}}, 2},
+		{"specials only", "Returning Series", []TVSeason{{SeasonNumber: 0}}, 1},
+		{"ended", "Ended", []TVSeason{{SeasonNumber: 1, AirDate: past}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useMemStore(t)
+			tg := useFakeTelegram(t)
+			f := useFakeTMDB(t)
+			f.route("/search/tv", map[string]interface{}{"results": []TVAPIResult{{ID: 5, Name: "Severance"}}})
+			f.route("/tv/5", TVShow{ID: 5, Name: "Severance", Status: tt.status, Seasons: tt.seasons})
+			for _, season := range tt.seasons {
+				f.route(fmt.Sprintf("/tv/5/season/%d", season.SeasonNumber), season)
+			}
+
+			handleTrackNextSeason(context.Background(), testMessage(42, "track next season severance"), []string{"", "severance"})
+
+			if tt.want == 0 {
+				if len(s.seasons) != 0 {
+					t.Errorf("tracked %+v, want nothing for an ended show", s.seasons)
+				}
+				if texts := tg.texts(42); len(texts) != 1 || !strings.Contains(texts[0], "no new season is expected") {
+					t.Errorf("sent %q, want the show ended", texts)
+				}
+				return
+			}
+			record, ok := s.seasons[memSeasonKey(5, tt.want)]
+			if !ok || len(s.seasons) != 1 {
+				t.Fatalf("tracked %+v, want season %d", s.seasons, tt.want)
+			}
+			if len(record.Subscribers) != 1 || record.Subscribers[0].ChatID != 42 {
+				t.Errorf("subscribers = %+v, want the chat", record.Subscribers)
+			}
+		})
+	}
+}
+
+func TestEndTrackingKeepsNewTrackers(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	f := useFakeTMDB(t)
+	ctx := context.Background()
+
+	f.route("/tv/5", TVShow{ID: 5, Name: "Severance", Status: "Canceled", Seasons: []TVSeason{{SeasonNumber: 1}, {SeasonNumber: 2}}})
+	record := SeasonRelease{ShowID: 5, ShowName: "Severance", Season: 3, Subscribers: []Subscriber{{ChatID: 42}}}
+	if err := store.PutSeason(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	// Another chat starts tracking the season while the first one is told
+	tg.fail = func(call telegramCall) string {
+		err := store.UpdateSeason(ctx, 5, 3, func(tx *SeasonRelease) error {
+			tx.Subscribers = append(tx.Subscribers, Subscriber{ChatID: 7})
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return ""
+	}
+
+	refreshSeasons(ctx)
+
+	if texts := tg.texts(42); len(texts) != 1 || !strings.Contains(texts[0], "won't come") {
+		t.Errorf("sent %q, want the chat told the show was canceled", texts)
+	}
+	got := s.seasons[memSeasonKey(5, 3)].Subscribers
+	if len(got) != 1 || got[0].ChatID != 7 {
+		t.Errorf("subscribers = %+v, want only the chat tracking it meanwhile", got)
+	}
+}
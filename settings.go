@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// isChatMember returns whether the user is a current member of the chat.
+func isChatMember(chatID int64, userID int) (bool, error) {
+	member, err := bot.GetChatMember(telegram.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return false, err
+	}
+	return member.IsCreator() || member.IsAdministrator() || member.IsMember(), nil
+}
+
+// isChatAdmin returns whether the user is an administrator or the creator of
+// the chat.
+func isChatAdmin(chatID int64, userID int) (bool, error) {
+	member, err := bot.GetChatMember(telegram.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return false, err
+	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
+// requireGroupAdmin checks that the sender of the message is an admin of the
+// chat when it is a group or a channel, telling them otherwise that only
+// admins can do what, e.g. "change the muted words". It returns whether the
+// command can proceed.
+func requireGroupAdmin(ctx context.Context, msg *telegram.Message, what string) bool {
+	if msg.Chat.IsPrivate() {
+		return true
+	}
+	if msg.From == nil {
+		return false
+	}
+	admin, err := isChatAdmin(msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		logf(ctx, "failed to get chat membership: %s", err)
+		sendMsg(ctx, telegram.NewMessage(msg.Chat.ID, "I couldn't check whether you are an admin of this group, try again later."))
+		return false
+	}
+	if !admin {
+		sendMsg(ctx, telegram.NewMessage(msg.Chat.ID, "Only group admins can "+what+"."))
+		return false
+	}
+	return true
+}
+
+// validateNotifyChat checks that notifications can be delivered to the target
+// chat on behalf of the given user: the bot must be able to post there and
+// the user must be a member of it.
+func validateNotifyChat(targetID int64, userID int) error {
+	chat, err := bot.GetChat(telegram.ChatConfig{ChatID: targetID})
+	if err != nil {
+		return errors.Wrap(err, "unknown chat")
+	}
+	if chat.IsPrivate() {
+		if int64(userID) != chat.ID {
+			return errors.New("private chat of another user")
+		}
+		return nil
+	}
+
+	botMember, err := bot.GetChatMember(telegram.ChatConfigWithUser{ChatID: targetID, UserID: bot.Self.ID})
+	if err != nil {
+		return errors.Wrap(err, "failed to get bot membership")
+	}
+	if chat.IsChannel() && !botMember.CanPostMessages && !botMember.IsCreator() {
+		return errors.New("bot cannot post in the channel")
+	}
+	if !chat.IsChannel() && !botMember.IsCreator() && !botMember.IsAdministrator() && !botMember.IsMember() {
+		return errors.New("bot is not a member of the chat")
+	}
+
+	ok, err := isChatMember(targetID, userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user membership")
+	}
+	if !ok {
+		return errors.New("user is not a member of the chat")
+	}
+	return nil
+}
+
+func handleNotifyChat(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	var target int64
+	if matches[1] == "set" {
+		if matches[2] == "" {
+			sendMsg(ctx, telegram.NewMessage(chatID, "Which chat? Use \"set notify chat <chat id>\"."))
+			return
+		}
+		id, err := strconv.ParseInt(matches[2], 10, 64)
+		if err != nil {
+			sendMsg(ctx, telegram.NewMessage(chatID, "That doesn't look like a chat ID."))
+			return
+		}
+		if update.Message.From == nil {
+			return
+		}
+		if err := validateNotifyChat(id, update.Message.From.ID); err != nil {
+			logf(ctx, "invalid notify chat %d: %s", id, err)
+			sendMsg(ctx, telegram.NewMessage(chatID, "I can't send messages to that chat. Add me to it first, and make sure you are a member too."))
+			return
+		}
+		if id != chatID {
+			target = id
+		}
+	}
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.NotifyChatID = target
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	// Existing subscriptions follow the new preference
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+	for _, rec := range subscriptions {
+		err := updateSubscriber(ctx, rec.ID, chatID, func(sub *Subscriber) {
+			sub.NotifyChatID = target
+		})
+		if err != nil {
+			storeFailed(ctx, chatID, err, "failed to update subscription")
+			return
+		}
+	}
+
+	text := "Notifications will be sent to this chat."
+	if target != 0 {
+		text = fmt.Sprintf("Notifications will be sent to chat %d. If I can't reach it I'll fall back to this chat.", target)
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// soonestLimit is the number of releases listed by the soonest command.
+const soonestLimit = 10
+
+// soonestReleases returns the next releases among the upcoming movies, sorted
+// by date, at most soonestLimit.
+func soonestReleases(results MovieAPIResults, now time.Time) MovieAPIResults {
+	var upcoming MovieAPIResults
+	for _, m := range results {
+		if m.ReleaseTime.After(now) {
+			upcoming = append(upcoming, m)
+		}
+	}
+	sort.Stable(upcoming)
+	if len(upcoming) > soonestLimit {
+		upcoming = upcoming[:soonestLimit]
+	}
+	return upcoming
+}
+
+// handleSoonest lists the next movies coming out in the chat region, whether
+// subscribed or not. The upcoming list of each region is served from the
+// TMDB response cache, see tmdbCache.
+func handleSoonest(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get user prefs")
+		return
+	}
+
+	results, err := upcomingMovies(ctx, prefs.region())
+	if err != nil {
+		tmdbFailed(ctx, chatID, err, "failed to get upcoming movies")
+		return
+	}
+
+	now := time.Now()
+	soonest := soonestReleases(results, now)
+	if len(soonest) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I couldn't find any upcoming release, try again later 🤷"))
+		return
+	}
+
+	text := fmt.Sprintf("Coming out soonest in %s 🍿:\n", regionLabel(prefs.region()))
+	for _, m := range soonest {
+		text += fmt.Sprintf("- %s (%s)\n", displayTitle(m.Title, m.ID), prefs.formatDate(m.ReleaseTime))
+	}
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ReplyMarkup = resultsKeyboard(soonest, now)
+	sendMsg(ctx, msgConfig)
+}
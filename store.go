@@ -0,0 +1,1737 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/datastore"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// EntityMovieReleases ...
+	EntityMovieReleases = "MovieReleases"
+
+	kindMovieRelease = "MovieRelease"
+	kindUserPrefs    = "UserPrefs"
+	kindDiagnostic   = "Diagnostic"
+	kindNotifyLease  = "NotifyLease"
+	kindSeason       = "SeasonRelease"
+	kindSearch       = "SearchedMovie"
+	kindSubscription = "Subscription"
+	kindCalendarLink = "CalendarLink"
+	kindUser         = "User"
+	kindNotification = "NotificationLog"
+	kindFollowed     = "FollowedPerson"
+	kindTransfer     = "Transfer"
+	kindLinkedChat   = "LinkedChat"
+	// kindChat is only used for the parent keys of the Subscription
+	// entities, no chat entity is stored.
+	kindChat = "Chat"
+
+	// maxReleaseHistory is the number of changes kept in the history of a
+	// movie release.
+	maxReleaseHistory = 20
+
+	// subscriptionsVersion is the layout of the Subscription entities: 1
+	// stored them as children of the release, 2 as children of the chat.
+	subscriptionsVersion = 2
+
+	// maxBatchSize is the maximum number of entities of a datastore batch
+	// operation.
+	maxBatchSize = 500
+
+	// defaultEmulatorProjectID is used when talking to a local datastore
+	// emulator without an explicit DATASTORE_PROJECT_ID.
+	defaultEmulatorProjectID = "movie-releases-bot-dev"
+)
+
+// Subscriber ...
+type Subscriber struct {
+	Notified bool
+	ChatID   int64
+	// NotifyChatID is the chat notifications are sent to, when it differs
+	// from the chat used to subscribe.
+	NotifyChatID int64
+	// RemindDays is how many days before the release the subscriber is
+	// notified, zero for defaultRemindDays.
+	RemindDays int
+	// CreatedAt is when the chat subscribed, zero for subscriptions older
+	// than the field.
+	CreatedAt time.Time
+	// Region is the region whose release date the subscription follows,
+	// empty for the worldwide date. RegionDate is the release date there,
+	// zero while unknown.
+	Region     string
+	RegionDate time.Time
+	// Regions is the region group the subscription follows, see
+	// UserPrefs.Regions. Region is then the one releasing first, empty
+	// until one of them has a date.
+	Regions []string
+	// DatePolicy is the date policy that picked Region, see
+	// UserPrefs.DatePolicy. The top-level date is followed while there is
+	// no date in Region. Empty when the chat chose the region.
+	DatePolicy string
+	// ThreadID is the forum topic of the group the chat subscribed from,
+	// where notifications are posted. Zero for the general thread.
+	ThreadID int
+	// QueriedAt is when the chat last searched for the movie, only recorded
+	// for chats opted in to light notifications.
+	QueriedAt time.Time
+
+	// Trailers enables trailer notifications for this subscription only.
+	Trailers bool
+	// TrailersSeeded is set once the trailers existing at opt-in time have
+	// been recorded, so that only newly added trailers are notified.
+	TrailersSeeded bool
+	// SeenTrailers holds the video keys of the trailers already seen.
+	SeenTrailers []string
+
+	// StreamingProviders are the streaming services listing the movie in
+	// StreamingRegion at the previous refresh, recorded for chats opted in
+	// to leaving streaming notifications.
+	StreamingRegion    string
+	StreamingProviders []string
+
+	// LastEpisode is the number of the latest episode notified, for season
+	// subscriptions notifying every episode.
+	LastEpisode int
+
+	// CountdownMessageID is the countdown message edited daily until the
+	// release, zero when there is no countdown.
+	CountdownMessageID int
+	// CountdownPinned is set when the countdown message could be pinned.
+	CountdownPinned bool
+}
+
+// SearchedMovie is an undated movie found by a search of a chat opted in to
+// surprise notifications, see refreshSearches.
+type SearchedMovie struct {
+	ChatID     int64
+	MovieID    int64
+	MovieTitle string
+	SearchedAt time.Time
+}
+
+// ReleaseChange is a change to a movie release detected during refresh.
+type ReleaseChange struct {
+	Field     string
+	From      string
+	To        string
+	ChangedAt time.Time
+}
+
+// MovieRelease ...
+type MovieRelease struct {
+	ID          int64
+	MovieTitle  string
+	ReleaseDate time.Time
+	// Status is the TMDB production status, e.g. "Post Production".
+	Status string
+	// Runtime is the runtime of the movie in minutes, zero while unknown.
+	Runtime int
+	// PosterPath is the TMDB path of the poster of the movie, empty if it
+	// has none.
+	PosterPath  string
+	Subscribers []Subscriber
+	// History holds the latest changes, oldest first.
+	History []ReleaseChange
+	// MissingSince is when TMDB stopped knowing the movie and no replacing
+	// entry could be found, see remapRelease. Zero while TMDB knows it.
+	MissingSince time.Time
+	// SubscriptionsMigrated was set once the Subscription entities mirrored
+	// the subscribers as children of the release, kept so that the stored
+	// releases still load. See SubscriptionsVersion.
+	SubscriptionsMigrated bool
+	// SubscriptionsVersion is the subscriptionsVersion of the Subscription
+	// entities mirroring the subscribers, zero until migrated, see
+	// syncSubscriptions.
+	SubscriptionsVersion int
+}
+
+// Subscription is the subscription of a chat to a movie release, stored as
+// a child of the chat key so that the subscriptions of a chat are read with
+// a strongly consistent ancestor query, see ChatReleases. Subscriptions
+// mirror MovieRelease.Subscribers, which stays the source of truth.
+type Subscription struct {
+	MovieID int64
+	Subscriber
+}
+
+// clone returns a copy of the release sharing no slice with r, those of its
+// subscribers included, so that the copy can be kept in memory and handed to
+// concurrent commands.
+func (r MovieRelease) clone() MovieRelease {
+	if r.Subscribers != nil {
+		subscribers := make([]Subscriber, len(r.Subscribers))
+		for i, sub := range r.Subscribers {
+			sub.Regions = cloneStrings(sub.Regions)
+			sub.SeenTrailers = cloneStrings(sub.SeenTrailers)
+			sub.StreamingProviders = cloneStrings(sub.StreamingProviders)
+			subscribers[i] = sub
+		}
+		r.Subscribers = subscribers
+	}
+	if r.History != nil {
+		r.History = append(make([]ReleaseChange, 0, len(r.History)), r.History...)
+	}
+	return r
+}
+
+// cloneStrings returns a copy of s, nil if s is nil so that copies compare
+// equal to the original, see changedSubscribers.
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// subscribed returns whether the chat is among the subscribers of r.
+func (r MovieRelease) subscribed(chatID int64) bool {
+	for _, sub := range r.Subscribers {
+		if sub.ChatID == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// addHistory appends changes to the history, dropping the oldest entries
+// beyond maxReleaseHistory.
+func (r *MovieRelease) addHistory(changes ...ReleaseChange) {
+	r.History = append(r.History, changes...)
+	if len(r.History) > maxReleaseHistory {
+		r.History = r.History[len(r.History)-maxReleaseHistory:]
+	}
+}
+
+// SeasonEpisode is an episode of a tracked season.
+type SeasonEpisode struct {
+	Number  int
+	AirDate time.Time
+}
+
+// SeasonRelease is a tracked season of a TV show.
+type SeasonRelease struct {
+	ShowID   int64
+	ShowName string
+	Season   int
+	// PremiereDate is the air date of the first episode, zero while the
+	// season isn't announced.
+	PremiereDate time.Time
+	Episodes     []SeasonEpisode
+	Subscribers  []Subscriber
+}
+
+// UserPrefs holds the settings of a chat.
+type UserPrefs struct {
+	ChatID int64
+	// NotifyChatID is the chat new subscriptions send their notifications
+	// to, zero for the chat itself.
+	NotifyChatID int64
+	// NotifyChannel is the name of the channel notifications are delivered
+	// through, empty for defaultChannel.
+	NotifyChannel string
+	// SilentNotifications delivers notifications without sound or
+	// vibration.
+	SilentNotifications bool
+	// Trailers enables trailer notifications for all subscriptions.
+	Trailers bool
+	// SeasonEpisodes notifies every episode of tracked seasons instead of
+	// the season premiere only.
+	SeasonEpisodes bool
+	// LightNotifications skips the reminder of movies the chat searched for
+	// within lightQueryWindow, it clearly knows about the release already.
+	LightNotifications bool
+	// LeavingStreaming notifies when a streaming service no longer lists one
+	// of the subscriptions in the region of the chat.
+	LeavingStreaming bool
+	// SurpriseNotifications records the undated movies found by searches to
+	// notify the chat once they get a release date.
+	SurpriseNotifications bool
+	// MonthlySummary sends a summary of the subscriptions at the start of
+	// every month. LastMonthlySummary is when the latest one was sent.
+	MonthlySummary     bool
+	LastMonthlySummary time.Time
+
+	// NotificationsPaused suspends release notifications until resumed, or
+	// until PausedUntil when it is set.
+	NotificationsPaused bool
+	PausedUntil         time.Time
+	// PausedAt is the start of the latest pause. Releases that came out since
+	// then without a notification are delivered on resume.
+	PausedAt time.Time
+
+	// Region is the ISO 3166-1 code of the region release dates are looked
+	// up for, empty for defaultRegion.
+	Region string
+	// Regions is the region group of the chat: new subscriptions follow the
+	// earliest release date among them, see earliestRegionDate. Empty to
+	// follow the date policy.
+	Regions []string
+	// DatePolicy is which release date the subscriptions not given a region
+	// follow, one of datePolicyPrimary, datePolicyRegion or
+	// datePolicyEarliest. Empty for datePolicyRegion.
+	DatePolicy string
+	// Timezone is the IANA name of the timezone of the chat, empty for the
+	// one of its region.
+	Timezone string
+	// DateFormat is how dates are displayed, one of dateFormatDMY,
+	// dateFormatMDY or dateFormatISO. Empty for the default of the region.
+	DateFormat string
+	// TimeFormat is how times of day are displayed, timeFormat12h or
+	// timeFormat24h. Empty for the default of the region.
+	TimeFormat string
+	// DeliveryTime is the time of day reminders are sent at in the timezone
+	// of the chat, e.g. "08:00". Empty to send them whenever they are due.
+	DeliveryTime string
+	// DefaultYear is the year release searches are restricted to when they
+	// don't give one, 0 for none.
+	DefaultYear int
+	// HideUndated leaves the movies without a release date out of search
+	// results.
+	HideUndated bool
+	// CastPhotos sends the photos of the cast along with movie details.
+	CastPhotos bool
+	// Posters confirms subscriptions with a card showing the poster of the
+	// movie, see sendConfirmationCard.
+	Posters bool
+	// ListOrder is how subscriptions are listed, one of listOrderSoonest,
+	// listOrderAdded or listOrderAlpha. Empty for listOrderSoonest.
+	ListOrder string
+	// MutedWords are the words that make the bot ignore group messages
+	// containing them, unless it is mentioned.
+	MutedWords []string
+	// FavoriteGenreID is the TMDB ID of the genre shown by the discover
+	// command, zero when unset.
+	FavoriteGenreID int
+	// TMDBKey is the TMDB API key of the chat used instead of the shared one,
+	// encrypted with TMDB_KEY_SECRET, see sealTMDBKey. Empty for the shared
+	// key.
+	TMDBKey []byte `datastore:",noindex"`
+	// RefusedNotifications is how many notifications in a row Telegram
+	// refused to deliver to the chat, e.g. because the user blocked the bot.
+	// The data of the chat is deleted once it reaches blockedChatThreshold,
+	// see cleanupBlockedChats.
+	RefusedNotifications int
+
+	// UpcomingTemplate and ReleasedTemplate override the notification
+	// templates, see renderNotification.
+	UpcomingTemplate string `datastore:",noindex"`
+	ReleasedTemplate string `datastore:",noindex"`
+}
+
+// CalendarLink connects a chat to the Google Calendar of the user, see
+// syncCalendar.
+type CalendarLink struct {
+	ChatID int64
+	// State authenticates the OAuth callback while connecting, see
+	// handleConnectCalendar. Empty once connected.
+	State string `datastore:",noindex"`
+	// Token is the OAuth token of the user, encrypted with
+	// CALENDAR_TOKEN_KEY. Empty until connected.
+	Token       []byte `datastore:",noindex"`
+	ConnectedAt time.Time
+	// Events are the calendar events created for the subscriptions of the
+	// chat.
+	Events []CalendarEvent
+}
+
+// User links the chats of a Telegram user, see handleLinkChat.
+type User struct {
+	// UserID is the Telegram ID of the user.
+	UserID int64
+	// ChatIDs are the chats linked to the user. A chat is linked to a single
+	// user.
+	ChatIDs []int64
+	// PrimaryChatID is the linked chat receiving the notifications of all
+	// the linked chats, 0 for each of them to receive them.
+	PrimaryChatID int64
+	LinkedAt      time.Time
+}
+
+// LinkedChat records the user a chat is linked to, so that the user of a
+// chat is read by key. The entities are written along with the User, see
+// syncLinkedChats.
+type LinkedChat struct {
+	ChatID int64
+	UserID int64
+}
+
+// errChatLinked is returned by UpdateUser when fn links a chat that is
+// linked to another user.
+var errChatLinked = errors.New("chat linked to another user")
+
+// NotificationLog records a notification sent to a chat, see
+// sendNotification. Logs are deleted after notificationLogRetention.
+type NotificationLog struct {
+	ChatID int64
+	// MovieID is the TMDB ID of the movie, or of the TV show, the
+	// notification is about. 0 for notifications about several movies, such
+	// as monthly summaries.
+	MovieID int64  `datastore:",noindex"`
+	Title   string `datastore:",noindex"`
+	// Kind is the kind of notification, e.g. notificationReminder.
+	Kind   string `datastore:",noindex"`
+	SentAt time.Time
+}
+
+// FollowedPerson is a person, e.g. an actor or a director, a chat follows to
+// be told about their new movies.
+type FollowedPerson struct {
+	ChatID int64
+	// PersonID is the TMDB ID of the person.
+	PersonID int64  `datastore:",noindex"`
+	Name     string `datastore:",noindex"`
+	// KnownMovies are the TMDB IDs of the upcoming movies of the person the
+	// chat was told about, DatedMovies the ones among them it was told the
+	// release date of.
+	KnownMovies []int64   `datastore:",noindex"`
+	DatedMovies []int64   `datastore:",noindex"`
+	FollowedAt  time.Time `datastore:",noindex"`
+}
+
+// CalendarEvent is the Google Calendar event created for the release of a
+// movie.
+type CalendarEvent struct {
+	MovieID int64
+	EventID string `datastore:",noindex"`
+	// Title and Date are the ones of the event, to know when it is outdated.
+	Title string    `datastore:",noindex"`
+	Date  time.Time `datastore:",noindex"`
+}
+
+// errSkipUpdate can be returned by the function given to UpdateRelease to
+// leave the stored release unchanged.
+var errSkipUpdate = errors.New("skip update")
+
+// Store gives access to the persisted movie releases and their subscribers.
+// It is implemented by datastoreStore, other implementations can be
+// substituted for local development and tests.
+type Store interface {
+	// Releases returns all stored movie releases.
+	Releases(ctx context.Context) ([]MovieRelease, error)
+	// Release returns the stored movie release, a zero MovieRelease if none
+	// is stored.
+	Release(ctx context.Context, id int64) (MovieRelease, error)
+	// PutRelease creates or replaces the stored movie release.
+	PutRelease(ctx context.Context, release MovieRelease) error
+	// UpdateRelease calls fn with the stored movie release identified by id
+	// and saves the result, all within a single transaction. fn is given a
+	// zero MovieRelease when no release is stored yet. Nothing is saved if fn
+	// returns errSkipUpdate.
+	UpdateRelease(ctx context.Context, id int64, fn func(release *MovieRelease) error) error
+	// DeleteRelease deletes the stored movie release if check returns true
+	// for it, within a single transaction. It returns whether the release was
+	// deleted.
+	DeleteRelease(ctx context.Context, id int64, check func(release MovieRelease) bool) (bool, error)
+	// MigrateRelease creates the Subscription entities of the stored movie
+	// release unless already done. It returns whether the release was
+	// migrated.
+	MigrateRelease(ctx context.Context, id int64) (bool, error)
+	// ChatReleases returns the migrated movie releases the chat is
+	// subscribed to, reflecting every committed write unlike Releases.
+	ChatReleases(ctx context.Context, chatID int64) ([]MovieRelease, error)
+
+	// Seasons returns all tracked TV show seasons.
+	Seasons(ctx context.Context) ([]SeasonRelease, error)
+	// PutSeason creates or replaces the stored season.
+	PutSeason(ctx context.Context, season SeasonRelease) error
+	// UpdateSeason is like UpdateRelease for the season of a TV show.
+	UpdateSeason(ctx context.Context, showID int64, number int, fn func(season *SeasonRelease) error) error
+
+	// Searches returns all recorded movie searches.
+	Searches(ctx context.Context) ([]SearchedMovie, error)
+	// PutSearches creates or replaces the given searches.
+	PutSearches(ctx context.Context, searches []SearchedMovie) error
+	// DeleteSearches deletes the given searches, if stored.
+	DeleteSearches(ctx context.Context, searches []SearchedMovie) error
+
+	// CalendarLinks returns the Google Calendar links of every chat.
+	CalendarLinks(ctx context.Context) ([]CalendarLink, error)
+	// CalendarLink returns the Google Calendar link of the chat, or an empty
+	// link if none is stored.
+	CalendarLink(ctx context.Context, chatID int64) (CalendarLink, error)
+	// PutCalendarLink creates or replaces the stored link of a chat.
+	PutCalendarLink(ctx context.Context, link CalendarLink) error
+	// DeleteCalendarLink deletes the stored link of a chat, if any.
+	DeleteCalendarLink(ctx context.Context, chatID int64) error
+
+	// Users returns every user having linked chats.
+	Users(ctx context.Context) ([]User, error)
+	// User returns the user, without linked chats if none is stored.
+	User(ctx context.Context, userID int64) (User, error)
+	// ChatUser returns the user the chat is linked to, a zero User if none.
+	ChatUser(ctx context.Context, chatID int64) (User, error)
+	// PutNotificationLogs stores the logs of sent notifications.
+	PutNotificationLogs(ctx context.Context, logs []NotificationLog) error
+	// NotificationLogs returns the logged notifications of the chat.
+	NotificationLogs(ctx context.Context, chatID int64) ([]NotificationLog, error)
+	// DeleteNotificationLogs deletes the logs of notifications sent before
+	// the given time, of the given chat unless chatID is 0. It returns how
+	// many were deleted.
+	DeleteNotificationLogs(ctx context.Context, chatID int64, before time.Time) (int, error)
+
+	// FollowedPeople returns the people followed by the chat, by every chat
+	// if chatID is 0.
+	FollowedPeople(ctx context.Context, chatID int64) ([]FollowedPerson, error)
+	// PutFollowedPerson creates or replaces the stored followed person.
+	PutFollowedPerson(ctx context.Context, person FollowedPerson) error
+	// DeleteFollowedPerson stops the chat following the person, if it does.
+	DeleteFollowedPerson(ctx context.Context, chatID, personID int64) error
+
+	// PutUser creates or replaces the stored user.
+	PutUser(ctx context.Context, user User) error
+	// UpdateUser is like UpdateRelease for a user, fn is given a user
+	// without linked chats if none is stored. The user is deleted once it
+	// has no linked chats left. It returns errChatLinked if fn linked a chat
+	// of another user.
+	UpdateUser(ctx context.Context, userID int64, fn func(user *User) error) error
+
+	// Prefs returns the preferences of the chat, or the defaults if none are
+	// stored.
+	Prefs(ctx context.Context, chatID int64) (UserPrefs, error)
+	// PutPrefs creates or replaces the stored preferences of a chat.
+	PutPrefs(ctx context.Context, prefs UserPrefs) error
+	// UpdatePrefs is like UpdateRelease for the preferences of a chat, fn
+	// is given the defaults if none are stored.
+	UpdatePrefs(ctx context.Context, chatID int64, fn func(prefs *UserPrefs) error) error
+	// DeletePrefs deletes the stored preferences of a chat, if any.
+	DeletePrefs(ctx context.Context, chatID int64) error
+	// RefusingChats returns the preferences of the chats that refused at
+	// least n notifications in a row, see UserPrefs.RefusedNotifications.
+	RefusingChats(ctx context.Context, n int) ([]UserPrefs, error)
+
+	// AcquireLease takes or renews the named lease for owner until expiresAt,
+	// unless another owner holds an unexpired lease. It returns whether owner
+	// holds the lease.
+	AcquireLease(ctx context.Context, name, owner string, expiresAt time.Time) (bool, error)
+	// ReleaseLease gives up the named lease if owner holds it.
+	ReleaseLease(ctx context.Context, name, owner string) error
+
+	// PutTransfer stores the pending transfer under its code, replacing the
+	// pending transfers from the same chat.
+	PutTransfer(ctx context.Context, code string, transfer Transfer) error
+	// Transfer returns the pending transfer of the code, a zero Transfer if
+	// there is none or it expired.
+	Transfer(ctx context.Context, code string) (Transfer, error)
+	// DeleteTransfer deletes the transfer of the code, if any.
+	DeleteTransfer(ctx context.Context, code string) error
+	// DeleteExpiredTransfers deletes the transfers that expired before the
+	// given time. It returns how many were deleted.
+	DeleteExpiredTransfers(ctx context.Context, before time.Time) (int, error)
+	// DeleteChatTransfers deletes the pending transfers from or to the
+	// chat.
+	DeleteChatTransfers(ctx context.Context, chatID int64) error
+
+	// Export calls fn with a pointer to every stored movie release, season,
+	// preferences, search, calendar link, user and followed person, one
+	// entity at a time so that they are never all loaded in memory.
+	// Subscription and LinkedChat entities are left out, PutRelease and
+	// PutUser derive them from the release and the user.
+	Export(ctx context.Context, fn func(entity interface{}) error) error
+
+	// Check writes, reads back and deletes a disposable entity to verify the
+	// store is reachable.
+	Check(ctx context.Context) error
+}
+
+// datastoreStore is a Store backed by GCP datastore.
+type datastoreStore struct {
+	client *datastore.Client
+}
+
+func newDatastoreStore(client *datastore.Client) *datastoreStore {
+	return &datastoreStore{client: client}
+}
+
+// newDatastoreClient creates a datastore client. When DATASTORE_EMULATOR_HOST
+// is set the client connects to the local emulator instead of GCP, no
+// credentials are needed in that case.
+//
+// The project is resolved upfront rather than left to the client
+// auto-detection, and must match EXPECTED_PROJECT_ID when set, so that the
+// bot never silently reads and writes the data of another project.
+func newDatastoreClient(ctx context.Context) (*datastore.Client, error) {
+	projectID := os.Getenv("DATASTORE_PROJECT_ID")
+
+	if host := os.Getenv("DATASTORE_EMULATOR_HOST"); host != "" {
+		if projectID == "" {
+			projectID = defaultEmulatorProjectID
+		}
+		log.Printf("Using datastore emulator at %s (project %s)", host, projectID)
+	} else {
+		resolved, err := resolveProjectID(projectID)
+		if err != nil {
+			return nil, err
+		}
+		projectID = resolved
+		log.Printf("Using datastore of project %s", projectID)
+	}
+
+	if expected := os.Getenv("EXPECTED_PROJECT_ID"); expected != "" && expected != projectID {
+		return nil, errors.Errorf("datastore project %q doesn't match EXPECTED_PROJECT_ID %q", projectID, expected)
+	}
+
+	client, err := datastore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create datastore client")
+	}
+	return client, nil
+}
+
+// resolveProjectID returns the GCP project to use: the configured one if
+// any, else the one of the environment, from GOOGLE_CLOUD_PROJECT or the
+// metadata server.
+func resolveProjectID(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	if id := os.Getenv("GOOGLE_CLOUD_PROJECT"); id != "" {
+		return id, nil
+	}
+	if metadata.OnGCE() {
+		id, err := metadata.ProjectID()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get project ID from the metadata server")
+		}
+		if id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.New("no datastore project configured, set DATASTORE_PROJECT_ID")
+}
+
+func releaseKey(id int64) *datastore.Key {
+	return datastore.NameKey(kindMovieRelease, fmt.Sprintf("%d", id), nil)
+}
+
+func (s *datastoreStore) Releases(ctx context.Context) ([]MovieRelease, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var records []MovieRelease
+	err := retryRead(ctx, func() error {
+		records = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindMovieRelease), &records)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get all movie releases")
+	}
+	return records, nil
+}
+
+func (s *datastoreStore) Release(ctx context.Context, id int64) (MovieRelease, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var record MovieRelease
+	err := retryRead(ctx, func() error {
+		return s.client.Get(ctx, releaseKey(id), &record)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return MovieRelease{}, nil
+	}
+	if err != nil {
+		return MovieRelease{}, errors.Wrapf(err, "failed to get movie release %d", id)
+	}
+	return record, nil
+}
+
+func (s *datastoreStore) PutRelease(ctx context.Context, release MovieRelease) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := releaseKey(release.ID)
+	err := s.migratingFirst(ctx, release.ID, func() error {
+		_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var stored MovieRelease
+			if err := tx.Get(key, &stored); err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			if err := syncSubscriptions(tx, stored, &release); err != nil {
+				return err
+			}
+			_, err := tx.Put(key, &release)
+			return err
+		})
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to put movie release %d", release.ID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) UpdateRelease(ctx context.Context, id int64, fn func(release *MovieRelease) error) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := releaseKey(id)
+	err := s.migratingFirst(ctx, id, func() error {
+		_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var release MovieRelease
+
+			// Try to get a stored record
+			err := tx.Get(key, &release)
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			stored := release.clone()
+
+			if err := fn(&release); err != nil {
+				return err
+			}
+
+			if err := syncSubscriptions(tx, stored, &release); err != nil {
+				return err
+			}
+			_, err = tx.Put(key, &release)
+			return err
+		})
+		return err
+	})
+	if err == errSkipUpdate {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to update movie release %d", id)
+	}
+	return nil
+}
+
+func chatKey(chatID int64) *datastore.Key {
+	return datastore.NameKey(kindChat, fmt.Sprintf("%d", chatID), nil)
+}
+
+func subscriptionKey(chatID, releaseID int64) *datastore.Key {
+	return datastore.NameKey(kindSubscription, fmt.Sprintf("%d", releaseID), chatKey(chatID))
+}
+
+// errNotMigrated is returned by syncSubscriptions for a release whose
+// Subscription entities have an older layout.
+var errNotMigrated = errors.New("subscriptions of the release not migrated")
+
+// syncSubscriptions makes the Subscription entities of the release match its
+// subscribers within the transaction. Only the subscribers that differ from
+// stored, the release as read by the transaction, are written, and those of
+// the chats no longer subscribed deleted, so that the transaction stays well
+// within the mutation limit of Datastore. Releases stored with an older
+// layout return errNotMigrated, they must be migrated first, see
+// migratingFirst.
+func syncSubscriptions(tx *datastore.Transaction, stored MovieRelease, release *MovieRelease) error {
+	if stored.ID != 0 && stored.SubscriptionsVersion < subscriptionsVersion {
+		return errNotMigrated
+	}
+
+	changed, removed := changedSubscribers(stored, *release)
+	keys := make([]*datastore.Key, len(changed))
+	subscriptions := make([]Subscription, len(changed))
+	for i, sub := range changed {
+		keys[i] = subscriptionKey(sub.ChatID, release.ID)
+		subscriptions[i] = Subscription{MovieID: release.ID, Subscriber: sub}
+	}
+	stale := make([]*datastore.Key, len(removed))
+	for i, chatID := range removed {
+		stale[i] = subscriptionKey(chatID, release.ID)
+	}
+
+	if len(keys) > 0 {
+		if _, err := tx.PutMulti(keys, subscriptions); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		if err := tx.DeleteMulti(stale); err != nil {
+			return err
+		}
+	}
+	release.SubscriptionsVersion = subscriptionsVersion
+	return nil
+}
+
+// changedSubscribers returns the subscribers of release added or changed
+// since stored, and the chats of stored no longer subscribed, in order.
+func changedSubscribers(stored, release MovieRelease) (changed []Subscriber, removed []int64) {
+	previous := map[int64]Subscriber{}
+	for _, sub := range stored.Subscribers {
+		previous[sub.ChatID] = sub
+	}
+	current := map[int64]bool{}
+	for _, sub := range release.Subscribers {
+		current[sub.ChatID] = true
+		if prev, ok := previous[sub.ChatID]; ok && reflect.DeepEqual(prev, sub) {
+			continue
+		}
+		changed = append(changed, sub)
+	}
+	for _, sub := range stored.Subscribers {
+		if !current[sub.ChatID] {
+			removed = append(removed, sub.ChatID)
+		}
+	}
+	return changed, removed
+}
+
+// migratingFirst runs the transaction writing the release, migrating the
+// release first if the transaction found it not migrated yet.
+func (s *datastoreStore) migratingFirst(ctx context.Context, id int64, run func() error) error {
+	err := run()
+	if err != errNotMigrated {
+		return err
+	}
+	if _, err := s.migrateRelease(ctx, id); err != nil {
+		return err
+	}
+	return run()
+}
+
+func (s *datastoreStore) MigrateRelease(ctx context.Context, id int64) (bool, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	migrated, err := s.migrateRelease(ctx, id)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to migrate movie release %d", id)
+	}
+	return migrated, nil
+}
+
+// migrateRelease writes the Subscription entities of every subscriber of the
+// release and deletes those of the older layout. A release can have more
+// subscribers than a transaction can write, the entities are written in
+// batches first. The transaction then only writes the subscribers that
+// changed meanwhile, and marks the release migrated.
+func (s *datastoreStore) migrateRelease(ctx context.Context, id int64) (bool, error) {
+	key := releaseKey(id)
+	var snapshot MovieRelease
+	err := s.client.Get(ctx, key, &snapshot)
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if snapshot.SubscriptionsVersion >= subscriptionsVersion {
+		return false, nil
+	}
+
+	legacy, err := s.client.GetAll(ctx, datastore.NewQuery(kindSubscription).Ancestor(key).KeysOnly(), nil)
+	if err != nil {
+		return false, err
+	}
+	if err := s.batchDelete(ctx, legacy); err != nil {
+		return false, err
+	}
+	keys := make([]*datastore.Key, len(snapshot.Subscribers))
+	subscriptions := make([]Subscription, len(snapshot.Subscribers))
+	for i, sub := range snapshot.Subscribers {
+		keys[i] = subscriptionKey(sub.ChatID, id)
+		subscriptions[i] = Subscription{MovieID: id, Subscriber: sub}
+	}
+	for i := 0; i < len(keys); i += maxBatchSize {
+		end := i + maxBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if _, err := s.client.PutMulti(ctx, keys[i:end], subscriptions[i:end]); err != nil {
+			return false, err
+		}
+	}
+
+	migrated, deleted := false, false
+	_, err = s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		migrated, deleted = false, false
+
+		var release MovieRelease
+		err := tx.Get(key, &release)
+		if err == datastore.ErrNoSuchEntity {
+			deleted = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if release.SubscriptionsVersion >= subscriptionsVersion {
+			return nil
+		}
+
+		written := snapshot
+		written.SubscriptionsVersion = subscriptionsVersion
+		if err := syncSubscriptions(tx, written, &release); err != nil {
+			return err
+		}
+		if _, err := tx.Put(key, &release); err != nil {
+			return err
+		}
+		migrated = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		// Deleted meanwhile, along with the entities it had then
+		return false, s.batchDelete(ctx, keys)
+	}
+	return migrated, nil
+}
+
+// batchDelete deletes the entities, maxBatchSize at a time.
+func (s *datastoreStore) batchDelete(ctx context.Context, keys []*datastore.Key) error {
+	for i := 0; i < len(keys); i += maxBatchSize {
+		end := i + maxBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := s.client.DeleteMulti(ctx, keys[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *datastoreStore) ChatReleases(ctx context.Context, chatID int64) ([]MovieRelease, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var subscriptions []Subscription
+	err := retryRead(ctx, func() error {
+		subscriptions = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindSubscription).Ancestor(chatKey(chatID)), &subscriptions)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get subscriptions of chat %d", chatID)
+	}
+
+	keys := make([]*datastore.Key, len(subscriptions))
+	for i, sub := range subscriptions {
+		keys[i] = releaseKey(sub.MovieID)
+	}
+	var records []MovieRelease
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		batch := make([]MovieRelease, n)
+		err := retryRead(ctx, func() error {
+			return s.client.GetMulti(ctx, keys[:n], batch)
+		})
+		if multi, ok := err.(datastore.MultiError); ok {
+			// A release deleted since the query, skipped
+			err = nil
+			for i, e := range multi {
+				if e == datastore.ErrNoSuchEntity {
+					batch[i].ID = 0
+				} else if e != nil {
+					err = e
+				}
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get movie releases of chat %d", chatID)
+		}
+		for _, rec := range batch {
+			if rec.ID != 0 {
+				records = append(records, rec)
+			}
+		}
+		keys = keys[n:]
+	}
+	return records, nil
+}
+
+func seasonKey(showID int64, number int) *datastore.Key {
+	return datastore.NameKey(kindSeason, fmt.Sprintf("%d-%d", showID, number), nil)
+}
+
+func (s *datastoreStore) Seasons(ctx context.Context) ([]SeasonRelease, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var seasons []SeasonRelease
+	err := retryRead(ctx, func() error {
+		seasons = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindSeason), &seasons)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get all seasons")
+	}
+	return seasons, nil
+}
+
+func (s *datastoreStore) PutSeason(ctx context.Context, season SeasonRelease) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	_, err := s.client.Put(ctx, seasonKey(season.ShowID, season.Season), &season)
+	if err != nil {
+		return errors.Wrapf(err, "failed to put season %d of show %d", season.Season, season.ShowID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) UpdateSeason(ctx context.Context, showID int64, number int, fn func(season *SeasonRelease) error) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := seasonKey(showID, number)
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var season SeasonRelease
+
+		err := tx.Get(key, &season)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if err := fn(&season); err != nil {
+			return err
+		}
+
+		_, err = tx.Put(key, &season)
+		return err
+	})
+	if err == errSkipUpdate {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to update season %d of show %d", number, showID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) DeleteRelease(ctx context.Context, id int64, check func(release MovieRelease) bool) (bool, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := releaseKey(id)
+
+	deleted := false
+	err := s.migratingFirst(ctx, id, func() error {
+		_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			deleted = false
+
+			var release MovieRelease
+			err := tx.Get(key, &release)
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !check(release) {
+				return nil
+			}
+			remaining := release
+			remaining.Subscribers = nil
+			if err := syncSubscriptions(tx, release, &remaining); err != nil {
+				return err
+			}
+			if err := tx.Delete(key); err != nil {
+				return err
+			}
+			deleted = true
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to delete movie release %d", id)
+	}
+	return deleted, nil
+}
+
+func searchKey(chatID, movieID int64) *datastore.Key {
+	return datastore.NameKey(kindSearch, fmt.Sprintf("%d-%d", chatID, movieID), nil)
+}
+
+func searchKeys(searches []SearchedMovie) []*datastore.Key {
+	keys := make([]*datastore.Key, len(searches))
+	for i, search := range searches {
+		keys[i] = searchKey(search.ChatID, search.MovieID)
+	}
+	return keys
+}
+
+func (s *datastoreStore) Searches(ctx context.Context) ([]SearchedMovie, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var searches []SearchedMovie
+	err := retryRead(ctx, func() error {
+		searches = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindSearch), &searches)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get all searches")
+	}
+	return searches, nil
+}
+
+func (s *datastoreStore) PutSearches(ctx context.Context, searches []SearchedMovie) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if _, err := s.client.PutMulti(ctx, searchKeys(searches), searches); err != nil {
+		return errors.Wrap(err, "failed to put searches")
+	}
+	return nil
+}
+
+func (s *datastoreStore) DeleteSearches(ctx context.Context, searches []SearchedMovie) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	keys := searchKeys(searches)
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		if err := s.client.DeleteMulti(ctx, keys[:n]); err != nil {
+			return errors.Wrap(err, "failed to delete searches")
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func calendarLinkKey(chatID int64) *datastore.Key {
+	return datastore.NameKey(kindCalendarLink, fmt.Sprintf("%d", chatID), nil)
+}
+
+func (s *datastoreStore) CalendarLinks(ctx context.Context) ([]CalendarLink, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var links []CalendarLink
+	err := retryRead(ctx, func() error {
+		links = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindCalendarLink), &links)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get all calendar links")
+	}
+	return links, nil
+}
+
+func (s *datastoreStore) CalendarLink(ctx context.Context, chatID int64) (CalendarLink, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var link CalendarLink
+	err := retryRead(ctx, func() error {
+		return s.client.Get(ctx, calendarLinkKey(chatID), &link)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return CalendarLink{ChatID: chatID}, nil
+	}
+	if err != nil {
+		return CalendarLink{}, errors.Wrapf(err, "failed to get calendar link of chat %d", chatID)
+	}
+	return link, nil
+}
+
+func (s *datastoreStore) PutCalendarLink(ctx context.Context, link CalendarLink) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if _, err := s.client.Put(ctx, calendarLinkKey(link.ChatID), &link); err != nil {
+		return errors.Wrapf(err, "failed to put calendar link of chat %d", link.ChatID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) DeleteCalendarLink(ctx context.Context, chatID int64) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if err := s.client.Delete(ctx, calendarLinkKey(chatID)); err != nil {
+		return errors.Wrapf(err, "failed to delete calendar link of chat %d", chatID)
+	}
+	return nil
+}
+
+func userKey(userID int64) *datastore.Key {
+	return datastore.NameKey(kindUser, fmt.Sprintf("%d", userID), nil)
+}
+
+func (s *datastoreStore) Users(ctx context.Context) ([]User, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var users []User
+	err := retryRead(ctx, func() error {
+		users = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindUser), &users)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get all users")
+	}
+	return users, nil
+}
+
+func (s *datastoreStore) User(ctx context.Context, userID int64) (User, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var user User
+	err := retryRead(ctx, func() error {
+		return s.client.Get(ctx, userKey(userID), &user)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return User{UserID: userID}, nil
+	}
+	if err != nil {
+		return User{}, errors.Wrapf(err, "failed to get user %d", userID)
+	}
+	return user, nil
+}
+
+func linkedChatKey(chatID int64) *datastore.Key {
+	return datastore.NameKey(kindLinkedChat, fmt.Sprintf("%d", chatID), nil)
+}
+
+func (s *datastoreStore) ChatUser(ctx context.Context, chatID int64) (User, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var user User
+	err := retryRead(ctx, func() error {
+		var linked LinkedChat
+		if err := s.client.Get(ctx, linkedChatKey(chatID), &linked); err != nil {
+			return err
+		}
+		user = User{}
+		return s.client.Get(ctx, userKey(linked.UserID), &user)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return User{}, nil
+	}
+	if err != nil {
+		return User{}, errors.Wrapf(err, "failed to get user of chat %d", chatID)
+	}
+	return user, nil
+}
+
+func (s *datastoreStore) PutUser(ctx context.Context, user User) error {
+	return s.UpdateUser(ctx, user.UserID, func(stored *User) error {
+		*stored = user
+		return nil
+	})
+}
+
+func (s *datastoreStore) UpdateUser(ctx context.Context, userID int64, fn func(user *User) error) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := userKey(userID)
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		user := User{UserID: userID}
+
+		err := tx.Get(key, &user)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		stored := user
+		stored.ChatIDs = append([]int64(nil), user.ChatIDs...)
+
+		if err := fn(&user); err != nil {
+			return err
+		}
+
+		if err := syncLinkedChats(tx, stored, user); err != nil {
+			return err
+		}
+		if len(user.ChatIDs) == 0 {
+			return tx.Delete(key)
+		}
+		_, err = tx.Put(key, &user)
+		return err
+	})
+	if err == errSkipUpdate {
+		return nil
+	}
+	if err == errChatLinked {
+		return err
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to update user %d", userID)
+	}
+	return nil
+}
+
+// syncLinkedChats makes the LinkedChat entities match the linked chats of
+// the user within the transaction, stored being the user as read by the
+// transaction. It returns errChatLinked if a newly linked chat is linked to
+// another user.
+func syncLinkedChats(tx *datastore.Transaction, stored, user User) error {
+	var added []*datastore.Key
+	var linked []LinkedChat
+	for _, chatID := range user.ChatIDs {
+		if stored.linked(chatID) {
+			continue
+		}
+		key := linkedChatKey(chatID)
+		var owner LinkedChat
+		err := tx.Get(key, &owner)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err == nil && owner.UserID != user.UserID {
+			return errChatLinked
+		}
+		added = append(added, key)
+		linked = append(linked, LinkedChat{ChatID: chatID, UserID: user.UserID})
+	}
+	var removed []*datastore.Key
+	for _, chatID := range stored.ChatIDs {
+		if !user.linked(chatID) {
+			removed = append(removed, linkedChatKey(chatID))
+		}
+	}
+
+	if len(added) > 0 {
+		if _, err := tx.PutMulti(added, linked); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.DeleteMulti(removed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *datastoreStore) PutNotificationLogs(ctx context.Context, logs []NotificationLog) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	keys := make([]*datastore.Key, len(logs))
+	for i := range logs {
+		keys[i] = datastore.IncompleteKey(kindNotification, nil)
+	}
+	if _, err := s.client.PutMulti(ctx, keys, logs); err != nil {
+		return errors.Wrap(err, "failed to put notification logs")
+	}
+	return nil
+}
+
+func (s *datastoreStore) NotificationLogs(ctx context.Context, chatID int64) ([]NotificationLog, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var logs []NotificationLog
+	err := retryRead(ctx, func() error {
+		logs = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindNotification).Filter("ChatID =", chatID), &logs)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get notification logs of chat %d", chatID)
+	}
+	return logs, nil
+}
+
+func (s *datastoreStore) DeleteNotificationLogs(ctx context.Context, chatID int64, before time.Time) (int, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var keys []*datastore.Key
+	if chatID == 0 {
+		var err error
+		keys, err = s.client.GetAll(ctx, datastore.NewQuery(kindNotification).Filter("SentAt <", before).KeysOnly(), nil)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get old notification logs")
+		}
+	} else {
+		// The few logs of a chat are filtered in memory, which avoids
+		// requiring a composite index
+		var logs []NotificationLog
+		all, err := s.client.GetAll(ctx, datastore.NewQuery(kindNotification).Filter("ChatID =", chatID), &logs)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to get notification logs of chat %d", chatID)
+		}
+		for i, l := range logs {
+			if l.SentAt.Before(before) {
+				keys = append(keys, all[i])
+			}
+		}
+	}
+
+	deleted := 0
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		if err := s.client.DeleteMulti(ctx, keys[:n]); err != nil {
+			return deleted, errors.Wrap(err, "failed to delete notification logs")
+		}
+		deleted += n
+		keys = keys[n:]
+	}
+	return deleted, nil
+}
+
+func followedPersonKey(chatID, personID int64) *datastore.Key {
+	return datastore.NameKey(kindFollowed, fmt.Sprintf("%d/%d", chatID, personID), nil)
+}
+
+func (s *datastoreStore) FollowedPeople(ctx context.Context, chatID int64) ([]FollowedPerson, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	q := datastore.NewQuery(kindFollowed)
+	if chatID != 0 {
+		q = q.Filter("ChatID =", chatID)
+	}
+	var people []FollowedPerson
+	err := retryRead(ctx, func() error {
+		people = nil
+		_, err := s.client.GetAll(ctx, q, &people)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get followed people of chat %d", chatID)
+	}
+	return people, nil
+}
+
+func (s *datastoreStore) PutFollowedPerson(ctx context.Context, person FollowedPerson) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if _, err := s.client.Put(ctx, followedPersonKey(person.ChatID, person.PersonID), &person); err != nil {
+		return errors.Wrapf(err, "failed to put followed person %d of chat %d", person.PersonID, person.ChatID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) DeleteFollowedPerson(ctx context.Context, chatID, personID int64) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if err := s.client.Delete(ctx, followedPersonKey(chatID, personID)); err != nil {
+		return errors.Wrapf(err, "failed to delete followed person %d of chat %d", personID, chatID)
+	}
+	return nil
+}
+
+func prefsKey(chatID int64) *datastore.Key {
+	return datastore.NameKey(kindUserPrefs, fmt.Sprintf("%d", chatID), nil)
+}
+
+func (s *datastoreStore) Prefs(ctx context.Context, chatID int64) (UserPrefs, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var prefs UserPrefs
+	err := retryRead(ctx, func() error {
+		return s.client.Get(ctx, prefsKey(chatID), &prefs)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return UserPrefs{ChatID: chatID}, nil
+	}
+	if err != nil {
+		return UserPrefs{}, errors.Wrapf(err, "failed to get prefs of chat %d", chatID)
+	}
+	return prefs, nil
+}
+
+func (s *datastoreStore) PutPrefs(ctx context.Context, prefs UserPrefs) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	_, err := s.client.Put(ctx, prefsKey(prefs.ChatID), &prefs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to put prefs of chat %d", prefs.ChatID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) UpdatePrefs(ctx context.Context, chatID int64, fn func(prefs *UserPrefs) error) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := prefsKey(chatID)
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		prefs := UserPrefs{ChatID: chatID}
+
+		err := tx.Get(key, &prefs)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if err := fn(&prefs); err != nil {
+			return err
+		}
+
+		_, err = tx.Put(key, &prefs)
+		return err
+	})
+	if err == errSkipUpdate {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to update prefs of chat %d", chatID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) DeletePrefs(ctx context.Context, chatID int64) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if err := s.client.Delete(ctx, prefsKey(chatID)); err != nil {
+		return errors.Wrapf(err, "failed to delete prefs of chat %d", chatID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) RefusingChats(ctx context.Context, n int) ([]UserPrefs, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var prefs []UserPrefs
+	err := retryRead(ctx, func() error {
+		prefs = nil
+		_, err := s.client.GetAll(ctx, datastore.NewQuery(kindUserPrefs).Filter("RefusedNotifications >=", n), &prefs)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get chats refusing notifications")
+	}
+	return prefs, nil
+}
+
+func (s *datastoreStore) AcquireLease(ctx context.Context, name, owner string, expiresAt time.Time) (bool, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := datastore.NameKey(kindNotifyLease, name, nil)
+
+	acquired := false
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		acquired = false
+
+		var lease NotifyLease
+		err := tx.Get(key, &lease)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if !lease.available(owner, time.Now()) {
+			return nil
+		}
+
+		lease = NotifyLease{Owner: owner, ExpiresAt: expiresAt}
+		if _, err := tx.Put(key, &lease); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to acquire lease %s", name)
+	}
+	return acquired, nil
+}
+
+func (s *datastoreStore) ReleaseLease(ctx context.Context, name, owner string) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := datastore.NameKey(kindNotifyLease, name, nil)
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var lease NotifyLease
+		err := tx.Get(key, &lease)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if lease.Owner != owner {
+			return nil
+		}
+		return tx.Delete(key)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to release lease %s", name)
+	}
+	return nil
+}
+
+func transferKey(code string) *datastore.Key {
+	return datastore.NameKey(kindTransfer, code, nil)
+}
+
+func (s *datastoreStore) PutTransfer(ctx context.Context, code string, transfer Transfer) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	// The query may miss a transfer created moments ago, it then expires on
+	// its own
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery(kindTransfer).Filter("FromChatID =", transfer.FromChatID).KeysOnly(), nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get transfers of chat %d", transfer.FromChatID)
+	}
+	if err := s.client.DeleteMulti(ctx, keys); err != nil {
+		return errors.Wrapf(err, "failed to delete transfers of chat %d", transfer.FromChatID)
+	}
+	if _, err := s.client.Put(ctx, transferKey(code), &transfer); err != nil {
+		return errors.Wrapf(err, "failed to put transfer of chat %d", transfer.FromChatID)
+	}
+	return nil
+}
+
+func (s *datastoreStore) Transfer(ctx context.Context, code string) (Transfer, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var transfer Transfer
+	err := retryRead(ctx, func() error {
+		return s.client.Get(ctx, transferKey(code), &transfer)
+	})
+	if err == datastore.ErrNoSuchEntity {
+		return Transfer{}, nil
+	}
+	if err != nil {
+		return Transfer{}, errors.Wrap(err, "failed to get transfer")
+	}
+	if transfer.expired(time.Now()) {
+		return Transfer{}, nil
+	}
+	return transfer, nil
+}
+
+func (s *datastoreStore) DeleteTransfer(ctx context.Context, code string) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	if err := s.client.Delete(ctx, transferKey(code)); err != nil {
+		return errors.Wrap(err, "failed to delete transfer")
+	}
+	return nil
+}
+
+func (s *datastoreStore) DeleteExpiredTransfers(ctx context.Context, before time.Time) (int, error) {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	keys, err := s.client.GetAll(ctx, datastore.NewQuery(kindTransfer).Filter("ExpiresAt <", before).KeysOnly(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get expired transfers")
+	}
+	if err := s.batchDelete(ctx, keys); err != nil {
+		return 0, errors.Wrap(err, "failed to delete expired transfers")
+	}
+	return len(keys), nil
+}
+
+func (s *datastoreStore) DeleteChatTransfers(ctx context.Context, chatID int64) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	var keys []*datastore.Key
+	for _, property := range []string{"FromChatID =", "ToChatID ="} {
+		found, err := s.client.GetAll(ctx, datastore.NewQuery(kindTransfer).Filter(property, chatID).KeysOnly(), nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get transfers of chat %d", chatID)
+		}
+		keys = append(keys, found...)
+	}
+	if err := s.batchDelete(ctx, keys); err != nil {
+		return errors.Wrapf(err, "failed to delete transfers of chat %d", chatID)
+	}
+	return nil
+}
+
+// exportedKinds are the kinds read by Export, in order.
+var exportedKinds = []struct {
+	kind      string
+	newEntity func() interface{}
+}{
+	{kindMovieRelease, func() interface{} { return &MovieRelease{} }},
+	{kindSeason, func() interface{} { return &SeasonRelease{} }},
+	{kindUserPrefs, func() interface{} { return &UserPrefs{} }},
+	{kindSearch, func() interface{} { return &SearchedMovie{} }},
+	{kindCalendarLink, func() interface{} { return &CalendarLink{} }},
+	{kindUser, func() interface{} { return &User{} }},
+	{kindFollowed, func() interface{} { return &FollowedPerson{} }},
+}
+
+func (s *datastoreStore) Export(ctx context.Context, fn func(entity interface{}) error) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	for _, k := range exportedKinds {
+		it := s.client.Run(ctx, datastore.NewQuery(k.kind))
+		for {
+			entity := k.newEntity()
+			_, err := it.Next(entity)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to export %s entities", k.kind)
+			}
+			if err := fn(entity); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diagnosticEntity is the disposable entity written by Check.
+type diagnosticEntity struct {
+	CreatedAt time.Time
+}
+
+func (s *datastoreStore) Check(ctx context.Context) error {
+	defer trackTime(ctx, timingDatastore, time.Now())
+	key := datastore.NameKey(kindDiagnostic, "check-"+newRequestID(), nil)
+
+	written := diagnosticEntity{CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+	if _, err := s.client.Put(ctx, key, &written); err != nil {
+		return errors.Wrap(err, "failed to write")
+	}
+	defer s.client.Delete(ctx, key)
+
+	var read diagnosticEntity
+	if err := s.client.Get(ctx, key, &read); err != nil {
+		return errors.Wrap(err, "failed to read back")
+	}
+	if !read.CreatedAt.Equal(written.CreatedAt) {
+		return errors.New("read back a different entity")
+	}
+	return nil
+}
+
+const (
+	// storeReadAttempts is how many times idempotent reads are attempted
+	// when the datastore is temporarily unavailable.
+	storeReadAttempts = 3
+	// storeRetryBackoff is the delay before the first retry, doubled after
+	// each attempt.
+	storeRetryBackoff = 100 * time.Millisecond
+
+	storeUnavailableText = "I can't access your subscriptions right now, please try again shortly."
+)
+
+// isStoreUnavailable returns whether err is a transient datastore failure:
+// the service unavailable, a quota exceeded or a deadline hit.
+func isStoreUnavailable(err error) bool {
+	s, ok := status.FromError(errors.Cause(err))
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// retryRead calls read until it succeeds, fails with a non transient error,
+// or storeReadAttempts is reached. Only idempotent reads must be retried.
+func retryRead(ctx context.Context, read func() error) error {
+	backoff := storeRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := read()
+		if err == nil || attempt == storeReadAttempts || !isStoreUnavailable(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// storeFailed handles a store error met while serving a chat: it is logged
+// and the user is asked to try again. The bot keeps running whatever the
+// error, so that the commands not needing the store still work.
+func storeFailed(ctx context.Context, chatID int64, err error, what string) {
+	logStoreFailure(ctx, err, what)
+	sendMsg(ctx, telegram.NewMessage(chatID, storeUnavailableText))
+}
+
+// callbackStoreFailed is like storeFailed for callback queries, it returns
+// the callback answer.
+func callbackStoreFailed(ctx context.Context, err error, what string) string {
+	logStoreFailure(ctx, err, what)
+	return storeUnavailableText
+}
+
+func logStoreFailure(ctx context.Context, err error, what string) {
+	if isStoreUnavailable(err) {
+		logf(ctx, "%s, datastore unavailable: %s", what, err)
+		return
+	}
+	logf(ctx, "%s, datastore error: %s", what, err)
+}
+
+// prefsOrDefault returns the prefs of the chat, or the default ones when the
+// store fails. Commands only reading them, e.g. searches, keep working
+// during a store outage.
+func prefsOrDefault(ctx context.Context, chatID int64) UserPrefs {
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		logf(ctx, "failed to get user prefs, using the defaults: %s", err)
+		return UserPrefs{ChatID: chatID}
+	}
+	return prefs
+}
+
+// jobStoreFailed is like storeFailed for scheduled jobs: the job stops and
+// is picked up again by its next run, the bot keeps serving the chats.
+func jobStoreFailed(ctx context.Context, err error, what string) {
+	if isStoreUnavailable(err) {
+		logf(ctx, "%s, datastore unavailable, stopping until the next run: %s", what, err)
+		return
+	}
+	logf(ctx, "%s, datastore error, stopping until the next run: %s", what, err)
+}
@@ -0,0 +1,926 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memStore is an in-memory Store for tests, no datastore emulator needed.
+// Entities are copied in and out so that callers never share memory with the
+// store, like with datastore. When err is set every call fails with it, to
+// simulate an outage. When stale is set Releases returns it instead of the
+// stored releases, like an eventually consistent query not reflecting the
+// latest writes yet.
+type memStore struct {
+	mu    sync.Mutex
+	err   error
+	stale []MovieRelease
+
+	releases  map[int64]MovieRelease
+	seasons   map[string]SeasonRelease
+	searches  map[string]SearchedMovie
+	links     map[int64]CalendarLink
+	users     map[int64]User
+	logs      []NotificationLog
+	followed  map[string]FollowedPerson
+	prefs     map[int64]UserPrefs
+	leases    map[string]NotifyLease
+	transfers map[string]Transfer
+}
+
+var _ Store = (*memStore)(nil)
+
+func newMemStore() *memStore {
+	return &memStore{
+		releases:  map[int64]MovieRelease{},
+		seasons:   map[string]SeasonRelease{},
+		searches:  map[string]SearchedMovie{},
+		links:     map[int64]CalendarLink{},
+		users:     map[int64]User{},
+		followed:  map[string]FollowedPerson{},
+		prefs:     map[int64]UserPrefs{},
+		leases:    map[string]NotifyLease{},
+		transfers: map[string]Transfer{},
+	}
+}
+
+// useMemStore makes a new memStore the store of the test.
+func useMemStore(t *testing.T) *memStore {
+	t.Helper()
+	s := newMemStore()
+	previous := store
+	store = s
+	t.Cleanup(func() { store = previous })
+	return s
+}
+
+// copyEntity deep copies src into dst through JSON, which all the stored
+// types support.
+func copyEntity(dst, src interface{}) {
+	b, err := json.Marshal(src)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(b, dst); err != nil {
+		panic(err)
+	}
+}
+
+func (s *memStore) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *memStore) Releases(ctx context.Context) ([]MovieRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.stale != nil {
+		var records []MovieRelease
+		copyEntity(&records, s.stale)
+		return records, nil
+	}
+	return s.sortedReleases(func(MovieRelease) bool { return true }), nil
+}
+
+func (s *memStore) Release(ctx context.Context, id int64) (MovieRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return MovieRelease{}, s.err
+	}
+	var record MovieRelease
+	if r, ok := s.releases[id]; ok {
+		copyEntity(&record, r)
+	}
+	return record, nil
+}
+
+// sortedReleases returns copies of the stored releases keep returns true for,
+// by ID. s.mu must be held.
+func (s *memStore) sortedReleases(keep func(MovieRelease) bool) []MovieRelease {
+	var records []MovieRelease
+	for _, r := range s.releases {
+		if keep(r) {
+			var c MovieRelease
+			copyEntity(&c, r)
+			records = append(records, c)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// freeze makes Releases return the releases stored now until unfrozen, like
+// a query lagging behind the writes.
+func (s *memStore) freeze() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stale = s.sortedReleases(func(MovieRelease) bool { return true })
+	if s.stale == nil {
+		s.stale = []MovieRelease{}
+	}
+}
+
+func (s *memStore) ChatReleases(ctx context.Context, chatID int64) ([]MovieRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.sortedReleases(func(r MovieRelease) bool {
+		return r.SubscriptionsVersion >= subscriptionsVersion && r.subscribed(chatID)
+	}), nil
+}
+
+func (s *memStore) PutRelease(ctx context.Context, release MovieRelease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	release.SubscriptionsVersion = subscriptionsVersion
+	var c MovieRelease
+	copyEntity(&c, release)
+	s.releases[release.ID] = c
+	return nil
+}
+
+func (s *memStore) UpdateRelease(ctx context.Context, id int64, fn func(release *MovieRelease) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	var release MovieRelease
+	if stored, ok := s.releases[id]; ok {
+		copyEntity(&release, stored)
+	}
+	if err := fn(&release); err != nil {
+		if err == errSkipUpdate {
+			return nil
+		}
+		return err
+	}
+	release.SubscriptionsVersion = subscriptionsVersion
+	var c MovieRelease
+	copyEntity(&c, release)
+	s.releases[id] = c
+	return nil
+}
+
+func (s *memStore) DeleteRelease(ctx context.Context, id int64, check func(release MovieRelease) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	stored, ok := s.releases[id]
+	if !ok {
+		return false, nil
+	}
+	var c MovieRelease
+	copyEntity(&c, stored)
+	if !check(c) {
+		return false, nil
+	}
+	delete(s.releases, id)
+	return true, nil
+}
+
+func (s *memStore) MigrateRelease(ctx context.Context, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	release, ok := s.releases[id]
+	if !ok || release.SubscriptionsVersion >= subscriptionsVersion {
+		return false, nil
+	}
+	release.SubscriptionsVersion = subscriptionsVersion
+	s.releases[id] = release
+	return true, nil
+}
+
+func memSeasonKey(showID int64, number int) string {
+	return fmt.Sprintf("%d-%d", showID, number)
+}
+
+func (s *memStore) Seasons(ctx context.Context) ([]SeasonRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var seasons []SeasonRelease
+	for _, r := range s.seasons {
+		var c SeasonRelease
+		copyEntity(&c, r)
+		seasons = append(seasons, c)
+	}
+	sort.Slice(seasons, func(i, j int) bool {
+		return memSeasonKey(seasons[i].ShowID, seasons[i].Season) < memSeasonKey(seasons[j].ShowID, seasons[j].Season)
+	})
+	return seasons, nil
+}
+
+func (s *memStore) PutSeason(ctx context.Context, season SeasonRelease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	var c SeasonRelease
+	copyEntity(&c, season)
+	s.seasons[memSeasonKey(season.ShowID, season.Season)] = c
+	return nil
+}
+
+func (s *memStore) UpdateSeason(ctx context.Context, showID int64, number int, fn func(season *SeasonRelease) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	key := memSeasonKey(showID, number)
+	var season SeasonRelease
+	if stored, ok := s.seasons[key]; ok {
+		copyEntity(&season, stored)
+	}
+	if err := fn(&season); err != nil {
+		if err == errSkipUpdate {
+			return nil
+		}
+		return err
+	}
+	var c SeasonRelease
+	copyEntity(&c, season)
+	s.seasons[key] = c
+	return nil
+}
+
+func memSearchKey(search SearchedMovie) string {
+	return fmt.Sprintf("%d-%d", search.ChatID, search.MovieID)
+}
+
+func (s *memStore) Searches(ctx context.Context) ([]SearchedMovie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var searches []SearchedMovie
+	for _, search := range s.searches {
+		searches = append(searches, search)
+	}
+	sort.Slice(searches, func(i, j int) bool { return memSearchKey(searches[i]) < memSearchKey(searches[j]) })
+	return searches, nil
+}
+
+func (s *memStore) PutSearches(ctx context.Context, searches []SearchedMovie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for _, search := range searches {
+		s.searches[memSearchKey(search)] = search
+	}
+	return nil
+}
+
+func (s *memStore) DeleteSearches(ctx context.Context, searches []SearchedMovie) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for _, search := range searches {
+		delete(s.searches, memSearchKey(search))
+	}
+	return nil
+}
+
+func (s *memStore) CalendarLinks(ctx context.Context) ([]CalendarLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var links []CalendarLink
+	for _, l := range s.links {
+		var c CalendarLink
+		copyEntity(&c, l)
+		links = append(links, c)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ChatID < links[j].ChatID })
+	return links, nil
+}
+
+func (s *memStore) CalendarLink(ctx context.Context, chatID int64) (CalendarLink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return CalendarLink{}, s.err
+	}
+	l, ok := s.links[chatID]
+	if !ok {
+		return CalendarLink{ChatID: chatID}, nil
+	}
+	var c CalendarLink
+	copyEntity(&c, l)
+	return c, nil
+}
+
+func (s *memStore) PutCalendarLink(ctx context.Context, link CalendarLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	var c CalendarLink
+	copyEntity(&c, link)
+	s.links[link.ChatID] = c
+	return nil
+}
+
+func (s *memStore) DeleteCalendarLink(ctx context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.links, chatID)
+	return nil
+}
+
+func (s *memStore) Users(ctx context.Context) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var users []User
+	for _, u := range s.users {
+		var c User
+		copyEntity(&c, u)
+		users = append(users, c)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	return users, nil
+}
+
+func (s *memStore) User(ctx context.Context, userID int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	u, ok := s.users[userID]
+	if !ok {
+		return User{UserID: userID}, nil
+	}
+	var c User
+	copyEntity(&c, u)
+	return c, nil
+}
+
+func (s *memStore) ChatUser(ctx context.Context, chatID int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return User{}, s.err
+	}
+	for _, u := range s.users {
+		for _, id := range u.ChatIDs {
+			if id == chatID {
+				var c User
+				copyEntity(&c, u)
+				return c, nil
+			}
+		}
+	}
+	return User{}, nil
+}
+
+func (s *memStore) PutUser(ctx context.Context, user User) error {
+	return s.UpdateUser(ctx, user.UserID, func(stored *User) error {
+		*stored = user
+		return nil
+	})
+}
+
+func (s *memStore) UpdateUser(ctx context.Context, userID int64, fn func(user *User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	user := User{UserID: userID}
+	if stored, ok := s.users[userID]; ok {
+		copyEntity(&user, stored)
+	}
+	if err := fn(&user); err != nil {
+		if err == errSkipUpdate {
+			return nil
+		}
+		return err
+	}
+	for _, other := range s.users {
+		if other.UserID == userID {
+			continue
+		}
+		for _, chatID := range user.ChatIDs {
+			if other.linked(chatID) {
+				return errChatLinked
+			}
+		}
+	}
+	if len(user.ChatIDs) == 0 {
+		delete(s.users, userID)
+		return nil
+	}
+	var c User
+	copyEntity(&c, user)
+	s.users[userID] = c
+	return nil
+}
+
+func (s *memStore) PutNotificationLogs(ctx context.Context, logs []NotificationLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.logs = append(s.logs, logs...)
+	return nil
+}
+
+func (s *memStore) NotificationLogs(ctx context.Context, chatID int64) ([]NotificationLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var logs []NotificationLog
+	for _, l := range s.logs {
+		if l.ChatID == chatID {
+			logs = append(logs, l)
+		}
+	}
+	return logs, nil
+}
+
+func (s *memStore) DeleteNotificationLogs(ctx context.Context, chatID int64, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	var kept []NotificationLog
+	deleted := 0
+	for _, l := range s.logs {
+		if (chatID == 0 || l.ChatID == chatID) && l.SentAt.Before(before) {
+			deleted++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	s.logs = kept
+	return deleted, nil
+}
+
+func memFollowedKey(chatID, personID int64) string {
+	return fmt.Sprintf("%d/%d", chatID, personID)
+}
+
+func (s *memStore) FollowedPeople(ctx context.Context, chatID int64) ([]FollowedPerson, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var people []FollowedPerson
+	for _, p := range s.followed {
+		if chatID == 0 || p.ChatID == chatID {
+			var c FollowedPerson
+			copyEntity(&c, p)
+			people = append(people, c)
+		}
+	}
+	sort.Slice(people, func(i, j int) bool {
+		return memFollowedKey(people[i].ChatID, people[i].PersonID) < memFollowedKey(people[j].ChatID, people[j].PersonID)
+	})
+	return people, nil
+}
+
+func (s *memStore) PutFollowedPerson(ctx context.Context, person FollowedPerson) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	var c FollowedPerson
+	copyEntity(&c, person)
+	s.followed[memFollowedKey(person.ChatID, person.PersonID)] = c
+	return nil
+}
+
+func (s *memStore) DeleteFollowedPerson(ctx context.Context, chatID, personID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.followed, memFollowedKey(chatID, personID))
+	return nil
+}
+
+func (s *memStore) Prefs(ctx context.Context, chatID int64) (UserPrefs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return UserPrefs{}, s.err
+	}
+	p, ok := s.prefs[chatID]
+	if !ok {
+		return UserPrefs{ChatID: chatID}, nil
+	}
+	var c UserPrefs
+	copyEntity(&c, p)
+	return c, nil
+}
+
+func (s *memStore) PutPrefs(ctx context.Context, prefs UserPrefs) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	var c UserPrefs
+	copyEntity(&c, prefs)
+	s.prefs[prefs.ChatID] = c
+	return nil
+}
+
+func (s *memStore) UpdatePrefs(ctx context.Context, chatID int64, fn func(prefs *UserPrefs) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	prefs := UserPrefs{ChatID: chatID}
+	if stored, ok := s.prefs[chatID]; ok {
+		copyEntity(&prefs, stored)
+	}
+	if err := fn(&prefs); err != nil {
+		if err == errSkipUpdate {
+			return nil
+		}
+		return err
+	}
+	var c UserPrefs
+	copyEntity(&c, prefs)
+	s.prefs[chatID] = c
+	return nil
+}
+
+func (s *memStore) DeletePrefs(ctx context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.prefs, chatID)
+	return nil
+}
+
+func (s *memStore) RefusingChats(ctx context.Context, n int) ([]UserPrefs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var prefs []UserPrefs
+	for _, p := range s.prefs {
+		if p.RefusedNotifications >= n {
+			var c UserPrefs
+			copyEntity(&c, p)
+			prefs = append(prefs, c)
+		}
+	}
+	sort.Slice(prefs, func(i, j int) bool { return prefs[i].ChatID < prefs[j].ChatID })
+	return prefs, nil
+}
+
+func (s *memStore) AcquireLease(ctx context.Context, name, owner string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if !s.leases[name].available(owner, time.Now()) {
+		return false, nil
+	}
+	s.leases[name] = NotifyLease{Owner: owner, ExpiresAt: expiresAt}
+	return true, nil
+}
+
+func (s *memStore) ReleaseLease(ctx context.Context, name, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.leases[name].Owner == owner {
+		delete(s.leases, name)
+	}
+	return nil
+}
+
+func (s *memStore) PutTransfer(ctx context.Context, code string, transfer Transfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for k, t := range s.transfers {
+		if t.FromChatID == transfer.FromChatID {
+			delete(s.transfers, k)
+		}
+	}
+	s.transfers[code] = transfer
+	return nil
+}
+
+func (s *memStore) Transfer(ctx context.Context, code string) (Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return Transfer{}, s.err
+	}
+	t := s.transfers[code]
+	if t.expired(time.Now()) {
+		return Transfer{}, nil
+	}
+	return t, nil
+}
+
+func (s *memStore) DeleteTransfer(ctx context.Context, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.transfers, code)
+	return nil
+}
+
+func (s *memStore) DeleteExpiredTransfers(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	deleted := 0
+	for k, t := range s.transfers {
+		if t.ExpiresAt.Before(before) {
+			delete(s.transfers, k)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *memStore) DeleteChatTransfers(ctx context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for k, t := range s.transfers {
+		if t.FromChatID == chatID || t.ToChatID == chatID {
+			delete(s.transfers, k)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Export(ctx context.Context, fn func(entity interface{}) error) error {
+	releases, err := s.Releases(ctx)
+	if err != nil {
+		return err
+	}
+	seasons, _ := s.Seasons(ctx)
+	searches, _ := s.Searches(ctx)
+	links, _ := s.CalendarLinks(ctx)
+	users, _ := s.Users(ctx)
+	people, _ := s.FollowedPeople(ctx, 0)
+
+	s.mu.Lock()
+	var prefs []UserPrefs
+	for _, p := range s.prefs {
+		prefs = append(prefs, p)
+	}
+	s.mu.Unlock()
+	sort.Slice(prefs, func(i, j int) bool { return prefs[i].ChatID < prefs[j].ChatID })
+
+	var entities []interface{}
+	for i := range releases {
+		entities = append(entities, &releases[i])
+	}
+	for i := range seasons {
+		entities = append(entities, &seasons[i])
+	}
+	for i := range prefs {
+		entities = append(entities, &prefs[i])
+	}
+	for i := range searches {
+		entities = append(entities, &searches[i])
+	}
+	for i := range links {
+		entities = append(entities, &links[i])
+	}
+	for i := range users {
+		entities = append(entities, &users[i])
+	}
+	for i := range people {
+		entities = append(entities, &people[i])
+	}
+	for _, e := range entities {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Check(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func TestNewDatastoreClientEmulator(t *testing.T) {
+	t.Setenv("DATASTORE_EMULATOR_HOST", "localhost:8081")
+	t.Setenv("DATASTORE_PROJECT_ID", "")
+	t.Setenv("EXPECTED_PROJECT_ID", "")
+
+	// No credentials are needed, the client doesn't connect until used
+	client, err := newDatastoreClient(context.Background())
+	if err != nil {
+		t.Fatalf("newDatastoreClient() error = %s", err)
+	}
+	client.Close()
+}
+
+func TestNewDatastoreClientExpectedProject(t *testing.T) {
+	t.Setenv("DATASTORE_EMULATOR_HOST", "localhost:8081")
+	t.Setenv("DATASTORE_PROJECT_ID", "other-project")
+	t.Setenv("EXPECTED_PROJECT_ID", "movie-releases-bot")
+
+	if _, err := newDatastoreClient(context.Background()); err == nil {
+		t.Fatal("newDatastoreClient() succeeded with a project other than EXPECTED_PROJECT_ID")
+	}
+}
+
+func TestStoreUpdateReleaseSkip(t *testing.T) {
+	s := useMemStore(t)
+	ctx := context.Background()
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.UpdateRelease(ctx, 1, func(release *MovieRelease) error {
+		release.MovieTitle = "changed"
+		return errSkipUpdate
+	})
+	if err != nil {
+		t.Fatalf("UpdateRelease() error = %s, errSkipUpdate should be swallowed", err)
+	}
+	if got := s.releases[1].MovieTitle; got != "Dune" {
+		t.Errorf("title = %q after a skipped update, want %q", got, "Dune")
+	}
+}
+
+func TestIsStoreUnavailable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.ResourceExhausted, "quota exceeded"), true},
+		{errors.Wrap(status.Error(codes.DeadlineExceeded, "timeout"), "failed to get"), true},
+		{status.Error(codes.InvalidArgument, "bad request"), false},
+		{errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		if got := isStoreUnavailable(tt.err); got != tt.want {
+			t.Errorf("isStoreUnavailable(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryRead(t *testing.T) {
+	attempts := 0
+	err := retryRead(context.Background(), func() error {
+		attempts++
+		if attempts < storeReadAttempts {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	})
+	if err != nil || attempts != storeReadAttempts {
+		t.Errorf("retryRead() = %v after %d attempts, want success after %d", err, attempts, storeReadAttempts)
+	}
+
+	attempts = 0
+	retryRead(context.Background(), func() error {
+		attempts++
+		return status.Error(codes.InvalidArgument, "bad request")
+	})
+	if attempts != 1 {
+		t.Errorf("retryRead() made %d attempts on a permanent error, want 1", attempts)
+	}
+}
+
+func TestStoreUnavailableReply(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	s.fail(status.Error(codes.Unavailable, "datastore unavailable"))
+
+	update := testMessage(42, "list subscriptions")
+	handlelistSubscriptions(context.Background(), update, nil)
+
+	if texts := tg.texts(42); len(texts) != 1 || texts[0] != storeUnavailableText {
+		t.Errorf("messages = %q, want %q", texts, storeUnavailableText)
+	}
+}
+
+func TestStoreOutageSearchStillWorks(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	api := useFakeTMDB(t)
+	api.route("/search/movie", map[string]interface{}{
+		"results": []map[string]interface{}{
+			{"id": 438631, "title": "Dune", "release_date": time.Now().AddDate(0, 3, 0).Format("2006-01-02")},
+		},
+	})
+	// Not even a transient failure, the bot must keep running
+	s.fail(status.Error(codes.PermissionDenied, "datastore disabled"))
+
+	handleUpdate(testMessage(42, "releases dune"))
+	texts := tg.texts(42)
+	if len(texts) != 1 || !strings.HasPrefix(texts[0], "I found these entries") || !strings.Contains(texts[0], "Dune") {
+		t.Fatalf("messages = %q, want the search results", texts)
+	}
+
+	handleUpdate(testMessage(42, "subscribe to dune"))
+	if texts := tg.texts(42); len(texts) != 2 || texts[1] != storeUnavailableText {
+		t.Errorf("messages = %q, want the subscription refused with %q", texts, storeUnavailableText)
+	}
+}
+
+func TestMovieReleaseClone(t *testing.T) {
+	release := MovieRelease{
+		ID: 1,
+		Subscribers: []Subscriber{{
+			ChatID:             42,
+			Regions:            []string{"US", "GB"},
+			SeenTrailers:       []string{"abc"},
+			StreamingProviders: []string{"Netflix"},
+		}},
+		History: []ReleaseChange{{Field: "date"}},
+	}
+	clone := release.clone()
+	if !reflect.DeepEqual(clone, release) {
+		t.Fatalf("clone() = %+v, want %+v", clone, release)
+	}
+
+	clone.Subscribers[0].Regions[0] = "DE"
+	clone.Subscribers[0].SeenTrailers[0] = "def"
+	clone.Subscribers[0].StreamingProviders[0] = "Hulu"
+	clone.History[0].Field = "title"
+	sub := release.Subscribers[0]
+	if sub.Regions[0] != "US" || sub.SeenTrailers[0] != "abc" || sub.StreamingProviders[0] != "Netflix" || release.History[0].Field != "date" {
+		t.Errorf("modifying the clone modified the release: %+v", release)
+	}
+	if clone := (MovieRelease{Subscribers: []Subscriber{{ChatID: 42}}}).clone(); clone.Subscribers[0].Regions != nil {
+		t.Errorf("clone() = %+v, want nil slices kept nil", clone)
+	}
+}
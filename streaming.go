@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// WatchProvider is a streaming service a movie is available on.
+type WatchProvider struct {
+	ID   int    `json:"provider_id"`
+	Name string `json:"provider_name"`
+}
+
+// StreamingProviders returns the sorted names of the subscription services
+// streaming the movie in the region. Watch providers must have been
+// requested via append_to_response.
+func (d MovieDetails) StreamingProviders(region string) []string {
+	var names []string
+	for _, p := range d.WatchProviders.Results[region].Flatrate {
+		if name := strings.TrimSpace(p.Name); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// notifyLeavingStreaming tells the subscriber about the streaming services
+// no longer listing the movie in the region since the previous refresh, and
+// returns the subscriber with the current services recorded. TMDB doesn't
+// know when a movie leaves a service, a removal is the best hint there is.
+// The services are recorded without notification the first time, or when
+// the region changed.
+func notifyLeavingStreaming(ctx context.Context, record MovieRelease, sub Subscriber, region string, providers []string) Subscriber {
+	recorded := append([]string(nil), providers...)
+	if sub.StreamingRegion == region {
+		current := map[string]bool{}
+		for _, name := range providers {
+			current[name] = true
+		}
+		for _, name := range sub.StreamingProviders {
+			if current[name] {
+				continue
+			}
+			text := fmt.Sprintf("%s is no longer listed on %s in %s %s, it may be leaving soon. 📺", record.MovieTitle, name, regionLabel(region), region)
+			if err := sendNotification(ctx, sub, text, notificationLog(notificationStreaming, record.ID, record.MovieTitle)); err != nil {
+				// Still recorded, the next refresh tells it again
+				logf(ctx, "failed to send leaving streaming notification: id=%d: %s", record.ID, err)
+				recorded = append(recorded, name)
+			}
+		}
+	}
+
+	sub.StreamingRegion = region
+	sub.StreamingProviders = recorded
+	return sub
+}
+
+func handleLeavingStreaming(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.LeavingStreaming = enabled
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	text := "I won't tell you about movies leaving streaming services anymore."
+	if enabled {
+		text = "I'll tell you when one of your movies is no longer listed on a streaming service in your region."
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// maxSuggestionCost is the highest cost of a suggested command, see
+// matchTemplate.
+const maxSuggestionCost = 2
+
+// commandTemplates are the forms of the commands suggested for near misses.
+// Words are matched literally, "a|b" is one of the alternatives, a word ending
+// with "?" is often left out and a word in angle brackets stands for the rest
+// of the input, at least one word.
+var commandTemplates = []string{
+	"releases <title>",
+	"search? again",
+	"subscribe to? <title>",
+	"subscribe list <titles>",
+	"unsubscribe from? <title>",
+	"list subscriptions?",
+	"list subscriptions by month",
+	"set list order soonest|added|alpha",
+	"total runtime?",
+	"digest image?",
+	"coming out <period>",
+	"details <title>",
+	"history <title>",
+	"compare <title>",
+	"trailers on|off",
+	"countdown on|off for? <title>",
+	"surprise me?",
+	"surprise notifications on|off",
+	"set favorite genre <genre>",
+	"preview <genre>",
+	"discover",
+	"soonest",
+	"trending searches?",
+	"set notify chat <chat>",
+	"clear notify chat",
+	"notify via <channel>",
+	"set silent on|off",
+	"set delivery time <time>",
+	"clear delivery time",
+	"notification history",
+	"light notifications on|off",
+	"leaving streaming on|off",
+	"monthly summary on|off",
+	"season episodes on|off",
+	"follow|unfollow person <name>",
+	"following",
+	"cast photos on|off",
+	"posters on|off",
+	"set show undated on|off",
+	"clear template upcoming|released",
+	"set default year <year>",
+	"clear default year",
+	"pause notifications?",
+	"resume notifications?",
+	"set region <region>",
+	"set regions <regions>",
+	"clear regions",
+	"set date policy primary|region|earliest",
+	"set timezone <timezone>",
+	"set date format dmy|mdy|iso",
+	"set time format 12h|24h",
+	"connect|disconnect|sync calendar",
+	"mute|unmute word <word>",
+	"import <url>",
+	"transfer subscriptions to? <chat>",
+	"accept transfer <code>",
+	"link chat",
+	"link chat <chat>",
+	"unlink chat",
+	"unlink chat <chat>",
+	"linked chats",
+	"set primary chat <chat>",
+	"set tmdb key <key>",
+	"clear tmdb key",
+	"my data",
+	"delete my data",
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// matchWord returns the alternative of the template word closest to the
+// input word and their distance, ok is false when none is close enough to
+// be a typo.
+func matchWord(word, template string) (string, int, bool) {
+	best, bestDist := "", -1
+	for _, alt := range strings.Split(template, "|") {
+		d := editDistance(word, alt)
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = alt, d
+		}
+	}
+	allowed := 1
+	if len(best) > 4 {
+		allowed = 2
+	}
+	return best, bestDist, bestDist <= allowed
+}
+
+// matchTemplate fits the input words to the command template. Each typo
+// costs its edit distance and each left out word of the template costs one.
+// It returns the corrected command and its cost.
+func matchTemplate(words []string, template string) (string, int, bool) {
+	parts := strings.Fields(template)
+	var fixed []string
+	placeholder := false
+	for _, p := range parts {
+		if strings.HasPrefix(p, "<") {
+			placeholder = true
+			break
+		}
+		fixed = append(fixed, p)
+	}
+
+	var corrected []string
+	cost, i := 0, 0
+	for _, part := range fixed {
+		optional := strings.HasSuffix(part, "?")
+		part = strings.TrimSuffix(part, "?")
+		if i < len(words) {
+			if alt, d, ok := matchWord(words[i], part); ok {
+				corrected = append(corrected, alt)
+				cost += d
+				i++
+				continue
+			}
+		}
+		if !optional {
+			return "", 0, false
+		}
+		corrected = append(corrected, part)
+		cost++
+	}
+
+	rest := words[i:]
+	if placeholder != (len(rest) > 0) {
+		return "", 0, false
+	}
+	return strings.Join(append(corrected, rest...), " "), cost, true
+}
+
+// suggestCommand returns the command the text most likely meant, if any is
+// close enough, see matchTemplate. Ties go to the first template.
+func suggestCommand(text string) (string, bool) {
+	words := strings.Fields(strings.Replace(text, "`", "", -1))
+	if len(words) == 0 {
+		return "", false
+	}
+
+	best, bestCost := "", maxSuggestionCost+1
+	for _, t := range commandTemplates {
+		s, cost, ok := matchTemplate(words, t)
+		if ok && cost < bestCost {
+			best, bestCost = s, cost
+		}
+	}
+	if best == "" || best == strings.Join(words, " ") {
+		return "", false
+	}
+	return best, true
+}
+
+func handleSuggestion(ctx context.Context, update telegram.Update, suggestion string) {
+	msgConfig := telegram.NewMessage(update.Message.Chat.ID, "Did you mean `"+suggestion+"`? Send `help` for all the commands.")
+	msgConfig.ParseMode = "Markdown"
+	sendMsg(ctx, msgConfig)
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"list", "list", 0},
+		{"lst", "list", 1},
+		{"subscirbe", "subscribe", 2},
+		{"", "abc", 3},
+		{"déjà", "deja", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestCommand(t *testing.T) {
+	tests := []struct {
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{"subscribe dune", "subscribe to dune", true},
+		{"subscirbe to dune", "subscribe to dune", true},
+		{"unsubscribe dune", "unsubscribe from dune", true},
+		{"lst subscriptions", "list subscriptions", true},
+		{"list subs", "", false},
+		{"trailer on", "trailers on", true},
+		{"set regoin us", "set region us", true},
+		{"releses `dune`", "releases dune", true},
+		{"set silent maybe", "", false},
+		{"subscribe to", "", false},
+		{"list subscriptions", "", false},
+		{"hello there", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := suggestCommand(tt.text)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("suggestCommand(%q) = %q, %t, want %q, %t", tt.text, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHandleUpdateSuggestsCommand(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+
+	handleUpdate(testMessage(42, "Subscirbe to Dune"))
+
+	texts := tg.texts(42)
+	if want := "Did you mean `subscribe to dune`? Send `help` for all the commands."; len(texts) != 1 || texts[0] != want {
+		t.Errorf("sent %q, want %q", texts, want)
+	}
+}
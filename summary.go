@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// monthStart returns the first instant of the month of t, in its location.
+func monthStart(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// monthlySummaryDue returns whether the chat opted in to monthly summaries
+// and didn't get one since the current month started in its timezone.
+func monthlySummaryDue(prefs UserPrefs, now time.Time) bool {
+	if !prefs.MonthlySummary {
+		return false
+	}
+	loc := prefs.location()
+	return prefs.LastMonthlySummary.Before(monthStart(now.In(loc)))
+}
+
+// monthlySummaryText returns the summary of the month before now: the
+// subscriptions released during that month and those coming out in the
+// current one. It returns false when there is nothing to tell.
+func monthlySummaryText(subscriptions []MovieRelease, prefs UserPrefs, now time.Time) (string, bool) {
+	thisMonth := monthStart(now.In(prefs.location()))
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	nextMonth := thisMonth.AddDate(0, 1, 0)
+
+	sorted := append([]MovieRelease(nil), subscriptions...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ReleaseDate.Before(sorted[j].ReleaseDate) })
+
+	var released, coming string
+	for _, rec := range sorted {
+		d := rec.ReleaseDate
+		line := fmt.Sprintf("- %s (%s)\n", displayTitle(rec.MovieTitle, rec.ID), prefs.formatDate(d))
+		switch {
+		case d.IsZero():
+		case !d.Before(lastMonth) && d.Before(thisMonth):
+			released += line
+		case !d.Before(thisMonth) && d.Before(nextMonth):
+			coming += line
+		}
+	}
+	if released == "" && coming == "" {
+		return "", false
+	}
+
+	text := fmt.Sprintf("Your %s in review 🗓\n", lastMonth.Format("January 2006"))
+	if released != "" {
+		text += "\nReleased:\n" + released
+	}
+	if coming != "" {
+		text += fmt.Sprintf("\nComing in %s:\n", thisMonth.Format("January")) + coming
+	}
+	return text, true
+}
+
+// sendMonthlySummaries sends the monthly summaries that came due, once the
+// month is over in the timezone of each chat. Chats without subscriptions
+// don't get any.
+func sendMonthlySummaries(ctx context.Context) {
+	records, err := store.Releases(ctx)
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to get all subscriptions")
+		return
+	}
+
+	byChat := map[int64][]MovieRelease{}
+	var chats []int64
+	for _, rec := range records {
+		for _, sub := range rec.Subscribers {
+			if _, ok := byChat[sub.ChatID]; !ok {
+				chats = append(chats, sub.ChatID)
+			}
+			byChat[sub.ChatID] = append(byChat[sub.ChatID], rec)
+		}
+	}
+
+	now := time.Now()
+	sent := 0
+	for _, chatID := range chats {
+		if ctx.Err() != nil {
+			logf(ctx, "stopping monthly summaries: %s", ctx.Err())
+			return
+		}
+
+		prefs, err := store.Prefs(ctx, chatID)
+		if err != nil {
+			jobStoreFailed(ctx, err, "failed to get user prefs")
+			return
+		}
+		if !monthlySummaryDue(prefs, now) {
+			continue
+		}
+
+		if text, ok := monthlySummaryText(byChat[chatID], prefs, now); ok {
+			if err := sendNotification(ctx, Subscriber{ChatID: chatID, NotifyChatID: prefs.NotifyChatID}, text, notificationLog(notificationSummary, 0, "")); err != nil {
+				// Still due, the next run sends it again
+				logf(ctx, "failed to send monthly summary: chat_id=%d: %s", chatID, err)
+				continue
+			}
+			sent++
+		}
+
+		// Only the summary is recorded, the chat may have changed its
+		// preferences meanwhile
+		err = store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+			prefs.LastMonthlySummary = now
+			return nil
+		})
+		if err != nil {
+			jobStoreFailed(ctx, err, "failed to save user prefs")
+			return
+		}
+	}
+	logf(ctx, "sent monthly summaries: sent=%d", sent)
+}
+
+func handleMonthlySummary(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	enabled := matches[1] == "on"
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.MonthlySummary = enabled
+		if enabled {
+			// The first summary covers the current month
+			prefs.LastMonthlySummary = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	if enabled {
+		sendMsg(ctx, telegram.NewMessage(chatID, "At the start of every month I'll send you what came out the month before and what's coming. 🗓"))
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Monthly summaries are off."))
+}
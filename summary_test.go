@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonthlySummaryText(t *testing.T) {
+	prefs := UserPrefs{Timezone: "UTC"}
+	// Summary of December sent in January
+	now := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	subscriptions := []MovieRelease{
+		{ID: 1, MovieTitle: "Heat", ReleaseDate: time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, MovieTitle: "Dune", ReleaseDate: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)},
+		{ID: 3, MovieTitle: "Alien", ReleaseDate: time.Date(2025, 11, 30, 0, 0, 0, 0, time.UTC)},
+		{ID: 4, MovieTitle: "Ran", ReleaseDate: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 5, MovieTitle: "Solaris"},
+	}
+
+	text, ok := monthlySummaryText(subscriptions, prefs, now)
+	if !ok {
+		t.Fatal("monthlySummaryText() found nothing to tell")
+	}
+	if !strings.Contains(text, "December 2025 in review") || !strings.Contains(text, "Coming in January") {
+		t.Errorf("summary = %q, want December 2025 reviewed and January coming", text)
+	}
+	for title, want := range map[string]bool{"Heat": true, "Dune": true, "Alien": false, "Ran": false, "Solaris": false} {
+		if got := strings.Contains(text, title); got != want {
+			t.Errorf("summary mentions %s = %v, want %v", title, got, want)
+		}
+	}
+
+	if _, ok := monthlySummaryText(subscriptions[2:], prefs, now); ok {
+		t.Error("monthlySummaryText() sent a summary without anything released or coming")
+	}
+}
+
+func TestMonthlySummaryDue(t *testing.T) {
+	now := time.Date(2026, 3, 1, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		prefs UserPrefs
+		want  bool
+	}{
+		{"opted out", UserPrefs{Timezone: "UTC", LastMonthlySummary: now.AddDate(0, -1, 0)}, false},
+		{"last sent the month before", UserPrefs{Timezone: "UTC", MonthlySummary: true, LastMonthlySummary: now.AddDate(0, -1, 0)}, true},
+		{"already sent this month", UserPrefs{Timezone: "UTC", MonthlySummary: true, LastMonthlySummary: now.Add(-10 * time.Minute)}, false},
+		{"month not over in the chat timezone", UserPrefs{Timezone: "America/New_York", MonthlySummary: true, LastMonthlySummary: now.AddDate(0, 0, -10)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := monthlySummaryDue(tt.prefs, now); got != tt.want {
+				t.Errorf("monthlySummaryDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMonthlySummariesKeepsConcurrentPrefs(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	lastMonth := time.Date(now.Year(), now.Month()-1, 10, 0, 0, 0, 0, time.UTC)
+	if err := store.PutPrefs(ctx, UserPrefs{ChatID: 42, Timezone: "UTC", MonthlySummary: true, LastMonthlySummary: lastMonth.AddDate(0, -1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune", ReleaseDate: lastMonth, Subscribers: []Subscriber{{ChatID: 42, Notified: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	// The chat turns silent notifications on while the summary is sent
+	tg.fail = func(c telegramCall) string {
+		store.UpdatePrefs(ctx, 42, func(p *UserPrefs) error {
+			p.SilentNotifications = true
+			return nil
+		})
+		return ""
+	}
+
+	sendMonthlySummaries(ctx)
+
+	if texts := tg.texts(42); len(texts) != 1 || !strings.Contains(texts[0], "Dune") {
+		t.Fatalf("sent %q, want the summary", texts)
+	}
+	got := s.prefs[42]
+	if !got.SilentNotifications {
+		t.Error("the preference changed during the run was overwritten")
+	}
+	if got.LastMonthlySummary.Before(monthStart(now)) {
+		t.Error("the summary wasn't recorded")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// chatMigrations remembers the groups upgraded to supergroups while the
+// process runs, so that subscribers updated by their old ID after the
+// migration, e.g. marked notified, are found under the new one.
+type chatMigrations struct {
+	mu    sync.Mutex
+	newID map[int64]int64
+}
+
+var migratedChats = &chatMigrations{newID: map[int64]int64{}}
+
+func (m *chatMigrations) add(from, to int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.newID[from] = to
+}
+
+// resolve returns the ID the chat migrated to, or the chat itself.
+func (m *chatMigrations) resolve(chatID int64) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if to, ok := m.newID[chatID]; ok {
+		return to
+	}
+	return chatID
+}
+
+// chatMigration returns the old and new ID of a group upgraded to a
+// supergroup, from the service message Telegram sends to either of them.
+func chatMigration(msg *telegram.Message) (from, to int64, ok bool) {
+	switch {
+	case msg.MigrateToChatID != 0:
+		return msg.Chat.ID, msg.MigrateToChatID, true
+	case msg.MigrateFromChatID != 0:
+		return msg.MigrateFromChatID, msg.Chat.ID, true
+	}
+	return 0, 0, false
+}
+
+// migratedChatID returns the new ID of the chat a message failed to be sent
+// to because the group was upgraded to a supergroup.
+func migratedChatID(err error) (int64, bool) {
+	tgErr, ok := errors.Cause(err).(telegram.Error)
+	if !ok || tgErr.MigrateToChatID == 0 {
+		return 0, false
+	}
+	return tgErr.MigrateToChatID, true
+}
+
+// migrateSubscribers moves the subscriber entry of the chat from to the chat
+// to, keeping its settings: it is the same group under a new ID. When to is
+// already subscribed its own entry is kept. It returns whether any changed.
+func migrateSubscribers(subscribers []Subscriber, from, to int64) ([]Subscriber, bool) {
+	subscribed := false
+	for _, sub := range subscribers {
+		if sub.ChatID == to {
+			subscribed = true
+		}
+	}
+
+	changed := false
+	var migrated []Subscriber
+	for _, sub := range subscribers {
+		if sub.NotifyChatID == from {
+			sub.NotifyChatID = to
+			changed = true
+		}
+		if sub.ChatID == from {
+			changed = true
+			if subscribed {
+				continue
+			}
+			sub.ChatID = to
+		}
+		migrated = append(migrated, sub)
+	}
+	return migrated, changed
+}
+
+// migrateChat moves the data of a group upgraded to a supergroup to its new
+// ID, the same data deleteChatData deletes: subscriptions, tracked seasons,
+// searches, calendar link, followed people, prefs, linked user and
+// notification logs. Pending transfers are left to expire, a new one is
+// asked for from the supergroup. Each entity is updated in its own
+// transaction, running it again after a failure moves the rest.
+func migrateChat(ctx context.Context, from, to int64) error {
+	migratedChats.add(from, to)
+
+	subscriptions, err := chatSubscriptions(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get subscriptions")
+	}
+	for _, rec := range subscriptions {
+		err := store.UpdateRelease(ctx, rec.ID, func(tx *MovieRelease) error {
+			subscribers, changed := migrateSubscribers(tx.Subscribers, from, to)
+			if !changed {
+				return errSkipUpdate
+			}
+			tx.Subscribers = subscribers
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate subscription %d", rec.ID)
+		}
+	}
+
+	seasons, err := chatSeasons(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get seasons")
+	}
+	for _, rec := range seasons {
+		err := store.UpdateSeason(ctx, rec.ShowID, rec.Season, func(tx *SeasonRelease) error {
+			subscribers, changed := migrateSubscribers(tx.Subscribers, from, to)
+			if !changed {
+				return errSkipUpdate
+			}
+			tx.Subscribers = subscribers
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate season %d of show %d", rec.Season, rec.ShowID)
+		}
+	}
+
+	searches, err := chatSearches(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get searches")
+	}
+	if len(searches) > 0 {
+		moved := make([]SearchedMovie, len(searches))
+		for i, s := range searches {
+			s.ChatID = to
+			moved[i] = s
+		}
+		if err := store.PutSearches(ctx, moved); err != nil {
+			return errors.Wrap(err, "failed to migrate searches")
+		}
+		if err := store.DeleteSearches(ctx, searches); err != nil {
+			return errors.Wrap(err, "failed to delete old searches")
+		}
+	}
+
+	// A connection still pending is dropped, its OAuth state names the old
+	// ID. A calendar already connected to the new ID is kept.
+	link, err := store.CalendarLink(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get calendar link")
+	}
+	if len(link.Token) > 0 {
+		existing, err := store.CalendarLink(ctx, to)
+		if err != nil {
+			return errors.Wrap(err, "failed to get calendar link of new chat")
+		}
+		if len(existing.Token) == 0 {
+			link.ChatID = to
+			if err := store.PutCalendarLink(ctx, link); err != nil {
+				return errors.Wrap(err, "failed to migrate calendar link")
+			}
+		}
+	}
+	if link.State != "" || len(link.Token) > 0 {
+		if err := store.DeleteCalendarLink(ctx, from); err != nil {
+			return errors.Wrap(err, "failed to delete old calendar link")
+		}
+	}
+
+	following, err := store.FollowedPeople(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get followed people")
+	}
+	for _, p := range following {
+		p.ChatID = to
+		if err := store.PutFollowedPerson(ctx, p); err != nil {
+			return errors.Wrapf(err, "failed to migrate followed person %d", p.PersonID)
+		}
+		if err := store.DeleteFollowedPerson(ctx, from, p.PersonID); err != nil {
+			return errors.Wrapf(err, "failed to delete old followed person %d", p.PersonID)
+		}
+	}
+
+	// Both chats get a service message, the prefs already moved must not be
+	// replaced by the defaults
+	prefs, err := store.Prefs(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user prefs")
+	}
+	if !reflect.DeepEqual(prefs, UserPrefs{ChatID: from}) {
+		prefs.ChatID = to
+		if prefs.NotifyChatID == from {
+			prefs.NotifyChatID = 0
+		}
+		if err := store.PutPrefs(ctx, prefs); err != nil {
+			return errors.Wrap(err, "failed to save user prefs")
+		}
+		if err := store.DeletePrefs(ctx, from); err != nil {
+			return errors.Wrap(err, "failed to delete old user prefs")
+		}
+	}
+
+	user, err := store.ChatUser(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user of chat")
+	}
+	if user.UserID != 0 {
+		err := store.UpdateUser(ctx, user.UserID, func(u *User) error {
+			if !u.linked(from) {
+				return errSkipUpdate
+			}
+			primary := u.PrimaryChatID == from
+			u.unlink(from)
+			if !u.linked(to) {
+				u.ChatIDs = append(u.ChatIDs, to)
+			}
+			if primary {
+				u.PrimaryChatID = to
+			}
+			return nil
+		})
+		// The new chat was linked to someone else meanwhile, it stays theirs
+		if errors.Cause(err) == errChatLinked {
+			err = unlinkChatUser(ctx, from)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to save user")
+		}
+	}
+
+	logs, err := store.NotificationLogs(ctx, from)
+	if err != nil {
+		return errors.Wrap(err, "failed to get notification logs")
+	}
+	if len(logs) > 0 {
+		for i := range logs {
+			logs[i].ChatID = to
+		}
+		if err := store.PutNotificationLogs(ctx, logs); err != nil {
+			return errors.Wrap(err, "failed to migrate notification logs")
+		}
+		if _, err := store.DeleteNotificationLogs(ctx, from, time.Now()); err != nil {
+			return errors.Wrap(err, "failed to delete old notification logs")
+		}
+	}
+	return nil
+}
+
+// handleChatMigration moves the data of a group upgraded to a supergroup,
+// on the service message of the migration.
+func handleChatMigration(ctx context.Context, from, to int64) {
+	logf(ctx, "chat %d migrated to %d, moving its data", from, to)
+	if err := migrateChat(ctx, from, to); err != nil {
+		logf(ctx, "failed to migrate chat %d to %d: %s", from, to, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestMigrateSubscribers(t *testing.T) {
+	subscribers := []Subscriber{
+		{ChatID: -100, RemindDays: 3},
+		{ChatID: 42, NotifyChatID: -100},
+	}
+	got, changed := migrateSubscribers(subscribers, -100, -1001)
+	want := []Subscriber{{ChatID: -1001, RemindDays: 3}, {ChatID: 42, NotifyChatID: -1001}}
+	if !changed || !reflect.DeepEqual(got, want) {
+		t.Errorf("migrateSubscribers = %+v %t, want %+v true", got, changed, want)
+	}
+
+	// The new chat already subscribed, its entry is kept
+	subscribers = []Subscriber{{ChatID: -100, RemindDays: 3}, {ChatID: -1001}}
+	got, changed = migrateSubscribers(subscribers, -100, -1001)
+	if want := []Subscriber{{ChatID: -1001}}; !changed || !reflect.DeepEqual(got, want) {
+		t.Errorf("already subscribed: migrateSubscribers = %+v %t, want %+v true", got, changed, want)
+	}
+
+	if _, changed := migrateSubscribers([]Subscriber{{ChatID: 42}}, -100, -1001); changed {
+		t.Error("subscribers of other chats changed")
+	}
+}
+
+func TestHandleUpdateChatMigration(t *testing.T) {
+	s := useMemStore(t)
+	useFakeTelegram(t)
+	ctx := context.Background()
+	const from, to = -100, -1001
+	sentAt := time.Now().Add(-time.Hour)
+
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: from, RemindDays: 3}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutSeason(ctx, SeasonRelease{ShowID: 2, Season: 1, Subscribers: []Subscriber{{ChatID: from}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutSearches(ctx, []SearchedMovie{{ChatID: from, MovieID: 3, MovieTitle: "Tenet"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutCalendarLink(ctx, CalendarLink{ChatID: from, Token: []byte("sealed")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutFollowedPerson(ctx, FollowedPerson{ChatID: from, PersonID: 4, Name: "Denis Villeneuve"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutPrefs(ctx, UserPrefs{ChatID: from, Posters: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutNotificationLogs(ctx, []NotificationLog{{ChatID: from, MovieID: 1, Title: "Dune", SentAt: sentAt}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Telegram sends the service message to both chats
+	handleUpdate(telegram.Update{Message: &telegram.Message{
+		Chat:            &telegram.Chat{ID: from, Type: "group"},
+		MigrateToChatID: to,
+	}})
+	handleUpdate(telegram.Update{Message: &telegram.Message{
+		Chat:              &telegram.Chat{ID: to, Type: "supergroup"},
+		MigrateFromChatID: from,
+	}})
+
+	if subs := s.releases[1].Subscribers; !reflect.DeepEqual(subs, []Subscriber{{ChatID: to, RemindDays: 3}}) {
+		t.Errorf("release subscribers = %+v, want the subscription moved", subs)
+	}
+	seasons, _ := chatSeasons(ctx, to)
+	if len(seasons) != 1 {
+		t.Errorf("seasons of the new chat = %+v, want the tracked season", seasons)
+	}
+	searches, _ := chatSearches(ctx, to)
+	if len(searches) != 1 || searches[0].MovieID != 3 {
+		t.Errorf("searches of the new chat = %+v, want the search moved", searches)
+	}
+	if old, _ := chatSearches(ctx, from); len(old) != 0 {
+		t.Errorf("searches of the old chat = %+v, want none", old)
+	}
+	if link := s.links[to]; string(link.Token) != "sealed" {
+		t.Errorf("calendar link of the new chat = %+v, want the link moved", link)
+	}
+	if _, ok := s.links[from]; ok {
+		t.Error("calendar link of the old chat kept")
+	}
+	if following, _ := store.FollowedPeople(ctx, to); len(following) != 1 || following[0].PersonID != 4 {
+		t.Errorf("followed people of the new chat = %+v, want the person moved", following)
+	}
+	if prefs, _ := store.Prefs(ctx, to); !prefs.Posters {
+		t.Errorf("prefs of the new chat = %+v, want the prefs moved", prefs)
+	}
+	if _, ok := s.prefs[from]; ok {
+		t.Error("prefs of the old chat kept")
+	}
+	logs, _ := store.NotificationLogs(ctx, to)
+	if len(logs) != 1 || logs[0].MovieID != 1 || !logs[0].SentAt.Equal(sentAt) {
+		t.Errorf("notification logs of the new chat = %+v, want the log moved", logs)
+	}
+	if old, _ := store.NotificationLogs(ctx, from); len(old) != 0 {
+		t.Errorf("notification logs of the old chat = %+v, want none", old)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// Notification templates, the operator defaults can be set via the
+// environment variable of the same name and users can set their own.
+const (
+	templateUpcoming = "upcoming"
+	templateReleased = "released"
+)
+
+// builtinTemplates are used when no valid template is configured.
+var builtinTemplates = map[string]string{
+	templateUpcoming: "{{.Title}} will be released in {{.Days}} days.",
+	templateReleased: "{{.Title}} came out on {{.Date}} while your notifications were paused.",
+}
+
+// templateEnv maps each template to the environment variable holding the
+// operator default.
+var templateEnv = map[string]string{
+	templateUpcoming: "NOTIFY_UPCOMING_TEMPLATE",
+	templateReleased: "NOTIFY_RELEASED_TEMPLATE",
+}
+
+// setTemplateCommand is matched against the original message text, the
+// template must keep its case.
+var setTemplateCommand = regexp.MustCompile(`(?is)^set template (upcoming|released) (.+)$`)
+
+// notificationData holds the fields available to notification templates.
+type notificationData struct {
+	Title string
+	Days  int
+	Date  string
+}
+
+var notifyTemplates = loadTemplates(func(string) string { return "" })
+
+// loadTemplates parses the operator templates read with getenv, falling back
+// to the built-in template on error.
+func loadTemplates(getenv func(string) string) map[string]*template.Template {
+	templates := map[string]*template.Template{}
+	for name, builtin := range builtinTemplates {
+		if text := getenv(templateEnv[name]); text != "" {
+			t, err := parseTemplate(name, text)
+			if err == nil {
+				templates[name] = t
+				continue
+			}
+			log.Printf("WARNING: invalid %s, using the built-in template: %s", templateEnv[name], err)
+		}
+		templates[name] = template.Must(parseTemplate(name, builtin))
+	}
+	return templates
+}
+
+// parseTemplate parses a notification template and checks it renders with
+// sample data, so that a template referencing unknown fields is rejected
+// upfront.
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse template")
+	}
+	sample := notificationData{Title: "Alita: Battle Angel", Days: 3, Date: formatReleaseDate(time.Now())}
+	if err := t.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, errors.Wrap(err, "failed to render template")
+	}
+	return t, nil
+}
+
+// renderNotification renders the named notification template, preferring the
+// one set by the user. Any failure falls back to the operator default, then
+// to the built-in template.
+func renderNotification(name string, prefs UserPrefs, data notificationData) string {
+	var candidates []*template.Template
+	if text := prefs.template(name); text != "" {
+		if t, err := parseTemplate(name, text); err == nil {
+			candidates = append(candidates, t)
+		}
+	}
+	candidates = append(candidates, notifyTemplates[name], template.Must(parseTemplate(name, builtinTemplates[name])))
+
+	for _, t := range candidates {
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err == nil {
+			return b.String()
+		}
+	}
+	return ""
+}
+
+func handleSetTemplate(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	matches := setTemplateCommand.FindStringSubmatch(strings.TrimSpace(update.Message.Text))
+	if matches == nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Use \"set template upcoming|released <template>\"."))
+		return
+	}
+	name := strings.ToLower(matches[1])
+	text := strings.TrimSpace(matches[2])
+
+	if _, err := parseTemplate(name, text); err != nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, "That template doesn't work: "+err.Error()+"\nAvailable fields are {{.Title}}, {{.Days}} and {{.Date}}."))
+		return
+	}
+
+	var prefs UserPrefs
+	err := store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.setTemplate(name, text)
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	preview := renderNotification(name, prefs, notificationData{Title: "Alita: Battle Angel", Days: 3, Date: formatReleaseDate(time.Now())})
+	sendMsg(ctx, telegram.NewMessage(chatID, "Template saved, your notifications will look like this:\n"+preview))
+}
+
+func handleClearTemplate(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.setTemplate(matches[1], "")
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, "Back to the default "+matches[1]+" template."))
+}
+
+// template returns the notification template set by the user, if any.
+func (p UserPrefs) template(name string) string {
+	switch name {
+	case templateUpcoming:
+		return p.UpcomingTemplate
+	case templateReleased:
+		return p.ReleasedTemplate
+	}
+	return ""
+}
+
+// setTemplate sets the notification template of the user, an empty text
+// restores the default.
+func (p *UserPrefs) setTemplate(name, text string) {
+	switch name {
+	case templateUpcoming:
+		p.UpcomingTemplate = text
+	case templateReleased:
+		p.ReleasedTemplate = text
+	}
+}
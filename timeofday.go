@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// Time formats a chat can choose from to display times of day.
+const (
+	timeFormat12h = "12h"
+	timeFormat24h = "24h"
+)
+
+// twelveHourRegions are the regions displaying times in the 12-hour format
+// by default.
+var twelveHourRegions = map[string]bool{
+	"US": true,
+	"CA": true,
+	"AU": true,
+	"NZ": true,
+	"IN": true,
+	"PH": true,
+}
+
+// timeOfDayInput matches times such as "18:30", "6pm", "6:30 pm" or "18h".
+var timeOfDayInput = regexp.MustCompile(`^([0-9]{1,2})(?:[:.h]([0-9]{2}))?\s*(am|pm|h)?$`)
+
+// timeOfDay is a time of day, in minutes after midnight.
+type timeOfDay int
+
+// parseTimeOfDay parses a time of day given in the 12-hour or the 24-hour
+// format, whatever the display preference of the chat.
+func parseTimeOfDay(s string) (timeOfDay, error) {
+	invalid := errors.Errorf("%q is not a time, try 18:30 or 6:30pm", s)
+
+	m := timeOfDayInput.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, invalid
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	if minute > 59 {
+		return 0, invalid
+	}
+
+	switch m[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, invalid
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	default:
+		if hour > 23 {
+			return 0, invalid
+		}
+	}
+	return timeOfDay(hour*60 + minute), nil
+}
+
+// Hour returns the hour, in the range [0, 23].
+func (t timeOfDay) Hour() int { return int(t) / 60 }
+
+// Minute returns the minute offset within the hour.
+func (t timeOfDay) Minute() int { return int(t) % 60 }
+
+// format formats the time of day, e.g. "18:30" or "6:30 PM".
+func (t timeOfDay) format(format string) string {
+	if format != timeFormat12h {
+		return fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+	}
+	suffix := "AM"
+	if t.Hour() >= 12 {
+		suffix = "PM"
+	}
+	hour := t.Hour() % 12
+	if hour == 0 {
+		hour = 12
+	}
+	return fmt.Sprintf("%d:%02d %s", hour, t.Minute(), suffix)
+}
+
+// timeFormat returns the time format of the chat, defaulting to the one usual
+// in its region.
+func (p UserPrefs) timeFormat() string {
+	if p.TimeFormat != "" {
+		return p.TimeFormat
+	}
+	if twelveHourRegions[p.region()] {
+		return timeFormat12h
+	}
+	return timeFormat24h
+}
+
+// formatTimeOfDay formats the time of day following the chat preference.
+func (p UserPrefs) formatTimeOfDay(t timeOfDay) string {
+	return t.format(p.timeFormat())
+}
+
+func handleTimeFormat(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+
+	var prefs UserPrefs
+	err := store.UpdatePrefs(ctx, chatID, func(tx *UserPrefs) error {
+		tx.TimeFormat = matches[1]
+		prefs = *tx
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	example := prefs.formatTimeOfDay(timeOfDay(18*60 + 30))
+	sendMsg(ctx, telegram.NewMessage(chatID, "Times will be shown like "+example+"."))
+}
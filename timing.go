@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// timingKind identifies a backend commands spend time waiting for.
+type timingKind int
+
+const (
+	timingTMDB timingKind = iota
+	timingDatastore
+	timingTelegram
+
+	numTimingKinds
+)
+
+// commandTimings accumulates the time a single command spent in each
+// backend. Calls can run concurrently, e.g. during bulk subscribe.
+type commandTimings struct {
+	mu    sync.Mutex
+	spent [numTimingKinds]time.Duration
+}
+
+// withTimings returns a copy of ctx accumulating the time spent in backends
+// into the returned timings.
+func withTimings(ctx context.Context) (context.Context, *commandTimings) {
+	t := &commandTimings{}
+	return context.WithValue(ctx, timingsKey, t), t
+}
+
+// trackTime adds the time elapsed since start to the timings carried by ctx,
+// if any. It is meant to be deferred at the start of a backend call:
+//
+//	defer trackTime(ctx, timingTMDB, time.Now())
+func trackTime(ctx context.Context, kind timingKind, start time.Time) {
+	t, ok := ctx.Value(timingsKey).(*commandTimings)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start)
+	t.mu.Lock()
+	t.spent[kind] += elapsed
+	t.mu.Unlock()
+}
+
+// log logs how long the command took since start, broken down by backend.
+// The remainder is time spent in the bot itself.
+func (t *commandTimings) log(ctx context.Context, command string, start time.Time) {
+	total := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	logf(ctx, "command timing: command=%s total_ms=%d tmdb_ms=%d datastore_ms=%d telegram_ms=%d",
+		command,
+		total.Milliseconds(),
+		t.spent[timingTMDB].Milliseconds(),
+		t.spent[timingDatastore].Milliseconds(),
+		t.spent[timingTelegram].Milliseconds(),
+	)
+}
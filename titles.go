@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// defaultMaxTitleLength is the number of characters a title is truncated to
+// in lists and notifications, unless MAX_TITLE_LENGTH is set.
+const defaultMaxTitleLength = 80
+
+var maxTitleLength = defaultMaxTitleLength
+
+// parseMaxTitleLength parses the maximum displayed title length.
+func parseMaxTitleLength(v string) int {
+	if v == "" {
+		return defaultMaxTitleLength
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 2 {
+		log.Printf("WARNING: invalid MAX_TITLE_LENGTH %q, using %d", v, defaultMaxTitleLength)
+		return defaultMaxTitleLength
+	}
+	return n
+}
+
+// truncateTitle shortens the title to max characters, ending with an
+// ellipsis when cut. It returns whether the title was truncated.
+func truncateTitle(title string, max int) (string, bool) {
+	runes := []rune(title)
+	if len(runes) <= max {
+		return title, false
+	}
+	return string(runes[:max-1]) + "…", true
+}
+
+// displayTitle returns the title as shown in lists and notifications.
+// Truncated titles are followed by the link to the TMDB page of the movie,
+// where the full title can be read. Stored titles are never truncated. Movies
+// TMDB has no title for are shown with a placeholder.
+func displayTitle(title string, movieID int64) string {
+	if title == "" {
+		if movieID == 0 {
+			return "Untitled"
+		}
+		return fmt.Sprintf("Untitled (TMDB #%d)", movieID)
+	}
+	short, truncated := truncateTitle(title, maxTitleLength)
+	if !truncated || movieID == 0 {
+		return short
+	}
+	return short + " " + tmdbMovieURL(movieID)
+}
+
+// releaseTitle returns the title of the release in the language of the chat
+// region, so that a movie keeps the name the user knows it by after a region
+// change. The stored title is used when the lookup fails.
+func releaseTitle(ctx context.Context, record MovieRelease, prefs UserPrefs) string {
+	language := prefs.language()
+	if language == "" {
+		return record.MovieTitle
+	}
+	title, err := localizedTitle(ctx, record.ID, language)
+	if err != nil {
+		logf(ctx, "failed to get localized title, using the stored one: id=%d: %s", record.ID, err)
+		return record.MovieTitle
+	}
+	if title == "" {
+		return record.MovieTitle
+	}
+	return title
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParseMaxTitleLength(t *testing.T) {
+	tests := []struct {
+		v    string
+		want int
+	}{
+		{"", defaultMaxTitleLength},
+		{"40", 40},
+		{"2", 2},
+		{"1", defaultMaxTitleLength},
+		{"long", defaultMaxTitleLength},
+	}
+	for _, tt := range tests {
+		if got := parseMaxTitleLength(tt.v); got != tt.want {
+			t.Errorf("parseMaxTitleLength(%q) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateTitle(t *testing.T) {
+	tests := []struct {
+		title, want string
+		truncated   bool
+	}{
+		{"Dune", "Dune", false},
+		{"Dune: Part Two", "Dune: Pa…", true},
+		{"Amélie Poulain", "Amélie P…", true},
+		{"千と千尋の神隠し", "千と千尋の神隠し", false},
+		{"123456789", "123456789", false},
+	}
+	for _, tt := range tests {
+		got, truncated := truncateTitle(tt.title, 9)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("truncateTitle(%q) = %q, %v, want %q, %v", tt.title, got, truncated, tt.want, tt.truncated)
+		}
+	}
+}
+
+func TestDisplayTitlePathologicallyLong(t *testing.T) {
+	title := strings.Repeat("Ça ", 2000)
+
+	got := displayTitle(title, 42)
+	short := strings.TrimSuffix(got, " "+tmdbMovieURL(42))
+	if short == got {
+		t.Fatalf("displayTitle() = %q, want the TMDB link after a truncated title", got)
+	}
+	if n := utf8.RuneCountInString(short); n != maxTitleLength || !strings.HasSuffix(short, "…") {
+		t.Errorf("displayed title has %d characters, want %d ending with an ellipsis", n, maxTitleLength)
+	}
+
+	// The stored title stays full, only the notification is shortened
+	record := MovieRelease{ID: 42, MovieTitle: title, ReleaseDate: time.Now().AddDate(0, 0, 2)}
+	text, _, ok := notificationText(record, Subscriber{ChatID: 1}, UserPrefs{ChatID: 1}, time.Now())
+	if !ok || len(text) > 1000 {
+		t.Errorf("notification is %d bytes long, want the title truncated", len(text))
+	}
+	if record.MovieTitle != title {
+		t.Error("the stored title was changed")
+	}
+}
+
+func TestReleaseTitle(t *testing.T) {
+	f := useFakeTMDB(t)
+	ctx := context.Background()
+	f.route("/movie/10", MovieAPIResult{ID: 10, Title: "Dune: Der Wüstenplanet"})
+	f.route("/movie/20", MovieAPIResult{ID: 20})
+
+	tests := []struct {
+		name   string
+		record MovieRelease
+		prefs  UserPrefs
+		want   string
+	}{
+		{"localized", MovieRelease{ID: 10, MovieTitle: "Dune"}, UserPrefs{Region: "DE"}, "Dune: Der Wüstenplanet"},
+		{"no localized title", MovieRelease{ID: 20, MovieTitle: "Alien"}, UserPrefs{Region: "DE"}, "Alien"},
+		{"lookup failed", MovieRelease{ID: 30, MovieTitle: "Heat"}, UserPrefs{Region: "DE"}, "Heat"},
+		{"region without language", MovieRelease{ID: 10, MovieTitle: "Dune"}, UserPrefs{Region: "XX"}, "Dune"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := releaseTitle(ctx, tt.record, tt.prefs); got != tt.want {
+				t.Errorf("releaseTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
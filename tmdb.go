@@ -0,0 +1,956 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultTMDBAPIURL and defaultTMDBAPIVersion make the base of the TMDB
+	// API paths, unless TMDB_API_URL or TMDB_API_VERSION are set.
+	defaultTMDBAPIURL     = "https://api.themoviedb.org"
+	defaultTMDBAPIVersion = "3"
+	// tmdbProfileSize is the size of the profile photos of people, fitting
+	// thumbnails.
+	tmdbProfileSize = "w185"
+)
+
+// MovieAPIResult ...
+type MovieAPIResult struct {
+	Title         string  `json:"title"`
+	OriginalTitle string  `json:"original_title"`
+	ReleaseDate   string  `json:"release_date"`
+	ID            int64   `json:"id"`
+	GenreIDs      []int   `json:"genre_ids"`
+	VoteAverage   float64 `json:"vote_average"`
+	VoteCount     int     `json:"vote_count"`
+	Popularity    float64 `json:"popularity"`
+	PosterPath    string  `json:"poster_path"`
+	ReleaseTime   time.Time
+}
+
+// MovieAPIResults ...
+type MovieAPIResults []MovieAPIResult
+
+func (r MovieAPIResults) Len() int           { return len(r) }
+func (r MovieAPIResults) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r MovieAPIResults) Less(i, j int) bool { return r[i].ReleaseTime.Before(r[j].ReleaseTime) }
+
+// tmdbMovieURL returns the link to the TMDB page of the movie.
+func tmdbMovieURL(id int64) string {
+	return fmt.Sprintf("https://www.themoviedb.org/movie/%d", id)
+}
+
+const (
+	// defaultTMDBCallTimeout bounds a single TMDB API call, on top of any
+	// deadline already carried by the caller context, unless TMDB_CALL_TIMEOUT
+	// is set.
+	defaultTMDBCallTimeout = 10 * time.Second
+	// defaultTMDBMaxResponseSize is the largest TMDB response body read, in
+	// bytes, unless TMDB_MAX_RESPONSE_BYTES is set.
+	defaultTMDBMaxResponseSize = 2 << 20
+	// tmdbClientTimeout bounds any request sent by the shared HTTP client.
+	tmdbClientTimeout = 30 * time.Second
+)
+
+// tmdb is the client used by every TMDB API call, created at startup.
+var tmdb = newTMDBClient("", newTMDBHTTPClient())
+
+// tmdbClient sends requests to the TMDB API. It paces requests according to
+// the rate limit headers of the responses, and prefers cached responses when
+// few requests are left.
+type tmdbClient struct {
+	httpClient *http.Client
+	apiKey     string
+	quota      *tmdbQuota
+	cache      *tmdbCache
+
+	// baseURL is the API URL the request paths are relative to, version
+	// included.
+	baseURL string
+	// callTimeout bounds a single API call.
+	callTimeout time.Duration
+	// maxResponseSize is the largest response body read, in bytes.
+	maxResponseSize int64
+}
+
+func newTMDBClient(apiKey string, httpClient *http.Client) *tmdbClient {
+	return &tmdbClient{
+		httpClient:      httpClient,
+		apiKey:          apiKey,
+		quota:           &tmdbQuota{},
+		cache:           newTMDBCache(),
+		baseURL:         defaultTMDBAPIURL + "/" + defaultTMDBAPIVersion,
+		callTimeout:     defaultTMDBCallTimeout,
+		maxResponseSize: defaultTMDBMaxResponseSize,
+	}
+}
+
+// configure overrides the call timeout and maximum response size with
+// TMDB_CALL_TIMEOUT, a duration such as "5s", and TMDB_MAX_RESPONSE_BYTES,
+// and the API base with TMDB_API_URL, e.g. a caching mirror, and
+// TMDB_API_VERSION. Invalid values are logged and ignored.
+func (c *tmdbClient) configure(getenv func(string) string) {
+	apiURL, version := defaultTMDBAPIURL, defaultTMDBAPIVersion
+	if v := getenv("TMDB_API_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Printf("WARNING: invalid TMDB_API_URL %q, using %s", v, apiURL)
+		} else {
+			apiURL = strings.TrimSuffix(v, "/")
+		}
+	}
+	if v := getenv("TMDB_API_VERSION"); v != "" {
+		if strings.Trim(v, "/") == "" || strings.ContainsAny(v, "?#") {
+			log.Printf("WARNING: invalid TMDB_API_VERSION %q, using %s", v, version)
+		} else {
+			version = strings.Trim(v, "/")
+		}
+	}
+	c.baseURL = apiURL + "/" + version
+
+	if v := getenv("TMDB_CALL_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("WARNING: invalid TMDB_CALL_TIMEOUT %q, using %s", v, c.callTimeout)
+		} else {
+			c.callTimeout = d
+		}
+	}
+	if v := getenv("TMDB_MAX_RESPONSE_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			log.Printf("WARNING: invalid TMDB_MAX_RESPONSE_BYTES %q, using %d", v, c.maxResponseSize)
+		} else {
+			c.maxResponseSize = n
+		}
+	}
+}
+
+// newTMDBHTTPClient returns the HTTP client shared by all TMDB calls. It reuses
+// connections and bounds every step of a request so that a slow upstream
+// cannot hang the bot.
+func newTMDBHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: tmdbClientTimeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          20,
+			MaxIdleConnsPerHost:   10,
+			MaxConnsPerHost:       20,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// get sends a GET request to the given TMDB API path and decodes the JSON
+// response into v.
+func (c *tmdbClient) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	defer trackTime(ctx, timingTMDB, time.Now())
+	ctx, cancel := context.WithTimeout(ctx, c.callTimeout)
+	defer cancel()
+
+	u, err := c.tmdbURL(path, query)
+	if err != nil {
+		return err
+	}
+	// The key of the chat, if any, spares the shared quota
+	key, shared := c.apiKey, true
+	if chatKey, ok := chatTMDBKey(ctx); ok {
+		key, shared = chatKey, false
+	}
+
+	b, err := c.fetch(ctx, withAPIKey(u, key), shared)
+	if err != nil {
+		return scrubKey(err, key)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		// Most likely a change of the API, the path tells which call
+		return errors.Wrapf(err, "failed to parse json of %s", path)
+	}
+
+	return nil
+}
+
+// tmdbURL returns the URL of the API path, e.g. "/movie/42", relative to the
+// configured base and version, with the query added to the one of the path.
+func (c *tmdbClient) tmdbURL(path string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(c.baseURL + path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse url")
+	}
+	q := u.Query()
+	for k, values := range query {
+		for _, value := range values {
+			q.Add(k, value)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// withAPIKey returns the URL of the request sent with the API key. It also
+// keys the cached responses, so that a chat key never gets the responses of
+// another key.
+func withAPIKey(u *url.URL, key string) string {
+	withKey := *u
+	q := withKey.Query()
+	q.Set("api_key", key)
+	withKey.RawQuery = q.Encode()
+	return withKey.String()
+}
+
+// forget drops the cached response of the request, so that the next get
+// under low quota fetches it again.
+func (c *tmdbClient) forget(path string, query url.Values) {
+	if u, err := c.tmdbURL(path, query); err == nil {
+		c.cache.drop(withAPIKey(u, c.apiKey))
+	}
+}
+
+// fetch returns the body of the response to the GET request. Responses to
+// the shared API key are cached, and served instead of sending the request
+// while its quota is low. Only requests with the shared API key count against
+// its quota.
+func (c *tmdbClient) fetch(ctx context.Context, rawURL string, shared bool) ([]byte, error) {
+	if shared && c.quota.low(time.Now()) {
+		if b, ok := c.cache.get(rawURL, tmdbCacheMaxStale, time.Now()); ok {
+			return b, nil
+		}
+	}
+
+	if shared {
+		if err := c.quota.wait(ctx); err != nil {
+			return nil, errors.Wrap(err, "failed to wait for tmdb quota")
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create http request")
+	}
+
+	res, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send http get request")
+	}
+	defer res.Body.Close()
+
+	if shared {
+		c.quota.update(res, time.Now())
+	}
+
+	if res.StatusCode != 200 {
+		return nil, tmdbStatusError(res)
+	}
+
+	// Read one byte more than allowed to detect oversized bodies
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, c.maxResponseSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed read request body")
+	}
+	if int64(len(b)) > c.maxResponseSize {
+		return nil, errors.Errorf("response body larger than %d bytes", c.maxResponseSize)
+	}
+
+	if shared {
+		c.cache.put(rawURL, b, time.Now())
+	}
+	return b, nil
+}
+
+// tmdbErrorBody is the body of TMDB error responses.
+type tmdbErrorBody struct {
+	StatusCode    int    `json:"status_code"`
+	StatusMessage string `json:"status_message"`
+}
+
+// maxErrorBodySize is the largest error response body read.
+const maxErrorBodySize = 4 << 10
+
+// errTMDBNotFound is the cause of the errors of 404 responses, e.g. for a
+// movie TMDB deleted or merged into another one.
+var errTMDBNotFound = errors.New("not found on tmdb")
+
+// errTMDBUnauthorized is the cause of the errors of 401 responses, TMDB
+// rejected the API key.
+var errTMDBUnauthorized = errors.New("api key rejected by tmdb")
+
+// tmdbUnavailableText is the reply to commands TMDB failed to answer, e.g.
+// after the call timeout.
+const tmdbUnavailableText = "TMDB is slow to answer right now, please try again in a moment 🐢"
+
+// tmdbFailed handles a TMDB error met while serving a chat: it is logged and
+// the user is asked to try again. The bot keeps running, a slow TMDB only
+// fails the commands needing it.
+func tmdbFailed(ctx context.Context, chatID int64, err error, what string) {
+	if keyRejected(ctx, err) {
+		logf(ctx, "%s, tmdb rejected the key of chat %d: %s", what, chatID, err)
+		forgetRejectedKey(ctx, chatID)
+		sendMsg(ctx, telegram.NewMessage(chatID, tmdbKeyRejectedText))
+		return
+	}
+	logf(ctx, "%s: %s", what, err)
+	sendMsg(ctx, telegram.NewMessage(chatID, tmdbUnavailableText))
+}
+
+// callbackTMDBFailed is like tmdbFailed for callback queries, it returns the
+// callback answer.
+func callbackTMDBFailed(ctx context.Context, chatID int64, err error, what string) string {
+	if keyRejected(ctx, err) {
+		logf(ctx, "%s, tmdb rejected the key of chat %d: %s", what, chatID, err)
+		forgetRejectedKey(ctx, chatID)
+		return tmdbKeyRejectedText
+	}
+	logf(ctx, "%s: %s", what, err)
+	return tmdbUnavailableText
+}
+
+// tmdbStatusError returns the error of a non-200 response, including the
+// status message of the body when TMDB sent one. The message is meant for
+// the logs, not for users.
+func tmdbStatusError(res *http.Response) error {
+	msg := fmt.Sprintf("unexpected status code: %d", res.StatusCode)
+	var body tmdbErrorBody
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	if err == nil && json.Unmarshal(b, &body) == nil && body.StatusMessage != "" {
+		msg += fmt.Sprintf(": tmdb status %d: %s", body.StatusCode, body.StatusMessage)
+	}
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return errors.Wrap(errTMDBNotFound, msg)
+	case http.StatusUnauthorized:
+		return errors.Wrap(errTMDBUnauthorized, msg)
+	}
+	return errors.New(msg)
+}
+
+// normalize fills in the fields derived from the raw TMDB data. TMDB can
+// return null or missing fields: a missing or malformed release date is
+// treated as unknown, a missing title falls back to the original title. A
+// title missing both is left empty, displayTitle shows a placeholder.
+func (m *MovieAPIResult) normalize() {
+	m.Title = strings.TrimSpace(m.Title)
+	if m.Title == "" {
+		m.Title = strings.TrimSpace(m.OriginalTitle)
+	}
+
+	m.ReleaseTime = time.Time{}
+	if m.ReleaseDate == "" {
+		return
+	}
+	t, err := time.Parse("2006-01-02", m.ReleaseDate)
+	if err != nil {
+		return
+	}
+	m.ReleaseTime = t
+}
+
+// normalize normalizes every result and sorts them, most recent release
+// first. Results without an ID cannot be referenced and are dropped.
+func (r MovieAPIResults) normalize() MovieAPIResults {
+	var results MovieAPIResults
+	for _, m := range r {
+		if m.ID == 0 {
+			continue
+		}
+		m.normalize()
+		results = append(results, m)
+	}
+	sort.Sort(sort.Reverse(results))
+	return results
+}
+
+func queryMovies(ctx context.Context, movieTitle, year string) (MovieAPIResults, error) {
+	q := url.Values{}
+	q.Set("query", movieTitle)
+	q.Set("year", year)
+
+	var data struct {
+		Results MovieAPIResults `json:"results"`
+	}
+	if err := tmdb.get(ctx, "/search/movie", q, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Results.normalize(), nil
+}
+
+// upcomingMovies returns the movies TMDB lists as upcoming in the given region.
+func upcomingMovies(ctx context.Context, region string) (MovieAPIResults, error) {
+	q := url.Values{}
+	q.Set("region", region)
+
+	var data struct {
+		Results MovieAPIResults `json:"results"`
+	}
+	if err := tmdb.get(ctx, "/movie/upcoming", q, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Results.normalize(), nil
+}
+
+// trendingMovies returns the movies trending on TMDB this week.
+func trendingMovies(ctx context.Context) (MovieAPIResults, error) {
+	var data struct {
+		Results MovieAPIResults `json:"results"`
+	}
+	if err := tmdb.get(ctx, "/trending/movie/week", nil, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Results.normalize(), nil
+}
+
+// discoverReleases returns the movies released in theaters in the region
+// between from and to, both days included, of the given genre unless genreID
+// is zero.
+func discoverReleases(ctx context.Context, region string, genreID int, from, to time.Time) (MovieAPIResults, error) {
+	q := url.Values{}
+	q.Set("region", region)
+	if genreID != 0 {
+		q.Set("with_genres", strconv.Itoa(genreID))
+	}
+	q.Set("release_date.gte", from.Format("2006-01-02"))
+	q.Set("release_date.lte", to.Format("2006-01-02"))
+	// Theatrical releases, limited or not
+	q.Set("with_release_type", "2|3")
+	q.Set("sort_by", "popularity.desc")
+
+	var data struct {
+		Results MovieAPIResults `json:"results"`
+	}
+	if err := tmdb.get(ctx, "/discover/movie", q, &data); err != nil {
+		return nil, err
+	}
+
+	return data.Results.normalize(), nil
+}
+
+// movieByID returns the movie identified by its TMDB ID.
+func movieByID(ctx context.Context, id int64) (MovieAPIResult, error) {
+	var movie MovieAPIResult
+	if err := tmdb.get(ctx, fmt.Sprintf("/movie/%d", id), nil, &movie); err != nil {
+		return MovieAPIResult{}, err
+	}
+	movie.normalize()
+	return movie, nil
+}
+
+// localizedTitle returns the title of the movie in the given TMDB language,
+// e.g. "de-DE".
+func localizedTitle(ctx context.Context, id int64, language string) (string, error) {
+	q := url.Values{}
+	q.Set("language", language)
+
+	var movie MovieAPIResult
+	if err := tmdb.get(ctx, fmt.Sprintf("/movie/%d", id), q, &movie); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(movie.Title), nil
+}
+
+// MovieListItem ...
+type MovieListItem struct {
+	MovieAPIResult
+	MediaType string `json:"media_type"`
+}
+
+// MovieList ...
+type MovieList struct {
+	Name  string          `json:"name"`
+	Items []MovieListItem `json:"items"`
+}
+
+// movieList returns the TMDB list identified by id.
+func movieList(ctx context.Context, id int64) (MovieList, error) {
+	var list MovieList
+	if err := tmdb.get(ctx, fmt.Sprintf("/list/%d", id), nil, &list); err != nil {
+		return MovieList{}, err
+	}
+	for i := range list.Items {
+		list.Items[i].normalize()
+	}
+	return list, nil
+}
+
+// Video ...
+type Video struct {
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Site     string `json:"site"`
+	Type     string `json:"type"`
+	Official bool   `json:"official"`
+}
+
+// URL returns the link to watch the video, or an empty string for sites other
+// than YouTube.
+func (v Video) URL() string {
+	if v.Site != "YouTube" {
+		return ""
+	}
+	return "https://www.youtube.com/watch?v=" + v.Key
+}
+
+// MovieDetails ...
+type MovieDetails struct {
+	MovieAPIResult
+	Overview string `json:"overview"`
+	Tagline  string `json:"tagline"`
+	Runtime  int    `json:"runtime"`
+	// Status is one of Rumored, Planned, In Production, Post Production,
+	// Released or Canceled.
+	Status  string `json:"status"`
+	Budget  int64  `json:"budget"`
+	Revenue int64  `json:"revenue"`
+	Videos  struct {
+		Results []Video `json:"results"`
+	} `json:"videos"`
+	Credits struct {
+		Cast []CastMember `json:"cast"`
+		Crew []CrewMember `json:"crew"`
+	} `json:"credits"`
+	// WatchProviders lists where the movie can be watched, keyed by ISO
+	// 3166-1 region code.
+	WatchProviders struct {
+		Results map[string]struct {
+			Flatrate []WatchProvider `json:"flatrate"`
+		} `json:"results"`
+	} `json:"watch/providers"`
+}
+
+// CastMember is an actor of a movie. Order is the billing order, zero for
+// the top billed actor.
+type CastMember struct {
+	Name        string `json:"name"`
+	Character   string `json:"character"`
+	Order       int    `json:"order"`
+	ProfilePath string `json:"profile_path"`
+}
+
+// ProfileURL returns the URL of the profile photo of the actor, empty if
+// there is none.
+func (c CastMember) ProfileURL() string {
+	if c.ProfilePath == "" {
+		return ""
+	}
+	return tmdbImageURL(tmdbProfileSize, c.ProfilePath)
+}
+
+// CrewMember is a member of the crew of a movie, e.g. its director.
+type CrewMember struct {
+	Name string `json:"name"`
+	Job  string `json:"job"`
+}
+
+// TopCast returns the n top billed actors of the movie. Credits must have
+// been requested via append_to_response.
+func (d MovieDetails) TopCast(n int) []CastMember {
+	var cast []CastMember
+	for _, c := range d.Credits.Cast {
+		if strings.TrimSpace(c.Name) != "" {
+			cast = append(cast, c)
+		}
+	}
+	sort.SliceStable(cast, func(i, j int) bool { return cast[i].Order < cast[j].Order })
+	if len(cast) > n {
+		cast = cast[:n]
+	}
+	return cast
+}
+
+// Directors returns the names of the directors of the movie. Credits must
+// have been requested via append_to_response.
+func (d MovieDetails) Directors() []string {
+	var names []string
+	for _, c := range d.Credits.Crew {
+		if c.Job == "Director" && strings.TrimSpace(c.Name) != "" {
+			names = append(names, strings.TrimSpace(c.Name))
+		}
+	}
+	return names
+}
+
+// movieDetails returns the details of the movie identified by its TMDB ID.
+// appendToResponse lists the sub-requests (e.g. "videos") to include in the
+// same call.
+func movieDetails(ctx context.Context, id int64, appendToResponse ...string) (MovieDetails, error) {
+	q := url.Values{}
+	if len(appendToResponse) > 0 {
+		q.Set("append_to_response", strings.Join(appendToResponse, ","))
+	}
+
+	var details MovieDetails
+	if err := tmdb.get(ctx, fmt.Sprintf("/movie/%d", id), q, &details); err != nil {
+		return MovieDetails{}, err
+	}
+	details.normalize()
+	return details, nil
+}
+
+// OfficialTrailers returns the official YouTube trailers of the movie. Videos
+// must have been requested via append_to_response.
+func (d MovieDetails) OfficialTrailers() []Video {
+	var trailers []Video
+	for _, v := range d.Videos.Results {
+		if v.Site == "YouTube" && v.Type == "Trailer" && v.Official {
+			trailers = append(trailers, v)
+		}
+	}
+	return trailers
+}
+
+// movieReleaseDates returns the earliest release date of the movie in each
+// region it has one for, keyed by ISO 3166-1 region code.
+func movieReleaseDates(ctx context.Context, id int64) (map[string]time.Time, error) {
+	var data struct {
+		Results []struct {
+			Region       string `json:"iso_3166_1"`
+			ReleaseDates []struct {
+				ReleaseDate string `json:"release_date"`
+			} `json:"release_dates"`
+		} `json:"results"`
+	}
+	if err := tmdb.get(ctx, fmt.Sprintf("/movie/%d/release_dates", id), nil, &data); err != nil {
+		return nil, err
+	}
+
+	dates := map[string]time.Time{}
+	for _, r := range data.Results {
+		for _, d := range r.ReleaseDates {
+			t, err := time.Parse(time.RFC3339, d.ReleaseDate)
+			if err != nil {
+				continue
+			}
+			if earliest, ok := dates[r.Region]; !ok || t.Before(earliest) {
+				dates[r.Region] = t
+			}
+		}
+	}
+	return dates, nil
+}
+
+// PersonAPIResult is a person, e.g. an actor or a director, as found by a TMDB
+// search.
+type PersonAPIResult struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	Department string  `json:"known_for_department"`
+	Popularity float64 `json:"popularity"`
+}
+
+// searchPeople returns the people matching the query.
+func searchPeople(ctx context.Context, query string) ([]PersonAPIResult, error) {
+	q := url.Values{}
+	q.Set("query", query)
+
+	var data struct {
+		Results []PersonAPIResult `json:"results"`
+	}
+	if err := tmdb.get(ctx, "/search/person", q, &data); err != nil {
+		return nil, err
+	}
+
+	var results []PersonAPIResult
+	for _, r := range data.Results {
+		if r.ID == 0 || strings.TrimSpace(r.Name) == "" {
+			continue
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// personMovies returns the movies the person acted in or directed, each
+// once, most recent release first.
+func personMovies(ctx context.Context, id int64) (MovieAPIResults, error) {
+	var data struct {
+		Cast MovieAPIResults `json:"cast"`
+		Crew []struct {
+			MovieAPIResult
+			Job string `json:"job"`
+		} `json:"crew"`
+	}
+	if err := tmdb.get(ctx, fmt.Sprintf("/person/%d/movie_credits", id), nil, &data); err != nil {
+		return nil, err
+	}
+
+	// An actor can play several characters in a movie, or direct it too
+	seen := map[int64]bool{}
+	var movies MovieAPIResults
+	add := func(m MovieAPIResult) {
+		if !seen[m.ID] {
+			seen[m.ID] = true
+			movies = append(movies, m)
+		}
+	}
+	for _, m := range data.Cast {
+		add(m)
+	}
+	for _, c := range data.Crew {
+		if c.Job == "Director" {
+			add(c.MovieAPIResult)
+		}
+	}
+	return movies.normalize(), nil
+}
+
+// TVAPIResult ...
+type TVAPIResult struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	OriginalName  string   `json:"original_name"`
+	FirstAirDate  string   `json:"first_air_date"`
+	GenreIDs      []int    `json:"genre_ids"`
+	OriginCountry []string `json:"origin_country"`
+	Popularity    float64  `json:"popularity"`
+}
+
+// HasGenre ...
+func (r TVAPIResult) HasGenre(id int) bool {
+	for _, g := range r.GenreIDs {
+		if g == id {
+			return true
+		}
+	}
+	return false
+}
+
+// tvGenreAnimation is the TMDB ID of the Animation TV genre.
+const tvGenreAnimation = 16
+
+// searchTV returns the TV shows matching the query.
+func searchTV(ctx context.Context, query string) ([]TVAPIResult, error) {
+	q := url.Values{}
+	q.Set("query", query)
+
+	var data struct {
+		Results []TVAPIResult `json:"results"`
+	}
+	if err := tmdb.get(ctx, "/search/tv", q, &data); err != nil {
+		return nil, err
+	}
+
+	var results []TVAPIResult
+	for _, r := range data.Results {
+		if r.ID == 0 {
+			continue
+		}
+		if strings.TrimSpace(r.Name) == "" {
+			r.Name = r.OriginalName
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// TVEpisode ...
+type TVEpisode struct {
+	EpisodeNumber int    `json:"episode_number"`
+	Name          string `json:"name"`
+	AirDate       string `json:"air_date"`
+}
+
+// TVSeason ...
+type TVSeason struct {
+	SeasonNumber int         `json:"season_number"`
+	Name         string      `json:"name"`
+	AirDate      string      `json:"air_date"`
+	EpisodeCount int         `json:"episode_count"`
+	Episodes     []TVEpisode `json:"episodes"`
+}
+
+// TVShow ...
+type TVShow struct {
+	ID      int64      `json:"id"`
+	Name    string     `json:"name"`
+	Status  string     `json:"status"`
+	Seasons []TVSeason `json:"seasons"`
+}
+
+// Season returns the season of the show with the given number, if TMDB knows
+// about it.
+func (s TVShow) Season(number int) (TVSeason, bool) {
+	for _, season := range s.Seasons {
+		if season.SeasonNumber == number {
+			return season, true
+		}
+	}
+	return TVSeason{}, false
+}
+
+// tvShow returns the TV show identified by its TMDB ID, without the episodes
+// of its seasons.
+func tvShow(ctx context.Context, id int64) (TVShow, error) {
+	var show TVShow
+	if err := tmdb.get(ctx, fmt.Sprintf("/tv/%d", id), nil, &show); err != nil {
+		return TVShow{}, err
+	}
+	return show, nil
+}
+
+// tvSeason returns a season of a TV show with its episodes.
+func tvSeason(ctx context.Context, showID int64, number int) (TVSeason, error) {
+	var season TVSeason
+	if err := tmdb.get(ctx, fmt.Sprintf("/tv/%d/season/%d", showID, number), nil, &season); err != nil {
+		return TVSeason{}, err
+	}
+	return season, nil
+}
+
+// parseAirDate parses a TMDB date, missing or malformed dates are returned as
+// the zero time.
+func parseAirDate(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Genre ...
+type Genre struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+var (
+	genresMu sync.Mutex
+	genres   []Genre
+
+	// genreAliases maps common short forms to the normalized TMDB genre name.
+	genreAliases = map[string]string{
+		"scifi":      "sciencefiction",
+		"sf":         "sciencefiction",
+		"romcom":     "romance",
+		"doc":        "documentary",
+		"docs":       "documentary",
+		"cartoon":    "animation",
+		"anime":      "animation",
+		"kids":       "family",
+		"suspense":   "thriller",
+		"musical":    "music",
+		"historical": "history",
+	}
+)
+
+// movieGenres returns the list of TMDB movie genres, fetched once and then
+// kept in memory until reloaded, see reloadGenres. The list is shared by
+// every command and must not be modified.
+func movieGenres(ctx context.Context) ([]Genre, error) {
+	genresMu.Lock()
+	defer genresMu.Unlock()
+
+	if genres != nil {
+		return genres, nil
+	}
+
+	fetched, err := fetchGenres(ctx)
+	if err != nil {
+		return nil, err
+	}
+	genres = fetched
+	return genres, nil
+}
+
+// reloadGenres fetches the TMDB movie genres again. The previous list is
+// kept on failure, commands keep reading it during the fetch.
+func reloadGenres(ctx context.Context) error {
+	tmdb.forget("/genre/movie/list", nil)
+	fetched, err := fetchGenres(ctx)
+	if err != nil {
+		return err
+	}
+	genresMu.Lock()
+	genres = fetched
+	genresMu.Unlock()
+	return nil
+}
+
+func fetchGenres(ctx context.Context) ([]Genre, error) {
+	var data struct {
+		Genres []Genre `json:"genres"`
+	}
+	if err := tmdb.get(ctx, "/genre/movie/list", nil, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get movie genres")
+	}
+	return data.Genres, nil
+}
+
+// normalizeGenreName lowercases name and strips everything but letters, so
+// that "Sci-Fi", "scifi" and "SciFi" compare equal.
+func normalizeGenreName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(name))
+}
+
+// findGenre looks up a TMDB genre by a user provided name, e.g. "scifi" or
+// "Science Fiction".
+func findGenre(ctx context.Context, name string) (Genre, bool, error) {
+	all, err := movieGenres(ctx)
+	if err != nil {
+		return Genre{}, false, err
+	}
+
+	normalized := normalizeGenreName(name)
+	if alias, ok := genreAliases[normalized]; ok {
+		normalized = alias
+	}
+	for _, g := range all {
+		if normalizeGenreName(g.Name) == normalized {
+			return g, true, nil
+		}
+	}
+	return Genre{}, false, nil
+}
+
+// genreByID looks up a TMDB genre by its ID.
+func genreByID(ctx context.Context, id int) (Genre, bool, error) {
+	all, err := movieGenres(ctx)
+	if err != nil {
+		return Genre{}, false, err
+	}
+	for _, g := range all {
+		if g.ID == id {
+			return g, true, nil
+		}
+	}
+	return Genre{}, false, nil
+}
+
+// HasGenre ...
+func (m MovieAPIResult) HasGenre(id int) bool {
+	for _, g := range m.GenreIDs {
+		if g == id {
+			return true
+		}
+	}
+	return false
+}
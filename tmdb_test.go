@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTMDBCallTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := newTMDBClient("key", server.Client())
+	c.baseURL = server.URL + "/3"
+	c.callTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	var v struct{}
+	err := c.get(context.Background(), "/movie/1", nil, &v)
+	if err == nil {
+		t.Fatal("get() succeeded on a server not answering")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("get() returned after %s, want the call timeout to end it", elapsed)
+	}
+}
+
+func TestHandleUpdateTMDBTimeout(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	previous := tmdb
+	tmdb = newTMDBClient("key", server.Client())
+	tmdb.baseURL = server.URL + "/3"
+	tmdb.callTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { tmdb = previous })
+
+	for i, text := range []string{"releases dune", "subscribe to dune", "details dune", "soonest"} {
+		chatID := int64(101 + i)
+		handleUpdate(testMessage(chatID, text))
+		if texts := tg.texts(chatID); len(texts) != 1 || texts[0] != tmdbUnavailableText {
+			t.Errorf("%q: sent %q, want the user asked to try again", text, texts)
+		}
+	}
+}
+
+func TestTMDBMaxResponseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{"under the limit", 1000, false},
+		{"at the limit", 1024, false},
+		{"oversized", 1025, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"title":"` + strings.Repeat("a", tt.size-len(`{"title":""}`)) + `"}`
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, body)
+			}))
+			defer server.Close()
+
+			c := newTMDBClient("key", server.Client())
+			c.baseURL = server.URL + "/3"
+			c.maxResponseSize = 1024
+
+			var v struct{ Title string }
+			err := c.get(context.Background(), "/movie/1", nil, &v)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "larger than 1024 bytes") {
+					t.Errorf("get() = %v, want the oversized body rejected", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestTMDBClientConfigure(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantTimeout time.Duration
+		wantSize    int64
+	}{
+		{"defaults", nil, defaultTMDBCallTimeout, defaultTMDBMaxResponseSize},
+		{"set", map[string]string{"TMDB_CALL_TIMEOUT": "3s", "TMDB_MAX_RESPONSE_BYTES": "4096"}, 3 * time.Second, 4096},
+		{"invalid", map[string]string{"TMDB_CALL_TIMEOUT": "soon", "TMDB_MAX_RESPONSE_BYTES": "-1"}, defaultTMDBCallTimeout, defaultTMDBMaxResponseSize},
+		{"zero", map[string]string{"TMDB_CALL_TIMEOUT": "0s", "TMDB_MAX_RESPONSE_BYTES": "0"}, defaultTMDBCallTimeout, defaultTMDBMaxResponseSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTMDBClient("key", http.DefaultClient)
+			c.configure(func(k string) string { return tt.env[k] })
+			if c.callTimeout != tt.wantTimeout || c.maxResponseSize != tt.wantSize {
+				t.Errorf("configured timeout=%s size=%d, want timeout=%s size=%d", c.callTimeout, c.maxResponseSize, tt.wantTimeout, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestTMDBErrorBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		want         string
+		wantNotFound bool
+	}{
+		{"invalid key", http.StatusUnauthorized, `{"status_code":7,"status_message":"Invalid API key: You must be granted a valid key.","success":false}`, "unexpected status code: 401: tmdb status 7: Invalid API key: You must be granted a valid key.: api key rejected by tmdb", false},
+		{"not found", http.StatusNotFound, `{"status_code":34,"status_message":"The resource you requested could not be found."}`, "unexpected status code: 404: tmdb status 34: The resource you requested could not be found.: not found on tmdb", true},
+		{"not json", http.StatusBadGateway, `<html>Bad Gateway</html>`, "unexpected status code: 502", false},
+		{"empty", http.StatusNotFound, ``, "unexpected status code: 404: not found on tmdb", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			c := newTMDBClient("secret-key", server.Client())
+			c.baseURL = server.URL + "/3"
+
+			var v struct{}
+			err := c.get(context.Background(), "/movie/1", nil, &v)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("get() = %v, want %q", err, tt.want)
+			}
+			if got := errors.Cause(err) == errTMDBNotFound; got != tt.wantNotFound {
+				t.Errorf("not found = %v, want %v", got, tt.wantNotFound)
+			}
+		})
+	}
+}
+
+func TestQueryMoviesNullFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"results": [
+			{"id": 1, "title": null, "release_date": null},
+			{"id": 2, "original_title": "Amélie", "release_date": ""},
+			{"id": 3, "title": "  Dune ", "release_date": "not a date"},
+			{"title": "No ID"}
+		]}`)
+	}))
+	defer server.Close()
+	previous := tmdb
+	defer func() { tmdb = previous }()
+	tmdb = newTMDBClient("key", server.Client())
+	tmdb.baseURL = server.URL + "/3"
+
+	results, err := queryMovies(context.Background(), "anything", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want the 3 with an ID", len(results))
+	}
+	want := map[int64]string{1: "Untitled (TMDB #1)", 2: "Amélie", 3: "Dune"}
+	for _, m := range results {
+		if !m.ReleaseTime.IsZero() {
+			t.Errorf("release time of %d = %s, want unknown", m.ID, m.ReleaseTime)
+		}
+		if got := displayTitle(m.Title, m.ID); got != want[m.ID] {
+			t.Errorf("displayed title of %d = %q, want %q", m.ID, got, want[m.ID])
+		}
+	}
+}
+
+func TestApplyDetailsKeepsTitleWhenMissing(t *testing.T) {
+	record := MovieRelease{ID: 1, MovieTitle: "Dune"}
+	details := MovieDetails{MovieAPIResult: MovieAPIResult{ID: 1}}
+	details.normalize()
+
+	if changes := applyDetails(&record, details, time.Now()); len(changes) != 0 {
+		t.Errorf("changes = %+v, want none", changes)
+	}
+	if record.MovieTitle != "Dune" {
+		t.Errorf("title = %q, want the stored one kept", record.MovieTitle)
+	}
+}
+
+func TestTMDBURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		path  string
+		query url.Values
+		want  string
+	}{
+		{"default", nil, "/movie/42", nil, "https://api.themoviedb.org/3/movie/42"},
+		{"query", nil, "/search/movie", url.Values{"query": {"dune"}, "year": {"2021"}}, "https://api.themoviedb.org/3/search/movie?query=dune&year=2021"},
+		{"query of the path", nil, "/movie/42?append_to_response=videos", url.Values{"language": {"de"}}, "https://api.themoviedb.org/3/movie/42?append_to_response=videos&language=de"},
+		{"mirror", map[string]string{"TMDB_API_URL": "http://tmdb-cache.internal:8080/"}, "/movie/42", nil, "http://tmdb-cache.internal:8080/3/movie/42"},
+		{"version", map[string]string{"TMDB_API_VERSION": "/4/"}, "/movie/42", nil, "https://api.themoviedb.org/4/movie/42"},
+		{"invalid", map[string]string{"TMDB_API_URL": "tmdb.internal", "TMDB_API_VERSION": "/"}, "/movie/42", nil, "https://api.themoviedb.org/3/movie/42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTMDBClient("key", http.DefaultClient)
+			c.configure(func(k string) string { return tt.env[k] })
+			u, err := c.tmdbURL(tt.path, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.String(); got != tt.want {
+				t.Errorf("tmdbURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
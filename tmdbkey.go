@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// tmdbKeySecret encrypts the TMDB API keys of the chats at rest, nil when
+// chats can't use their own key.
+var tmdbKeySecret []byte
+
+// errInvalidTMDBKey is returned by checkKey for keys TMDB rejects.
+var errInvalidTMDBKey = errors.New("invalid tmdb api key")
+
+// parseTMDBKeySecret parses TMDB_KEY_SECRET, a base64 encoded 32 bytes key.
+// It returns nil when empty or invalid.
+func parseTMDBKeySecret(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	secret, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(secret) != 32 {
+		log.Printf("WARNING: invalid TMDB_KEY_SECRET, expected 32 base64 encoded bytes, chats can't use their own TMDB key")
+		return nil
+	}
+	return secret
+}
+
+// sealTMDBKey encrypts the TMDB API key of a chat, see openTMDBKey.
+func sealTMDBKey(key string) ([]byte, error) {
+	return sealSecret(tmdbKeySecret, []byte(key))
+}
+
+// openTMDBKey decrypts a TMDB API key encrypted by sealTMDBKey.
+func openTMDBKey(sealed []byte) (string, error) {
+	plain, err := openSecret(tmdbKeySecret, sealed)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt tmdb key")
+	}
+	return string(plain), nil
+}
+
+// withChatTMDBKey returns a copy of ctx carrying the TMDB API key of the
+// chat, if it set one. TMDB requests made with ctx then use it instead of
+// the shared key. Failures are only logged, the shared key still works.
+func withChatTMDBKey(ctx context.Context, chatID int64) context.Context {
+	if tmdbKeySecret == nil {
+		return ctx
+	}
+	prefs, err := store.Prefs(ctx, chatID)
+	if err != nil {
+		logf(ctx, "failed to get user prefs for tmdb key, using the shared one: %s", err)
+		return ctx
+	}
+	if len(prefs.TMDBKey) == 0 {
+		return ctx
+	}
+	key, err := openTMDBKey(prefs.TMDBKey)
+	if err != nil {
+		logf(ctx, "failed to open tmdb key of chat %d, using the shared one: %s", chatID, err)
+		return ctx
+	}
+	return context.WithValue(ctx, tmdbKeyKey, key)
+}
+
+// chatTMDBKey returns the TMDB API key of the chat carried by ctx, see
+// withChatTMDBKey.
+func chatTMDBKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(tmdbKeyKey).(string)
+	return key, ok && key != ""
+}
+
+// tmdbKeyRejectedText tells a chat its own TMDB key stopped working.
+const tmdbKeyRejectedText = "TMDB rejected your TMDB key, it may have been revoked. I forgot it and I'm back to the shared one, send set tmdb key <key> to use a new one."
+
+// keyRejected returns whether TMDB rejected the key of the chat carried by
+// ctx, e.g. revoked after checkKey accepted it.
+func keyRejected(ctx context.Context, err error) bool {
+	_, ok := chatTMDBKey(ctx)
+	return ok && errors.Cause(err) == errTMDBUnauthorized
+}
+
+// forgetRejectedKey clears the TMDB key of the chat TMDB rejected, its next
+// requests use the shared key. Failures are only logged, the key is cleared
+// on the next rejection.
+func forgetRejectedKey(ctx context.Context, chatID int64) {
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		if len(prefs.TMDBKey) == 0 {
+			return errSkipUpdate
+		}
+		prefs.TMDBKey = nil
+		return nil
+	})
+	if err != nil {
+		logf(ctx, "failed to clear rejected tmdb key: chat_id=%d: %s", chatID, err)
+	}
+}
+
+// scrubKey removes the API key from the error, e.g. from the URL of a failed
+// request, so that it never ends up in the logs.
+func scrubKey(err error, key string) error {
+	if err == nil || key == "" || !strings.Contains(err.Error(), key) {
+		return err
+	}
+	return errors.New(strings.Replace(err.Error(), key, "REDACTED", -1))
+}
+
+// checkKey sends a request with the API key, bypassing the cache, to make
+// sure TMDB accepts it. It returns errInvalidTMDBKey when rejected.
+func (c *tmdbClient) checkKey(ctx context.Context, key string) error {
+	defer trackTime(ctx, timingTMDB, time.Now())
+	ctx, cancel := context.WithTimeout(ctx, c.callTimeout)
+	defer cancel()
+
+	u, err := c.tmdbURL("/configuration", nil)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("api_key", key)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return scrubKey(errors.Wrap(err, "failed to create http request"), key)
+	}
+	res, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return scrubKey(errors.Wrap(err, "failed to send http get request"), key)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, c.maxResponseSize))
+		return nil
+	case http.StatusUnauthorized:
+		return errInvalidTMDBKey
+	default:
+		return tmdbStatusError(res)
+	}
+}
+
+// handleSetTMDBKey validates the TMDB API key and stores it encrypted, the
+// requests of the chat then use it instead of the shared key. The message
+// containing the key is deleted, it shouldn't stay in the chat history.
+func handleSetTMDBKey(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	if tmdbKeySecret == nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Using your own TMDB key isn't available on this bot."))
+		return
+	}
+
+	// Keys are case sensitive, the command text is lowercased
+	fields := strings.Fields(update.Message.Text)
+	key := fields[len(fields)-1]
+
+	start := time.Now()
+	if _, err := bot.DeleteMessage(telegram.NewDeleteMessage(chatID, update.Message.MessageID)); err != nil {
+		logf(ctx, "failed to delete message with tmdb key: %s", err)
+	}
+	trackTime(ctx, timingTelegram, start)
+
+	if err := tmdb.checkKey(ctx, key); err != nil {
+		if errors.Cause(err) == errInvalidTMDBKey {
+			sendMsg(ctx, telegram.NewMessage(chatID, "TMDB doesn't accept this key, check it at https://www.themoviedb.org/settings/api"))
+			return
+		}
+		logf(ctx, "failed to check tmdb key: %s", err)
+		sendMsg(ctx, telegram.NewMessage(chatID, "I couldn't reach TMDB to check your key, please try again later."))
+		return
+	}
+
+	sealed, err := sealTMDBKey(key)
+	if err != nil {
+		fatalf(ctx, "failed to seal tmdb key: %s", err)
+	}
+	err = store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.TMDBKey = sealed
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, "Your TMDB key works 🔑 I'll use it for your searches from now on, and deleted your message so that it doesn't stay in the chat."))
+}
+
+func handleClearTMDBKey(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	err := store.UpdatePrefs(ctx, chatID, func(prefs *UserPrefs) error {
+		prefs.TMDBKey = nil
+		return nil
+	})
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to save user prefs")
+		return
+	}
+
+	sendMsg(ctx, telegram.NewMessage(chatID, "Forgot your TMDB key, I'm back to the shared one."))
+}
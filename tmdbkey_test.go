@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleUpdateRevokedTMDBKey(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	previousSecret := tmdbKeySecret
+	tmdbKeySecret = make([]byte, 32)
+	t.Cleanup(func() { tmdbKeySecret = previousSecret })
+
+	// The key of the chat was revoked after it was checked
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("api_key") != "shared" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"status_code":7,"status_message":"Invalid API key: You must be granted a valid key."}`)
+			return
+		}
+		fmt.Fprint(w, `{"results":[{"id":1,"title":"Dune","release_date":"2021-09-15"}]}`)
+	}))
+	defer server.Close()
+	previous := tmdb
+	tmdb = newTMDBClient("shared", server.Client())
+	tmdb.baseURL = server.URL + "/3"
+	t.Cleanup(func() { tmdb = previous })
+
+	sealed, err := sealTMDBKey("revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.prefs[42] = UserPrefs{ChatID: 42, TMDBKey: sealed}
+
+	handleUpdate(testMessage(42, "releases dune"))
+	if texts := tg.texts(42); len(texts) != 1 || texts[0] != tmdbKeyRejectedText {
+		t.Fatalf("sent %q, want the chat told its key was rejected", texts)
+	}
+	if key := s.prefs[42].TMDBKey; len(key) != 0 {
+		t.Errorf("stored key = %q, want the rejected key cleared", key)
+	}
+
+	// Other chats never were affected, this one is back to the shared key
+	handleUpdate(testMessage(43, "releases dune"))
+	handleUpdate(testMessage(42, "releases tenet"))
+	for _, chatID := range []int64{42, 43} {
+		texts := tg.texts(chatID)
+		if len(texts) == 0 || !strings.Contains(texts[len(texts)-1], "Dune (2021)") {
+			t.Errorf("chat %d got %q, want the search results", chatID, texts)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// topicTTL is how long the forum topic of a received message is remembered,
+// it only needs to cover the handling of the update.
+const topicTTL = 10 * time.Minute
+
+// topicMessage holds the forum topic fields of a message, which the Telegram
+// client doesn't decode.
+type topicMessage struct {
+	MessageID int `json:"message_id"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+}
+
+// topicUpdate holds the messages of an update that can belong to a topic.
+type topicUpdate struct {
+	Message       *topicMessage `json:"message"`
+	CallbackQuery *struct {
+		Message *topicMessage `json:"message"`
+	} `json:"callback_query"`
+}
+
+type messageTopic struct {
+	thread   int
+	received time.Time
+}
+
+// messageTopics remembers the forum topic of the messages received, keyed
+// like pendingSubscribes by chat and message ID.
+type messageTopics struct {
+	mu     sync.Mutex
+	topics map[pendingKey]messageTopic
+}
+
+var receivedTopics = &messageTopics{topics: map[pendingKey]messageTopic{}}
+
+// add records the topic of the message, messages outside of a topic are
+// ignored.
+func (t *messageTopics) add(msg *topicMessage, now time.Time) {
+	if msg == nil || !msg.IsTopicMessage || msg.MessageThreadID == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, topic := range t.topics {
+		if now.Sub(topic.received) > topicTTL {
+			delete(t.topics, k)
+		}
+	}
+	t.topics[pendingKey{msg.Chat.ID, msg.MessageID}] = messageTopic{thread: msg.MessageThreadID, received: now}
+}
+
+// thread returns the ID of the forum topic the message was posted in, zero
+// for the general thread or chats without topics.
+func (t *messageTopics) thread(msg *telegram.Message) int {
+	if msg == nil || msg.Chat == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.topics[pendingKey{msg.Chat.ID, msg.MessageID}].thread
+}
+
+// listenForWebhook is like telegram.BotAPI.ListenForWebhook, also recording
+// the forum topic of the messages received, see messageTopics.
+func listenForWebhook(pattern string) telegram.UpdatesChannel {
+	ch := make(chan telegram.Update, bot.Buffer)
+
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("failed to read update: %s", err)
+			return
+		}
+
+		var update telegram.Update
+		if err := json.Unmarshal(b, &update); err != nil {
+			log.Printf("failed to decode update: %s", err)
+			return
+		}
+
+		var topics topicUpdate
+		if err := json.Unmarshal(b, &topics); err == nil {
+			now := time.Now()
+			receivedTopics.add(topics.Message, now)
+			if topics.CallbackQuery != nil {
+				receivedTopics.add(topics.CallbackQuery.Message, now)
+			}
+		}
+
+		ch <- update
+	})
+
+	return ch
+}
+
+// sendTopicMsg sends the text to the forum topic of the chat. The Telegram
+// client can't, the request is made by hand.
+func sendTopicMsg(ctx context.Context, chatID int64, thread int, text string, silent bool) error {
+	defer trackTime(ctx, timingTelegram, time.Now())
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(chatID, 10))
+	v.Add("message_thread_id", strconv.Itoa(thread))
+	v.Add("text", text)
+	v.Add("disable_notification", strconv.FormatBool(silent))
+	_, err := bot.MakeRequest("sendMessage", v)
+	return errors.Wrapf(err, "failed to send message to topic %d of chat %d", thread, chatID)
+}
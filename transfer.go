@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/pkg/errors"
+)
+
+// transferTTL is how long the target chat has to accept a transfer of
+// subscriptions.
+const transferTTL = 15 * time.Minute
+
+// Transfer is the datastore entity of a transfer of the subscriptions of a
+// chat waiting for the target chat to accept it, keyed by the code the
+// target chat accepts it with.
+type Transfer struct {
+	FromChatID int64
+	ToChatID   int64
+	ExpiresAt  time.Time
+}
+
+// expired returns whether the transfer can't be accepted anymore.
+func (t Transfer) expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// newTransferCode returns a random transfer code.
+func newTransferCode() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate transfer code")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cleanupTransfers deletes the transfers that were never accepted.
+func cleanupTransfers(ctx context.Context) {
+	deleted, err := store.DeleteExpiredTransfers(ctx, time.Now())
+	if err != nil {
+		jobStoreFailed(ctx, err, "failed to delete expired transfers")
+		return
+	}
+	logf(ctx, "cleaned up expired transfers: deleted=%d", deleted)
+}
+
+// moveSubscriber moves the subscriber entry of the chat from to the chat to.
+// When to is already subscribed its own entry is kept. The forum topic and
+// notify chat belong to the old chat and are dropped. It returns whether
+// from was subscribed.
+func moveSubscriber(subscribers []Subscriber, from, to int64) ([]Subscriber, bool) {
+	var moved *Subscriber
+	subscribed := false
+	var kept []Subscriber
+	for _, sub := range subscribers {
+		switch sub.ChatID {
+		case from:
+			sub := sub
+			moved = &sub
+			continue
+		case to:
+			subscribed = true
+		}
+		kept = append(kept, sub)
+	}
+	if moved == nil {
+		return subscribers, false
+	}
+	if !subscribed {
+		moved.ChatID = to
+		moved.ThreadID = 0
+		moved.NotifyChatID = 0
+		kept = append(kept, *moved)
+	}
+	return kept, true
+}
+
+// transferSubscriptions moves every subscription of the chat from to the
+// chat to, those to releases then those to seasons, each in its own
+// transaction. It returns how many were moved before any failure, running it
+// again moves the rest.
+func transferSubscriptions(ctx context.Context, from, to int64) (moved, total int, err error) {
+	subscriptions, err := chatSubscriptions(ctx, from)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get subscriptions")
+	}
+	seasons, err := chatSeasons(ctx, from)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get seasons")
+	}
+	total = len(subscriptions) + len(seasons)
+
+	for _, rec := range subscriptions {
+		err := store.UpdateRelease(ctx, rec.ID, func(txRelease *MovieRelease) error {
+			subscribers, ok := moveSubscriber(txRelease.Subscribers, from, to)
+			if !ok {
+				return errSkipUpdate
+			}
+			txRelease.Subscribers = subscribers
+			return nil
+		})
+		if err != nil {
+			return moved, total, errors.Wrapf(err, "failed to move subscription %d", rec.ID)
+		}
+		moved++
+	}
+	for _, rec := range seasons {
+		err := store.UpdateSeason(ctx, rec.ShowID, rec.Season, func(txSeason *SeasonRelease) error {
+			subscribers, ok := moveSubscriber(txSeason.Subscribers, from, to)
+			if !ok {
+				return errSkipUpdate
+			}
+			txSeason.Subscribers = subscribers
+			return nil
+		})
+		if err != nil {
+			return moved, total, errors.Wrapf(err, "failed to move season %d of show %d", rec.Season, rec.ShowID)
+		}
+		moved++
+	}
+	return moved, total, nil
+}
+
+// handleTransferSubscriptions starts moving the subscriptions of the chat to
+// another chat, which must accept. Only admins can move those of a group.
+func handleTransferSubscriptions(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	if !requireGroupAdmin(ctx, update.Message, "transfer the subscriptions of this chat") {
+		return
+	}
+
+	target, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		sendMsg(ctx, telegram.NewMessage(chatID, "That doesn't look like a chat ID."))
+		return
+	}
+	if target == chatID {
+		sendMsg(ctx, telegram.NewMessage(chatID, "These subscriptions are already in this chat."))
+		return
+	}
+
+	subscriptions, err := chatSubscriptions(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get subscriptions")
+		return
+	}
+	seasons, err := chatSeasons(ctx, chatID)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get seasons")
+		return
+	}
+	if len(subscriptions)+len(seasons) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "You have no subscriptions to transfer."))
+		return
+	}
+
+	code, err := newTransferCode()
+	if err != nil {
+		fatalf(ctx, "%s", err)
+	}
+	// A new transfer from the chat replaces the previous one
+	transfer := Transfer{FromChatID: chatID, ToChatID: target, ExpiresAt: time.Now().Add(transferTTL)}
+	if err := store.PutTransfer(ctx, code, transfer); err != nil {
+		storeFailed(ctx, chatID, err, "failed to save transfer")
+		return
+	}
+	text := fmt.Sprintf("To move your %d subscriptions to chat %d, send `accept transfer %s` there within %d minutes.", len(subscriptions)+len(seasons), target, code, int(transferTTL/time.Minute))
+	msgConfig := telegram.NewMessage(chatID, text)
+	msgConfig.ParseMode = "Markdown"
+	sendMsg(ctx, msgConfig)
+}
+
+func handleAcceptTransfer(ctx context.Context, update telegram.Update, matches []string) {
+	chatID := update.Message.Chat.ID
+	code := matches[1]
+
+	// Only the target chat can accept, the source chat knowing the code. The
+	// transfer stays pending until done, so that accepting again resumes a
+	// partial transfer
+	t, err := store.Transfer(ctx, code)
+	if err != nil {
+		storeFailed(ctx, chatID, err, "failed to get transfer")
+		return
+	}
+	if t.ToChatID != chatID {
+		sendMsg(ctx, telegram.NewMessage(chatID, "I don't know this transfer, or it expired. Ask for a new one with \"transfer subscriptions to <chat id>\"."))
+		return
+	}
+
+	moved, total, err := transferSubscriptions(ctx, t.FromChatID, t.ToChatID)
+	if err != nil {
+		logf(ctx, "transfer stopped: from=%d to=%d moved=%d total=%d: %s", t.FromChatID, t.ToChatID, moved, total, err)
+		msgConfig := telegram.NewMessage(chatID, fmt.Sprintf("Moved %d of %d subscriptions before the database failed. Send `accept transfer %s` again to move the rest.", moved, total, code))
+		msgConfig.ParseMode = "Markdown"
+		sendMsg(ctx, msgConfig)
+		return
+	}
+
+	// Accepting again would only find nothing to move, the transfer expires
+	// anyway
+	if err := store.DeleteTransfer(ctx, code); err != nil {
+		logf(ctx, "failed to delete done transfer: %s", err)
+	}
+	logf(ctx, "transfer done: from=%d to=%d moved=%d", t.FromChatID, t.ToChatID, moved)
+	sendMsg(ctx, telegram.NewMessage(chatID, fmt.Sprintf("Moved %d subscriptions to this chat. 📦", moved)))
+	if _, err := trySendMsg(ctx, telegram.NewMessage(t.FromChatID, fmt.Sprintf("Your subscriptions were moved to chat %d.", t.ToChatID))); err != nil {
+		logf(ctx, "failed to tell the source chat of the transfer: %s", err)
+	}
+}
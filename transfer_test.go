@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestHandleTransferSubscriptions(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: 42}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutSeason(ctx, SeasonRelease{ShowID: 2, ShowName: "Severance", Season: 2, Subscribers: []Subscriber{{ChatID: 42, ThreadID: 5}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := func(text string) string {
+		update := testMessage(42, text)
+		handleTransferSubscriptions(ctx, update, transferCommand.FindStringSubmatch(update.Message.Text))
+		for code, tr := range s.transfers {
+			if tr.FromChatID == 42 {
+				return code
+			}
+		}
+		return ""
+	}
+	accept := func(chatID int64, code string) {
+		update := testMessage(chatID, "accept transfer "+code)
+		handleAcceptTransfer(ctx, update, acceptTransferCommand.FindStringSubmatch(update.Message.Text))
+	}
+
+	first := transfer("transfer subscriptions to 43")
+	code := transfer("transfer subscriptions to 44")
+	if len(s.transfers) != 1 || s.transfers[code].ToChatID != 44 || !s.transfers[code].ExpiresAt.After(time.Now()) {
+		t.Fatalf("transfers = %+v, want the second one to replace the first", s.transfers)
+	}
+
+	// Neither the replaced transfer nor another chat can accept
+	accept(43, first)
+	accept(43, code)
+	if !s.releases[1].subscribed(42) {
+		t.Fatal("subscriptions moved by a transfer that wasn't accepted")
+	}
+
+	accept(44, code)
+	if s.releases[1].subscribed(42) || !s.releases[1].subscribed(44) {
+		t.Errorf("subscribers = %+v, want the subscription moved to chat 44", s.releases[1].Subscribers)
+	}
+	if subs := s.seasons[memSeasonKey(2, 2)].Subscribers; len(subs) != 1 || subs[0].ChatID != 44 || subs[0].ThreadID != 0 {
+		t.Errorf("season subscribers = %+v, want the season moved to chat 44", subs)
+	}
+	if len(s.transfers) != 0 {
+		t.Errorf("transfers = %+v, want the done one deleted", s.transfers)
+	}
+	if texts := tg.texts(42); len(texts) != 3 || texts[2] != "Your subscriptions were moved to chat 44." {
+		t.Errorf("sent %q to the source chat, want the transfer told", texts)
+	}
+}
+
+func TestHandleTransferSubscriptionsGroup(t *testing.T) {
+	s := useMemStore(t)
+	tg := useFakeTelegram(t)
+	ctx := context.Background()
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: -100}}}); err != nil {
+		t.Fatal(err)
+	}
+	tg.results["getChatMember"] = `{"user":{"id":7},"status":"member"}`
+
+	update := testMessage(7, "transfer subscriptions to 7")
+	update.Message.Chat = &telegram.Chat{ID: -100, Type: "group"}
+	handleTransferSubscriptions(ctx, update, transferCommand.FindStringSubmatch(update.Message.Text))
+	if len(s.transfers) != 0 {
+		t.Errorf("transfers = %+v, want a member refused", s.transfers)
+	}
+	if texts := tg.texts(-100); len(texts) != 1 || texts[0] != "Only group admins can transfer the subscriptions of this chat." {
+		t.Errorf("sent %q, want the member refused", texts)
+	}
+}
+
+func TestTransferExpires(t *testing.T) {
+	s := useMemStore(t)
+	useFakeTelegram(t)
+	ctx := context.Background()
+	if err := store.PutRelease(ctx, MovieRelease{ID: 1, MovieTitle: "Dune", Subscribers: []Subscriber{{ChatID: 42}}}); err != nil {
+		t.Fatal(err)
+	}
+	s.transfers["abcd"] = Transfer{FromChatID: 42, ToChatID: 43, ExpiresAt: time.Now().Add(-time.Minute)}
+	s.transfers["ef01"] = Transfer{FromChatID: 45, ToChatID: 46, ExpiresAt: time.Now().Add(time.Minute)}
+
+	update := testMessage(43, "accept transfer abcd")
+	handleAcceptTransfer(ctx, update, acceptTransferCommand.FindStringSubmatch(update.Message.Text))
+	if !s.releases[1].subscribed(42) {
+		t.Error("expired transfer accepted")
+	}
+
+	cleanupTransfers(ctx)
+	if _, ok := s.transfers["abcd"]; ok || len(s.transfers) != 1 {
+		t.Errorf("transfers = %+v, want only the expired one deleted", s.transfers)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// trendHalfLife is how long it takes for a search to count half as
+	// much, so that the trends reflect the last week or so.
+	trendHalfLife = 3 * 24 * time.Hour
+	// trendRepeatWindow is how long more searches of a movie by the same
+	// chat don't count again.
+	trendRepeatWindow = 24 * time.Hour
+	// trendMinScore is the score under which a movie is forgotten.
+	trendMinScore = 0.1
+	// trendMinMovies is how many movies must have been searched on the bot
+	// for its trends to be shown, TMDB trends are shown otherwise.
+	trendMinMovies = 3
+	// maxTrendingMovies is how many trending movies are listed.
+	maxTrendingMovies = 10
+)
+
+// trendScore is the decayed number of searches of a movie.
+type trendScore struct {
+	title   string
+	score   float64
+	updated time.Time
+}
+
+// at returns the score decayed until now.
+func (s trendScore) at(now time.Time) float64 {
+	return s.score * math.Pow(0.5, float64(now.Sub(s.updated))/float64(trendHalfLife))
+}
+
+type trendRepeat struct {
+	chatID, movieID int64
+}
+
+// searchTrends counts the movies searched on the bot, in memory. Only the
+// titles are ever shown, the chats are remembered for trendRepeatWindow so
+// that a chat searching a movie again doesn't count twice.
+type searchTrends struct {
+	mu      sync.Mutex
+	scores  map[int64]trendScore
+	repeats map[trendRepeat]time.Time
+}
+
+var trends = &searchTrends{scores: map[int64]trendScore{}, repeats: map[trendRepeat]time.Time{}}
+
+// record counts a search of the chat finding the movie.
+func (t *searchTrends) record(chatID int64, m MovieAPIResult, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, at := range t.repeats {
+		if now.Sub(at) > trendRepeatWindow {
+			delete(t.repeats, k)
+		}
+	}
+	key := trendRepeat{chatID, m.ID}
+	if _, ok := t.repeats[key]; ok {
+		return
+	}
+	t.repeats[key] = now
+
+	for id, s := range t.scores {
+		if s.at(now) < trendMinScore {
+			delete(t.scores, id)
+		}
+	}
+	t.scores[m.ID] = trendScore{title: m.Title, score: t.scores[m.ID].at(now) + 1, updated: now}
+}
+
+// top returns the most searched movies, at most n, highest score first.
+func (t *searchTrends) top(n int, now time.Time) MovieAPIResults {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type scored struct {
+		movie MovieAPIResult
+		score float64
+	}
+	var all []scored
+	for id, s := range t.scores {
+		all = append(all, scored{MovieAPIResult{ID: id, Title: s.title}, s.at(now)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].movie.ID < all[j].movie.ID
+	})
+
+	if len(all) > n {
+		all = all[:n]
+	}
+	movies := make(MovieAPIResults, len(all))
+	for i, s := range all {
+		movies[i] = s.movie
+	}
+	return movies
+}
+
+// recordTrend counts the best result of a search of the chat, if any.
+func recordTrend(chatID int64, results MovieAPIResults) {
+	if len(results) > 0 {
+		trends.record(chatID, results[0], time.Now())
+	}
+}
+
+func handleTrendingSearches(ctx context.Context, update telegram.Update) {
+	chatID := update.Message.Chat.ID
+
+	text := "Most searched on the bot this week 📈\n"
+	movies := trends.top(maxTrendingMovies, time.Now())
+	if len(movies) < trendMinMovies {
+		trending, err := trendingMovies(ctx)
+		if err != nil {
+			tmdbFailed(ctx, chatID, err, "failed to get trending movies")
+			return
+		}
+		if len(trending) > maxTrendingMovies {
+			trending = trending[:maxTrendingMovies]
+		}
+		text = "Not enough searches on the bot yet, here is what's trending on TMDB this week 📈\n"
+		movies = trending
+	}
+	if len(movies) == 0 {
+		sendMsg(ctx, telegram.NewMessage(chatID, "Nothing is trending right now, try again later 🤷"))
+		return
+	}
+
+	for i, m := range movies {
+		text += fmt.Sprintf("%d. %s\n", i+1, displayTitle(m.Title, m.ID))
+	}
+	sendMsg(ctx, telegram.NewMessage(chatID, text))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// updateWorkers is the number of updates handled concurrently.
+	updateWorkers = 8
+	// updateQueueSize is the number of updates waiting for each worker
+	// before dispatch blocks.
+	updateQueueSize = 32
+)
+
+// updatePool handles updates on a fixed number of workers, so that a slow
+// command doesn't delay the other chats. The updates of a chat always go to
+// the same worker and are handled in the order they were received.
+type updatePool struct {
+	queues []chan telegram.Update
+}
+
+func newUpdatePool(workers int, handle func(telegram.Update)) *updatePool {
+	p := &updatePool{queues: make([]chan telegram.Update, workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan telegram.Update, updateQueueSize)
+		go p.work(p.queues[i], handle)
+	}
+	return p
+}
+
+// dispatch queues the update on the worker of its chat.
+func (p *updatePool) dispatch(update telegram.Update) {
+	worker := uint64(updateChatID(update)) % uint64(len(p.queues))
+	p.queues[worker] <- update
+}
+
+func (p *updatePool) work(queue <-chan telegram.Update, handle func(telegram.Update)) {
+	for update := range queue {
+		handleRecovered(update, handle)
+	}
+}
+
+// handleRecovered handles the update, logging instead of crashing if the
+// handler panics so that the worker keeps serving its other chats.
+func handleRecovered(update telegram.Update, handle func(telegram.Update)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: panic handling update: update_id=%d: %v\n%s", update.UpdateID, r, debug.Stack())
+		}
+	}()
+	handle(update)
+}
+
+// updateChatID returns the chat the update belongs to, or the user for inline
+// queries. Updates without either share the same worker.
+func updateChatID(update telegram.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return int64(update.InlineQuery.From.ID)
+	}
+	return 0
+}
+
+// sentByBot returns whether the message was posted by a bot, this one
+// included. Such messages are ignored so that bots replying to each other in
+// a group don't loop.
+func sentByBot(msg *telegram.Message) bool {
+	if msg.From == nil {
+		return false
+	}
+	return msg.From.IsBot || (bot != nil && msg.From.ID == bot.Self.ID)
+}
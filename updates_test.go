@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestUpdatePoolSlowChatDoesntBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var handled []string
+	done := make(chan struct{}, 10)
+
+	pool := newUpdatePool(4, func(update telegram.Update) {
+		chatID := update.Message.Chat.ID
+		if chatID == 1 && update.Message.Text == "slow" {
+			<-release
+		}
+		mu.Lock()
+		handled = append(handled, update.Message.Text)
+		mu.Unlock()
+		done <- struct{}{}
+	})
+
+	pool.dispatch(testMessage(1, "slow"))
+	pool.dispatch(testMessage(2, "fast"))
+	pool.dispatch(testMessage(1, "after slow"))
+	pool.dispatch(testMessage(-2, "other fast"))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the fast chats waited for the slow one")
+		}
+	}
+	mu.Lock()
+	if len(handled) != 2 || handled[0] == "after slow" || handled[1] == "after slow" {
+		t.Errorf("handled %q before the slow update, want only the other chats", handled)
+	}
+	mu.Unlock()
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the slow chat never caught up")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if handled[2] != "slow" || handled[3] != "after slow" {
+		t.Errorf("handled %q, want the updates of chat 1 in order", handled)
+	}
+}
+
+func TestUpdatePoolRecoversPanics(t *testing.T) {
+	done := make(chan string, 2)
+	pool := newUpdatePool(1, func(update telegram.Update) {
+		if update.Message.Text == "boom" {
+			panic("malformed update")
+		}
+		done <- update.Message.Text
+	})
+
+	pool.dispatch(testMessage(1, "boom"))
+	pool.dispatch(testMessage(1, "next"))
+
+	select {
+	case text := <-done:
+		if text != "next" {
+			t.Errorf("handled %q, want the update after the panic", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker stopped after a panic")
+	}
+}
+
+func TestUpdateChatID(t *testing.T) {
+	chat := &telegram.Chat{ID: -100}
+	tests := []struct {
+		name   string
+		update telegram.Update
+		want   int64
+	}{
+		{"message", telegram.Update{Message: &telegram.Message{Chat: chat}}, -100},
+		{"callback", telegram.Update{CallbackQuery: &telegram.CallbackQuery{Message: &telegram.Message{Chat: chat}}}, -100},
+		{"inline query", telegram.Update{InlineQuery: &telegram.InlineQuery{From: &telegram.User{ID: 7}}}, 7},
+		{"callback of an inline message", telegram.Update{CallbackQuery: &telegram.CallbackQuery{InlineMessageID: "x"}}, 0},
+		{"empty", telegram.Update{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := updateChatID(tt.update); got != tt.want {
+				t.Errorf("updateChatID() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleUpdateIgnoresBots(t *testing.T) {
+	useMemStore(t)
+	tg := useFakeTelegram(t)
+
+	tests := []struct {
+		name   string
+		chatID int64
+		from   telegram.User
+		want   int
+	}{
+		{"user", 101, telegram.User{ID: 101, FirstName: "Test"}, 1},
+		{"other bot", 102, telegram.User{ID: 7, FirstName: "Other", IsBot: true}, 0},
+		{"the bot itself", 103, bot.Self, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := testMessage(tt.chatID, "what's up")
+			update.Message.From = &tt.from
+			handleUpdate(update)
+			if texts := tg.texts(tt.chatID); len(texts) != tt.want {
+				t.Errorf("sent %q, want %d messages", texts, tt.want)
+			}
+		})
+	}
+}
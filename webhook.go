@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// startupAttempts is how many times the Telegram calls made at startup
+	// are tried before giving up.
+	startupAttempts = 6
+	// startupBackoff is the delay before the first retry, doubled after each
+	// attempt unless Telegram asks for a specific delay.
+	startupBackoff = time.Second
+	// maxStartupBackoff caps the delay between two attempts.
+	maxStartupBackoff = time.Minute
+)
+
+// startupSleep waits between two attempts, tests replace it.
+var startupSleep = time.Sleep
+
+// retryDelay returns how long to wait before retrying the failed call, and
+// whether it should be retried at all. Rate limited calls are retried after
+// the delay given by Telegram, other API errors such as an invalid token are
+// not retried. Network errors are retried after backoff.
+func retryDelay(err error, backoff time.Duration) (time.Duration, bool) {
+	if apiErr, ok := err.(telegram.Error); ok {
+		if apiErr.RetryAfter <= 0 {
+			return 0, false
+		}
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	}
+	return backoff, true
+}
+
+// retryStartup calls fn until it succeeds, it fails with an error that isn't
+// worth retrying, or startupAttempts is reached. It returns the last error.
+func retryStartup(what string, fn func() error) error {
+	backoff := startupBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		delay, retry := retryDelay(err, backoff)
+		if !retry || attempt == startupAttempts {
+			return err
+		}
+		if delay > maxStartupBackoff {
+			delay = maxStartupBackoff
+		}
+		log.Printf("WARNING: failed to %s, retrying in %s: attempt=%d: %s", what, delay, attempt, err)
+		startupSleep(delay)
+
+		backoff *= 2
+		if backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+}
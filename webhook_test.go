@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{"rate limited", telegram.Error{Message: "Too Many Requests: retry after 3", ResponseParameters: telegram.ResponseParameters{RetryAfter: 3}}, 3 * time.Second, true},
+		{"api error", telegram.Error{Message: "Unauthorized"}, 0, false},
+		{"network error", errors.New("connection reset by peer"), 4 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := retryDelay(tt.err, 4*time.Second)
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("retryDelay() = %s, %v, want %s, %v", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
+
+// useStartupSleep records the delays waited by retryStartup instead of
+// sleeping.
+func useStartupSleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	previous := startupSleep
+	startupSleep = func(d time.Duration) { delays = append(delays, d) }
+	t.Cleanup(func() { startupSleep = previous })
+	return &delays
+}
+
+func setupWebhook() error {
+	return retryStartup("setup webhook", func() error {
+		_, err := bot.SetWebhook(telegram.NewWebhook("https://example.com/test"))
+		return err
+	})
+}
+
+func TestRetryStartupRateLimited(t *testing.T) {
+	tg := useFakeTelegram(t)
+	delays := useStartupSleep(t)
+	refused := 0
+	tg.fail = func(c telegramCall) string {
+		if refused < 2 {
+			refused++
+			return "Too Many Requests: retry after 2"
+		}
+		return ""
+	}
+
+	if err := setupWebhook(); err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+	if n := len(tg.sent("setWebhook")); n != 3 {
+		t.Errorf("called setWebhook %d times, want 3", n)
+	}
+	if want := []time.Duration{2 * time.Second, 2 * time.Second}; len(*delays) != 2 || (*delays)[0] != want[0] || (*delays)[1] != want[1] {
+		t.Errorf("waited %v, want %v as asked by Telegram", *delays, want)
+	}
+}
+
+func TestRetryStartupGivesUp(t *testing.T) {
+	tg := useFakeTelegram(t)
+	delays := useStartupSleep(t)
+	tg.fail = func(c telegramCall) string { return "Too Many Requests: retry after 120" }
+
+	if err := setupWebhook(); err == nil {
+		t.Fatal("setup succeeded, want the last error once the attempts are exhausted")
+	}
+	if n := len(tg.sent("setWebhook")); n != startupAttempts {
+		t.Errorf("called setWebhook %d times, want %d", n, startupAttempts)
+	}
+	for _, d := range *delays {
+		if d != maxStartupBackoff {
+			t.Errorf("waited %s, want the delay capped to %s", d, maxStartupBackoff)
+		}
+	}
+}
+
+func TestRetryStartupInvalidToken(t *testing.T) {
+	tg := useFakeTelegram(t)
+	delays := useStartupSleep(t)
+	tg.fail = func(c telegramCall) string { return "Unauthorized" }
+
+	if err := setupWebhook(); err == nil {
+		t.Fatal("setup succeeded with an invalid token")
+	}
+	if n := len(tg.sent("setWebhook")); n != 1 || len(*delays) != 0 {
+		t.Errorf("called setWebhook %d times and waited %v, want a single attempt", n, *delays)
+	}
+}